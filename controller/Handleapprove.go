@@ -1,13 +1,19 @@
 package controller
 
 import (
+	"errors"
 	"fmt"
 	"strings"
+	"github.com/HealthMe-pls/medic-go-api/middleware"
 	"github.com/HealthMe-pls/medic-go-api/model"
 	"github.com/gofiber/fiber/v2"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// Handleapprove publishes a submitted TempShop. Every step runs in one
+// transaction so a failure leaves the shop exactly as it was; photo files
+// queued for deletion are removed only after the commit.
 func Handleapprove(db *gorm.DB,c *fiber.Ctx) error {
 	id := c.Params("id")
 	var tempShop model.TempShop
@@ -17,53 +23,55 @@ func Handleapprove(db *gorm.DB,c *fiber.Ctx) error {
     }
 	
 	tempID := tempShop.TempID
-	// Change the status to "Approve"
-	if err := ChangeStateHandleApprove(db, tempID); err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error":   "Failed to update status to Approve",
-			"details": err.Error(),
-		})
-	}
-	// Update Shop details from TempShop
-	if err := UpdateShopFromTemp(db, tempID); err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error":   "Failed to update Shop from TempShop",
-			"details": err.Error(),
-		})
-	}
-	if err := UpdateMenuFromTemp(db, tempID); err != nil && !isNotFoundError(err) {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error":   "Failed to update Menu from TempShop",
-			"details": err.Error(),
-		})
+	if tempShop.Status != model.StatusSubmitted && tempShop.Status != model.StatusInReview {
+		return transitionResponse(c, &TransitionError{From: tempShop.Status, To: model.StatusApproved})
 	}
-	
-	// Update Social details from TempSocial, ignore if TempSocial not found
-	if err := UpdateSocialFromTemp(db, tempID); err != nil && !isNotFoundError(err) {
+	if _, err := requireReviewClaim(c, tempID); err != nil {
+		var conflict *claimConflict
+		if errors.As(err, &conflict) {
+			return claimResponse(c, conflict)
+		}
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error":   "Failed to update Social from TempShop",
+			"error":   "Failed to check review claim",
 			"details": err.Error(),
 		})
 	}
-	if err := DeleteBinMenuByTempID(db, c); err != nil {
-		return err
+	var shopBefore *model.Shop
+	if tempShop.ShopID != nil {
+		shopBefore = shopSnapshot(db, *tempShop.ShopID)
 	}
 
-	if err := DeleteBinPhotoByTempID(db, c); err != nil {
+	var files []string
+	var published publishedEdits
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// Lock the TempShop so two approvals of the same shop cannot interleave
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&tempShop, "temp_id = ?", tempID).Error; err != nil {
+			return fmt.Errorf("failed to lock TempShop: %w", err)
+		}
+		var err error
+		files, published, err = publishSubmission(tx, actorOf(c), reviewerID(c), &tempShop, "")
 		return err
+	})
+	var refused *TransitionError
+	if errors.As(err, &refused) {
+		return transitionResponse(c, refused)
 	}
-
-	if err := DeleteBinSocialByTempID(db, c); err != nil {
-		return err
+	var invalid changeErrors
+	if errors.As(err, &invalid) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":  "Approval failed, some changes cannot be applied",
+			"errors": invalid,
+		})
 	}
-	// Update IsPublic status to true for all related items by TempID
-	if err := UpdateStatusToPublicByTempID(db, tempID); err != nil {
+	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error":   "Failed to update IsPublic to true",
+			"error":   "Approval failed, nothing was changed",
 			"details": err.Error(),
 		})
 	}
-	Handletimeapprove(db,tempID)
+	removeUploads(files)
+	releaseDecidedClaim(c, tempID)
+
 	// Fetch updated TempShop
 	if err := db.First(&tempShop, "temp_id = ?", tempID).Error; err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
@@ -72,107 +80,208 @@ func Handleapprove(db *gorm.DB,c *fiber.Ctx) error {
 		})
 	}
 
+	var shopAfter *model.Shop
+	if tempShop.ShopID != nil {
+		shopAfter = shopSnapshot(db, *tempShop.ShopID)
+	}
+	recordAudit(db, c, "shop.approve", auditEntityTempShop, tempID, shopBefore, fiber.Map{"temp_shop": tempShop, "shop": shopAfter})
+
 	// If everything goes well, return the TempShop
 	return c.JSON(fiber.Map{
-		"tempShop": tempShop,
-		"error":    nil,
+		"tempShop":  tempShop,
+		"published": published,
+		"error":     nil,
 	})
 }
-func isNotFoundError(err error) bool {
-	return err != nil && strings.Contains(err.Error(), "record not found")
-}
-func HandleNotApprove(db *gorm.DB, c *fiber.Ctx) error {
-	// Extract the temp ID from the request
-	tempID := c.Params("temp_id")
-
-	// Check if tempID is valid
-	var tempShop model.TempShop
-	if err := db.First(&tempShop, "temp_id = ? AND status = ?", tempID, "Waiting").Error; err != nil {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": "TempShop not found or not in Waiting state",
-		})
+// publishSubmission approves a submitted TempShop locked by tx: it records
+// the decision, applies the change set and saves the new shop version.
+// reviewer is zero when the approval was automatic.
+func publishSubmission(tx *gorm.DB, actor transitionActor, reviewer uint, tempShop *model.TempShop, reason string) ([]string, publishedEdits, error) {
+	tempID := tempShop.TempID
+	if err := decideTempShop(tx, actor, tempShop, model.StatusApproved, reason); err != nil {
+		return nil, publishedEdits{}, err
 	}
-
-	// Update the status to "NotApprove"
-	if err := db.Model(&tempShop).Update("status", "NotApprove").Error; err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error":   "Failed to update status",
-			"details": err.Error(),
-		})
+	if tempShop.ShopID != nil {
+		if err := ensureBaselineVersion(tx, *tempShop.ShopID, reviewer); err != nil {
+			return nil, publishedEdits{}, fmt.Errorf("failed to record baseline version: %w", err)
+		}
+	}
+	files, published, err := approveTempShop(tx, tempID)
+	if err != nil {
+		return nil, published, err
+	}
+	if _, err := closeReviewRound(tx, tempID, "Approve", reviewer, reason); err != nil {
+		return nil, published, fmt.Errorf("failed to close review round: %w", err)
 	}
+	if tempShop.ShopID != nil {
+		if _, err := saveShopVersion(tx, *tempShop.ShopID, model.VersionApprove, &tempID, nil, reviewer); err != nil {
+			return nil, published, fmt.Errorf("failed to record shop version: %w", err)
+		}
+	}
+	return files, published, nil
+}
 
-	// Return success response
-	return c.JSON(fiber.Map{
-		"message": "TempShop status updated to NotApprove",
-		"temp_id": tempShop.TempID,
-		"status":  "NotApprove",
-	})
+// editCounts counts the applied edits of one entity type by action
+type editCounts struct {
+	Created int `json:"created"`
+	Updated int `json:"updated"`
+	Deleted int `json:"deleted"`
 }
 
-func ChangeStateHandleApprove(db *gorm.DB, tempID uint) error {
-	// Update status to "Approve" where temp_id matches
-	if err := db.Model(&model.TempShop{}).
-		Where("temp_id = ?", tempID).
-		Update("status", "Approve").Error; err != nil {
-		return err
+// add counts one applied op
+func (counts *editCounts) add(action string) {
+	switch action {
+	case model.ChangeCreate:
+		counts.Created++
+	case model.ChangeUpdate:
+		counts.Updated++
+	case model.ChangeDelete:
+		counts.Deleted++
 	}
-	return nil
 }
 
+// publishedEdits counts the pending edits an approval applied, per entity type
+type publishedEdits struct {
+	Shop      editCounts `json:"shop"`
+	Menus     editCounts `json:"menus"`
+	Socials   editCounts `json:"socials"`
+	Photos    editCounts `json:"photos"`
+	OpenTimes editCounts `json:"open_times"`
+}
 
-func Handletimeapprove(db *gorm.DB,tempID uint) error {
-	var tempShopOpenDate []model.TempShopOpenDate
-	if err := db.Where("temp_id = ?", tempID).Find(&tempShopOpenDate).Error; err != nil {
-		return nil
+// approveTempShop applies every pending op of the TempShop's change set on
+// tx. Ops whose target disappeared are dropped as stale. It returns the photo
+// files to remove once tx commits. Approvals and rollbacks both publish
+// through here; the status is left to the caller.
+func approveTempShop(tx *gorm.DB, tempID uint) ([]string, publishedEdits, error) {
+	var published publishedEdits
+	var tempShop model.TempShop
+	if err := tx.First(&tempShop, "temp_id = ?", tempID).Error; err != nil {
+		return nil, published, fmt.Errorf("TempShop not found: %w", err)
+	}
+	if tempShop.ShopID == nil {
+		return nil, published, fmt.Errorf("TempShop with TempID %d has no associated ShopID", tempID)
 	}
 
-	for _, time := range tempShopOpenDate {
-		if time.Operation == "add" {
-			addToShopOpenDate(db,time)
-		}else if time.Operation == "delete" {
-			deleteToShopOpenDate(db,time)
-		}else if time.Operation == "edit" {
-			editToShopOpenDate(db,time)
+	cs, ok, err := findOpenChangeSet(tx, *tempShop.ShopID)
+	if err != nil || !ok {
+		return nil, published, err
+	}
+	ops, err := pruneStaleOps(tx, cs)
+	if err != nil {
+		return nil, published, fmt.Errorf("failed to drop stale changes: %w", err)
+	}
+	files, err := applyChangeOps(tx, cs, ops)
+	if err != nil {
+		return nil, published, err
+	}
+	for _, op := range ops {
+		switch op.Entity {
+		case model.ChangeEntityShop:
+			published.Shop.add(op.Action)
+		case model.ChangeEntityMenu:
+			published.Menus.add(op.Action)
+		case model.ChangeEntitySocial:
+			published.Socials.add(op.Action)
+		case model.ChangeEntityPhoto:
+			published.Photos.add(op.Action)
+		case model.ChangeEntityOpenTime:
+			published.OpenTimes.add(op.Action)
 		}
 	}
-	return nil
+	return files, published, nil
 }
 
-func addToShopOpenDate(db *gorm.DB, time model.TempShopOpenDate) error {
-	shopOpenDate := model.ShopOpenDate{
-		StartTime:        time.StartTime,
-		EndTime:          time.EndTime,
-		ShopID:           time.ShopID,
-		MarketOpenDateID: time.MarketOpenDateID,
+// HandleNotApprove rejects a submitted TempShop. The body may carry a reason for
+// the whole submission and reasons for single items:
+// {"reason": "...", "items": [{"item_type": "menu", "item_id": 3, "reason": "..."}]}
+func HandleNotApprove(db *gorm.DB, c *fiber.Ctx) error {
+	// Extract the temp ID from the request
+	tempID := c.Params("temp_id")
+
+	var input struct {
+		Reason string       `json:"reason"`
+		Items  []reviewItem `json:"items"`
+	}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+	}
+	for _, item := range input.Items {
+		if err := item.validate(); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
 	}
 
-	if err := db.Create(&shopOpenDate).Error; err != nil {
-		return fmt.Errorf("failed to create shop open date: %w", err)
+	// Check if tempID is valid
+	var tempShop model.TempShop
+	if err := db.First(&tempShop, "temp_id = ? AND status IN ?", tempID, awaitingReview).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "TempShop not found or not waiting for review",
+		})
 	}
 
-	return nil
-}
+	if _, err := requireReviewClaim(c, tempShop.TempID); err != nil {
+		var conflict *claimConflict
+		if errors.As(err, &conflict) {
+			return claimResponse(c, conflict)
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to check review claim",
+			"details": err.Error(),
+		})
+	}
 
-func deleteToShopOpenDate(db *gorm.DB,time model.TempShopOpenDate) error {
-		var record model.ShopOpenDate
-		if err := db.Where("shop_id = ? AND market_open_date_id = ?", time.ShopID, time.MarketOpenDateID).First(&record).Error; err != nil {
+	// Reject the submission and close the round with the reasons
+	before := tempShop
+	var round model.ReviewRound
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := decideTempShop(tx, actorOf(c), &tempShop, model.StatusRejected, input.Reason); err != nil {
 			return err
 		}
-		if err := db.Delete(&record).Error; err != nil {
+		var err error
+		if round, err = closeReviewRound(tx, tempShop.TempID, "NotApprove", reviewerID(c), input.Reason); err != nil {
 			return err
 		}
+		if strings.TrimSpace(input.Reason) != "" {
+			if _, err := addReviewComment(tx, c, round, model.ReviewComment{Body: input.Reason}); err != nil {
+				return err
+			}
+		}
+		for _, item := range input.Items {
+			if _, err := addReviewComment(tx, c, round, model.ReviewComment{ItemType: item.ItemType, ItemID: item.ItemID, Body: item.Reason}); err != nil {
+				return err
+			}
+		}
 		return nil
+	})
+	var refused *TransitionError
+	if errors.As(err, &refused) {
+		return transitionResponse(c, refused)
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to update status",
+			"details": err.Error(),
+		})
+	}
+	releaseDecidedClaim(c, tempShop.TempID)
+	recordAudit(db, c, "shop.reject", auditEntityTempShop, tempShop.TempID, before, fiber.Map{"temp_shop": tempShop, "reason": input.Reason, "items": input.Items})
+
+	// Return success response
+	return c.JSON(fiber.Map{
+		"message": "TempShop rejected",
+		"temp_id": tempShop.TempID,
+		"status":  tempShop.Status,
+		"round":   round.Round,
+	})
 }
 
-func editToShopOpenDate(db *gorm.DB,time model.TempShopOpenDate) error {
-	 var record model.ShopOpenDate
-	 if err := db.Where("shop_id = ? AND market_open_date_id = ?", time.ShopID, time.MarketOpenDateID).First(&record).Error; err != nil {
-		 return err
-	 }
-	 record.StartTime = time.StartTime
-	 record.EndTime = time.EndTime
-	 if err := db.Save(&record).Error; err != nil {
-		 return err
-	 }
-	 return nil
-}
\ No newline at end of file
+// reviewerID is the admin deciding on a submission
+func reviewerID(c *fiber.Ctx) uint {
+	if claims, ok := middleware.GetClaims(c); ok {
+		return claims.UserID()
+	}
+	return 0
+}
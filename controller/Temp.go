@@ -1,6 +1,8 @@
 package controller
 
 import (
+	"errors"
+
 	"github.com/HealthMe-pls/medic-go-api/model"
 	"github.com/gofiber/fiber/v2"
 	"gorm.io/gorm"
@@ -42,16 +44,31 @@ func CreateTempShop(db *gorm.DB, c *fiber.Ctx) error {
 			"details": err.Error(),
 		})
 	}
-	if result := db.Create(&tempShop); result.Error != nil {
+	if tempShop.Status == "" {
+		tempShop.Status = model.StatusDraft
+	}
+	if !tempShop.Status.Valid() {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":  "Unknown status",
+			"status": tempShop.Status,
+		})
+	}
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&tempShop).Error; err != nil {
+			return err
+		}
+		return logTransition(tx, tempShop.TempID, "", tempShop.Status, actorOf(c), "")
+	}); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error":   "Failed to create temp shop",
-			"details": result.Error.Error(),
+			"details": err.Error(),
 		})
 	}
 	return c.Status(fiber.StatusCreated).JSON(tempShop)
 }
 
 // UpdateTempShop อัปเดต TempShop ตาม ID
+// The status only changes through the review endpoints and is kept as it is.
 func UpdateTempShop(db *gorm.DB, c *fiber.Ctx) error {
 	id := c.Params("id")
 	var tempShop model.TempShop
@@ -61,12 +78,14 @@ func UpdateTempShop(db *gorm.DB, c *fiber.Ctx) error {
 			"details": err.Error(),
 		})
 	}
+	tempID, status := tempShop.TempID, tempShop.Status
 	if err := c.BodyParser(&tempShop); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error":   "Failed to parse request body",
 			"details": err.Error(),
 		})
 	}
+	tempShop.TempID, tempShop.Status = tempID, status
 	if result := db.Save(&tempShop); result.Error != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error":   "Failed to update temp shop",
@@ -90,82 +109,63 @@ func DeleteTempShop(db *gorm.DB, c *fiber.Ctx) error {
 
 // --------------------- TempShopOpenDate Controller --------------------- //
 
-// GetTempShopOpenDates ดึงข้อมูล TempShopOpenDate ทั้งหมด
-func GetTempShopOpenDates(db *gorm.DB, c *fiber.Ctx) error {
-	var tempShopOpenDates []model.TempShopOpenDate
-	if err := db.Find(&tempShopOpenDates).Error; err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error":   "Failed to retrieve temp shop open dates",
-			"details": err.Error(),
-		})
-	}
-	return c.JSON(tempShopOpenDates)
-}
-
-// GetTempShopOpenDateByID ดึง TempShopOpenDate ตาม ID
-func GetTempShopOpenDateByID(db *gorm.DB, c *fiber.Ctx) error {
-	id := c.Params("id")
-	var tempShopOpenDate model.TempShopOpenDate
-	if err := db.First(&tempShopOpenDate, id).Error; err != nil {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error":   "Temp shop open date not found",
-			"details": err.Error(),
-		})
-	}
-	return c.JSON(tempShopOpenDate)
-}
-
-// CreateTempShopOpenDate สร้าง TempShopOpenDate ใหม่
+// CreateTempShopOpenDate stages a change to the shop's open times. The
+// operation is "add", "edit" or "delete"; edits and deletions name the market
+// date whose open time they change.
+// Body: {"shop_id": 1, "market_open_date_id": 2, "start_time": "...", "end_time": "...", "operation": "edit"}
 func CreateTempShopOpenDate(db *gorm.DB, c *fiber.Ctx) error {
-	tempShopOpenDate := new(model.TempShopOpenDate)
-	if err := c.BodyParser(tempShopOpenDate); err != nil {
+	var entry model.TempShopOpenDate
+	if err := c.BodyParser(&entry); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error":   "Failed to parse request body",
 			"details": err.Error(),
 		})
 	}
-	if result := db.Create(&tempShopOpenDate); result.Error != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error":   "Failed to create temp shop open date",
-			"details": result.Error.Error(),
-		})
+	shopID := entry.ShopID
+	if shopID == 0 {
+		var err error
+		if shopID, err = shopOfTemp(db, entry.TempID); err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "TempShop not found for this TempID",
+			})
+		}
 	}
-	return c.Status(fiber.StatusCreated).JSON(tempShopOpenDate)
-}
 
-// UpdateTempShopOpenDate อัปเดต TempShopOpenDate ตาม ID
-func UpdateTempShopOpenDate(db *gorm.DB, c *fiber.Ctx) error {
-	id := c.Params("id")
-	var tempShopOpenDate model.TempShopOpenDate
-	if err := db.First(&tempShopOpenDate, id).Error; err != nil {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error":   "Temp shop open date not found",
-			"details": err.Error(),
-		})
-	}
-	if err := c.BodyParser(&tempShopOpenDate); err != nil {
+	action, ok := map[string]string{
+		"add":    model.ChangeCreate,
+		"edit":   model.ChangeUpdate,
+		"delete": model.ChangeDelete,
+	}[entry.Operation]
+	if !ok {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error":   "Failed to parse request body",
-			"details": err.Error(),
+			"error": "Operation must be add, edit or delete",
 		})
 	}
-	if result := db.Save(&tempShopOpenDate); result.Error != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error":   "Failed to update temp shop open date",
-			"details": result.Error.Error(),
-		})
+
+	var entityID *uint
+	var payload interface{}
+	if action != model.ChangeCreate {
+		var live model.ShopOpenDate
+		if err := db.Where("shop_id = ? AND market_open_date_id = ?", shopID, entry.MarketOpenDateID).First(&live).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+					"error": "The shop has no open time on this market date",
+				})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error":   "Failed to retrieve shop open date",
+				"details": err.Error(),
+			})
+		}
+		entityID = &live.ID
+	}
+	if action != model.ChangeDelete {
+		payload = openTimePayload{MarketOpenDateID: entry.MarketOpenDateID, StartTime: entry.StartTime, EndTime: entry.EndTime}
 	}
-	return c.JSON(tempShopOpenDate)
-}
 
-// DeleteTempShopOpenDate ลบ TempShopOpenDate ตาม ID
-func DeleteTempShopOpenDate(db *gorm.DB, c *fiber.Ctx) error {
-	id := c.Params("id")
-	if result := db.Delete(&model.TempShopOpenDate{}, id); result.Error != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error":   "Failed to delete temp shop open date",
-			"details": result.Error.Error(),
-		})
+	op, err := newChangeOp(model.ChangeEntityOpenTime, action, entityID, payload)
+	if err == nil {
+		op, err = stageShopChange(db, actorOf(c), shopID, op)
 	}
-	return c.SendStatus(fiber.StatusNoContent)
+	return stageResponse(c, op, err)
 }
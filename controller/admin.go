@@ -1,8 +1,16 @@
 package controller
 
 import (
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/HealthMe-pls/medic-go-api/database"
+	"github.com/HealthMe-pls/medic-go-api/middleware"
 	"github.com/HealthMe-pls/medic-go-api/model"
 	"github.com/gofiber/fiber/v2"
+	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
@@ -29,16 +37,39 @@ func GetAdminByUsername(db *gorm.DB,c *fiber.Ctx) error {
 }
 
 
+// adminInput is the request body for creating or updating an Admin.
+// model.Admin never serializes its password, so it cannot be parsed from JSON.
+type adminInput struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// minAdminPasswordLength is the shortest password accepted for an admin account
+const minAdminPasswordLength = 8
+
 // Create Admin
 func CreateAdmin(db *gorm.DB, c *fiber.Ctx) error {
-    // Parse the request body into the Admin struct
-    admin := new(model.Admin)
-    if err := c.BodyParser(admin); err != nil {
+    // Parse the request body
+    var input adminInput
+    if err := c.BodyParser(&input); err != nil {
         return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
             "error": "Failed to parse request body",
 			"details": err.Error(),
         })
     }
+	if input.Email == "" || len(input.Password) < minAdminPasswordLength {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("Email and a password of at least %d characters are required", minAdminPasswordLength),
+		})
+	}
+
+	hashedPassword, err := HashPassword(input.Password)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to hash password",
+		})
+	}
+	admin := &model.Admin{Email: input.Email, Password: hashedPassword}
 
     // Save the Admin to the database
     if result := db.Create(&admin); result.Error != nil {
@@ -62,9 +93,24 @@ func UpdateAdmin(db *gorm.DB,c *fiber.Ctx) error {
 		return c.Status(fiber.StatusNotFound).SendString("Admin not found")
 	}
 	// Parse updated data from request body
-	if err := c.BodyParser(&admin); err != nil {
+	var input adminInput
+	if err := c.BodyParser(&input); err != nil {
 		return c.Status(fiber.StatusBadRequest).SendString("Failed to parse request body")
 	}
+	if input.Email != "" {
+		admin.Email = input.Email
+	}
+	// Only replace the password when a new one is provided
+	if input.Password != "" {
+		if len(input.Password) < minAdminPasswordLength {
+			return c.Status(fiber.StatusBadRequest).SendString("Password is too short")
+		}
+		hashedPassword, err := HashPassword(input.Password)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).SendString("Failed to hash password")
+		}
+		admin.Password = hashedPassword
+	}
 	if result := db.Save(&admin); result.Error != nil {
 		return c.Status(fiber.StatusInternalServerError).SendString("Failed to update admin")
 	}
@@ -80,3 +126,140 @@ func DeleteAdmin(db *gorm.DB,c *fiber.Ctx) error {
 	}
 	return c.SendString("Admin successfully deleted")
 }
+
+// AdminLogin checks an admin's email and password and issues an admin-scoped JWT.
+// Admins with 2FA, or without it when ADMIN_REQUIRE_2FA is set, get an mfa_token instead.
+func AdminLogin(db *gorm.DB, c *fiber.Ctx) error {
+	var input adminInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid input"})
+	}
+
+	// Admin logins are throttled like entrepreneur logins, on their own counters
+	attempt, reason, wait := beginLogin(adminThrottleKey(input.Email), c.IP())
+	if reason != "" {
+		recordAuthEvent(db, c, middleware.RoleAdmin, input.Email, nil, reason)
+		if reason == authReasonLocked {
+			return tooManyAttempts(c, "Account temporarily locked after repeated failed logins", wait)
+		}
+		return tooManyAttempts(c, "Too many failed logins, try again later", wait)
+	}
+
+	// Find admin by email
+	var admin model.Admin
+	if err := db.Where("email = ?", input.Email).First(&admin).Error; err != nil {
+		attempt.failed()
+		recordAuthEvent(db, c, middleware.RoleAdmin, input.Email, nil, authReasonUnknownUser)
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid credentials"})
+	}
+
+	if isHashedPassword(admin.Password) {
+		if bcrypt.CompareHashAndPassword([]byte(admin.Password), []byte(input.Password)) != nil {
+			attempt.failed()
+			recordAuthEvent(db, c, middleware.RoleAdmin, input.Email, &admin.ID, authReasonBadPassword)
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid credentials"})
+		}
+	} else {
+		// Admins created before hashing stored the password as sent; hash it now
+		if input.Password == "" || subtle.ConstantTimeCompare([]byte(admin.Password), []byte(input.Password)) != 1 {
+			attempt.failed()
+			recordAuthEvent(db, c, middleware.RoleAdmin, input.Email, &admin.ID, authReasonBadPassword)
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid credentials"})
+		}
+		if hashedPassword, err := HashPassword(input.Password); err == nil {
+			if err := db.Model(&admin).Update("password", hashedPassword).Error; err != nil {
+				log.Println("Failed to rehash password for admin", admin.Email, err)
+			}
+		}
+	}
+
+	// With 2FA the password only unlocks the second step. Earlier failures are
+	// kept until the code is right, so wrong codes still lead to the lockout,
+	// and the successful login is recorded once the code is checked.
+	if admin.TOTPEnabled || adminRequires2FA() {
+		attempt.release()
+		if admin.TOTPEnabled {
+			return startMFAChallenge(c, admin, database.MFAVerify)
+		}
+		return startMFAChallenge(c, admin, database.MFAEnroll)
+	}
+	attempt.succeeded()
+
+	tokens, err := startSession(c, admin.ID, admin.Email, middleware.RoleAdmin)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not generate token"})
+	}
+	recordAuthEvent(db, c, middleware.RoleAdmin, admin.Email, &admin.ID, authReasonSuccess)
+
+	return c.Status(fiber.StatusOK).JSON(tokens)
+}
+
+// ChangeAdminPassword lets the logged-in admin replace their password
+func ChangeAdminPassword(db *gorm.DB, c *fiber.Ctx) error {
+	claims, ok := middleware.GetClaims(c)
+	if !ok {
+		return middleware.Unauthorized(c, "Missing token")
+	}
+
+	var input struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid input"})
+	}
+	if len(input.NewPassword) < minAdminPasswordLength {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("New password must be at least %d characters", minAdminPasswordLength),
+		})
+	}
+
+	var admin model.Admin
+	if err := db.First(&admin, "id = ?", claims.UserID()).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Admin not found"})
+	}
+	if bcrypt.CompareHashAndPassword([]byte(admin.Password), []byte(input.CurrentPassword)) != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Current password is incorrect"})
+	}
+
+	hashedPassword, err := HashPassword(input.NewPassword)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to hash password"})
+	}
+	if err := db.Model(&admin).Update("password", hashedPassword).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update password"})
+	}
+
+	return c.JSON(fiber.Map{"message": "Password changed successfully"})
+}
+
+// BootstrapAdmin creates the first admin from ADMIN_EMAIL and ADMIN_PASSWORD.
+// It does nothing when either variable is unset or an admin already exists.
+func BootstrapAdmin(db *gorm.DB) error {
+	email := os.Getenv("ADMIN_EMAIL")
+	password := os.Getenv("ADMIN_PASSWORD")
+	if email == "" || password == "" {
+		return nil
+	}
+
+	var count int64
+	if err := db.Model(&model.Admin{}).Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to count admins: %w", err)
+	}
+	if count > 0 {
+		return nil
+	}
+	if len(password) < minAdminPasswordLength {
+		return fmt.Errorf("ADMIN_PASSWORD must be at least %d characters", minAdminPasswordLength)
+	}
+
+	hashedPassword, err := HashPassword(password)
+	if err != nil {
+		return fmt.Errorf("failed to hash admin password: %w", err)
+	}
+	if err := db.Create(&model.Admin{Email: email, Password: hashedPassword}).Error; err != nil {
+		return fmt.Errorf("failed to create admin: %w", err)
+	}
+	log.Println("Created bootstrap admin", email)
+	return nil
+}
@@ -0,0 +1,275 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/HealthMe-pls/medic-go-api/middleware"
+	"github.com/HealthMe-pls/medic-go-api/model"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// auditedKey marks a request whose handler already wrote a detailed audit entry
+const auditedKey = "audited"
+
+// Audit entity types
+const (
+	auditEntityShop         = "shop"
+	auditEntityTempShop     = "temp_shop"
+	auditEntityEntrepreneur = "entrepreneur"
+	auditEntityMarketMap    = "market_map"
+)
+
+// auditSnapshot encodes v for the Before or After column. nil stays NULL.
+func auditSnapshot(v interface{}) json.RawMessage {
+	if v == nil {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Println("Failed to encode audit snapshot:", err)
+		return nil
+	}
+	return data
+}
+
+// recordAudit appends an entry for the admin action handled by c. A failure to
+// write the entry is logged and does not undo the action.
+func recordAudit(db *gorm.DB, c *fiber.Ctx, action string, entityType string, entityID interface{}, before interface{}, after interface{}) {
+	entry := model.AuditLog{
+		Action:     action,
+		EntityType: entityType,
+		EntityID:   fmt.Sprint(entityID),
+		Before:     auditSnapshot(before),
+		After:      auditSnapshot(after),
+		IP:         c.IP(),
+		Method:     c.Method(),
+		Path:       c.Path(),
+	}
+	if claims, ok := middleware.GetClaims(c); ok {
+		entry.ActorID = claims.UserID()
+		entry.ActorRole = claims.Role
+		entry.ActorName = claims.Username
+	}
+	if err := db.Create(&entry).Error; err != nil {
+		log.Printf("Failed to write audit entry %s %s %v: %v", action, entityType, entityID, err)
+	}
+	c.Locals(auditedKey, true)
+}
+
+// shopSnapshot loads a shop with the data vendors care about for audit snapshots.
+// It returns nil when the shop does not exist.
+func shopSnapshot(db *gorm.DB, shopID uint) *model.Shop {
+	var shop model.Shop
+	if err := db.Preload("ShopMenus").Preload("SocialMedia").Preload("Photos").Preload("ShopOpenDates").
+		First(&shop, shopID).Error; err != nil {
+		return nil
+	}
+	return &shop
+}
+
+// auditTargets maps admin routes whose handlers do not write their own entry
+// to the record the route's ID names, so the generic entry still holds it as
+// it was before and after the request
+var auditTargets = map[string]func() interface{}{
+	"/patient/:id":                    func() interface{} { return &model.Patient{} },
+	"/admin/:id":                      func() interface{} { return &model.Admin{} },
+	"/entrepreneur/:id":               func() interface{} { return &model.Entrepreneur{} },
+	"/shopcategory/:id":               func() interface{} { return &model.ShopCategory{} },
+	"/workshops/:id":                  func() interface{} { return &model.Workshop{} },
+	"/marketDate/:id":                 func() interface{} { return &model.MarketOpenDate{} },
+	"/photos/:id":                     func() interface{} { return &model.Photo{} },
+	"/uploadphotos/:id":               func() interface{} { return &model.Photo{} },
+	"/contacts/:id":                   func() interface{} { return &model.ContactToAdmin{} },
+	"/tempshops/:id":                  func() interface{} { return &model.TempShop{} },
+	"/review/queue/:id/claim":         func() interface{} { return &model.TempShop{} },
+	"/shopmenu/:id":                   func() interface{} { return &model.ShopMenu{} },
+	"/social/:id":                     func() interface{} { return &model.SocialMedia{} },
+	"/shoptime/:id":                   func() interface{} { return &model.ShopOpenDate{} },
+	"/notifications/outbox/:id/retry": func() interface{} { return &model.Notification{} },
+}
+
+// auditTarget returns the loader and ID of the record path names, if its route is in auditTargets
+func auditTarget(path string) (func() interface{}, uint, bool) {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for pattern, target := range auditTargets {
+		parts := strings.Split(strings.Trim(pattern, "/"), "/")
+		if len(parts) != len(segments) {
+			continue
+		}
+		var id uint64
+		matched := true
+		for i, part := range parts {
+			if part == ":id" {
+				var err error
+				id, err = strconv.ParseUint(segments[i], 10, 32)
+				matched = matched && err == nil
+			} else {
+				matched = matched && part == segments[i]
+			}
+		}
+		if matched {
+			return target, uint(id), true
+		}
+	}
+	return nil, 0, false
+}
+
+// loadAuditTarget reads the record for a snapshot, nil when it does not exist
+func loadAuditTarget(db *gorm.DB, target func() interface{}, id uint) interface{} {
+	record := target()
+	if err := db.First(record, id).Error; err != nil {
+		return nil
+	}
+	return record
+}
+
+// AuditAdminMutations records every successful non-GET request made by an admin.
+// Handlers that record their own entry with before/after snapshots are skipped.
+// Routes in auditTargets are snapshotted before and after the request; for the
+// others the request body, with secrets removed, is stored as the After snapshot.
+func AuditAdminMutations(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		switch c.Method() {
+		case fiber.MethodGet, fiber.MethodHead, fiber.MethodOptions:
+			return c.Next()
+		}
+		// The route is only matched inside c.Next, so the target is found from the path
+		target, id, targeted := auditTarget(c.Path())
+		var before interface{}
+		if targeted {
+			before = loadAuditTarget(db, target, id)
+		}
+
+		err := c.Next()
+
+		claims, ok := middleware.GetClaims(c)
+		if !ok || claims.Role != middleware.RoleAdmin {
+			return err
+		}
+		if audited, _ := c.Locals(auditedKey).(bool); audited || err != nil || c.Response().StatusCode() >= 400 {
+			return err
+		}
+
+		// The first path segment names the entity, the route params identify it
+		route := c.Route().Path
+		entityType, _, _ := strings.Cut(strings.TrimPrefix(route, "/"), "/")
+		var ids []string
+		for _, name := range c.Route().Params {
+			ids = append(ids, c.Params(name))
+		}
+		after := redactedBody(c)
+		if targeted {
+			after = loadAuditTarget(db, target, id)
+		}
+		recordAudit(db, c, c.Method()+" "+route, entityType, strings.Join(ids, ","), before, after)
+		return nil
+	}
+}
+
+// redactedBody returns the JSON request body without password, secret, token and 2FA code fields
+func redactedBody(c *fiber.Ctx) interface{} {
+	if !strings.HasPrefix(c.Get(fiber.HeaderContentType), fiber.MIMEApplicationJSON) || len(c.Body()) == 0 {
+		return nil
+	}
+	var body interface{}
+	if err := json.Unmarshal(c.Body(), &body); err != nil {
+		return nil
+	}
+	return redact(body)
+}
+
+func redact(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for key, field := range value {
+			lower := strings.ToLower(key)
+			if lower == "code" || strings.Contains(lower, "password") || strings.Contains(lower, "secret") || strings.Contains(lower, "token") {
+				value[key] = "[redacted]"
+			} else {
+				value[key] = redact(field)
+			}
+		}
+	case []interface{}:
+		for i := range value {
+			value[i] = redact(value[i])
+		}
+	}
+	return v
+}
+
+// GetAuditLogs lists audit entries, newest first.
+// Filters: actor_id, actor_role, action, entity_type, entity_id, from and to
+// (RFC 3339 or YYYY-MM-DD), limit and offset.
+func GetAuditLogs(db *gorm.DB, c *fiber.Ctx) error {
+	var conditions []string
+	var args []interface{}
+	for _, column := range []string{"actor_id", "actor_role", "action", "entity_type", "entity_id"} {
+		if value := c.Query(column); value != "" {
+			conditions = append(conditions, column+" = ?")
+			args = append(args, value)
+		}
+	}
+	for _, bound := range []struct {
+		param string
+		op    string
+	}{{"from", ">="}, {"to", "<="}} {
+		raw := c.Query(bound.param)
+		if raw == "" {
+			continue
+		}
+		t, err := parseAuditTime(raw, bound.param == "to")
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": bound.param + " must be an RFC 3339 time or a YYYY-MM-DD date",
+			})
+		}
+		conditions = append(conditions, "created_at "+bound.op+" ?")
+		args = append(args, t)
+	}
+	filter := func(tx *gorm.DB) *gorm.DB {
+		if len(conditions) == 0 {
+			return tx
+		}
+		return tx.Where(strings.Join(conditions, " AND "), args...)
+	}
+
+	var total int64
+	if err := db.Model(&model.AuditLog{}).Scopes(filter).Count(&total).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to count audit logs",
+			"details": err.Error(),
+		})
+	}
+
+	var logs []model.AuditLog
+	if err := db.Scopes(filter).Order("id desc").Limit(c.QueryInt("limit", 100)).Offset(c.QueryInt("offset", 0)).
+		Find(&logs).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to retrieve audit logs",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(fiber.Map{"total": total, "logs": logs})
+}
+
+// parseAuditTime accepts RFC 3339 or a plain date. A plain date used as the
+// upper bound covers the whole day.
+func parseAuditTime(raw string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", raw, time.Local)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if endOfDay {
+		t = t.Add(24*time.Hour - time.Nanosecond)
+	}
+	return t, nil
+}
@@ -0,0 +1,440 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/HealthMe-pls/medic-go-api/model"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// A submission is approved automatically when every one of its pending
+// changes is covered by an enabled rule. It is then published through
+// publishSubmission like a reviewer's approval. Each check is recorded as an
+// AutoApprovalDecision, approved or not.
+
+type priceChangeParams struct {
+	MaxPercent float64 `json:"max_percent"`
+}
+
+type descriptionEditParams struct {
+	FlaggedWords []string `json:"flagged_words"`
+}
+
+// futureOpenTimeParams covers open times on market dates at least
+// MinDaysAhead days away, and never today's market. An update must leave and
+// land on such dates.
+type futureOpenTimeParams struct {
+	MinDaysAhead int `json:"min_days_ahead"`
+}
+
+// ruleParams returns the params type of a rule kind
+func ruleParams(kind string) (interface{}, bool) {
+	switch kind {
+	case model.RulePriceChange:
+		return &priceChangeParams{}, true
+	case model.RuleDescriptionEdit:
+		return &descriptionEditParams{}, true
+	case model.RuleFutureOpenTime:
+		return &futureOpenTimeParams{}, true
+	}
+	return nil, false
+}
+
+// validateRule checks the kind and params of rule
+func validateRule(rule model.AutoApprovalRule) error {
+	if strings.TrimSpace(rule.Name) == "" {
+		return errors.New("name is required")
+	}
+	params, ok := ruleParams(rule.Kind)
+	if !ok {
+		return fmt.Errorf("kind must be one of %s, %s or %s", model.RulePriceChange, model.RuleDescriptionEdit, model.RuleFutureOpenTime)
+	}
+	if len(rule.Params) > 0 {
+		decoder := json.NewDecoder(bytes.NewReader(rule.Params))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(params); err != nil {
+			return fmt.Errorf("invalid params: %v", err)
+		}
+	}
+	switch p := params.(type) {
+	case *priceChangeParams:
+		if p.MaxPercent <= 0 {
+			return errors.New("max_percent must be greater than 0")
+		}
+	case *futureOpenTimeParams:
+		if p.MinDaysAhead < 0 {
+			return errors.New("min_days_ahead cannot be negative")
+		}
+	}
+	return nil
+}
+
+// ruleMatch is one pending change and the rule that covered it. RuleID is
+// empty when no rule did; Note says why.
+type ruleMatch struct {
+	OpID   uint   `json:"op_id"`
+	Entity string `json:"entity"`
+	Action string `json:"action"`
+	RuleID *uint  `json:"rule_id"`
+	Rule   string `json:"rule,omitempty"`
+	Note   string `json:"note"`
+}
+
+// evaluateAutoApproval checks the shop's pending changes against the enabled
+// rules and reports whether all of them are covered
+func evaluateAutoApproval(db *gorm.DB, shopID uint) (bool, []ruleMatch, error) {
+	var rules []model.AutoApprovalRule
+	if err := db.Where("enabled = ?", true).Order("id").Find(&rules).Error; err != nil {
+		return false, nil, err
+	}
+	ops, err := draftOps(db, shopID)
+	if err != nil {
+		return false, nil, err
+	}
+
+	matches := []ruleMatch{}
+	covered := len(ops) > 0
+	for _, op := range ops {
+		match := ruleMatch{OpID: op.ID, Entity: op.Entity, Action: op.Action, Note: "no rule covers this change"}
+		for _, rule := range rules {
+			ok, note, err := matchRule(db, rule, op)
+			if err != nil {
+				return false, nil, err
+			}
+			if ok {
+				ruleID := rule.ID
+				match.RuleID, match.Rule, match.Note = &ruleID, rule.Name, note
+				break
+			}
+			if note != "" {
+				match.Note = note
+			}
+		}
+		covered = covered && match.RuleID != nil
+		matches = append(matches, match)
+	}
+	return covered, matches, nil
+}
+
+// matchRule reports whether rule covers op. A note explains the outcome when
+// the op is of the rule's kind.
+func matchRule(db *gorm.DB, rule model.AutoApprovalRule, op model.ChangeOp) (bool, string, error) {
+	params, _ := ruleParams(rule.Kind)
+	if len(rule.Params) > 0 {
+		if err := json.Unmarshal(rule.Params, params); err != nil {
+			return false, "", fmt.Errorf("rule %d has invalid params: %w", rule.ID, err)
+		}
+	}
+
+	switch p := params.(type) {
+	case *priceChangeParams:
+		if op.Entity != model.ChangeEntityMenu || op.Action != model.ChangeUpdate {
+			return false, "", nil
+		}
+		var menu model.ShopMenu
+		if err := db.First(&menu, *op.EntityID).Error; err != nil {
+			return false, "", err
+		}
+		var payload menuPayload
+		if err := decodePayload(op, &payload); err != nil {
+			return false, "", err
+		}
+		if payload.ProductName != menu.ProductName || payload.ProductDescription != menu.ProductDescription {
+			return false, "", nil
+		}
+		if menu.Price <= 0 {
+			return false, "the current price is zero", nil
+		}
+		percent := (payload.Price - menu.Price) / menu.Price * 100
+		note := fmt.Sprintf("price %.2f to %.2f (%+.1f%%)", menu.Price, payload.Price, percent)
+		return math.Abs(percent) <= p.MaxPercent, note, nil
+
+	case *descriptionEditParams:
+		if op.Action != model.ChangeUpdate {
+			return false, "", nil
+		}
+		var description string
+		switch op.Entity {
+		case model.ChangeEntityShop:
+			var shop model.Shop
+			if err := db.First(&shop, *op.EntityID).Error; err != nil {
+				return false, "", err
+			}
+			var payload shopPayload
+			if err := decodePayload(op, &payload); err != nil {
+				return false, "", err
+			}
+			if payload.Name != shop.Name || payload.ShopCategoryID != shop.ShopCategoryID {
+				return false, "", nil
+			}
+			description = payload.Description
+		case model.ChangeEntityMenu:
+			var menu model.ShopMenu
+			if err := db.First(&menu, *op.EntityID).Error; err != nil {
+				return false, "", err
+			}
+			var payload menuPayload
+			if err := decodePayload(op, &payload); err != nil {
+				return false, "", err
+			}
+			if payload.ProductName != menu.ProductName || payload.Price != menu.Price {
+				return false, "", nil
+			}
+			description = payload.ProductDescription
+		default:
+			return false, "", nil
+		}
+		lower := strings.ToLower(description)
+		for _, word := range p.FlaggedWords {
+			if word = strings.ToLower(strings.TrimSpace(word)); word != "" && strings.Contains(lower, word) {
+				return false, fmt.Sprintf("description contains flagged word %q", word), nil
+			}
+		}
+		return true, "description edit", nil
+
+	case *futureOpenTimeParams:
+		if op.Entity != model.ChangeEntityOpenTime {
+			return false, "", nil
+		}
+		// An update moves the slot off one market date and onto another; both
+		// must be far enough ahead, or a near booking changes unreviewed
+		var marketOpenDateIDs []uint
+		if op.Action != model.ChangeCreate {
+			var live model.ShopOpenDate
+			if err := db.First(&live, *op.EntityID).Error; err != nil {
+				return false, "", err
+			}
+			marketOpenDateIDs = append(marketOpenDateIDs, live.MarketOpenDateID)
+		}
+		if op.Action != model.ChangeDelete {
+			var payload openTimePayload
+			if err := decodePayload(op, &payload); err != nil {
+				return false, "", err
+			}
+			marketOpenDateIDs = append(marketOpenDateIDs, payload.MarketOpenDateID)
+		}
+		now := time.Now()
+		today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+		earliest := today.AddDate(0, 0, max(p.MinDaysAhead, 1))
+		var days []string
+		ok := true
+		for _, marketOpenDateID := range marketOpenDateIDs {
+			var market model.MarketOpenDate
+			if err := db.First(&market, marketOpenDateID).Error; err != nil {
+				return false, "", err
+			}
+			marketDay := time.Date(market.Date.Year(), market.Date.Month(), market.Date.Day(), 0, 0, 0, 0, now.Location())
+			days = append(days, marketDay.Format("2006-01-02"))
+			ok = ok && !marketDay.Before(earliest)
+		}
+		return ok, "market date " + strings.Join(days, " to "), nil
+	}
+	return false, "", nil
+}
+
+// errNotCovered means a change of the submission matches no enabled rule
+var errNotCovered = errors.New("not every change is covered by a rule")
+
+// autoApprove checks a freshly submitted round against the rules and
+// publishes it when they cover every change. The rules are checked again
+// under the submission's lock, and a reviewer who picked it up in the
+// meantime wins. The decision is returned and recorded either way.
+func autoApprove(db *gorm.DB, c *fiber.Ctx, tempShop model.TempShop, round model.ReviewRound) (model.AutoApprovalDecision, error) {
+	decision := model.AutoApprovalDecision{TempID: tempShop.TempID, Round: round.Round}
+	if tempShop.ShopID == nil {
+		return decision, errors.New("TempShop has no associated shop")
+	}
+
+	approved, matches, err := evaluateAutoApproval(db, *tempShop.ShopID)
+	if err != nil {
+		return decision, err
+	}
+	if decision.Matches, err = json.Marshal(matches); err != nil {
+		return decision, err
+	}
+	if !approved {
+		decision.Reason = errNotCovered.Error()
+		return decision, db.Create(&decision).Error
+	}
+
+	shopBefore := shopSnapshot(db, *tempShop.ShopID)
+	var files []string
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&tempShop, "temp_id = ?", tempShop.TempID).Error; err != nil {
+			return err
+		}
+		if tempShop.Status != model.StatusSubmitted {
+			return &TransitionError{From: tempShop.Status, To: model.StatusApproved}
+		}
+		// The changes may have moved since the first look; publish only what the rules cover now
+		approved, matches, err = evaluateAutoApproval(tx, *tempShop.ShopID)
+		if err != nil {
+			return err
+		}
+		if decision.Matches, err = json.Marshal(matches); err != nil {
+			return err
+		}
+		if !approved {
+			return errNotCovered
+		}
+		var rules []string
+		for _, match := range matches {
+			rules = append(rules, match.Rule)
+		}
+		reason := "Approved automatically by " + strings.Join(uniqueStrings(rules), ", ")
+		if files, _, err = publishSubmission(tx, systemActor, 0, &tempShop, reason); err != nil {
+			return err
+		}
+		decision.Approved = true
+		return tx.Create(&decision).Error
+	})
+	if errors.Is(err, errNotCovered) {
+		decision.Reason = err.Error()
+		return decision, db.Create(&decision).Error
+	}
+	if err != nil {
+		// The submission stays in the queue for a reviewer
+		log.Printf("Auto-approval of TempShop %d failed: %v", tempShop.TempID, err)
+		decision.Approved = false
+		decision.Reason = err.Error()
+		return decision, db.Create(&decision).Error
+	}
+	removeUploads(files)
+	recordAudit(db, c, "shop.auto_approve", auditEntityTempShop, tempShop.TempID, shopBefore, fiber.Map{
+		"matches": matches,
+		"shop":    shopSnapshot(db, *tempShop.ShopID),
+	})
+	return decision, nil
+}
+
+func uniqueStrings(values []string) []string {
+	seen := map[string]bool{}
+	var unique []string
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			unique = append(unique, value)
+		}
+	}
+	return unique
+}
+
+// GetAutoApprovalRules lists every rule, enabled or not
+func GetAutoApprovalRules(db *gorm.DB, c *fiber.Ctx) error {
+	var rules []model.AutoApprovalRule
+	if err := db.Order("id").Find(&rules).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to retrieve rules",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(rules)
+}
+
+// CreateAutoApprovalRule adds a rule.
+// Body: {"name": "Small price changes", "kind": "price_change", "params": {"max_percent": 10}, "enabled": true}
+func CreateAutoApprovalRule(db *gorm.DB, c *fiber.Ctx) error {
+	var rule model.AutoApprovalRule
+	if err := c.BodyParser(&rule); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	rule.ID = 0
+	if err := validateRule(rule); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := db.Create(&rule).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to create rule",
+			"details": err.Error(),
+		})
+	}
+	recordAudit(db, c, "autoapproval.rule_create", "auto_approval_rule", rule.ID, nil, rule)
+	return c.Status(fiber.StatusCreated).JSON(rule)
+}
+
+// UpdateAutoApprovalRule replaces the fields given in the body
+func UpdateAutoApprovalRule(db *gorm.DB, c *fiber.Ctx) error {
+	var rule model.AutoApprovalRule
+	if err := db.First(&rule, c.Params("id")).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Rule not found"})
+	}
+	before := rule
+	if err := c.BodyParser(&rule); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	rule.ID = before.ID
+	if err := validateRule(rule); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := db.Save(&rule).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to update rule",
+			"details": err.Error(),
+		})
+	}
+	recordAudit(db, c, "autoapproval.rule_update", "auto_approval_rule", rule.ID, before, rule)
+	return c.JSON(rule)
+}
+
+// DeleteAutoApprovalRule removes a rule. Past decisions keep its ID.
+func DeleteAutoApprovalRule(db *gorm.DB, c *fiber.Ctx) error {
+	var rule model.AutoApprovalRule
+	if err := db.First(&rule, c.Params("id")).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Rule not found"})
+	}
+	if err := db.Delete(&rule).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to delete rule",
+			"details": err.Error(),
+		})
+	}
+	recordAudit(db, c, "autoapproval.rule_delete", "auto_approval_rule", rule.ID, rule, nil)
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// GetAutoApprovalDecisions lists recorded decisions, newest first. ?temp_id filters by TempShop.
+func GetAutoApprovalDecisions(db *gorm.DB, c *fiber.Ctx) error {
+	query := db.Order("id desc").Limit(c.QueryInt("limit", 100)).Offset(c.QueryInt("offset", 0))
+	if tempID := c.Query("temp_id"); tempID != "" {
+		query = query.Where("temp_id = ?", tempID)
+	}
+	var decisions []model.AutoApprovalDecision
+	if err := query.Find(&decisions).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to retrieve decisions",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(decisions)
+}
+
+// CheckAutoApproval shows how the rules would judge a TempShop's pending
+// changes right now, without recording or publishing anything
+func CheckAutoApproval(db *gorm.DB, c *fiber.Ctx) error {
+	tempShop, err := reviewTempShop(db, c)
+	if err != nil || tempShop.ShopID == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Temp shop not found"})
+	}
+	approved, matches, err := evaluateAutoApproval(db, *tempShop.ShopID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to evaluate rules",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(fiber.Map{
+		"temp_id":  tempShop.TempID,
+		"status":   tempShop.Status,
+		"approved": approved,
+		"matches":  matches,
+	})
+}
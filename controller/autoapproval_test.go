@@ -0,0 +1,140 @@
+package controller
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/HealthMe-pls/medic-go-api/model"
+)
+
+func testOp(entity string, action string, entityID uint, payload interface{}) model.ChangeOp {
+	op := model.ChangeOp{Entity: entity, Action: action}
+	if entityID != 0 {
+		op.EntityID = &entityID
+	}
+	if payload != nil {
+		op.Payload, _ = json.Marshal(payload)
+	}
+	return op
+}
+
+func testRule(kind string, params string) model.AutoApprovalRule {
+	return model.AutoApprovalRule{ID: 1, Name: kind, Kind: kind, Params: json.RawMessage(params), Enabled: true}
+}
+
+func TestMatchRule(t *testing.T) {
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	tables := fakeTables{
+		"shop_menus": {
+			{"id": int64(1), "product_name": "Tea", "product_description": "Green tea", "price": 100.0, "shop_id": int64(1)},
+			{"id": int64(2), "product_name": "Sample", "product_description": "Free", "price": 0.0, "shop_id": int64(1)},
+		},
+		"shops": {
+			{"id": int64(1), "name": "Tea House", "description": "Teas", "shop_category_id": int64(3)},
+		},
+		"market_open_dates": {
+			{"id": int64(10), "date": today},
+			{"id": int64(11), "date": today.AddDate(0, 0, 3)},
+			{"id": int64(12), "date": today.AddDate(0, 0, 10)},
+			{"id": int64(13), "date": today.AddDate(0, 0, 20)},
+		},
+		"shop_open_dates": {
+			{"id": int64(21), "shop_id": int64(1), "market_open_date_id": int64(11)},
+			{"id": int64(22), "shop_id": int64(1), "market_open_date_id": int64(12)},
+		},
+	}
+	db := fakeDB(t, tables)
+
+	price := testRule(model.RulePriceChange, `{"max_percent": 10}`)
+	description := testRule(model.RuleDescriptionEdit, `{"flagged_words": ["promo"]}`)
+	futureWeek := testRule(model.RuleFutureOpenTime, `{"min_days_ahead": 7}`)
+	futureAny := testRule(model.RuleFutureOpenTime, `{"min_days_ahead": 0}`)
+	openTime := func(marketOpenDateID uint) openTimePayload {
+		return openTimePayload{MarketOpenDateID: marketOpenDateID, StartTime: today.Add(9 * time.Hour), EndTime: today.Add(17 * time.Hour)}
+	}
+
+	tests := []struct {
+		name     string
+		rule     model.AutoApprovalRule
+		op       model.ChangeOp
+		want     bool
+		wantNote string
+	}{
+		{"price within limit", price,
+			testOp(model.ChangeEntityMenu, model.ChangeUpdate, 1, menuPayload{"Tea", "Green tea", 105}), true, "price 100.00 to 105.00 (+5.0%)"},
+		{"price drop within limit", price,
+			testOp(model.ChangeEntityMenu, model.ChangeUpdate, 1, menuPayload{"Tea", "Green tea", 90}), true, "price 100.00 to 90.00 (-10.0%)"},
+		{"price over limit", price,
+			testOp(model.ChangeEntityMenu, model.ChangeUpdate, 1, menuPayload{"Tea", "Green tea", 120}), false, "price 100.00 to 120.00 (+20.0%)"},
+		{"price with a renamed menu", price,
+			testOp(model.ChangeEntityMenu, model.ChangeUpdate, 1, menuPayload{"Black tea", "Green tea", 101}), false, ""},
+		{"price from zero", price,
+			testOp(model.ChangeEntityMenu, model.ChangeUpdate, 2, menuPayload{"Sample", "Free", 10}), false, "the current price is zero"},
+		{"price rule ignores new menus", price,
+			testOp(model.ChangeEntityMenu, model.ChangeCreate, 0, menuPayload{"Tea", "Green tea", 100}), false, ""},
+
+		{"shop description", description,
+			testOp(model.ChangeEntityShop, model.ChangeUpdate, 1, shopPayload{"Tea House", "Teas and cakes", 3}), true, "description edit"},
+		{"shop description with a flagged word", description,
+			testOp(model.ChangeEntityShop, model.ChangeUpdate, 1, shopPayload{"Tea House", "PROMO teas", 3}), false, `description contains flagged word "promo"`},
+		{"shop rename", description,
+			testOp(model.ChangeEntityShop, model.ChangeUpdate, 1, shopPayload{"Cake House", "Teas", 3}), false, ""},
+		{"menu description", description,
+			testOp(model.ChangeEntityMenu, model.ChangeUpdate, 1, menuPayload{"Tea", "Jasmine tea", 100}), true, "description edit"},
+		{"menu description with a new price", description,
+			testOp(model.ChangeEntityMenu, model.ChangeUpdate, 1, menuPayload{"Tea", "Jasmine tea", 110}), false, ""},
+		{"description rule ignores socials", description,
+			testOp(model.ChangeEntitySocial, model.ChangeUpdate, 5, socialPayload{"Tea", "line", "x"}), false, ""},
+
+		{"new open time far ahead", futureWeek,
+			testOp(model.ChangeEntityOpenTime, model.ChangeCreate, 0, openTime(12)), true, "market date " + today.AddDate(0, 0, 10).Format("2006-01-02")},
+		{"new open time too soon", futureWeek,
+			testOp(model.ChangeEntityOpenTime, model.ChangeCreate, 0, openTime(11)), false, "market date " + today.AddDate(0, 0, 3).Format("2006-01-02")},
+		{"open time moved off a near date", futureWeek,
+			testOp(model.ChangeEntityOpenTime, model.ChangeUpdate, 21, openTime(13)), false,
+			"market date " + today.AddDate(0, 0, 3).Format("2006-01-02") + " to " + today.AddDate(0, 0, 20).Format("2006-01-02")},
+		{"open time moved between far dates", futureWeek,
+			testOp(model.ChangeEntityOpenTime, model.ChangeUpdate, 22, openTime(13)), true,
+			"market date " + today.AddDate(0, 0, 10).Format("2006-01-02") + " to " + today.AddDate(0, 0, 20).Format("2006-01-02")},
+		{"open time dropped from a near date", futureWeek,
+			testOp(model.ChangeEntityOpenTime, model.ChangeDelete, 21, nil), false, "market date " + today.AddDate(0, 0, 3).Format("2006-01-02")},
+		{"never today's market", futureAny,
+			testOp(model.ChangeEntityOpenTime, model.ChangeCreate, 0, openTime(10)), false, "market date " + today.Format("2006-01-02")},
+		{"a later market with no minimum", futureAny,
+			testOp(model.ChangeEntityOpenTime, model.ChangeCreate, 0, openTime(11)), true, "market date " + today.AddDate(0, 0, 3).Format("2006-01-02")},
+		{"open time rule ignores menus", futureWeek,
+			testOp(model.ChangeEntityMenu, model.ChangeUpdate, 1, menuPayload{"Tea", "Green tea", 100}), false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, note, err := matchRule(db, tt.rule, tt.op)
+			if err != nil {
+				t.Fatalf("matchRule() error = %v", err)
+			}
+			if got != tt.want || note != tt.wantNote {
+				t.Errorf("matchRule() = %v, %q, want %v, %q", got, note, tt.want, tt.wantNote)
+			}
+		})
+	}
+}
+
+func TestMatchRuleInvalidParams(t *testing.T) {
+	db := fakeDB(t, fakeTables{})
+	rule := testRule(model.RulePriceChange, `{"max_percent": "ten"}`)
+	op := testOp(model.ChangeEntityMenu, model.ChangeUpdate, 1, menuPayload{"Tea", "Green tea", 105})
+	if _, _, err := matchRule(db, rule, op); err == nil {
+		t.Error("matchRule() with invalid params returned no error")
+	}
+}
+
+func TestMatchRuleMissingRecord(t *testing.T) {
+	db := fakeDB(t, fakeTables{"shop_menus": []map[string]driver.Value{}})
+	rule := testRule(model.RulePriceChange, `{"max_percent": 10}`)
+	op := testOp(model.ChangeEntityMenu, model.ChangeUpdate, 99, menuPayload{"Tea", "Green tea", 105})
+	if _, _, err := matchRule(db, rule, op); err == nil {
+		t.Error("matchRule() for a menu that does not exist returned no error")
+	}
+}
@@ -0,0 +1,818 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/HealthMe-pls/medic-go-api/model"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// Change set sources
+const (
+	changeSourceEntrepreneur = "entrepreneur"
+	changeSourceRollback     = "rollback"
+	changeSourceLegacy       = "legacy"
+)
+
+// changeActions lists what can be done to each entity. Photos are replaced, not edited.
+var changeActions = map[string]map[string]bool{
+	model.ChangeEntityShop:     {model.ChangeUpdate: true},
+	model.ChangeEntityMenu:     {model.ChangeCreate: true, model.ChangeUpdate: true, model.ChangeDelete: true},
+	model.ChangeEntitySocial:   {model.ChangeCreate: true, model.ChangeUpdate: true, model.ChangeDelete: true},
+	model.ChangeEntityPhoto:    {model.ChangeCreate: true, model.ChangeDelete: true},
+	model.ChangeEntityOpenTime: {model.ChangeCreate: true, model.ChangeUpdate: true, model.ChangeDelete: true},
+}
+
+// Op payloads. Creates and updates carry every field of the new value; deletes carry none.
+type shopPayload struct {
+	Name           string `json:"name"`
+	Description    string `json:"description"`
+	ShopCategoryID uint   `json:"shop_category_id"`
+}
+
+type menuPayload struct {
+	ProductName        string  `json:"product_name"`
+	ProductDescription string  `json:"product_description"`
+	Price              float64 `json:"price"`
+}
+
+type socialPayload struct {
+	Name     string `json:"name"`
+	Platform string `json:"platform"`
+	Link     string `json:"link"`
+}
+
+// photoPayload hangs a photo off the shop or a live menu. A photo for a menu
+// that is still pending uses the op's RefOpID instead.
+type photoPayload struct {
+	PathFile string `json:"path_file"`
+	ShopID   *uint  `json:"shop_id,omitempty"`
+	MenuID   *uint  `json:"menu_id,omitempty"`
+}
+
+type openTimePayload struct {
+	MarketOpenDateID uint      `json:"market_open_date_id"`
+	StartTime        time.Time `json:"start_time"`
+	EndTime          time.Time `json:"end_time"`
+}
+
+// ChangeOpError is why one op cannot be staged or applied. Open times that
+// break the market schedule also carry the broken rules and a suggestion.
+type ChangeOpError struct {
+	OpID       uint            `json:"op_id,omitempty"`
+	Entity     string          `json:"entity"`
+	Action     string          `json:"action"`
+	Error      string          `json:"error"`
+	Details    []SlotError     `json:"details,omitempty"`
+	Suggestion *SlotSuggestion `json:"suggestion,omitempty"`
+}
+
+// changeErrors is returned by the validator and carries every failing op
+type changeErrors []ChangeOpError
+
+func (e changeErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, opErr := range e {
+		messages[i] = fmt.Sprintf("%s %s: %s", opErr.Action, opErr.Entity, opErr.Error)
+	}
+	return strings.Join(messages, "; ")
+}
+
+func opError(op model.ChangeOp, format string, args ...interface{}) changeErrors {
+	return changeErrors{{OpID: op.ID, Entity: op.Entity, Action: op.Action, Error: fmt.Sprintf(format, args...)}}
+}
+
+// newChangeOp builds a pending op; payload is marshalled as the op's JSON payload
+func newChangeOp(entity string, action string, entityID *uint, payload interface{}) (model.ChangeOp, error) {
+	op := model.ChangeOp{Entity: entity, Action: action, EntityID: entityID, Status: model.ChangeOpPending}
+	if payload == nil {
+		op.Payload = json.RawMessage("null")
+		return op, nil
+	}
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return op, err
+	}
+	op.Payload = raw
+	return op, nil
+}
+
+// decodePayload reads an op's payload into v, rejecting fields the entity does not have
+func decodePayload(op model.ChangeOp, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(op.Payload))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("invalid payload: %w", err)
+	}
+	return nil
+}
+
+// ==================== CHANGE SETS ====================
+
+// findOpenChangeSet returns the shop's open change set, if it has one
+func findOpenChangeSet(db *gorm.DB, shopID uint) (model.ChangeSet, bool, error) {
+	var cs model.ChangeSet
+	err := db.Where("shop_id = ? AND status = ?", shopID, model.ChangeSetOpen).Order("id desc").First(&cs).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return cs, false, nil
+	}
+	return cs, err == nil, err
+}
+
+// openChangeSet returns the shop's open change set, starting one on its TempShop when there is none
+func openChangeSet(db *gorm.DB, shopID uint, source string) (model.ChangeSet, error) {
+	cs, ok, err := findOpenChangeSet(db, shopID)
+	if err != nil || ok {
+		return cs, err
+	}
+	var tempShop model.TempShop
+	if err := db.First(&tempShop, "shop_id = ?", shopID).Error; err != nil {
+		return cs, fmt.Errorf("TempShop not found for ShopID %d: %w", shopID, err)
+	}
+	cs = model.ChangeSet{ShopID: shopID, TempID: tempShop.TempID, Status: model.ChangeSetOpen, Source: source}
+	err = db.Create(&cs).Error
+	return cs, err
+}
+
+// pendingOps returns the ops of a change set that are still waiting, in the order they were staged
+func pendingOps(db *gorm.DB, changeSetID uint) ([]model.ChangeOp, error) {
+	var ops []model.ChangeOp
+	err := db.Where("change_set_id = ? AND status = ?", changeSetID, model.ChangeOpPending).Order("id").Find(&ops).Error
+	return ops, err
+}
+
+// pendingOpsOfShop returns the pending ops of the shop's open change set, if any
+func pendingOpsOfShop(db *gorm.DB, shopID uint) ([]model.ChangeOp, error) {
+	cs, ok, err := findOpenChangeSet(db, shopID)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return pendingOps(db, cs.ID)
+}
+
+// stageChange adds op to cs. A later change to a record replaces the pending
+// one: a second edit overwrites the first and a delete drops an earlier edit.
+// Deleting a record twice returns the pending delete.
+func stageChange(tx *gorm.DB, cs model.ChangeSet, op model.ChangeOp) (model.ChangeOp, error) {
+	pending, err := pendingOps(tx, cs.ID)
+	if err != nil {
+		return op, err
+	}
+	op.ChangeSetID = cs.ID
+	op.Status = model.ChangeOpPending
+
+	var replaced *model.ChangeOp
+	for i := range pending {
+		existing := pending[i]
+		if existing.Entity != op.Entity || !sameTarget(existing, op) {
+			continue
+		}
+		switch {
+		case existing.Action == model.ChangeDelete && op.Action == model.ChangeDelete:
+			return existing, nil
+		case existing.Action == model.ChangeDelete:
+			return op, opError(op, "%s %d is already staged for deletion", op.Entity, *op.EntityID)
+		case op.Action == existing.Action:
+			// Same record, same action: the new payload wins
+			op.ID, op.CreatedAt = existing.ID, existing.CreatedAt
+		default:
+			replaced = &existing
+		}
+		pending = append(pending[:i], pending[i+1:]...)
+		break
+	}
+
+	if err := validateChangeOp(tx, cs.ShopID, op, append(pending, op)); err != nil {
+		return op, err
+	}
+	if replaced != nil {
+		if err := tx.Model(replaced).Update("status", model.ChangeOpDiscarded).Error; err != nil {
+			return op, err
+		}
+	}
+	if err := tx.Save(&op).Error; err != nil {
+		return op, err
+	}
+	return op, nil
+}
+
+// sameTarget reports whether two ops of one entity change the same record.
+// Pending open time creates are matched by market date.
+func sameTarget(a model.ChangeOp, b model.ChangeOp) bool {
+	if a.EntityID != nil && b.EntityID != nil {
+		return *a.EntityID == *b.EntityID
+	}
+	if a.Entity == model.ChangeEntityOpenTime && a.Action == model.ChangeCreate && b.Action == model.ChangeCreate {
+		var pa, pb openTimePayload
+		return decodePayload(a, &pa) == nil && decodePayload(b, &pb) == nil && pa.MarketOpenDateID == pb.MarketOpenDateID
+	}
+	return false
+}
+
+// discardChangeSet drops the shop's open change set and returns the files of
+// photos that were uploaded only for it, to remove after commit
+func discardChangeSet(tx *gorm.DB, shopID uint) ([]string, error) {
+	cs, ok, err := findOpenChangeSet(tx, shopID)
+	if err != nil || !ok {
+		return nil, err
+	}
+	ops, err := pendingOps(tx, cs.ID)
+	if err != nil {
+		return nil, err
+	}
+	files, err := discardOps(tx, ops)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	return files, tx.Model(&cs).Updates(map[string]interface{}{"status": model.ChangeSetDiscarded, "closed_at": now}).Error
+}
+
+// discardOps marks ops discarded and returns the upload files nothing else uses
+func discardOps(tx *gorm.DB, ops []model.ChangeOp) ([]string, error) {
+	var files []string
+	for _, op := range ops {
+		if err := tx.Model(&op).Update("status", model.ChangeOpDiscarded).Error; err != nil {
+			return nil, err
+		}
+		if op.Entity != model.ChangeEntityPhoto || op.Action != model.ChangeCreate {
+			continue
+		}
+		var payload photoPayload
+		if decodePayload(op, &payload) != nil || payload.PathFile == "" {
+			continue
+		}
+		// Uploads are stored by file name, so a live photo may share the file
+		var count int64
+		if err := tx.Model(&model.Photo{}).Where("path_file = ?", payload.PathFile).Count(&count).Error; err != nil {
+			return nil, err
+		}
+		if count == 0 {
+			files = append(files, payload.PathFile)
+		}
+	}
+	return files, nil
+}
+
+// ==================== VALIDATOR ====================
+
+// validateChangeOps checks ops as one batch: each op on its own, and no two
+// ops changing the same record
+func validateChangeOps(db *gorm.DB, shopID uint, ops []model.ChangeOp) error {
+	var errs changeErrors
+	seen := map[string]bool{}
+	for _, op := range ops {
+		if err := validateChangeOp(db, shopID, op, ops); err != nil {
+			var opErrs changeErrors
+			if !errors.As(err, &opErrs) {
+				return err
+			}
+			errs = append(errs, opErrs...)
+			continue
+		}
+		if op.EntityID != nil {
+			target := fmt.Sprintf("%s:%d", op.Entity, *op.EntityID)
+			if seen[target] {
+				errs = append(errs, opError(op, "%s %d has more than one pending change", op.Entity, *op.EntityID)...)
+			}
+			seen[target] = true
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// validateChangeOp checks that op fits its entity and that what it points at
+// exists and belongs to the shop. set is the batch op is staged or applied
+// with; a photo may only reference a menu created in that batch or already applied.
+// Problems with the op come back as changeErrors, database failures as plain errors.
+func validateChangeOp(db *gorm.DB, shopID uint, op model.ChangeOp, set []model.ChangeOp) error {
+	if !changeActions[op.Entity][op.Action] {
+		return opError(op, "cannot %s a %s", op.Action, op.Entity)
+	}
+	if op.Action == model.ChangeCreate && op.EntityID != nil {
+		return opError(op, "a create must not name an existing record")
+	}
+	if op.Action != model.ChangeCreate && op.EntityID == nil {
+		return opError(op, "entity_id is required")
+	}
+	if op.RefOpID != nil && (op.Entity != model.ChangeEntityPhoto || op.Action != model.ChangeCreate) {
+		return opError(op, "only a new photo can reference another change")
+	}
+
+	// Update and delete targets must be live records of this shop
+	if op.Action != model.ChangeCreate {
+		owner, err := shopOfTarget(db, op.Entity, *op.EntityID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return opError(op, "%s %d not found", op.Entity, *op.EntityID)
+		}
+		if err != nil {
+			return err
+		}
+		if owner != shopID {
+			return opError(op, "%s %d belongs to another shop", op.Entity, *op.EntityID)
+		}
+	}
+	if op.Action == model.ChangeDelete {
+		return nil
+	}
+
+	switch op.Entity {
+	case model.ChangeEntityShop:
+		var payload shopPayload
+		if err := decodePayload(op, &payload); err != nil {
+			return opError(op, "%v", err)
+		}
+		if strings.TrimSpace(payload.Name) == "" {
+			return opError(op, "name is required")
+		}
+		var category model.ShopCategory
+		if err := db.First(&category, payload.ShopCategoryID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return opError(op, "shop category %d not found", payload.ShopCategoryID)
+			}
+			return err
+		}
+
+	case model.ChangeEntityMenu:
+		var payload menuPayload
+		if err := decodePayload(op, &payload); err != nil {
+			return opError(op, "%v", err)
+		}
+		if strings.TrimSpace(payload.ProductName) == "" {
+			return opError(op, "product_name is required")
+		}
+		if payload.Price < 0 {
+			return opError(op, "price must not be negative")
+		}
+
+	case model.ChangeEntitySocial:
+		var payload socialPayload
+		if err := decodePayload(op, &payload); err != nil {
+			return opError(op, "%v", err)
+		}
+		if strings.TrimSpace(payload.Link) == "" {
+			return opError(op, "link is required")
+		}
+
+	case model.ChangeEntityPhoto:
+		return validatePhotoOp(db, shopID, op, set)
+
+	case model.ChangeEntityOpenTime:
+		var payload openTimePayload
+		if err := decodePayload(op, &payload); err != nil {
+			return opError(op, "%v", err)
+		}
+		others, err := plannedSlots(db, shopID, op, set)
+		if err != nil {
+			return err
+		}
+		problem, err := validateSlot(db, slot(payload), others)
+		if err != nil {
+			return err
+		}
+		if problem != nil {
+			errs := opError(op, "%v", problem)
+			errs[0].Details, errs[0].Suggestion = problem.Errors, problem.Suggestion
+			return errs
+		}
+	}
+	return nil
+}
+
+func validatePhotoOp(db *gorm.DB, shopID uint, op model.ChangeOp, set []model.ChangeOp) error {
+	var payload photoPayload
+	if err := decodePayload(op, &payload); err != nil {
+		return opError(op, "%v", err)
+	}
+	if payload.PathFile == "" {
+		return opError(op, "path_file is required")
+	}
+	if _, err := os.Stat(fmt.Sprintf("./uploads/%s", payload.PathFile)); err != nil {
+		return opError(op, "uploaded file %s not found", payload.PathFile)
+	}
+
+	targets := 0
+	for _, has := range []bool{payload.ShopID != nil, payload.MenuID != nil, op.RefOpID != nil} {
+		if has {
+			targets++
+		}
+	}
+	if targets != 1 {
+		return opError(op, "a photo belongs to exactly one of shop_id, menu_id or a pending menu")
+	}
+
+	switch {
+	case payload.ShopID != nil && *payload.ShopID != shopID:
+		return opError(op, "photo must belong to shop %d", shopID)
+	case payload.MenuID != nil:
+		owner, err := shopOfMenu(db, *payload.MenuID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return opError(op, "menu %d not found", *payload.MenuID)
+		}
+		if err != nil {
+			return err
+		}
+		if owner != shopID {
+			return opError(op, "menu %d belongs to another shop", *payload.MenuID)
+		}
+	case op.RefOpID != nil:
+		for _, other := range set {
+			if other.ID == *op.RefOpID && other.ID != 0 {
+				if other.Entity != model.ChangeEntityMenu || other.Action != model.ChangeCreate {
+					return opError(op, "change %d does not create a menu", other.ID)
+				}
+				return nil
+			}
+		}
+		// Outside the batch the menu must already have been created by this change set
+		var ref model.ChangeOp
+		err := db.First(&ref, "id = ? AND change_set_id = ? AND entity = ? AND action = ?",
+			*op.RefOpID, op.ChangeSetID, model.ChangeEntityMenu, model.ChangeCreate).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return opError(op, "change %d is not a new menu of this shop", *op.RefOpID)
+		}
+		if err != nil {
+			return err
+		}
+		if ref.Status != model.ChangeOpApplied {
+			return opError(op, "the menu of this photo (change %d) is not part of this decision", ref.ID)
+		}
+	}
+	return nil
+}
+
+// shopOfTarget resolves the shop of the live record an op targets
+func shopOfTarget(db *gorm.DB, entity string, id uint) (uint, error) {
+	switch entity {
+	case model.ChangeEntityShop:
+		var shop model.Shop
+		err := db.Select("id").First(&shop, id).Error
+		return shop.ID, err
+	case model.ChangeEntityMenu:
+		return shopOfMenu(db, id)
+	case model.ChangeEntitySocial:
+		return shopOfSocial(db, id)
+	case model.ChangeEntityPhoto:
+		return shopOfPhoto(db, id)
+	case model.ChangeEntityOpenTime:
+		var date model.ShopOpenDate
+		err := db.Select("shop_id").First(&date, id).Error
+		return date.ShopID, err
+	}
+	return 0, fmt.Errorf("unknown entity %s", entity)
+}
+
+// staleOp reports whether the record an op depends on disappeared after it
+// was staged, for example a menu an admin deleted. Stale ops cannot apply.
+func staleOp(db *gorm.DB, op model.ChangeOp) (bool, error) {
+	if op.EntityID != nil {
+		_, err := shopOfTarget(db, op.Entity, *op.EntityID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return true, nil
+		}
+		return false, err
+	}
+	if op.Entity != model.ChangeEntityPhoto {
+		return false, nil
+	}
+	if op.RefOpID != nil {
+		var ref model.ChangeOp
+		if err := db.Select("status").First(&ref, *op.RefOpID).Error; err != nil {
+			return errors.Is(err, gorm.ErrRecordNotFound), ignoreNotFound(err)
+		}
+		return ref.Status == model.ChangeOpStale || ref.Status == model.ChangeOpDiscarded, nil
+	}
+	var payload photoPayload
+	if decodePayload(op, &payload) != nil || payload.MenuID == nil {
+		return false, nil
+	}
+	_, err := shopOfMenu(db, *payload.MenuID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return true, nil
+	}
+	return false, err
+}
+
+func ignoreNotFound(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	return err
+}
+
+// pruneStaleOps marks the stale pending ops of cs and returns the ones still pending
+func pruneStaleOps(tx *gorm.DB, cs model.ChangeSet) ([]model.ChangeOp, error) {
+	ops, err := pendingOps(tx, cs.ID)
+	if err != nil {
+		return nil, err
+	}
+	live := ops[:0]
+	for _, op := range ops {
+		stale, err := staleOp(tx, op)
+		if err != nil {
+			return nil, err
+		}
+		if !stale {
+			live = append(live, op)
+			continue
+		}
+		if err := tx.Model(&op).Update("status", model.ChangeOpStale).Error; err != nil {
+			return nil, err
+		}
+	}
+	return live, nil
+}
+
+// ==================== APPLIER ====================
+
+// applyChangeOps validates ops as a batch and applies them in the order they
+// were staged. The change set is closed once nothing is left pending.
+// It returns the photo files to remove once tx commits.
+func applyChangeOps(tx *gorm.DB, cs model.ChangeSet, ops []model.ChangeOp) ([]string, error) {
+	sort.Slice(ops, func(i, j int) bool { return ops[i].ID < ops[j].ID })
+	if err := validateChangeOps(tx, cs.ShopID, ops); err != nil {
+		return nil, err
+	}
+
+	var files []string
+	results := map[uint]uint{}
+	now := time.Now()
+	for _, op := range ops {
+		resultID, opFiles, err := applyChangeOp(tx, cs, op, results)
+		if err != nil {
+			return nil, fmt.Errorf("%s %s (change %d): %w", op.Action, op.Entity, op.ID, err)
+		}
+		files = append(files, opFiles...)
+		results[op.ID] = resultID
+		updates := map[string]interface{}{"status": model.ChangeOpApplied, "applied_at": now}
+		if resultID != 0 {
+			updates["result_id"] = resultID
+		}
+		if err := tx.Model(&op).Updates(updates).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	var left int64
+	if err := tx.Model(&model.ChangeOp{}).Where("change_set_id = ? AND status = ?", cs.ID, model.ChangeOpPending).Count(&left).Error; err != nil {
+		return nil, err
+	}
+	if left == 0 {
+		if err := tx.Model(&cs).Updates(map[string]interface{}{"status": model.ChangeSetApplied, "closed_at": now}).Error; err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// applyChangeOp writes one validated op to the live tables. results maps the
+// ops applied so far to the records they created.
+func applyChangeOp(tx *gorm.DB, cs model.ChangeSet, op model.ChangeOp, results map[uint]uint) (uint, []string, error) {
+	var id uint
+	if op.EntityID != nil {
+		id = *op.EntityID
+	}
+
+	switch op.Entity {
+	case model.ChangeEntityShop:
+		var payload shopPayload
+		if err := decodePayload(op, &payload); err != nil {
+			return 0, nil, err
+		}
+		return id, nil, tx.Model(&model.Shop{}).Where("id = ?", id).Updates(map[string]interface{}{
+			"name":             payload.Name,
+			"description":      payload.Description,
+			"shop_category_id": payload.ShopCategoryID,
+		}).Error
+
+	case model.ChangeEntityMenu:
+		if op.Action == model.ChangeDelete {
+			files, err := deleteShopMenuRows(tx, id)
+			return id, files, err
+		}
+		var payload menuPayload
+		if err := decodePayload(op, &payload); err != nil {
+			return 0, nil, err
+		}
+		if op.Action == model.ChangeCreate {
+			menu := model.ShopMenu{ShopID: cs.ShopID, TempID: &cs.TempID, IsPublic: true,
+				ProductName: payload.ProductName, ProductDescription: payload.ProductDescription, Price: payload.Price}
+			err := tx.Create(&menu).Error
+			return menu.ID, nil, err
+		}
+		return id, nil, tx.Model(&model.ShopMenu{}).Where("id = ?", id).Updates(map[string]interface{}{
+			"product_name":        payload.ProductName,
+			"product_description": payload.ProductDescription,
+			"price":               payload.Price,
+		}).Error
+
+	case model.ChangeEntitySocial:
+		if op.Action == model.ChangeDelete {
+			return id, nil, tx.Delete(&model.SocialMedia{}, id).Error
+		}
+		var payload socialPayload
+		if err := decodePayload(op, &payload); err != nil {
+			return 0, nil, err
+		}
+		if op.Action == model.ChangeCreate {
+			social := model.SocialMedia{ShopID: cs.ShopID, TempID: &cs.TempID, IsPublic: true,
+				Name: payload.Name, Platform: payload.Platform, Link: payload.Link}
+			err := tx.Create(&social).Error
+			return social.ID, nil, err
+		}
+		return id, nil, tx.Model(&model.SocialMedia{}).Where("id = ?", id).Updates(map[string]interface{}{
+			"name":     payload.Name,
+			"platform": payload.Platform,
+			"link":     payload.Link,
+		}).Error
+
+	case model.ChangeEntityPhoto:
+		if op.Action == model.ChangeDelete {
+			var photo model.Photo
+			if err := tx.First(&photo, id).Error; err != nil {
+				// Removed together with its menu earlier in this batch
+				return id, nil, ignoreNotFound(err)
+			}
+			return id, []string{photo.PathFile}, tx.Delete(&photo).Error
+		}
+		var payload photoPayload
+		if err := decodePayload(op, &payload); err != nil {
+			return 0, nil, err
+		}
+		photo := model.Photo{PathFile: payload.PathFile, ShopID: payload.ShopID, MenuID: payload.MenuID, TempID: &cs.TempID, IsPublic: true}
+		if op.RefOpID != nil {
+			menuID, ok := results[*op.RefOpID]
+			if !ok {
+				var ref model.ChangeOp
+				if err := tx.First(&ref, *op.RefOpID).Error; err != nil || ref.ResultID == nil {
+					return 0, nil, fmt.Errorf("menu of change %d was not created", *op.RefOpID)
+				}
+				menuID = *ref.ResultID
+			}
+			photo.MenuID = &menuID
+		}
+		err := tx.Create(&photo).Error
+		return photo.ID, nil, err
+
+	case model.ChangeEntityOpenTime:
+		if op.Action == model.ChangeDelete {
+			return id, nil, tx.Delete(&model.ShopOpenDate{}, id).Error
+		}
+		var payload openTimePayload
+		if err := decodePayload(op, &payload); err != nil {
+			return 0, nil, err
+		}
+		if op.Action == model.ChangeCreate {
+			date := model.ShopOpenDate{ShopID: cs.ShopID, MarketOpenDateID: payload.MarketOpenDateID, StartTime: payload.StartTime, EndTime: payload.EndTime}
+			err := tx.Create(&date).Error
+			return date.ID, nil, err
+		}
+		return id, nil, tx.Model(&model.ShopOpenDate{}).Where("id = ?", id).Updates(map[string]interface{}{
+			"market_open_date_id": payload.MarketOpenDateID,
+			"start_time":          payload.StartTime,
+			"end_time":            payload.EndTime,
+		}).Error
+	}
+	return 0, nil, fmt.Errorf("unknown entity %s", op.Entity)
+}
+
+// ==================== HANDLERS ====================
+
+// stageResponse answers an entrepreneur's staging request: 201 with the op,
+// 400 with the validation errors, 404 when the shop has no TempShop, or 500
+func stageResponse(c *fiber.Ctx, op model.ChangeOp, err error) error {
+	var invalid changeErrors
+	if errors.As(err, &invalid) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":  "Change rejected",
+			"errors": invalid,
+		})
+	}
+	var refused *TransitionError
+	if errors.As(err, &refused) {
+		return transitionResponse(c, refused)
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error":   "TempShop not found for this ShopID",
+			"details": err.Error(),
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to stage change",
+			"details": err.Error(),
+		})
+	}
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message": "Change saved to the draft",
+		"change":  op,
+	})
+}
+
+// stageShopChange stages op on the shop's open change set in a transaction.
+// The shop goes back to Draft; a submission waiting for review cannot change.
+func stageShopChange(db *gorm.DB, actor transitionActor, shopID uint, op model.ChangeOp) (model.ChangeOp, error) {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if _, err := beginDraft(tx, actor, shopID); err != nil {
+			return err
+		}
+		cs, err := openChangeSet(tx, shopID, changeSourceEntrepreneur)
+		if err != nil {
+			return err
+		}
+		op, err = stageChange(tx, cs, op)
+		return err
+	})
+	return op, err
+}
+
+// GetPendingChanges returns the open change set of a shop with its pending ops.
+// Entrepreneur routes address the shop by :shop_id, admin routes the TempShop by :id.
+func GetPendingChanges(db *gorm.DB, c *fiber.Ctx) error {
+	tempShop, err := reviewTempShop(db, c)
+	if err != nil || tempShop.ShopID == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Temp shop not found"})
+	}
+	cs, ok, err := findOpenChangeSet(db, *tempShop.ShopID)
+	if err == nil && ok {
+		cs.Ops, err = pendingOps(db, cs.ID)
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to retrieve pending changes",
+			"details": err.Error(),
+		})
+	}
+	if !ok {
+		return c.JSON(fiber.Map{"temp_id": tempShop.TempID, "status": tempShop.Status, "change_set": nil})
+	}
+	return c.JSON(fiber.Map{"temp_id": tempShop.TempID, "status": tempShop.Status, "change_set": cs})
+}
+
+// WithdrawChange drops one pending op of the caller's shop, together with the
+// photos staged for it if it creates a menu
+func WithdrawChange(db *gorm.DB, c *fiber.Ctx) error {
+	shopID, err := paramID(c, "shop_id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid shop ID"})
+	}
+	opID, err := paramID(c, "op_id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid change ID"})
+	}
+
+	var files []string
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if _, err := beginDraft(tx, actorOf(c), shopID); err != nil {
+			return err
+		}
+		cs, ok, err := findOpenChangeSet(tx, shopID)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return gorm.ErrRecordNotFound
+		}
+		var ops []model.ChangeOp
+		if err := tx.Where("change_set_id = ? AND status = ? AND (id = ? OR ref_op_id = ?)",
+			cs.ID, model.ChangeOpPending, opID, opID).Find(&ops).Error; err != nil {
+			return err
+		}
+		found := false
+		for _, op := range ops {
+			found = found || op.ID == opID
+		}
+		if !found {
+			return gorm.ErrRecordNotFound
+		}
+		files, err = discardOps(tx, ops)
+		return err
+	})
+	var refused *TransitionError
+	if errors.As(err, &refused) {
+		return transitionResponse(c, refused)
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Pending change not found"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to withdraw change",
+			"details": err.Error(),
+		})
+	}
+	removeUploads(files)
+	return c.SendStatus(fiber.StatusNoContent)
+}
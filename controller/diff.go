@@ -0,0 +1,285 @@
+package controller
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/HealthMe-pls/medic-go-api/model"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// Kinds of change in a TempShop diff
+const (
+	changeAdded   = "added"
+	changeEdited  = "edited"
+	changeRemoved = "removed"
+)
+
+// Item types of a TempShop diff, shared with review comments
+const (
+	itemShop     = "shop"
+	itemMenu     = "menu"
+	itemSocial   = "social"
+	itemPhoto    = "photo"
+	itemOpenTime = "open_time"
+)
+
+// FieldChange is one field whose live value differs from the pending one
+type FieldChange struct {
+	Field string      `json:"field"`
+	Old   interface{} `json:"old"`
+	New   interface{} `json:"new"`
+}
+
+// ItemChange is one menu, social, photo or open time that the change set adds,
+// edits or removes. Old is null for additions and New is null for removals.
+type ItemChange struct {
+	Key    string        `json:"key,omitempty"` // identifies a pending change, see itemKey
+	ID     uint          `json:"id,omitempty"`  // live record ID, zero while it only exists as a pending change
+	Change string        `json:"change"`
+	Old    fiber.Map     `json:"old"`
+	New    fiber.Map     `json:"new"`
+	Fields []FieldChange `json:"fields,omitempty"`
+}
+
+// TempShopDiff compares a TempShop with the live shop it would be applied to
+type TempShopDiff struct {
+	TempID    uint                 `json:"temp_id"`
+	ShopID    uint                 `json:"shop_id"`
+	Status    model.TempShopStatus `json:"status"`
+	Shop      []FieldChange        `json:"shop"`
+	Menus     []ItemChange         `json:"menus"`
+	Socials   []ItemChange         `json:"socials"`
+	Photos    []ItemChange         `json:"photos"`
+	OpenTimes []ItemChange         `json:"open_times"`
+
+	ops map[string]model.ChangeOp // pending op behind each key
+}
+
+// Empty reports whether the TempShop has no pending change to decide on
+func (d *TempShopDiff) Empty() bool {
+	return len(d.ops) == 0
+}
+
+// GetTempShopDiff returns the field-level diff between a TempShop and its live shop
+func GetTempShopDiff(db *gorm.DB, c *fiber.Ctx) error {
+	var tempShop model.TempShop
+	if err := db.First(&tempShop, "temp_id = ?", c.Params("id")).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error":   "Temp shop not found",
+			"details": err.Error(),
+		})
+	}
+
+	diff, err := computeTempShopDiff(db, tempShop)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to compute diff",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(diff)
+}
+
+// computeTempShopDiff lists every pending op of the shop's open change set
+// with its live and pending values. Ops whose target has disappeared since
+// they were staged are left out; they are dropped when the shop is reviewed.
+func computeTempShopDiff(db *gorm.DB, tempShop model.TempShop) (*TempShopDiff, error) {
+	if tempShop.ShopID == nil {
+		return nil, fmt.Errorf("TempShop with TempID %d has no associated ShopID", tempShop.TempID)
+	}
+	var shop model.Shop
+	if err := db.First(&shop, *tempShop.ShopID).Error; err != nil {
+		return nil, fmt.Errorf("Shop not found for ShopID %d: %w", *tempShop.ShopID, err)
+	}
+
+	diff := &TempShopDiff{
+		TempID:    tempShop.TempID,
+		ShopID:    shop.ID,
+		Status:    tempShop.Status,
+		Shop:      []FieldChange{},
+		Menus:     []ItemChange{},
+		Socials:   []ItemChange{},
+		Photos:    []ItemChange{},
+		OpenTimes: []ItemChange{},
+		ops:       map[string]model.ChangeOp{},
+	}
+
+	ops, err := draftOps(db, shop.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load pending changes: %w", err)
+	}
+	for _, op := range ops {
+		if op.Entity == model.ChangeEntityShop {
+			var payload shopPayload
+			if err := decodePayload(op, &payload); err != nil {
+				return nil, err
+			}
+			diff.Shop = diffValues(
+				fiber.Map{"name": shop.Name, "description": shop.Description, "shop_category_id": shop.ShopCategoryID},
+				fiber.Map{"name": payload.Name, "description": payload.Description, "shop_category_id": payload.ShopCategoryID},
+			)
+			diff.ops[itemShop] = op
+			continue
+		}
+
+		change, err := opChange(db, op)
+		if err != nil {
+			return nil, err
+		}
+		diff.ops[change.Key] = op
+		switch op.Entity {
+		case model.ChangeEntityMenu:
+			diff.Menus = append(diff.Menus, change)
+		case model.ChangeEntitySocial:
+			diff.Socials = append(diff.Socials, change)
+		case model.ChangeEntityPhoto:
+			diff.Photos = append(diff.Photos, change)
+		case model.ChangeEntityOpenTime:
+			diff.OpenTimes = append(diff.OpenTimes, change)
+		}
+	}
+	return diff, nil
+}
+
+// opChange describes a menu, social, photo or open time op against the live record it targets
+func opChange(db *gorm.DB, op model.ChangeOp) (ItemChange, error) {
+	var old, new fiber.Map
+	var id uint
+	if op.EntityID != nil {
+		id = *op.EntityID
+	}
+
+	switch op.Entity {
+	case model.ChangeEntityMenu:
+		if op.Action != model.ChangeCreate {
+			var menu model.ShopMenu
+			if err := db.First(&menu, id).Error; err != nil {
+				return ItemChange{}, fmt.Errorf("ShopMenu not found for MenuID %d: %w", id, err)
+			}
+			old = menuValues(menu)
+		}
+		if op.Action != model.ChangeDelete {
+			var payload menuPayload
+			if err := decodePayload(op, &payload); err != nil {
+				return ItemChange{}, err
+			}
+			new = menuValues(model.ShopMenu{ProductName: payload.ProductName, ProductDescription: payload.ProductDescription, Price: payload.Price})
+		}
+	case model.ChangeEntitySocial:
+		if op.Action != model.ChangeCreate {
+			var social model.SocialMedia
+			if err := db.First(&social, id).Error; err != nil {
+				return ItemChange{}, fmt.Errorf("SocialMedia not found for SocialID %d: %w", id, err)
+			}
+			old = socialValues(social)
+		}
+		if op.Action != model.ChangeDelete {
+			var payload socialPayload
+			if err := decodePayload(op, &payload); err != nil {
+				return ItemChange{}, err
+			}
+			new = socialValues(model.SocialMedia{Name: payload.Name, Platform: payload.Platform, Link: payload.Link})
+		}
+	case model.ChangeEntityPhoto:
+		// Photos cannot be edited in place
+		if op.Action == model.ChangeDelete {
+			var photo model.Photo
+			if err := db.First(&photo, id).Error; err != nil {
+				return ItemChange{}, fmt.Errorf("Photo not found for PhotoID %d: %w", id, err)
+			}
+			old = photoValues(photo)
+		} else {
+			var payload photoPayload
+			if err := decodePayload(op, &payload); err != nil {
+				return ItemChange{}, err
+			}
+			new = photoValues(model.Photo{PathFile: payload.PathFile, ShopID: payload.ShopID, MenuID: payload.MenuID})
+			if op.RefOpID != nil {
+				new["menu_change_id"] = *op.RefOpID
+			}
+		}
+	case model.ChangeEntityOpenTime:
+		if op.Action != model.ChangeCreate {
+			var date model.ShopOpenDate
+			if err := db.First(&date, id).Error; err != nil {
+				return ItemChange{}, fmt.Errorf("ShopOpenDate not found for ID %d: %w", id, err)
+			}
+			old = openTimeValues(date.MarketOpenDateID, date.StartTime, date.EndTime)
+		}
+		if op.Action != model.ChangeDelete {
+			var payload openTimePayload
+			if err := decodePayload(op, &payload); err != nil {
+				return ItemChange{}, err
+			}
+			new = openTimeValues(payload.MarketOpenDateID, payload.StartTime, payload.EndTime)
+		}
+	default:
+		return ItemChange{}, fmt.Errorf("unknown entity %s", op.Entity)
+	}
+
+	var change ItemChange
+	switch op.Action {
+	case model.ChangeCreate:
+		change = ItemChange{Change: changeAdded, New: new}
+	case model.ChangeUpdate:
+		change = editedItem(id, old, new)
+	default:
+		change = ItemChange{ID: id, Change: changeRemoved, Old: old}
+	}
+	change.Key = itemKey(op.Entity, change.Change, op.ID)
+	return change, nil
+}
+
+func menuValues(menu model.ShopMenu) fiber.Map {
+	return fiber.Map{"product_name": menu.ProductName, "product_description": menu.ProductDescription, "price": menu.Price}
+}
+
+func socialValues(social model.SocialMedia) fiber.Map {
+	return fiber.Map{"name": social.Name, "platform": social.Platform, "link": social.Link}
+}
+
+func photoValues(photo model.Photo) fiber.Map {
+	return fiber.Map{"path_file": photo.PathFile, "shop_id": photo.ShopID, "menu_id": photo.MenuID}
+}
+
+func openTimeValues(marketOpenDateID uint, start time.Time, end time.Time) fiber.Map {
+	return fiber.Map{"market_open_date_id": marketOpenDateID, "start_time": start, "end_time": end}
+}
+
+// itemKey names one pending change so it can be accepted or rejected on its
+// own. The ID is the ChangeOp, not the live record.
+func itemKey(itemType string, change string, pendingID uint) string {
+	return fmt.Sprintf("%s:%s:%d", itemType, change, pendingID)
+}
+
+func editedItem(id uint, old fiber.Map, new fiber.Map) ItemChange {
+	return ItemChange{ID: id, Change: changeEdited, Old: old, New: new, Fields: diffValues(old, new)}
+}
+
+// diffValues lists the keys of old and new whose values differ, sorted by name
+func diffValues(old fiber.Map, new fiber.Map) []FieldChange {
+	keys := make([]string, 0, len(new))
+	for key := range new {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	changes := []FieldChange{}
+	for _, key := range keys {
+		if !sameValue(old[key], new[key]) {
+			changes = append(changes, FieldChange{Field: key, Old: old[key], New: new[key]})
+		}
+	}
+	return changes
+}
+
+func sameValue(a interface{}, b interface{}) bool {
+	if at, ok := a.(time.Time); ok {
+		bt, ok := b.(time.Time)
+		return ok && at.Equal(bt)
+	}
+	return a == b
+}
@@ -0,0 +1,240 @@
+package controller
+
+import (
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/HealthMe-pls/medic-go-api/model"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Entrepreneur edits collect in a private draft: the shop's open change set
+// while its TempShop is Draft. Reviewers only see it once it is submitted.
+
+var errNothingToSubmit = errors.New("the draft has no changes to submit")
+
+// beginDraft locks the shop's TempShop and moves it back to Draft unless it
+// is one already. A submission waiting for review cannot be edited and fails
+// with a TransitionError; the entrepreneur has to withdraw it first.
+func beginDraft(tx *gorm.DB, actor transitionActor, shopID uint) (model.TempShop, error) {
+	var tempShop model.TempShop
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&tempShop, "shop_id = ?", shopID).Error; err != nil {
+		return tempShop, err
+	}
+	if tempShop.Status == model.StatusDraft {
+		return tempShop, nil
+	}
+	err := transitionTempShop(tx, actor, &tempShop, model.StatusDraft, "")
+	return tempShop, err
+}
+
+// draftOps returns the pending ops of the shop that still apply, in staging order
+func draftOps(db *gorm.DB, shopID uint) ([]model.ChangeOp, error) {
+	ops, err := pendingOpsOfShop(db, shopID)
+	if err != nil {
+		return nil, err
+	}
+	var live []model.ChangeOp
+	for _, op := range ops {
+		stale, err := staleOp(db, op)
+		if err != nil {
+			return nil, err
+		}
+		if !stale {
+			live = append(live, op)
+		}
+	}
+	return live, nil
+}
+
+// submitTempShop validates the shop's pending changes and sends them for
+// review as a new round. Stale changes are dropped first; message, if any,
+// becomes the entrepreneur's comment on the round.
+func submitTempShop(db *gorm.DB, c *fiber.Ctx, tempID uint, message string) (model.TempShop, model.ReviewRound, error) {
+	var tempShop model.TempShop
+	var round model.ReviewRound
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&tempShop, "temp_id = ?", tempID).Error; err != nil {
+			return err
+		}
+		if tempShop.ShopID == nil {
+			return gorm.ErrRecordNotFound
+		}
+		cs, ok, err := findOpenChangeSet(tx, *tempShop.ShopID)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errNothingToSubmit
+		}
+		ops, err := pruneStaleOps(tx, cs)
+		if err != nil {
+			return err
+		}
+		if len(ops) == 0 {
+			return errNothingToSubmit
+		}
+		if err := validateChangeOps(tx, *tempShop.ShopID, ops); err != nil {
+			return err
+		}
+
+		if err := transitionTempShop(tx, actorOf(c), &tempShop, model.StatusSubmitted, message); err != nil {
+			return err
+		}
+		if round, err = openReviewRound(tx, tempShop.TempID); err != nil {
+			return err
+		}
+		if strings.TrimSpace(message) != "" {
+			_, err = addReviewComment(tx, c, round, model.ReviewComment{Body: message})
+		}
+		return err
+	})
+	return tempShop, round, err
+}
+
+// submitResponse answers a submission made with submitTempShop. A successful
+// submission is offered to the auto-approval rules first.
+func submitResponse(db *gorm.DB, c *fiber.Ctx, tempShop model.TempShop, round model.ReviewRound, err error) error {
+	var refused *TransitionError
+	var invalid changeErrors
+	switch {
+	case err == nil:
+		message := "TempShop submitted for review"
+		decision, err := autoApprove(db, c, tempShop, round)
+		if err != nil {
+			log.Printf("Auto-approval check of TempShop %d failed: %v", tempShop.TempID, err)
+		}
+		if decision.Approved {
+			message = "TempShop approved automatically"
+			tempShop.Status = model.StatusApproved
+		}
+		return c.JSON(fiber.Map{
+			"message":       message,
+			"temp_id":       tempShop.TempID,
+			"status":        tempShop.Status,
+			"round":         round.Round,
+			"auto_approval": decision,
+		})
+	case errors.As(err, &refused):
+		return transitionResponse(c, refused)
+	case errors.As(err, &invalid):
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error":  "The draft has invalid changes; fix or withdraw them before submitting",
+			"errors": invalid,
+		})
+	case errors.Is(err, errNothingToSubmit):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Temp shop not found"})
+	default:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to submit",
+			"details": err.Error(),
+		})
+	}
+}
+
+// SubmitTempShop sends the shop's draft for review once every change in it is valid.
+// An optional {"message": "..."} is kept as the entrepreneur's comment on the round.
+func SubmitTempShop(db *gorm.DB, c *fiber.Ctx) error {
+	var input struct {
+		Message string `json:"message"`
+	}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+	}
+
+	tempShop, err := reviewTempShop(db, c)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Temp shop not found"})
+	}
+	tempShop, round, err := submitTempShop(db, c, tempShop.TempID, input.Message)
+	return submitResponse(db, c, tempShop, round, err)
+}
+
+// WithdrawSubmission takes back a submission no reviewer has picked up yet.
+// Its changes stay in the draft and the waiting round is closed as Withdrawn.
+func WithdrawSubmission(db *gorm.DB, c *fiber.Ctx) error {
+	tempShop, err := reviewTempShop(db, c)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Temp shop not found"})
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&tempShop, "temp_id = ?", tempShop.TempID).Error; err != nil {
+			return err
+		}
+		if err := transitionTempShop(tx, actorOf(c), &tempShop, model.StatusWithdrawn, ""); err != nil {
+			return err
+		}
+		return tx.Model(&model.ReviewRound{}).
+			Where("temp_id = ? AND status = ?", tempShop.TempID, "Waiting").
+			Update("status", "Withdrawn").Error
+	})
+	var refused *TransitionError
+	if errors.As(err, &refused) {
+		return transitionResponse(c, refused)
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to withdraw submission",
+			"details": err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Submission withdrawn, the changes are back in the draft",
+		"temp_id": tempShop.TempID,
+		"status":  tempShop.Status,
+	})
+}
+
+// PreviewTempShop shows the shop as it would look with the draft approved,
+// the changes that make the difference, and the problems that would stop a submission
+func PreviewTempShop(db *gorm.DB, c *fiber.Ctx) error {
+	tempShop, err := reviewTempShop(db, c)
+	if err != nil || tempShop.ShopID == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Temp shop not found"})
+	}
+
+	preview, err := tempShopView(db, tempShop)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to build preview",
+			"details": err.Error(),
+		})
+	}
+	diff, err := computeTempShopDiff(db, tempShop)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to compute diff",
+			"details": err.Error(),
+		})
+	}
+
+	problems := changeErrors{}
+	ops, err := draftOps(db, *tempShop.ShopID)
+	if err == nil {
+		err = validateChangeOps(db, *tempShop.ShopID, ops)
+	}
+	if err != nil && !errors.As(err, &problems) {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to validate draft",
+			"details": err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"temp_id":  tempShop.TempID,
+		"status":   tempShop.Status,
+		"preview":  preview,
+		"changes":  diff,
+		"problems": problems,
+		"ready":    len(ops) > 0 && len(problems) == 0,
+	})
+}
@@ -30,16 +30,29 @@ func GetEntrepreneurByID(db *gorm.DB, c *fiber.Ctx) error {
 
 func CreateEntrepreneur(db *gorm.DB, c *fiber.Ctx) error {
 	// Parse the request body into the Entrepreneur struct
-	entrepreneur := new(model.Entrepreneur)
-	if err := c.BodyParser(entrepreneur); err != nil {
+	var input credentialsInput
+	if err := c.BodyParser(&input); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error": "Failed to parse request body",
 		})
 	}
+	email, err := normalizeEmail(input.Email)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	hashedPassword, err := HashPassword(input.Password)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to hash password",
+		})
+	}
+	entrepreneur := &model.Entrepreneur{Username: input.Username, Email: email, Password: hashedPassword}
 
 	// Check if the username already exists
     var existingEntrepreneur model.Entrepreneur
-	err := db.Where("username = ?", entrepreneur.Username).First(&existingEntrepreneur).Error
+	err = db.Where("username = ?", entrepreneur.Username).First(&existingEntrepreneur).Error
 	if err == nil {
 		// Username already exists
 		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
@@ -75,16 +88,30 @@ func UpdateEntrepreneur(db *gorm.DB, c *fiber.Ctx) error {
     }
 
     // Parse the updated details from the request body
-    if err := c.BodyParser(&entrepreneur); err != nil {
+    var input credentialsInput
+    if err := c.BodyParser(&input); err != nil {
         return c.Status(fiber.StatusBadRequest).SendString("Failed to parse request body")
     }
-	encryptedPassword, err := EncryptPassword(entrepreneur.Password)
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to encrypt password",
-		})
+	if input.Username != "" {
+		entrepreneur.Username = input.Username
+	}
+	if input.Email != "" {
+		email, err := normalizeEmail(input.Email)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+		}
+		entrepreneur.Email = email
+	}
+	// Only replace the password when a new one is provided
+	if input.Password != "" {
+		hashedPassword, err := HashPassword(input.Password)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to hash password",
+			})
+		}
+		entrepreneur.Password = hashedPassword
 	}
-	entrepreneur.Password = encryptedPassword
     // Save the updated entrepreneur details to the database
     if result := db.Save(&entrepreneur); result.Error != nil {
         return c.Status(fiber.StatusInternalServerError).SendString("Failed to update entrepreneur")
@@ -127,15 +154,24 @@ func DeleteEntrepreneurByID(db *gorm.DB, c *fiber.Ctx) error {
 		})
 	}
 
+	// Snapshot the shops for the audit log before they are gone
+	shopSnapshots := make([]*model.Shop, 0, len(shops))
+	for _, shop := range shops {
+		shopSnapshots = append(shopSnapshots, shopSnapshot(tx, shop.ID))
+	}
+
 	// Step 3: Delete each shop using DeleteShopByID within the same transaction
+	var files []string
 	for _, shop := range shops {
-		if err := DeleteShopByID(tx, shop.ID); err != nil {
+		shopFiles, err := DeleteShopByID(tx, shop.ID)
+		if err != nil {
 			tx.Rollback()
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error":   fmt.Sprintf("Failed to delete shop ID %d", shop.ID),
 				"details": err.Error(),
 			})
 		}
+		files = append(files, shopFiles...)
 	}
 
 	// Step 4: Delete the Entrepreneur after all shops are deleted (within the same transaction)
@@ -153,6 +189,9 @@ func DeleteEntrepreneurByID(db *gorm.DB, c *fiber.Ctx) error {
 			"error": "Failed to commit transaction",
 		})
 	}
+	removeUploads(files)
+	recordAudit(db, c, "entrepreneur.delete", auditEntityEntrepreneur, entrepreneurID,
+		fiber.Map{"entrepreneur": entrepreneur, "shops": shopSnapshots}, nil)
 
 	// Return success message
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
@@ -0,0 +1,105 @@
+package controller
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"regexp"
+	"sort"
+	"testing"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// fakeTables holds the rows a test reads, by table name. It answers the
+// lookups by primary key that db.First makes: the first query argument is
+// matched against the "id" column. Anything else is an error, so a test
+// notices when the code under test starts asking for more.
+type fakeTables map[string][]map[string]driver.Value
+
+// fakeDB opens a gorm handle that reads from tables
+func fakeDB(t *testing.T, tables fakeTables) *gorm.DB {
+	t.Helper()
+	sqlDB := sql.OpenDB(fakeConnector{tables})
+	db, err := gorm.Open(mysql.New(mysql.Config{Conn: sqlDB, SkipInitializeWithVersion: true}), &gorm.Config{
+		SkipDefaultTransaction: true,
+		Logger:                 logger.Discard,
+	})
+	if err != nil {
+		t.Fatalf("open fake db: %v", err)
+	}
+	return db
+}
+
+var selectFrom = regexp.MustCompile("^SELECT \\* FROM `(\\w+)` WHERE")
+
+type fakeConnector struct{ tables fakeTables }
+
+func (f fakeConnector) Connect(context.Context) (driver.Conn, error) { return fakeConn(f), nil }
+func (f fakeConnector) Driver() driver.Driver                        { return nil }
+
+type fakeConn struct{ tables fakeTables }
+
+func (f fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return fakeStmt{tables: f.tables, query: query}, nil
+}
+func (f fakeConn) Close() error              { return nil }
+func (f fakeConn) Begin() (driver.Tx, error) { return nil, errors.New("fake db has no transactions") }
+
+type fakeStmt struct {
+	tables fakeTables
+	query  string
+}
+
+func (s fakeStmt) Close() error  { return nil }
+func (s fakeStmt) NumInput() int { return -1 }
+func (s fakeStmt) Exec([]driver.Value) (driver.Result, error) {
+	return nil, errors.New("fake db is read-only")
+}
+
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	match := selectFrom.FindStringSubmatch(s.query)
+	if match == nil || len(args) == 0 {
+		return nil, errors.New("fake db cannot answer " + s.query)
+	}
+	rows := &fakeRows{}
+	for _, row := range s.tables[match[1]] {
+		if row["id"] != args[0] {
+			continue
+		}
+		for column := range row {
+			rows.columns = append(rows.columns, column)
+		}
+		sort.Strings(rows.columns)
+		values := make([]driver.Value, len(rows.columns))
+		for i, column := range rows.columns {
+			values[i] = row[column]
+		}
+		rows.values = append(rows.values, values)
+		break
+	}
+	if rows.columns == nil {
+		rows.columns = []string{"id"}
+	}
+	return rows, nil
+}
+
+type fakeRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
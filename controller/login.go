@@ -2,25 +2,36 @@ package controller
 
 import (
 	"errors"
-	"io"
+	"log"
 	"time"
 	"context"
 	"crypto/aes"
 	"crypto/cipher"
-	"crypto/rand"
+	"crypto/subtle"
 	"encoding/base64"
+	"strconv"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/HealthMe-pls/medic-go-api/model"
 	"github.com/HealthMe-pls/medic-go-api/database"
+	"github.com/HealthMe-pls/medic-go-api/middleware"
+	"github.com/HealthMe-pls/medic-go-api/token"
 	"github.com/gofiber/fiber/v2"
 	"gorm.io/gorm"
 	"golang.org/x/crypto/bcrypt"
 )
 
 
-// Secret key for JWT
-var jwtSecret = []byte("your_secret_key")
-var secretKey = []byte("mysecretencryptionkey123") // Must be 16, 24, or 32 bytes
+// legacyPasswordKey decrypts passwords stored by the old AES scheme so they can
+// be verified once and rehashed. It must never be used to store new passwords.
+var legacyPasswordKey = []byte("mysecretencryptionkey123") // Must be 16, 24, or 32 bytes
+
+// credentialsInput is the request body for endpoints that accept a password.
+// model.Entrepreneur never serializes its password, so it cannot be parsed from JSON.
+type credentialsInput struct {
+	Username string `json:"username"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
 
 func HashPassword(password string) (string, error) {
 	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
@@ -30,45 +41,38 @@ func HashPassword(password string) (string, error) {
 	return string(hashed), nil
 }
 
-func CheckPassword(encryptedPassword, inputPassword string) bool {
-	// Decrypt the stored encrypted password
-	decryptedPassword, err := DecryptPassword(encryptedPassword)
-	if err != nil {
-		return false // Return false if decryption fails
-	}
-
-	// Compare decrypted password with the user input
-	return decryptedPassword == inputPassword
+// isHashedPassword reports whether the stored value is a bcrypt hash
+func isHashedPassword(storedPassword string) bool {
+	_, err := bcrypt.Cost([]byte(storedPassword))
+	return err == nil
 }
 
+// CheckPassword compares the stored password with the user input.
+// needsRehash is true when the stored value still uses the legacy AES scheme.
+func CheckPassword(storedPassword, inputPassword string) (ok bool, needsRehash bool) {
+	if isHashedPassword(storedPassword) {
+		return bcrypt.CompareHashAndPassword([]byte(storedPassword), []byte(inputPassword)) == nil, false
+	}
 
-// EncryptPassword encrypts a password using AES
-func EncryptPassword(password string) (string, error) {
-	block, err := aes.NewCipher(secretKey)
+	// Fall back to the legacy AES-encrypted value
+	decryptedPassword, err := decryptLegacyPassword(storedPassword)
 	if err != nil {
-		return "", err
+		return false, false // Return false if decryption fails
 	}
-
-	ciphertext := make([]byte, aes.BlockSize+len(password))
-	iv := ciphertext[:aes.BlockSize]
-	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
-		return "", err
+	if subtle.ConstantTimeCompare([]byte(decryptedPassword), []byte(inputPassword)) != 1 {
+		return false, false
 	}
-
-	stream := cipher.NewCFBEncrypter(block, iv)
-	stream.XORKeyStream(ciphertext[aes.BlockSize:], []byte(password))
-
-	return base64.StdEncoding.EncodeToString(ciphertext), nil
+	return true, true
 }
 
-// DecryptPassword decrypts an encrypted password
-func DecryptPassword(encrypted string) (string, error) {
+// decryptLegacyPassword decrypts a password stored with the old AES scheme
+func decryptLegacyPassword(encrypted string) (string, error) {
 	ciphertext, err := base64.StdEncoding.DecodeString(encrypted)
 	if err != nil {
 		return "", err
 	}
 
-	block, err := aes.NewCipher(secretKey)
+	block, err := aes.NewCipher(legacyPasswordKey)
 	if err != nil {
 		return "", err
 	}
@@ -85,123 +89,185 @@ func DecryptPassword(encrypted string) (string, error) {
 
 	return string(ciphertext), nil
 }
+
 // Register a new Entrepreneur
 func Register(db *gorm.DB, c *fiber.Ctx) error {
-	var input model.Entrepreneur
+	var input credentialsInput
 
 	// Parse request body
 	if err := c.BodyParser(&input); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid input"})
 	}
+	if input.Username == "" || input.Password == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Username and password are required"})
+	}
+
+	email, err := normalizeEmail(input.Email)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
 
-	encryptedPassword, err := EncryptPassword(input.Password)
+	hashedPassword, err := HashPassword(input.Password)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to encrypt password"})
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to hash password"})
 	}
 
 	// Create a new Entrepreneur
 	Entrepreneur := model.Entrepreneur{
 		Username: input.Username,
-		Password: encryptedPassword, // Store hashed password
+		Email:    email,
+		Password: hashedPassword, // Store hashed password
 	}
 
 	// Save to database
 	if err := db.Create(&Entrepreneur).Error; err != nil {
-		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Username already exists"})
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Username or email already exists"})
 	}
 
 	// Return response
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"message":            "User registered successfully",
-		"username":           Entrepreneur.Username,
-		"password_encrypted": encryptedPassword, // Show encrypted password for debugging (Remove in production)
+		"message":  "User registered successfully",
+		"username": Entrepreneur.Username,
 	})
 }
 
-// GenerateJWT generates a JWT token
-func GenerateJWT(username string) (string, error) {
-	claims := jwt.MapClaims{
-		"username": username,
-		"exp":      time.Now().Add(time.Hour * 24).Unix(), // Token expires in 24 hours
+// GenerateJWT generates a short-lived access token for the given user, role and session
+func GenerateJWT(userID uint, username string, role string, sessionID string) (string, error) {
+	claims := middleware.Claims{
+		Username:  username,
+		Role:      role,
+		SessionID: sessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   strconv.FormatUint(uint64(userID), 10),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(accessTokenTTL)),
+		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret)
+	return token.Default.Sign(claims)
+}
+
+// GetJWKS publishes the public keys that verify our tokens
+func GetJWKS(c *fiber.Ctx) error {
+	return c.JSON(token.Default.JWKS())
 }
 
 // Login function using Entrepreneur model directly
 func Login(db *gorm.DB, c *fiber.Ctx) error {
-	var input model.Entrepreneur
+	var input credentialsInput
 	if err := c.BodyParser(&input); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid input"})
 	}
 
+	// Refuse locked usernames and attempts that come too soon after a failure
+	username := throttleKey(input.Username)
+	attempt, reason, wait := beginLogin(username, c.IP())
+	if reason != "" {
+		recordAuthEvent(db, c, middleware.RoleEntrepreneur, input.Username, nil, reason)
+		if reason == authReasonLocked {
+			return tooManyAttempts(c, "Account temporarily locked after repeated failed logins", wait)
+		}
+		return tooManyAttempts(c, "Too many failed logins, try again later", wait)
+	}
+
 	// Find entrepreneur by username
 	var entrepreneur model.Entrepreneur
 	if err := db.Where("username = ?", input.Username).First(&entrepreneur).Error; err != nil {
+		attempt.failed()
+		recordAuthEvent(db, c, middleware.RoleEntrepreneur, input.Username, nil, authReasonUnknownUser)
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid credentials"})
 	}
 
 	// Check password
-	if !CheckPassword(entrepreneur.Password, input.Password) {
+	ok, needsRehash := CheckPassword(entrepreneur.Password, input.Password)
+	if !ok {
+		attempt.failed()
+		recordAuthEvent(db, c, middleware.RoleEntrepreneur, input.Username, &entrepreneur.ID, authReasonBadPassword)
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid credentials"})
 	}
+	attempt.succeeded()
+
+	// Migrate legacy AES passwords to bcrypt now that we know the plaintext
+	if needsRehash {
+		if hashedPassword, err := HashPassword(input.Password); err == nil {
+			if err := db.Model(&entrepreneur).Update("password", hashedPassword).Error; err != nil {
+				log.Println("Failed to rehash password for", entrepreneur.Username, err)
+			}
+		}
+	}
 
-	// Generate JWT
-	token, err := GenerateJWT(entrepreneur.Username)
+	// Start a session and issue access and refresh tokens
+	tokens, err := startSession(c, entrepreneur.ID, entrepreneur.Username, middleware.RoleEntrepreneur)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not generate token"})
 	}
+	recordAuthEvent(db, c, middleware.RoleEntrepreneur, entrepreneur.Username, &entrepreneur.ID, authReasonSuccess)
 
-	return c.Status(fiber.StatusOK).JSON(fiber.Map{"token": token})
+	return c.Status(fiber.StatusOK).JSON(tokens)
 }
-// Logout function to blacklist JWT token
+// Logout blacklists the current access token and ends its session
 func Logout(c *fiber.Ctx) error {
-	// Get token from header
-	tokenString := c.Get("Authorization")
-	if tokenString == "" {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Missing token"})
+	claims, ok := middleware.GetClaims(c)
+	if !ok {
+		return middleware.Unauthorized(c, "Missing token")
 	}
 
-	// Remove "Bearer " prefix if present
-	if len(tokenString) > 7 && tokenString[:7] == "Bearer " {
-		tokenString = tokenString[7:]
+	// Keep the token blacklisted until it would have expired anyway
+	ttl := accessTokenTTL
+	if claims.ExpiresAt != nil {
+		ttl = time.Until(claims.ExpiresAt.Time)
+	}
+	if ttl > 0 {
+		err := database.RedisClient.Set(context.Background(), middleware.BearerToken(c), "blacklisted", ttl).Err()
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to blacklist token"})
+		}
 	}
 
-	// Store the token in Redis with an expiration time
-	err := database.RedisClient.Set(context.Background(), tokenString, "blacklisted", 24*time.Hour).Err()
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to blacklist token"})
+	// Ending the session also invalidates its refresh token
+	if session, err := database.GetSession(claims.SessionID); err == nil {
+		if err := database.RevokeSession(session); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to end session"})
+		}
 	}
 
 	return c.JSON(fiber.Map{"message": "Successfully logged out"})
 }
 
-// ResetPassword allows an entrepreneur to reset their password
+// ResetPassword lets the logged-in entrepreneur change their password after
+// confirming the current one. Forgotten passwords go through ForgotPassword.
 func ResetPassword(db *gorm.DB, c *fiber.Ctx) error {
-	
-	id := c.Params("entrepreneur_id")
+	claims, ok := middleware.GetClaims(c)
+	if !ok {
+		return middleware.Unauthorized(c, "Missing token")
+	}
 	var entrepreneur model.Entrepreneur
     // Find the entrepreneur by id
-    if err := db.First(&entrepreneur, "id = ?", id).Error; err != nil {
+    if err := db.First(&entrepreneur, "id = ?", claims.UserID()).Error; err != nil {
         return c.Status(fiber.StatusNotFound).SendString("Entrepreneur not found")
     }
 	
-    // Parse the updated details from the request body
-    if err := c.BodyParser(&entrepreneur); err != nil {
-        return c.Status(fiber.StatusBadRequest).SendString("Failed to parse request body")
+    // Parse the current and new password from the request body
+    var input struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+    if err := c.BodyParser(&input); err != nil || input.CurrentPassword == "" || input.NewPassword == "" {
+        return c.Status(fiber.StatusBadRequest).SendString("current_password and new_password are required")
     }
+	if ok, _ := CheckPassword(entrepreneur.Password, input.CurrentPassword); !ok {
+		return middleware.Unauthorized(c, "Current password is incorrect")
+	}
 
-	// Encrypt the new password
-	encryptedPassword, err := EncryptPassword(entrepreneur.Password)
+	// Hash the new password
+	hashedPassword, err := HashPassword(input.NewPassword)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to encrypt password",
+			"error": "Failed to hash password",
 		})
 	}
 	// Update the password
-	entrepreneur.Password = encryptedPassword
-	if err := db.Save(&entrepreneur).Error; err != nil {
+	if err := db.Model(&entrepreneur).Update("password", hashedPassword).Error; err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to update password",
 		})
@@ -213,24 +279,6 @@ func ResetPassword(db *gorm.DB, c *fiber.Ctx) error {
 	})
 }
 
-// GetEntrepreneurWithPassword fetches entrepreneur details including the hashed password
-func GetEntrepreneurWithPassword(db *gorm.DB, c *fiber.Ctx) error {
-	username := c.Params("username") // Get username from URL params
-
-	var entrepreneur model.Entrepreneur
-	if err := db.Where("username = ?", username).First(&entrepreneur).Error; err != nil {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Entrepreneur not found"})
-	}
-
-	// WARNING: Returning hashed passwords is a security risk!
-	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"username": entrepreneur.Username,
-		"password": entrepreneur.Password, // Hashed password
-		"message":  "Entrepreneur found",
-	})
-}
-
-
 func GetAllEntrepreneur(db *gorm.DB, c *fiber.Ctx) error {
 	var entrepreneur []model.Entrepreneur
 
@@ -242,17 +290,5 @@ func GetAllEntrepreneur(db *gorm.DB, c *fiber.Ctx) error {
 		})
 	}
 
-	// Decrypt passwords
-	for i := range entrepreneur {
-		decryptedPassword, err := DecryptPassword(entrepreneur[i].Password)
-		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error":   "Failed to decrypt password",
-				"details": err.Error(),
-			})
-		}
-		entrepreneur[i].Password = decryptedPassword
-	}
-
 	return c.Status(fiber.StatusOK).JSON(entrepreneur)
 }
@@ -0,0 +1,84 @@
+package controller
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"testing"
+)
+
+// legacyEncrypt stores a password the way the old AES scheme did
+func legacyEncrypt(t *testing.T, password string) string {
+	t.Helper()
+	block, err := aes.NewCipher(legacyPasswordKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext := make([]byte, aes.BlockSize+len(password))
+	iv := ciphertext[:aes.BlockSize]
+	if _, err := rand.Read(iv); err != nil {
+		t.Fatal(err)
+	}
+	cipher.NewCFBEncrypter(block, iv).XORKeyStream(ciphertext[aes.BlockSize:], []byte(password))
+	return base64.StdEncoding.EncodeToString(ciphertext)
+}
+
+func TestCheckPassword(t *testing.T) {
+	hashed, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatalf("HashPassword() error = %v", err)
+	}
+	legacy := legacyEncrypt(t, "correct horse")
+
+	tests := []struct {
+		name       string
+		stored     string
+		input      string
+		wantOK     bool
+		wantRehash bool
+	}{
+		{"bcrypt match", hashed, "correct horse", true, false},
+		{"bcrypt mismatch", hashed, "wrong horse", false, false},
+		{"bcrypt empty input", hashed, "", false, false},
+		{"legacy match needs a rehash", legacy, "correct horse", true, true},
+		{"legacy mismatch", legacy, "wrong horse", false, false},
+		{"legacy prefix", legacy, "correct", false, false},
+		{"not base64", "%%%", "correct horse", false, false},
+		{"too short for an IV", base64.StdEncoding.EncodeToString([]byte("short")), "", false, false},
+		{"plain text is never accepted", "correct horse", "correct horse", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, rehash := CheckPassword(tt.stored, tt.input)
+			if ok != tt.wantOK || rehash != tt.wantRehash {
+				t.Errorf("CheckPassword() = %v, %v, want %v, %v", ok, rehash, tt.wantOK, tt.wantRehash)
+			}
+		})
+	}
+}
+
+func TestHashPassword(t *testing.T) {
+	first, err := HashPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := HashPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == second {
+		t.Error("HashPassword() gave the same hash twice; the salt is missing")
+	}
+	for _, hashed := range []string{first, second} {
+		if !isHashedPassword(hashed) {
+			t.Errorf("isHashedPassword(%q) = false", hashed)
+		}
+		if ok, rehash := CheckPassword(hashed, "secret"); !ok || rehash {
+			t.Errorf("CheckPassword() = %v, %v, want true, false", ok, rehash)
+		}
+	}
+	if isHashedPassword(legacyEncrypt(t, "secret")) {
+		t.Error("isHashedPassword() took a legacy AES value for a bcrypt hash")
+	}
+}
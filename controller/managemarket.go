@@ -1,6 +1,9 @@
 package controller
 
 import (
+	"errors"
+	"fmt"
+
 	"github.com/HealthMe-pls/medic-go-api/model"
 	"github.com/gofiber/fiber/v2"
 	"gorm.io/gorm"
@@ -50,7 +53,9 @@ func GetMarketOpenDate(db *gorm.DB, c *fiber.Ctx) error {
 	return c.JSON(marketOpenDate)
 }
 
-// UpdateMarketOpenDate updates an existing MarketOpenDate by ID
+// UpdateMarketOpenDate updates an existing MarketOpenDate by ID. A date
+// generated by a schedule is taken out of its series so the edit sticks.
+// Changing a date shops have booked needs ?confirm=true.
 func UpdateMarketOpenDate(db *gorm.DB, c *fiber.Ctx) error {
 	id := c.Params("id")
 	var marketOpenDate model.MarketOpenDate
@@ -59,14 +64,33 @@ func UpdateMarketOpenDate(db *gorm.DB, c *fiber.Ctx) error {
 			"error": "Market open date not found",
 		})
 	}
+	before := marketOpenDate
 
 	if err := c.BodyParser(&marketOpenDate); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error": "Invalid request payload",
 		})
 	}
+	marketOpenDate.ID, marketOpenDate.ScheduleID = before.ID, nil
 
-	if err := db.Save(&marketOpenDate).Error; err != nil {
+	bookings, err := countBookings(db, before.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to count bookings",
+			"details": err.Error(),
+		})
+	}
+	if bookings > 0 && !c.QueryBool("confirm") {
+		return confirmationResponse(c, []scheduleChange{{Action: "update", Date: before.Date.Format("2006-01-02"), MarketOpenDateID: before.ID, Bookings: bookings}})
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := detachOccurrence(tx, before); err != nil {
+			return err
+		}
+		return tx.Omit("ShopOpenDates").Save(&marketOpenDate).Error
+	})
+	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to update market open date",
 		})
@@ -74,10 +98,35 @@ func UpdateMarketOpenDate(db *gorm.DB, c *fiber.Ctx) error {
 	return c.JSON(marketOpenDate)
 }
 
-// DeleteMarketOpenDate deletes a MarketOpenDate by ID
+// DeleteMarketOpenDate deletes a MarketOpenDate by ID. Its schedule, if any,
+// skips the date from then on. Deleting a date shops have booked needs ?confirm=true.
 func DeleteMarketOpenDate(db *gorm.DB, c *fiber.Ctx) error {
 	id := c.Params("id")
-	if err := db.Delete(&model.MarketOpenDate{}, id).Error; err != nil {
+	var marketOpenDate model.MarketOpenDate
+	if err := db.First(&marketOpenDate, id).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Market open date not found",
+		})
+	}
+
+	bookings, err := countBookings(db, marketOpenDate.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to count bookings",
+			"details": err.Error(),
+		})
+	}
+	if bookings > 0 && !c.QueryBool("confirm") {
+		return confirmationResponse(c, []scheduleChange{{Action: "delete", Date: marketOpenDate.Date.Format("2006-01-02"), MarketOpenDateID: marketOpenDate.ID, Bookings: bookings}})
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := detachOccurrence(tx, marketOpenDate); err != nil {
+			return err
+		}
+		return tx.Delete(&model.MarketOpenDate{}, marketOpenDate.ID).Error
+	})
+	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to delete market open date",
 		})
@@ -86,7 +135,7 @@ func DeleteMarketOpenDate(db *gorm.DB, c *fiber.Ctx) error {
 }
 
 //shop open time
-// CreateShopOpenDate creates a new ShopOpenDate entry
+// CreateShopOpenDate creates a new ShopOpenDate entry once it fits the market schedule
 func CreateShopOpenDate(db *gorm.DB, c *fiber.Ctx) error {
 	var shopOpenDate model.ShopOpenDate
 	if err := c.BodyParser(&shopOpenDate); err != nil {
@@ -94,6 +143,16 @@ func CreateShopOpenDate(db *gorm.DB, c *fiber.Ctx) error {
 			"error": "Invalid request payload",
 		})
 	}
+	problem, err := shopOpenDateProblem(db, shopOpenDate)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to check the market schedule",
+			"details": err.Error(),
+		})
+	}
+	if problem != nil {
+		return slotResponse(c, problem)
+	}
 	if err := db.Create(&shopOpenDate).Error; err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to create shop open date",
@@ -147,7 +206,8 @@ func GetShopOpenDateByShopID(db *gorm.DB, c *fiber.Ctx) error {
 	return c.JSON(shopOpenDates)
 }
 
-// UpdateShopOpenDate updates a ShopOpenDate entry by ID
+// UpdateShopOpenDate updates a ShopOpenDate entry by ID. Times that break the
+// market schedule are refused with 422 and a suggested slot.
 func UpdateShopOpenDate(db *gorm.DB, c *fiber.Ctx) error {
 	id := c.Params("id")
 	var shopOpenDate model.ShopOpenDate
@@ -157,12 +217,24 @@ func UpdateShopOpenDate(db *gorm.DB, c *fiber.Ctx) error {
 		})
 	}
 
+	existingID := shopOpenDate.ID
 	if err := c.BodyParser(&shopOpenDate); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error": "Invalid request payload",
 		})
 	}
+	shopOpenDate.ID = existingID
 
+	problem, err := shopOpenDateProblem(db, shopOpenDate)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to check the market schedule",
+			"details": err.Error(),
+		})
+	}
+	if problem != nil {
+		return slotResponse(c, problem)
+	}
 	if err := db.Save(&shopOpenDate).Error; err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to update shop open date",
@@ -264,8 +336,8 @@ func DeleteContactToAdmin(db *gorm.DB, c *fiber.Ctx) error {
 func GetAllTempShopsWaiting(db *gorm.DB, c *fiber.Ctx) error {
 	var tempShops []model.TempShop
 
-	// Fetch all TempShop entries where status is "Waiting"
-	if err := db.Where("status = ?", "Waiting").
+	// Fetch all TempShop entries submitted or under review
+	if err := db.Where("status IN ?", awaitingReview).
 		Find(&tempShops).Error; err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error":   "Failed to retrieve temp shops",
@@ -278,193 +350,313 @@ func GetAllTempShopsWaiting(db *gorm.DB, c *fiber.Ctx) error {
 		if tempShop.ShopID == nil {
 			continue // Skip if ShopID is nil
 		}
-		shopID := *tempShop.ShopID
-
-		// Fetch social media info
-		TempSocials, err := GetTempSocialsByShopID(db, shopID)
+		response, err := tempShopView(db, tempShop)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error":   "Failed to retrieve social media data",
+				"error":   "Failed to retrieve shop data",
 				"details": err.Error(),
 			})
 		}
+		tempShopResponses = append(tempShopResponses, response)
+	}
 
-		// Fetch menu info
-		tempMenus, err := getMenuForTempByShopID(db, shopID)
-		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error":   "Failed to retrieve menu data",
-				"details": err.Error(),
-			})
-		}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"temp_shops": tempShopResponses,
+	})
+}
 
-		// Fetch shop photos and menu photos separately
-		photoData, err := getPhotoForTempByShopID(db, shopID)
-		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error":   "Failed to retrieve photo data",
-				"details": err.Error(),
-			})
-		}
+// tempShopView shows a shop as it would be once its pending changes are
+// approved, next to its current open times
+func tempShopView(db *gorm.DB, tempShop model.TempShop) (fiber.Map, error) {
+	shopID := *tempShop.ShopID
 
-		// Extract separate lists for shop photos and menu photos
-		photosShop, _ := photoData["photos_shop"].([]fiber.Map)
-		photosMenu, _ := photoData["photos_menu"].([]fiber.Map)
+	// Fetch social media info
+	TempSocials, err := GetTempSocialsByShopID(db, shopID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve social media data: %w", err)
+	}
 
-		// Fetch shop open time info
-		tempTimes, err := GetTempTimeByShopID(db, shopID)
-		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error":   "Failed to retrieve shop time data",
-				"details": err.Error(),
-			})
-		}
+	// Fetch menu info
+	tempMenus, err := getMenuForTempByShopID(db, shopID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve menu data: %w", err)
+	}
 
-		// Fetch permanent shop open date info
-		shopOpenDates, err := GetShopOpenDateForTempByShopID(db, shopID)
-		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error":   "Failed to retrieve shop open date data",
-				"details": err.Error(),
-			})
-		}
+	// Fetch shop photos and menu photos separately
+	photoData, err := getPhotoForTempByShopID(db, shopID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve photo data: %w", err)
+	}
 
-		socials, _ := TempSocials["socials"].([]fiber.Map)
+	// Extract separate lists for shop photos and menu photos
+	photosShop, _ := photoData["photos_shop"].([]fiber.Map)
+	photosMenu, _ := photoData["photos_menu"].([]fiber.Map)
 
-		// Extract time data
-		addTime, _ := tempTimes["addTime"].([]fiber.Map)
-		editTime, _ := tempTimes["editTime"].([]fiber.Map)
-		deleteTime, _ := tempTimes["deleteTime"].([]fiber.Map)
+	// Fetch shop open time info
+	tempTimes, err := GetTempTimeByShopID(db, shopID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve shop time data: %w", err)
+	}
 
-		tempShopResponses = append(tempShopResponses, fiber.Map{
-			"id":            tempShop.TempID,
-			"name":          tempShop.Name,
-			"description":	 tempShop.Description,
-			"category_id":	 tempShop.ShopCategoryID,
-			"shop_id":       shopID,
-			"socials":       socials,
-			"menus":         tempMenus,  // Include menus in the response
-			"photos_shop":   photosShop, // Photos directly related to the shop
-			"photos_menu":   photosMenu, // Photos linked to menus
-			"addTime":       addTime,    // Include added times
-			"editTime":      editTime,   // Include edited times
-			"deleteTime":    deleteTime, // Include deleted times
-			"time":          shopOpenDates, // Include shop open dates
-		})
+	// Fetch permanent shop open date info
+	shopOpenDates, err := GetShopOpenDateForTempByShopID(db, shopID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve shop open date data: %w", err)
 	}
 
-	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"temp_shops": tempShopResponses,
-	})
+	socials, _ := TempSocials["socials"].([]fiber.Map)
+
+	// Extract time data
+	addTime, _ := tempTimes["addTime"].([]fiber.Map)
+	editTime, _ := tempTimes["editTime"].([]fiber.Map)
+	deleteTime, _ := tempTimes["deleteTime"].([]fiber.Map)
+
+	return fiber.Map{
+		"id":          tempShop.TempID,
+		"name":        tempShop.Name,
+		"description": tempShop.Description,
+		"category_id": tempShop.ShopCategoryID,
+		"status":      tempShop.Status,
+		"shop_id":     shopID,
+		"socials":     socials,
+		"menus":       tempMenus,     // Include menus in the response
+		"photos_shop": photosShop,    // Photos directly related to the shop
+		"photos_menu": photosMenu,    // Photos linked to menus
+		"addTime":     addTime,       // Include added times
+		"editTime":    editTime,      // Include edited times
+		"deleteTime":  deleteTime,    // Include deleted times
+		"time":        shopOpenDates, // Include shop open dates
+	}, nil
 }
 
+// pendingView indexes the pending ops of a shop for the review listings:
+// updates and deletions by the record they target, creations in staging order.
+type pendingView struct {
+	targeted map[string]map[uint]model.ChangeOp
+	created  map[string][]model.ChangeOp
+}
 
+func loadPendingView(db *gorm.DB, shopID uint) (pendingView, error) {
+	view := pendingView{targeted: map[string]map[uint]model.ChangeOp{}, created: map[string][]model.ChangeOp{}}
+	ops, err := pendingOpsOfShop(db, shopID)
+	if err != nil {
+		return view, err
+	}
+	for _, op := range ops {
+		if op.EntityID == nil {
+			view.created[op.Entity] = append(view.created[op.Entity], op)
+			continue
+		}
+		if view.targeted[op.Entity] == nil {
+			view.targeted[op.Entity] = map[uint]model.ChangeOp{}
+		}
+		view.targeted[op.Entity][*op.EntityID] = op
+	}
+	return view, nil
+}
 
+// pending returns the update or delete op staged for a live record, if any
+func (v pendingView) pending(entity string, id uint) (model.ChangeOp, bool) {
+	op, ok := v.targeted[entity][id]
+	return op, ok
+}
 
 func GetTempSocialsByShopID(db *gorm.DB, shopID uint) (fiber.Map, error) {
-    var socials []model.SocialMedia
-
-    // Subquery to get SocialIDs that exist in DeleteSocial
-    subQuery := db.Table("delete_socials").Select("social_id")
-
-    // Query for socials that are not in DeleteSocial
-    if err := db.Where("shop_id = ? AND id NOT IN (?)", shopID, subQuery).
-        Find(&socials).Error; err != nil {
-        return nil, err
-    }
-
-    // Convert results to fiber.Map
-    socialsResult := make([]fiber.Map, len(socials))
-    for i, social := range socials {
-        socialsResult[i] = fiber.Map{
-            "id":        social.ID,
-            "name":      social.Name,
-            "platform":  social.Platform,
-            "link":      social.Link,
-            "shop_id":   social.ShopID,
-            "is_public": social.IsPublic,
-        }
-    }
-
-    return fiber.Map{
-        "socials": socialsResult,
-    }, nil
+	view, err := loadPendingView(db, shopID)
+	if err != nil {
+		return nil, err
+	}
+
+	var socials []model.SocialMedia
+	if err := db.Where("shop_id = ?", shopID).Find(&socials).Error; err != nil {
+		return nil, err
+	}
+
+	// Live socials as they would be after approval, then the added ones
+	socialsResult := []fiber.Map{}
+	for _, social := range socials {
+		result := fiber.Map{
+			"id":        social.ID,
+			"name":      social.Name,
+			"platform":  social.Platform,
+			"link":      social.Link,
+			"shop_id":   social.ShopID,
+			"is_public": social.IsPublic,
+		}
+		if op, ok := view.pending(model.ChangeEntitySocial, social.ID); ok {
+			if op.Action == model.ChangeDelete {
+				continue
+			}
+			var payload socialPayload
+			if err := decodePayload(op, &payload); err != nil {
+				return nil, err
+			}
+			result["name"], result["platform"], result["link"] = payload.Name, payload.Platform, payload.Link
+			result["op_id"] = op.ID
+		}
+		socialsResult = append(socialsResult, result)
+	}
+	for _, op := range view.created[model.ChangeEntitySocial] {
+		var payload socialPayload
+		if err := decodePayload(op, &payload); err != nil {
+			return nil, err
+		}
+		socialsResult = append(socialsResult, fiber.Map{
+			"id":        0,
+			"op_id":     op.ID,
+			"name":      payload.Name,
+			"platform":  payload.Platform,
+			"link":      payload.Link,
+			"shop_id":   shopID,
+			"is_public": false,
+		})
+	}
+
+	return fiber.Map{
+		"socials": socialsResult,
+	}, nil
 }
+
 func getMenuForTempByShopID(db *gorm.DB, shopID uint) ([]fiber.Map, error) {
-    var menus []model.ShopMenu
-
-    // Subquery to get MenuIDs that exist in DeleteMenu
-    subQuery := db.Table("delete_menus").Select("menu_id")
-
-    // Query menus based on the conditions
-    if err := db.Where("shop_id = ? AND id NOT IN (?)", shopID, subQuery).
-        Find(&menus).Error; err != nil {
-        return nil, err
-    }
-
-    // Convert results to fiber.Map
-    var menuResults []fiber.Map
-
-    for _, menu := range menus {
-        // Get associated photos
-        photos, err := getPhotoForTempByMenuID(db, menu.ID)
-        if err != nil {
-            return nil, err
-        }
-
-        menuResults = append(menuResults, fiber.Map{
-            "id":                  menu.ID,
-            "product_name":        menu.ProductName,
-            "product_description": menu.ProductDescription,
-            "price":               menu.Price,
-            "shop_id":             menu.ShopID,
-            "is_public":           menu.IsPublic,
-            "photos":              photos,
-        })
-    }
-
-    return menuResults, nil
-}
+	view, err := loadPendingView(db, shopID)
+	if err != nil {
+		return nil, err
+	}
 
+	var menus []model.ShopMenu
+	if err := db.Where("shop_id = ?", shopID).Find(&menus).Error; err != nil {
+		return nil, err
+	}
 
-func getPhotoForTempByMenuID(db *gorm.DB, menuID uint) ([]fiber.Map, error) {
-	var photos []model.Photo
+	var menuResults []fiber.Map
+	for _, menu := range menus {
+		result := fiber.Map{
+			"id":                  menu.ID,
+			"product_name":        menu.ProductName,
+			"product_description": menu.ProductDescription,
+			"price":               menu.Price,
+			"shop_id":             menu.ShopID,
+			"is_public":           menu.IsPublic,
+		}
+		if op, ok := view.pending(model.ChangeEntityMenu, menu.ID); ok {
+			if op.Action == model.ChangeDelete {
+				continue
+			}
+			var payload menuPayload
+			if err := decodePayload(op, &payload); err != nil {
+				return nil, err
+			}
+			result["product_name"], result["product_description"], result["price"] = payload.ProductName, payload.ProductDescription, payload.Price
+			result["op_id"] = op.ID
+		}
 
-	// Subquery to get PhotoIDs that exist in DeletePhoto
-	subQuery := db.Table("delete_photos").Select("photo_id")
+		photos, err := getPhotoForTempByMenuID(db, view, menu.ID)
+		if err != nil {
+			return nil, err
+		}
+		result["photos"] = photos
+		menuResults = append(menuResults, result)
+	}
+
+	// Added menus carry the photos staged for them
+	for _, op := range view.created[model.ChangeEntityMenu] {
+		var payload menuPayload
+		if err := decodePayload(op, &payload); err != nil {
+			return nil, err
+		}
+		photos := []fiber.Map{}
+		for _, photoOp := range view.created[model.ChangeEntityPhoto] {
+			if photoOp.RefOpID == nil || *photoOp.RefOpID != op.ID {
+				continue
+			}
+			photo, err := stagedPhoto(photoOp)
+			if err != nil {
+				return nil, err
+			}
+			photos = append(photos, photo)
+		}
+		menuResults = append(menuResults, fiber.Map{
+			"id":                  0,
+			"op_id":               op.ID,
+			"product_name":        payload.ProductName,
+			"product_description": payload.ProductDescription,
+			"price":               payload.Price,
+			"shop_id":             shopID,
+			"is_public":           false,
+			"photos":              photos,
+		})
+	}
+
+	return menuResults, nil
+}
+
+// stagedPhoto lists a photo that only exists as a pending create op
+func stagedPhoto(op model.ChangeOp) (fiber.Map, error) {
+	var payload photoPayload
+	if err := decodePayload(op, &payload); err != nil {
+		return nil, err
+	}
+	photo := fiber.Map{
+		"id":        0,
+		"op_id":     op.ID,
+		"path_file": payload.PathFile,
+		"shop_id":   payload.ShopID,
+		"menu_id":   payload.MenuID,
+		"is_public": false,
+	}
+	if op.RefOpID != nil {
+		photo["menu_change_id"] = *op.RefOpID
+	}
+	return photo, nil
+}
 
-	// Query photos based on the condition
-	if err := db.Where("(is_public = ? AND id NOT IN (?)) OR is_public = ?", true, subQuery, false).
-		Where("menu_id = ?", menuID).
-		Find(&photos).Error; err != nil {
+func getPhotoForTempByMenuID(db *gorm.DB, view pendingView, menuID uint) ([]fiber.Map, error) {
+	var photos []model.Photo
+	if err := db.Where("menu_id = ?", menuID).Find(&photos).Error; err != nil {
 		return nil, err
 	}
 
-	// Convert results to fiber.Map
-	result := make([]fiber.Map, len(photos))
-	for i, photo := range photos {
-		result[i] = fiber.Map{
+	result := []fiber.Map{}
+	for _, photo := range photos {
+		if _, ok := view.pending(model.ChangeEntityPhoto, photo.ID); ok {
+			continue // only deletions can target a photo
+		}
+		result = append(result, fiber.Map{
 			"id":        photo.ID,
 			"path_file": photo.PathFile,
 			"menu_id":   photo.MenuID,
 			"is_public": photo.IsPublic,
+		})
+	}
+	for _, op := range view.created[model.ChangeEntityPhoto] {
+		var payload photoPayload
+		if err := decodePayload(op, &payload); err != nil {
+			return nil, err
+		}
+		if payload.MenuID == nil || *payload.MenuID != menuID {
+			continue
+		}
+		photo, err := stagedPhoto(op)
+		if err != nil {
+			return nil, err
 		}
+		result = append(result, photo)
 	}
 
 	return result, nil
 }
 
 func getPhotoForTempByShopID(db *gorm.DB, shopID uint) (fiber.Map, error) {
+	view, err := loadPendingView(db, shopID)
+	if err != nil {
+		return nil, err
+	}
+
 	var photosShop []model.Photo
 	var photosMenu []model.Photo
 
-	// Subquery to get PhotoIDs that exist in DeletePhoto
-	subQuery := db.Table("delete_photos").Select("photo_id")
-
-	// Fetch photos that belong to the shop (excluding deleted ones)
-	if err := db.Where("(is_public = ? AND id NOT IN (?)) OR is_public = ?", true, subQuery, false).
-		Where("shop_id = ?", shopID).
-		Find(&photosShop).Error; err != nil {
+	// Fetch photos that belong to the shop
+	if err := db.Where("shop_id = ?", shopID).Find(&photosShop).Error; err != nil {
 		return nil, err
 	}
 
@@ -474,96 +666,99 @@ func getPhotoForTempByShopID(db *gorm.DB, shopID uint) (fiber.Map, error) {
 		return nil, err
 	}
 
-	// Fetch photos that belong to those menu IDs (excluding deleted ones)
+	// Fetch photos that belong to those menu IDs
 	if len(menuIDs) > 0 {
-		if err := db.Where("(is_public = ? AND id NOT IN (?)) OR is_public = ?", true, subQuery, false).
-			Where("menu_id IN (?)", menuIDs).
-			Find(&photosMenu).Error; err != nil {
+		if err := db.Where("menu_id IN (?)", menuIDs).Find(&photosMenu).Error; err != nil {
 			return nil, err
 		}
 	}
 
-	// Convert results to fiber.Map
+	// Convert results to fiber.Map, leaving out photos staged for deletion
 	convertPhotos := func(photos []model.Photo) []fiber.Map {
-		result := make([]fiber.Map, len(photos))
-		for i, photo := range photos {
-			result[i] = fiber.Map{
+		result := []fiber.Map{}
+		for _, photo := range photos {
+			if _, ok := view.pending(model.ChangeEntityPhoto, photo.ID); ok {
+				continue
+			}
+			result = append(result, fiber.Map{
 				"id":        photo.ID,
 				"path_file": photo.PathFile,
 				"shop_id":   photo.ShopID,
 				"menu_id":   photo.MenuID,
 				"is_public": photo.IsPublic,
-			}
+			})
 		}
 		return result
 	}
+	shopResults, menuResults := convertPhotos(photosShop), convertPhotos(photosMenu)
+
+	// Added photos go with the shop unless they name a menu or a menu being added
+	for _, op := range view.created[model.ChangeEntityPhoto] {
+		var payload photoPayload
+		if err := decodePayload(op, &payload); err != nil {
+			return nil, err
+		}
+		photo, err := stagedPhoto(op)
+		if err != nil {
+			return nil, err
+		}
+		if payload.ShopID != nil {
+			shopResults = append(shopResults, photo)
+		} else {
+			menuResults = append(menuResults, photo)
+		}
+	}
 
 	// Return structured response
 	return fiber.Map{
-		"photos_shop": convertPhotos(photosShop), // Photos with shop_id
-		"photos_menu": convertPhotos(photosMenu), // Photos linked to menus
+		"photos_shop": shopResults, // Photos with shop_id
+		"photos_menu": menuResults, // Photos linked to menus
 	}, nil
 }
 
-
+// GetTempTimeByShopID lists the open time changes staged for a shop. Each
+// entry's id is the ChangeOp; deletions show the open time they remove.
 func GetTempTimeByShopID(db *gorm.DB, shopID uint) (fiber.Map, error) {
-	var addTime []model.TempShopOpenDate
-	var editTime []model.TempShopOpenDate
-	var deleteTime []model.TempShopOpenDate
-
-	// Query for added times
-	if err := db.Where("shop_id = ? AND operation = ?", shopID, "add").Find(&addTime).Error; err != nil {
+	ops, err := pendingOpsOfShop(db, shopID)
+	if err != nil {
 		return nil, err
 	}
 
-	// Query for edited times
-	if err := db.Where("shop_id = ? AND operation = ?", shopID, "edit").Find(&editTime).Error; err != nil {
-		return nil, err
+	result := map[string][]fiber.Map{
+		model.ChangeCreate: {},
+		model.ChangeUpdate: {},
+		model.ChangeDelete: {},
 	}
-
-	// Query for deleted times
-	if err := db.Where("shop_id = ? AND operation = ?", shopID, "delete").Find(&deleteTime).Error; err != nil {
-		return nil, err
-	}
-
-	// Convert results to fiber.Map
-	addTimeResult := make([]fiber.Map, len(addTime))
-	for i, timeEntry := range addTime {
-		addTimeResult[i] = fiber.Map{
-			"id":                 timeEntry.ID,
-			"start_time":         timeEntry.StartTime,
-			"end_time":           timeEntry.EndTime,
-			"shop_id":            timeEntry.ShopID,
-			"market_open_date_id": timeEntry.MarketOpenDateID,
-		}
-	}
-
-	editTimeResult := make([]fiber.Map, len(editTime))
-	for i, timeEntry := range editTime {
-		editTimeResult[i] = fiber.Map{
-			"id":                 timeEntry.ID,
-			"start_time":         timeEntry.StartTime,
-			"end_time":           timeEntry.EndTime,
-			"shop_id":            timeEntry.ShopID,
-			"market_open_date_id": timeEntry.MarketOpenDateID,
+	for _, op := range ops {
+		if op.Entity != model.ChangeEntityOpenTime {
+			continue
 		}
-	}
-
-	deleteTimeResult := make([]fiber.Map, len(deleteTime))
-	for i, timeEntry := range deleteTime {
-		deleteTimeResult[i] = fiber.Map{
-			"id":                 timeEntry.ID,
-			"start_time":         timeEntry.StartTime,
-			"end_time":           timeEntry.EndTime,
-			"shop_id":            timeEntry.ShopID,
-			"market_open_date_id": timeEntry.MarketOpenDateID,
+		var payload openTimePayload
+		if op.Action == model.ChangeDelete {
+			var live model.ShopOpenDate
+			if err := db.First(&live, *op.EntityID).Error; err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					continue // the open time is already gone
+				}
+				return nil, err
+			}
+			payload = openTimePayload{MarketOpenDateID: live.MarketOpenDateID, StartTime: live.StartTime, EndTime: live.EndTime}
+		} else if err := decodePayload(op, &payload); err != nil {
+			return nil, err
 		}
+		result[op.Action] = append(result[op.Action], fiber.Map{
+			"id":                  op.ID,
+			"start_time":          payload.StartTime,
+			"end_time":            payload.EndTime,
+			"shop_id":             shopID,
+			"market_open_date_id": payload.MarketOpenDateID,
+		})
 	}
 
 	return fiber.Map{
-		"addTime":    addTimeResult,
-		"editTime":   editTimeResult,
-		"deleteTime": deleteTimeResult,
+		"addTime":    result[model.ChangeCreate],
+		"editTime":   result[model.ChangeUpdate],
+		"deleteTime": result[model.ChangeDelete],
 	}, nil
 }
 
@@ -1,8 +1,11 @@
 package controller
 
 import (
+	"errors"
 	"fmt"
+	"log"
 	"strconv"
+	"time"
 
 	"github.com/HealthMe-pls/medic-go-api/model"
 	"github.com/gofiber/fiber/v2"
@@ -15,7 +18,28 @@ func GetMarketMap(db *gorm.DB,c *fiber.Ctx) error {
 	return c.JSON(marketMap)
 }
 
+// GetMarketMapDetail returns every block with its shop. With ?date=YYYY-MM-DD
+// the shops are the ones with a confirmed reservation that day; without it,
+// the block's permanent shop.
 func GetMarketMapDetail(db *gorm.DB, c *fiber.Ctx) error {
+	if day := c.Query("date"); day != "" {
+		date, err := time.ParseInLocation("2006-01-02", day, time.Local)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "date must be YYYY-MM-DD"})
+		}
+		layout, err := marketDayLayout(db, date)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "No market on this date"})
+		}
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error":   "Failed to retrieve market layout",
+				"details": err.Error(),
+			})
+		}
+		return c.JSON(layout)
+	}
+
 	// Retrieve all market maps
 	var marketMaps []model.MarketMap
 	if err := db.Find(&marketMaps).Error; err != nil {
@@ -78,6 +102,7 @@ func CreateMarketMap(db *gorm.DB, c *fiber.Ctx) error {
             "error": "Failed to create market map",
         })
     }
+    recordAudit(db, c, "marketmap.create", auditEntityMarketMap, marketMap.BlockID, nil, marketMap)
 
     // Return the newly created MarketMap as a JSON response
     return c.Status(fiber.StatusCreated).JSON(marketMap)
@@ -135,11 +160,17 @@ func DeleteMarketMapsByBlockID(db *gorm.DB, c *fiber.Ctx) error {
         return c.Status(fiber.StatusBadRequest).SendString("Invalid BlockID format")
     }
 
+    var before []model.MarketMap
+    if err := db.Where("block_id = ?", blockIDUint).Find(&before).Error; err != nil {
+        return c.Status(fiber.StatusInternalServerError).SendString("Failed to load MarketMaps for BlockID")
+    }
+
     // Delete all MarketMap entries for the specific BlockID
     if err := db.Where("block_id = ?", blockIDUint).Delete(&model.MarketMap{}).Error; err != nil {
         // Return an internal server error if deletion fails
         return c.Status(fiber.StatusInternalServerError).SendString("Failed to delete MarketMaps for BlockID")
     }
+    recordAudit(db, c, "marketmap.delete", auditEntityMarketMap, blockIDUint, before, nil)
 
     // Successfully deleted all MarketMaps for the BlockID
     return c.SendString("All MarketMaps for the BlockID successfully deleted")
@@ -151,6 +182,7 @@ func UpdateAllMarketMaps(db *gorm.DB, c *fiber.Ctx) error {
         return c.Status(fiber.StatusBadRequest).SendString("Failed to parse request body")
     }
 
+    var before []model.MarketMap
     for _, update := range updates {
         blockID, ok := update["block_id"].(float64) // Ensure block_id is provided and is a valid number
         if !ok {
@@ -165,6 +197,7 @@ func UpdateAllMarketMaps(db *gorm.DB, c *fiber.Ctx) error {
         if err := db.First(&marketMap, "block_id = ?", blockIDUint).Error; err != nil {
             return c.Status(fiber.StatusNotFound).SendString(fmt.Sprintf("MarketMap with block_id %d not found", blockIDUint))
         }
+        before = append(before, marketMap)
 
         // Update fields dynamically from the update map
         for key, value := range update {
@@ -179,6 +212,16 @@ func UpdateAllMarketMaps(db *gorm.DB, c *fiber.Ctx) error {
         }
     }
 
+    // One entry per block, so the audit log can be filtered by block ID
+    for _, marketMap := range before {
+        var after model.MarketMap
+        if err := db.First(&after, "block_id = ?", marketMap.BlockID).Error; err != nil {
+            log.Printf("Failed to load MarketMap %d for the audit log: %v", marketMap.BlockID, err)
+            continue
+        }
+        recordAudit(db, c, "marketmap.bulk_update", auditEntityMarketMap, marketMap.BlockID, marketMap, after)
+    }
+
     // Return success response
     return c.Status(fiber.StatusOK).SendString("All MarketMaps updated successfully")
 }
@@ -199,6 +242,7 @@ func UpdateMarketMapByBlockID(db *gorm.DB, c *fiber.Ctx) error {
     if err := db.First(&marketMap, "block_id = ?", blockIDUint).Error; err != nil {
         return c.Status(fiber.StatusNotFound).SendString("MarketMap with specified BlockID not found")
     }
+    before := marketMap
 
     // Parse the request body to get updated fields
     if err := c.BodyParser(&marketMap); err != nil {
@@ -209,6 +253,7 @@ func UpdateMarketMapByBlockID(db *gorm.DB, c *fiber.Ctx) error {
     if err := db.Save(&marketMap).Error; err != nil {
         return c.Status(fiber.StatusInternalServerError).SendString("Failed to update MarketMap")
     }
+    recordAudit(db, c, "marketmap.update", auditEntityMarketMap, marketMap.BlockID, before, marketMap)
 
     // Return the updated MarketMap as JSON
     return c.Status(fiber.StatusOK).JSON(marketMap)
@@ -241,11 +286,20 @@ func DeleteMarketMapsByBlockName(db *gorm.DB, c *fiber.Ctx) error {
     // Get the BlockName from the URL parameters
     blockName := c.Params("block_name")
 
+    var before []model.MarketMap
+    if err := db.Where("block_name = ?", blockName).Find(&before).Error; err != nil {
+        return c.Status(fiber.StatusInternalServerError).SendString("Failed to load MarketMaps for BlockName")
+    }
+
     // Delete all MarketMap entries for the specified BlockName
     if err := db.Where("block_name = ?", blockName).Delete(&model.MarketMap{}).Error; err != nil {
         // Return an internal server error if deletion fails
         return c.Status(fiber.StatusInternalServerError).SendString("Failed to delete MarketMaps for BlockName")
     }
+    // One entry per block, so the audit log can be filtered by block ID
+    for _, marketMap := range before {
+        recordAudit(db, c, "marketmap.delete", auditEntityMarketMap, marketMap.BlockID, marketMap, nil)
+    }
 
     // Successfully deleted all MarketMaps for the BlockName
     return c.SendString("All MarketMaps for the BlockName successfully deleted")
@@ -260,6 +314,7 @@ func UpdateMarketMapByBlockName(db *gorm.DB, c *fiber.Ctx) error {
     if err := db.First(&marketMap, "block_name = ?", blockName).Error; err != nil {
         return c.Status(fiber.StatusNotFound).SendString("MarketMap with specified BlockName not found")
     }
+    before := marketMap
 
     // Parse the request body to get updated fields
     if err := c.BodyParser(&marketMap); err != nil {
@@ -270,6 +325,7 @@ func UpdateMarketMapByBlockName(db *gorm.DB, c *fiber.Ctx) error {
     if err := db.Save(&marketMap).Error; err != nil {
         return c.Status(fiber.StatusInternalServerError).SendString("Failed to update MarketMap")
     }
+    recordAudit(db, c, "marketmap.update", auditEntityMarketMap, marketMap.BlockID, before, marketMap)
 
     // Return the updated MarketMap as JSON
     return c.Status(fiber.StatusOK).JSON(marketMap)
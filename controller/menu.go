@@ -2,7 +2,6 @@ package controller
 
 import (
 	"fmt"
-	"os"
 	"strconv"
 
 	"github.com/HealthMe-pls/medic-go-api/model"
@@ -57,7 +56,7 @@ func GetShopMenuByShopID(db *gorm.DB, c *fiber.Ctx) error {
 
 	return c.JSON(shopMenus)
 }
-// UpdateShopMenu updates a ShopMenu entry and its corresponding TempMenu entry
+// UpdateShopMenu updates a ShopMenu entry
 func UpdateShopMenu(db *gorm.DB, c *fiber.Ctx) error {
 	id := c.Params("id")
 
@@ -76,71 +75,53 @@ func UpdateShopMenu(db *gorm.DB, c *fiber.Ctx) error {
 		})
 	}
 
-	// Begin transaction to update both tables
-	tx := db.Begin()
-
 	// Update the ShopMenu entry
-	if err := tx.Save(&shopMenu).Error; err != nil {
-		tx.Rollback()
+	if err := db.Save(&shopMenu).Error; err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to update shop menu",
 		})
 	}
 
-	// Check if a TempMenu exists for this menu ID
-	var tempMenu model.TempMenu
-	if err := tx.Where("menu_id = ?", shopMenu.ID).First(&tempMenu).Error; err == nil {
-		// Update TempMenu if it exists
-		tempMenu.ProductDescription = shopMenu.ProductDescription
-		tempMenu.Price = shopMenu.Price
-		tempMenu.ProductName = shopMenu.ProductName
-
-		if err := tx.Save(&tempMenu).Error; err != nil {
-			tx.Rollback()
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": "Failed to update temp menu",
-			})
-		}
-	}
-
-	// Commit the transaction
-	tx.Commit()
-
 	return c.JSON(shopMenu)
 }
 
-// DeleteShopMenu deletes a menu and related data within the same transaction
+// DeleteShopMenu deletes a menu and related data within the same transaction.
+// Photo files are removed right away; use deleteShopMenuRows to defer that until commit.
 func DeleteShopMenu(tx *gorm.DB, menuID uint) error {
+	files, err := deleteShopMenuRows(tx, menuID)
+	if err != nil {
+		return err
+	}
+	removeUploads(files)
+	return nil
+}
+
+// deleteShopMenuRows deletes a menu with its photos and returns
+// the photo files to remove once the transaction has committed
+func deleteShopMenuRows(tx *gorm.DB, menuID uint) ([]string, error) {
 	// Step 1: Retrieve and delete associated photos
 	var photos []model.Photo
 	if err := tx.Where("menu_id = ?", menuID).Find(&photos).Error; err != nil {
-		return fmt.Errorf("failed to check associated photos")
+		return nil, fmt.Errorf("failed to check associated photos")
 	}
 
-	// Delete photos from filesystem
+	// Collect files to delete from the filesystem
+	var files []string
 	for _, photo := range photos {
-		filePath := fmt.Sprintf("./uploads/%s", photo.PathFile)
-		if err := os.Remove(filePath); err != nil {
-			fmt.Println("Error deleting file:", err)
-		}
+		files = append(files, photo.PathFile)
 	}
 
 	// Delete all photos in DB
 	if err := tx.Where("menu_id = ?", menuID).Delete(&model.Photo{}).Error; err != nil {
-		return fmt.Errorf("failed to delete photos from database")
+		return nil, fmt.Errorf("failed to delete photos from database")
 	}
 
-	// Step 2: Delete the corresponding TempMenu entry
-	if err := tx.Where("menu_id = ?", menuID).Delete(&model.TempMenu{}).Error; err != nil {
-		return fmt.Errorf("failed to delete associated TempMenu")
-	}
-
-	// Step 3: Delete the ShopMenu entry
+	// Step 2: Delete the ShopMenu entry
 	if err := tx.Where("id = ?", menuID).Delete(&model.ShopMenu{}).Error; err != nil {
-		return fmt.Errorf("failed to delete shop menu")
+		return nil, fmt.Errorf("failed to delete shop menu")
 	}
 
-	return nil
+	return files, nil
 }
 
 // DeleteShopMenuByID extracts menu_id from request, converts to uint, and calls DeleteShopMenu
@@ -175,6 +156,8 @@ func DeleteShopMenuByID(db *gorm.DB, c *fiber.Ctx) error {
 }
 
 
+// CreateMenuWithTemp adds a menu to a shop. Admins create it live; an
+// entrepreneur's menu is staged as a change and appears once approved.
 func CreateMenuWithTemp(db *gorm.DB, c *fiber.Ctx, isPublic bool) error {
 	menu := new(model.ShopMenu)
 	if err := c.BodyParser(menu); err != nil {
@@ -184,9 +167,17 @@ func CreateMenuWithTemp(db *gorm.DB, c *fiber.Ctx, isPublic bool) error {
 		})
 	}
 
-	// Assign isPublic from function parameter
-	menu.IsPublic = isPublic
-    // Find TempShop that has the same ShopID as the social media
+	if !isPublic {
+		payload := menuPayload{ProductName: menu.ProductName, ProductDescription: menu.ProductDescription, Price: menu.Price}
+		op, err := newChangeOp(model.ChangeEntityMenu, model.ChangeCreate, nil, payload)
+		if err == nil {
+			op, err = stageShopChange(db, actorOf(c), menu.ShopID, op)
+		}
+		return stageResponse(c, op, err)
+	}
+
+	menu.IsPublic = true
+    // Find TempShop that has the same ShopID as the menu
     var tempShop model.TempShop
     if err := db.Where("shop_id = ?", menu.ShopID).First(&tempShop).Error; err != nil {
         return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
@@ -203,52 +194,12 @@ func CreateMenuWithTemp(db *gorm.DB, c *fiber.Ctx, isPublic bool) error {
 		})
 	}
 
-
-	// Create a corresponding TempMenu entry
-	tempMenu := model.TempMenu{
-		TempID:             tempShop.TempID,
-		MenuID:             menu.ID,
-		ProductName:        menu.ProductName,
-		ProductDescription: menu.ProductDescription,
-		Price:              menu.Price,
-	}
-
-	if result := db.Create(&tempMenu); result.Error != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error":   "Failed to create temp menu",
-			"details": result.Error.Error(),
-		})
-	}
-
-	// Return created menu and temp menu
 	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
-		"menu":     menu,
-		"tempMenu": tempMenu,
+		"menu": menu,
 	})
 }
 
 
-
-func CreateTempMenu(db *gorm.DB, c *fiber.Ctx) error {
-	tempMenu := new(model.TempMenu)
-	if err := c.BodyParser(tempMenu); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error":   "Failed to parse request body",
-			"details": err.Error(),
-		})
-	}
-
-	if result := db.Create(&tempMenu); result.Error != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error":   "Failed to create temp menu",
-			"details": result.Error.Error(),
-		})
-	}
-
-	return c.Status(fiber.StatusCreated).JSON(tempMenu)
-}
-
-
 func GetShopIDByMenuID(db *gorm.DB, c *fiber.Ctx) error {
 	menuID := c.Params("menu_id")
 
@@ -267,6 +218,8 @@ func GetShopIDByMenuID(db *gorm.DB, c *fiber.Ctx) error {
 	})
 }
 
+// UpdateTempMenuByMenuID stages an edit of a menu. Fields missing from the
+// body keep their live value.
 func UpdateTempMenuByMenuID(db *gorm.DB, c *fiber.Ctx) error {
 	menuID, err := strconv.Atoi(c.Params("menu_id"))
 	if err != nil {
@@ -283,64 +236,18 @@ func UpdateTempMenuByMenuID(db *gorm.DB, c *fiber.Ctx) error {
 		})
 	}
 
-	// Check if TempMenu exists for this MenuID
-	var tempMenu model.TempMenu
-	if err := db.Where("menu_id = ?", menu.ID).First(&tempMenu).Error; err != nil {
-		// If not found, create a new TempMenu entry
-		tempMenu = model.TempMenu{
-			MenuID:             menu.ID,
-			TempID:             *menu.TempID, // Ensure TempID is not nil
-			ProductDescription: menu.ProductDescription,
-			Price:              menu.Price,
-			ProductName:        menu.ProductName,
-		}
-		if err := db.Create(&tempMenu).Error; err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": "Failed to create temp menu",
-			})
-		}
-	} else {
-		// If found, update existing TempMenu
-		tempMenu.ProductDescription = menu.ProductDescription
-		tempMenu.Price = menu.Price
-		tempMenu.ProductName = menu.ProductName
-
-		if err := db.Save(&tempMenu).Error; err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": "Failed to update temp menu",
+	payload := menuPayload{ProductName: menu.ProductName, ProductDescription: menu.ProductDescription, Price: menu.Price}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&payload); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error":   "Failed to parse request body",
+				"details": err.Error(),
 			})
 		}
 	}
-
-	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"message":   "Temp menu updated successfully",
-		"temp_menu": tempMenu,
-	})
-}
-
-
-func UpdateMenuFromTemp(db *gorm.DB, tempID uint) error {
-	// Fetch the TempMenu record by TempID
-	var tempMenu model.TempMenu
-	if err := db.First(&tempMenu, "temp_id = ?", tempID).Error; err != nil {
-		return fmt.Errorf("TempMenu not found: %w", err)
+	op, err := newChangeOp(model.ChangeEntityMenu, model.ChangeUpdate, &menu.ID, payload)
+	if err == nil {
+		op, err = stageShopChange(db, actorOf(c), menu.ShopID, op)
 	}
-
-	// Fetch the corresponding ShopMenu record by MenuID
-	var shopMenu model.ShopMenu
-	if err := db.First(&shopMenu, "id = ?", tempMenu.MenuID).Error; err != nil {
-		return fmt.Errorf("ShopMenu not found for MenuID %d: %w", tempMenu.MenuID, err)
-	}
-
-	// Update ShopMenu with values from TempMenu
-	shopMenu.ProductDescription = tempMenu.ProductDescription
-	shopMenu.Price = tempMenu.Price
-	shopMenu.ProductName = tempMenu.ProductName
-
-	// Save the updated ShopMenu record
-	if err := db.Save(&shopMenu).Error; err != nil {
-		return fmt.Errorf("failed to update ShopMenu: %w", err)
-	}
-
-	return nil
+	return stageResponse(c, op, err)
 }
@@ -0,0 +1,369 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/HealthMe-pls/medic-go-api/model"
+	"gorm.io/gorm"
+)
+
+// legacyChangesMigration names the conversion in the data_migrations table
+const legacyChangesMigration = "legacy_changes_to_change_sets"
+
+// MigrateLegacyChanges moves pending edits still stored the old way (TempShop
+// fields, TempMenu, TempSocial, TempShopOpenDate, the Delete* bins and
+// is_public=false rows) into change sets and removes the old rows. It runs
+// once: afterwards change sets are the only pending state, and private rows or
+// TempShop fields written since mean nothing. A database that already has
+// change sets was converted by an earlier build and is only marked done, and
+// only TempShops still holding legacy rows or waiting for review are
+// converted. Each shop is converted in its own transaction, so a failed run
+// picks up what is left. Entries that no longer make sense, such as an edit of
+// a deleted menu, are logged and dropped, and the files of photos that are
+// dropped or were binned are removed once their shop is converted.
+func MigrateLegacyChanges(db *gorm.DB) error {
+	var done int64
+	if err := db.Model(&model.DataMigration{}).Where("name = ?", legacyChangesMigration).Count(&done).Error; err != nil {
+		return fmt.Errorf("failed to check migration marker: %w", err)
+	}
+	if done > 0 {
+		return nil
+	}
+	var changeSets int64
+	if err := db.Model(&model.ChangeSet{}).Count(&changeSets).Error; err != nil {
+		return fmt.Errorf("failed to count change sets: %w", err)
+	}
+
+	if changeSets == 0 {
+		var tempShops []model.TempShop
+		if err := db.Where("shop_id IS NOT NULL").Find(&tempShops).Error; err != nil {
+			return fmt.Errorf("failed to load temp shops: %w", err)
+		}
+		for _, tempShop := range tempShops {
+			var converted int
+			var orphans []string
+			err := db.Transaction(func(tx *gorm.DB) error {
+				var err error
+				converted, orphans, err = migrateLegacyTempShop(tx, tempShop)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("shop %d: %w", *tempShop.ShopID, err)
+			}
+			removeUploads(orphans)
+			if converted > 0 {
+				log.Printf("Converted %d legacy pending changes of shop %d", converted, *tempShop.ShopID)
+			}
+		}
+	}
+	return db.Create(&model.DataMigration{Name: legacyChangesMigration, AppliedAt: time.Now()}).Error
+}
+
+// hasLegacyChanges reports whether tempShop still holds edits the old way: rows
+// in the Temp* or Delete* tables, or a submission waiting for review
+func hasLegacyChanges(tx *gorm.DB, tempShop model.TempShop) (bool, error) {
+	if tempShop.Status == model.StatusSubmitted || tempShop.Status == model.StatusInReview {
+		return true, nil
+	}
+	for _, legacy := range []interface{}{
+		&model.TempMenu{}, &model.TempSocial{}, &model.TempShopOpenDate{},
+		&model.DeleteMenu{}, &model.DeleteSocial{}, &model.DeletePhoto{},
+	} {
+		var count int64
+		if err := tx.Model(legacy).Where("temp_id = ?", tempShop.TempID).Count(&count).Error; err != nil {
+			return false, err
+		}
+		if count > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// migrateLegacyTempShop converts tempShop's legacy rows and returns how many
+// changes were staged and the photo files no staged change refers to any more,
+// to remove once the transaction has committed
+func migrateLegacyTempShop(tx *gorm.DB, tempShop model.TempShop) (int, []string, error) {
+	shopID, tempID := *tempShop.ShopID, tempShop.TempID
+	if legacy, err := hasLegacyChanges(tx, tempShop); err != nil || !legacy {
+		return 0, nil, err
+	}
+	var shop model.Shop
+	if err := tx.First(&shop, shopID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return 0, nil, nil
+		}
+		return 0, nil, err
+	}
+
+	var cs *model.ChangeSet
+	var orphans []string
+	converted := 0
+	stage := func(op model.ChangeOp, err error) (model.ChangeOp, error) {
+		if err != nil {
+			return op, err
+		}
+		if cs == nil {
+			set, err := openChangeSet(tx, shopID, changeSourceLegacy)
+			if err != nil {
+				return op, err
+			}
+			cs = &set
+		}
+		staged, err := stageChange(tx, *cs, op)
+		var invalid changeErrors
+		if errors.As(err, &invalid) {
+			log.Printf("Dropped legacy change of shop %d: %v", shopID, invalid)
+			return staged, nil
+		}
+		if err == nil {
+			converted++
+		}
+		return staged, err
+	}
+
+	// Staged deletions, so records that were added and then binned are dropped
+	var binnedMenus, binnedSocials, binnedPhotos []uint
+	if err := tx.Model(&model.DeleteMenu{}).Where("temp_id = ?", tempID).Pluck("menu_id", &binnedMenus).Error; err != nil {
+		return 0, nil, err
+	}
+	if err := tx.Model(&model.DeleteSocial{}).Where("temp_id = ?", tempID).Pluck("social_id", &binnedSocials).Error; err != nil {
+		return 0, nil, err
+	}
+	if err := tx.Model(&model.DeletePhoto{}).Where("temp_id = ?", tempID).Pluck("photo_id", &binnedPhotos).Error; err != nil {
+		return 0, nil, err
+	}
+	binned := func(ids []uint, id uint) bool {
+		for _, binnedID := range ids {
+			if binnedID == id {
+				return true
+			}
+		}
+		return false
+	}
+
+	// TempShop fields differing from the shop are an edit of the shop
+	category := shop.ShopCategoryID
+	if tempShop.ShopCategoryID != nil {
+		category = *tempShop.ShopCategoryID
+	}
+	if tempShop.Name != shop.Name || tempShop.Description != shop.Description || category != shop.ShopCategoryID {
+		payload := shopPayload{Name: tempShop.Name, Description: tempShop.Description, ShopCategoryID: category}
+		if _, err := stage(newChangeOp(model.ChangeEntityShop, model.ChangeUpdate, &shopID, payload)); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	// Private menus were created for review; their photos follow them
+	var hiddenMenus []model.ShopMenu
+	if err := tx.Where("shop_id = ? AND is_public = ?", shopID, false).Order("id").Find(&hiddenMenus).Error; err != nil {
+		return 0, nil, err
+	}
+	for _, menu := range hiddenMenus {
+		var photos []model.Photo
+		if err := tx.Where("menu_id = ?", menu.ID).Order("id").Find(&photos).Error; err != nil {
+			return 0, nil, err
+		}
+		if !binned(binnedMenus, menu.ID) {
+			payload := menuPayload{ProductName: menu.ProductName, ProductDescription: menu.ProductDescription, Price: menu.Price}
+			menuOp, err := stage(newChangeOp(model.ChangeEntityMenu, model.ChangeCreate, nil, payload))
+			if err != nil {
+				return 0, nil, err
+			}
+			for _, photo := range photos {
+				if menuOp.ID == 0 || binned(binnedPhotos, photo.ID) {
+					orphans = append(orphans, photo.PathFile)
+					continue
+				}
+				photoOp, err := newChangeOp(model.ChangeEntityPhoto, model.ChangeCreate, nil, photoPayload{PathFile: photo.PathFile})
+				photoOp.RefOpID = &menuOp.ID
+				staged, err := stage(photoOp, err)
+				if err != nil {
+					return 0, nil, err
+				}
+				if staged.ID == 0 {
+					orphans = append(orphans, photo.PathFile)
+				}
+			}
+		} else {
+			for _, photo := range photos {
+				orphans = append(orphans, photo.PathFile)
+			}
+		}
+		// Rows only; the files of staged photos now belong to their changes
+		if err := tx.Where("menu_id = ?", menu.ID).Delete(&model.Photo{}).Error; err != nil {
+			return 0, nil, err
+		}
+		if err := tx.Where("menu_id = ?", menu.ID).Delete(&model.TempMenu{}).Error; err != nil {
+			return 0, nil, err
+		}
+		if err := tx.Delete(&menu).Error; err != nil {
+			return 0, nil, err
+		}
+	}
+
+	var hiddenSocials []model.SocialMedia
+	if err := tx.Where("shop_id = ? AND is_public = ?", shopID, false).Order("id").Find(&hiddenSocials).Error; err != nil {
+		return 0, nil, err
+	}
+	for _, social := range hiddenSocials {
+		if !binned(binnedSocials, social.ID) {
+			payload := socialPayload{Name: social.Name, Platform: social.Platform, Link: social.Link}
+			if _, err := stage(newChangeOp(model.ChangeEntitySocial, model.ChangeCreate, nil, payload)); err != nil {
+				return 0, nil, err
+			}
+		}
+		if err := tx.Where("social_id = ?", social.ID).Delete(&model.TempSocial{}).Error; err != nil {
+			return 0, nil, err
+		}
+		if err := tx.Delete(&social).Error; err != nil {
+			return 0, nil, err
+		}
+	}
+
+	var menuIDs []uint
+	if err := tx.Model(&model.ShopMenu{}).Where("shop_id = ?", shopID).Pluck("id", &menuIDs).Error; err != nil {
+		return 0, nil, err
+	}
+	photoQuery := tx.Where("is_public = ?", false)
+	if len(menuIDs) > 0 {
+		photoQuery = photoQuery.Where("shop_id = ? OR menu_id IN ?", shopID, menuIDs)
+	} else {
+		photoQuery = photoQuery.Where("shop_id = ?", shopID)
+	}
+	var hiddenPhotos []model.Photo
+	if err := photoQuery.Order("id").Find(&hiddenPhotos).Error; err != nil {
+		return 0, nil, err
+	}
+	for _, photo := range hiddenPhotos {
+		if binned(binnedPhotos, photo.ID) {
+			orphans = append(orphans, photo.PathFile)
+		} else {
+			payload := photoPayload{PathFile: photo.PathFile, ShopID: photo.ShopID, MenuID: photo.MenuID}
+			staged, err := stage(newChangeOp(model.ChangeEntityPhoto, model.ChangeCreate, nil, payload))
+			if err != nil {
+				return 0, nil, err
+			}
+			if staged.ID == 0 {
+				orphans = append(orphans, photo.PathFile)
+			}
+		}
+		if err := tx.Delete(&photo).Error; err != nil {
+			return 0, nil, err
+		}
+	}
+
+	// Edits only count where they differ from the live record
+	var menuEdits []model.TempMenu
+	if err := tx.Where("temp_id = ?", tempID).Order("id").Find(&menuEdits).Error; err != nil {
+		return 0, nil, err
+	}
+	for _, edit := range menuEdits {
+		var menu model.ShopMenu
+		if err := tx.First(&menu, edit.MenuID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				continue
+			}
+			return 0, nil, err
+		}
+		if binned(binnedMenus, menu.ID) || (menu.ProductName == edit.ProductName && menu.ProductDescription == edit.ProductDescription && menu.Price == edit.Price) {
+			continue
+		}
+		payload := menuPayload{ProductName: edit.ProductName, ProductDescription: edit.ProductDescription, Price: edit.Price}
+		if _, err := stage(newChangeOp(model.ChangeEntityMenu, model.ChangeUpdate, &menu.ID, payload)); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	var socialEdits []model.TempSocial
+	if err := tx.Where("temp_id = ?", tempID).Order("id").Find(&socialEdits).Error; err != nil {
+		return 0, nil, err
+	}
+	for _, edit := range socialEdits {
+		var social model.SocialMedia
+		if err := tx.First(&social, edit.SocialID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				continue
+			}
+			return 0, nil, err
+		}
+		if binned(binnedSocials, social.ID) || (social.Name == edit.Name && social.Platform == edit.Platform && social.Link == edit.Link) {
+			continue
+		}
+		payload := socialPayload{Name: edit.Name, Platform: edit.Platform, Link: edit.Link}
+		if _, err := stage(newChangeOp(model.ChangeEntitySocial, model.ChangeUpdate, &social.ID, payload)); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	for _, bin := range []struct {
+		entity string
+		ids    []uint
+		live   func(uint) (uint, error)
+	}{
+		{model.ChangeEntityMenu, binnedMenus, func(id uint) (uint, error) { return shopOfMenu(tx, id) }},
+		{model.ChangeEntitySocial, binnedSocials, func(id uint) (uint, error) { return shopOfSocial(tx, id) }},
+		{model.ChangeEntityPhoto, binnedPhotos, func(id uint) (uint, error) { return shopOfPhoto(tx, id) }},
+	} {
+		for _, id := range bin.ids {
+			if _, err := bin.live(id); err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					continue
+				}
+				return 0, nil, err
+			}
+			id := id
+			if _, err := stage(newChangeOp(bin.entity, model.ChangeDelete, &id, nil)); err != nil {
+				return 0, nil, err
+			}
+		}
+	}
+
+	// Open dates were never cleared after approval, so compare each with what
+	// the shop has now and keep only real changes
+	var openDates []model.TempShopOpenDate
+	if err := tx.Where("temp_id = ?", tempID).Order("id").Find(&openDates).Error; err != nil {
+		return 0, nil, err
+	}
+	for _, entry := range openDates {
+		var live *model.ShopOpenDate
+		var record model.ShopOpenDate
+		err := tx.Where("shop_id = ? AND market_open_date_id = ?", shopID, entry.MarketOpenDateID).First(&record).Error
+		if err == nil {
+			live = &record
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return 0, nil, err
+		}
+
+		payload := openTimePayload{MarketOpenDateID: entry.MarketOpenDateID, StartTime: entry.StartTime, EndTime: entry.EndTime}
+		var op model.ChangeOp
+		switch {
+		case entry.Operation == "delete" && live != nil:
+			op, err = newChangeOp(model.ChangeEntityOpenTime, model.ChangeDelete, &live.ID, nil)
+		case entry.Operation == "delete":
+			continue
+		case live == nil:
+			op, err = newChangeOp(model.ChangeEntityOpenTime, model.ChangeCreate, nil, payload)
+		case live.StartTime.Equal(entry.StartTime) && live.EndTime.Equal(entry.EndTime):
+			continue
+		default:
+			op, err = newChangeOp(model.ChangeEntityOpenTime, model.ChangeUpdate, &live.ID, payload)
+		}
+		if _, err := stage(op, err); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	for _, legacy := range []interface{}{
+		&model.TempMenu{}, &model.TempSocial{}, &model.TempShopOpenDate{},
+		&model.DeleteMenu{}, &model.DeleteSocial{}, &model.DeletePhoto{},
+	} {
+		if err := tx.Where("temp_id = ?", tempID).Delete(legacy).Error; err != nil {
+			return 0, nil, err
+		}
+	}
+	return converted, orphans, nil
+}
@@ -0,0 +1,204 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/HealthMe-pls/medic-go-api/middleware"
+	"github.com/HealthMe-pls/medic-go-api/model"
+	"github.com/HealthMe-pls/medic-go-api/notify"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// notifyOwner queues event for the entrepreneur who owns tempShop's shop
+func notifyOwner(tx *gorm.DB, tempShop model.TempShop, event string, subject string, body string) error {
+	if tempShop.ShopID == nil {
+		return nil
+	}
+	var shop model.Shop
+	if err := tx.Select("id", "name", "entrepreneur_id").First(&shop, *tempShop.ShopID).Error; err != nil {
+		return fmt.Errorf("failed to load shop to notify: %w", err)
+	}
+	subject = fmt.Sprintf(subject, shop.Name)
+	return notify.Enqueue(tx, shop.EntrepreneurID, event, subject, body)
+}
+
+// notifyTransition tells the entrepreneur their submission was received,
+// approved or rejected. Other moves, such as a reviewer handing a submission
+// back to the queue, are not news to them.
+func notifyTransition(tx *gorm.DB, tempShop model.TempShop, from model.TempShopStatus, to model.TempShopStatus, note string) error {
+	var event, subject, body string
+	switch {
+	case to == model.StatusSubmitted && from != model.StatusInReview:
+		event, subject = model.EventSubmitted, "Changes to %s submitted for review"
+		body = "We received your changes and will let you know once they are reviewed."
+	case to == model.StatusApproved:
+		event, subject = model.EventApproved, "Changes to %s approved"
+		body = "Your changes are now live."
+	case to == model.StatusRejected:
+		event, subject = model.EventRejected, "Changes to %s need another look"
+		body = "Some of your changes were not approved. Check the review comments, update the draft and submit again."
+	default:
+		return nil
+	}
+	if note = strings.TrimSpace(note); note != "" {
+		body += "\n\n" + note
+	}
+	return notifyOwner(tx, tempShop, event, subject, body)
+}
+
+// GetNotificationPreferences returns the logged-in entrepreneur's channels.
+// With none saved, notifications go by email to the account address.
+func GetNotificationPreferences(db *gorm.DB, c *fiber.Ctx) error {
+	claims, ok := middleware.GetClaims(c)
+	if !ok {
+		return middleware.Unauthorized(c, "Missing token")
+	}
+	var prefs []model.NotificationPreference
+	if err := db.Where("entrepreneur_id = ?", claims.UserID()).Order("id").Find(&prefs).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to retrieve notification preferences",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(fiber.Map{
+		"preferences": prefs,
+		"channels":    []string{model.ChannelEmail, model.ChannelLine, model.ChannelWebhook},
+		"events":      notify.Events,
+	})
+}
+
+// validatePreference checks the channel, target and events of pref
+func validatePreference(pref model.NotificationPreference) error {
+	switch pref.Channel {
+	case model.ChannelEmail:
+		if pref.Target != "" && !strings.Contains(pref.Target, "@") {
+			return errors.New("email target must be an email address")
+		}
+	case model.ChannelLine:
+		if pref.Target == "" {
+			return errors.New("line target must be a LINE user ID")
+		}
+	case model.ChannelWebhook:
+		if err := notify.CheckWebhookTarget(pref.Target, notify.WebhookPolicyFromEnv()); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown channel %q", pref.Channel)
+	}
+	if strings.TrimSpace(pref.Events) == "" {
+		return nil
+	}
+	for _, event := range strings.Split(pref.Events, ",") {
+		known := false
+		for _, e := range notify.Events {
+			known = known || strings.TrimSpace(event) == e
+		}
+		if !known {
+			return fmt.Errorf("unknown event %q", strings.TrimSpace(event))
+		}
+	}
+	return nil
+}
+
+// UpdateNotificationPreferences replaces the logged-in entrepreneur's channels.
+// Body: [{"channel": "line", "target": "U123...", "events": "approved,rejected", "enabled": true}]
+// An empty list goes back to email for every event.
+func UpdateNotificationPreferences(db *gorm.DB, c *fiber.Ctx) error {
+	claims, ok := middleware.GetClaims(c)
+	if !ok {
+		return middleware.Unauthorized(c, "Missing token")
+	}
+	var prefs []model.NotificationPreference
+	if err := c.BodyParser(&prefs); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	seen := map[string]bool{}
+	for i := range prefs {
+		prefs[i].ID = 0
+		prefs[i].EntrepreneurID = claims.UserID()
+		prefs[i].Target = strings.TrimSpace(prefs[i].Target)
+		if err := validatePreference(prefs[i]); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+		if seen[prefs[i].Channel] {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": fmt.Sprintf("channel %q is listed twice", prefs[i].Channel)})
+		}
+		seen[prefs[i].Channel] = true
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("entrepreneur_id = ?", claims.UserID()).Delete(&model.NotificationPreference{}).Error; err != nil {
+			return err
+		}
+		if len(prefs) == 0 {
+			return nil
+		}
+		return tx.Create(&prefs).Error
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to save notification preferences",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(fiber.Map{"preferences": prefs})
+}
+
+// GetMyNotifications lists the notifications sent or queued for the logged-in entrepreneur, newest first
+func GetMyNotifications(db *gorm.DB, c *fiber.Ctx) error {
+	claims, ok := middleware.GetClaims(c)
+	if !ok {
+		return middleware.Unauthorized(c, "Missing token")
+	}
+	var notifications []model.Notification
+	if err := db.Where("entrepreneur_id = ?", claims.UserID()).
+		Order("id desc").Limit(c.QueryInt("limit", 50)).Offset(c.QueryInt("offset", 0)).
+		Find(&notifications).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to retrieve notifications",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(notifications)
+}
+
+// GetNotificationOutbox lists notifications for admins, newest first.
+// ?status=failed and ?entrepreneur_id= narrow the list.
+func GetNotificationOutbox(db *gorm.DB, c *fiber.Ctx) error {
+	query := db.Order("id desc").Limit(c.QueryInt("limit", 100)).Offset(c.QueryInt("offset", 0))
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if entrepreneurID := c.Query("entrepreneur_id"); entrepreneurID != "" {
+		query = query.Where("entrepreneur_id = ?", entrepreneurID)
+	}
+	var notifications []model.Notification
+	if err := query.Find(&notifications).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to retrieve notifications",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(notifications)
+}
+
+// RetryNotification queues a failed notification again
+func RetryNotification(db *gorm.DB, c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid notification ID"})
+	}
+	if err := notify.Retry(db, uint(id)); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "No failed notification with this ID"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to retry notification",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(fiber.Map{"message": "Notification queued again", "id": id})
+}
@@ -0,0 +1,255 @@
+package controller
+
+import (
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/HealthMe-pls/medic-go-api/middleware"
+	"github.com/HealthMe-pls/medic-go-api/model"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// ShopResolver returns the IDs of the shops a request would change
+type ShopResolver func(db *gorm.DB, c *fiber.Ctx) ([]uint, error)
+
+// errInvalidRef is returned by resolvers when an ID in the request is malformed
+var errInvalidRef = errors.New("invalid ID")
+
+// OwnsShop rejects the request unless every shop found by the resolvers belongs
+// to the authenticated entrepreneur. Admins may edit any shop. Attempts to edit
+// another entrepreneur's shop are stored as AccessViolation rows.
+// It must run after middleware.Entrepreneur.
+func OwnsShop(db *gorm.DB, resolvers ...ShopResolver) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		claims, ok := middleware.GetClaims(c)
+		if !ok {
+			return middleware.Unauthorized(c, "Missing token")
+		}
+		if claims.Role == middleware.RoleAdmin {
+			return c.Next()
+		}
+
+		var shopIDs []uint
+		for _, resolve := range resolvers {
+			ids, err := resolve(db, c)
+			if errors.Is(err, errInvalidRef) {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+			}
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Resource not found"})
+			}
+			if err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error":   "Failed to check shop ownership",
+					"details": err.Error(),
+				})
+			}
+			shopIDs = append(shopIDs, ids...)
+		}
+
+		for _, shopID := range shopIDs {
+			var shop model.Shop
+			if err := db.Select("id", "entrepreneur_id").First(&shop, shopID).Error; err != nil {
+				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Shop not found"})
+			}
+			if shop.EntrepreneurID != claims.UserID() {
+				recordViolation(db, c, claims.UserID(), shop.ID)
+				return middleware.Forbidden(c, "You do not own this shop")
+			}
+		}
+		return c.Next()
+	}
+}
+
+// IsSelf rejects the request unless the entrepreneur ID in param is the caller's own.
+// Admins may read any entrepreneur.
+func IsSelf(param string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		claims, ok := middleware.GetClaims(c)
+		if !ok {
+			return middleware.Unauthorized(c, "Missing token")
+		}
+		if claims.Role == middleware.RoleAdmin {
+			return c.Next()
+		}
+		id, err := strconv.ParseUint(c.Params(param), 10, 32)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid entrepreneur ID"})
+		}
+		if uint(id) != claims.UserID() {
+			return middleware.Forbidden(c, "You can only access your own account")
+		}
+		return c.Next()
+	}
+}
+
+func recordViolation(db *gorm.DB, c *fiber.Ctx, entrepreneurID uint, shopID uint) {
+	violation := model.AccessViolation{
+		EntrepreneurID: entrepreneurID,
+		ShopID:         shopID,
+		Method:         c.Method(),
+		Path:           c.Path(),
+		IP:             c.IP(),
+		CreatedAt:      time.Now(),
+	}
+	log.Printf("Access violation: entrepreneur %d tried %s %s on shop %d", entrepreneurID, violation.Method, violation.Path, shopID)
+	if err := db.Create(&violation).Error; err != nil {
+		log.Println("Failed to record access violation:", err)
+	}
+}
+
+// GetAccessViolations lists recorded cross-tenant edit attempts, newest first
+func GetAccessViolations(db *gorm.DB, c *fiber.Ctx) error {
+	query := db.Order("id desc")
+	if entrepreneurID := c.Query("entrepreneur_id"); entrepreneurID != "" {
+		query = query.Where("entrepreneur_id = ?", entrepreneurID)
+	}
+
+	var violations []model.AccessViolation
+	if err := query.Limit(c.QueryInt("limit", 100)).Find(&violations).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to retrieve access violations",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(violations)
+}
+
+// ==================== RESOLVERS ====================
+
+func paramID(c *fiber.Ctx, param string) (uint, error) {
+	id, err := strconv.ParseUint(c.Params(param), 10, 32)
+	if err != nil {
+		return 0, errInvalidRef
+	}
+	return uint(id), nil
+}
+
+// ShopParam resolves a shop ID taken from the URL
+func ShopParam(param string) ShopResolver {
+	return func(db *gorm.DB, c *fiber.Ctx) ([]uint, error) {
+		id, err := paramID(c, param)
+		if err != nil {
+			return nil, err
+		}
+		return []uint{id}, nil
+	}
+}
+
+// MenuParam resolves the shop of a menu ID taken from the URL
+func MenuParam(param string) ShopResolver {
+	return func(db *gorm.DB, c *fiber.Ctx) ([]uint, error) {
+		id, err := paramID(c, param)
+		if err != nil {
+			return nil, err
+		}
+		shopID, err := shopOfMenu(db, id)
+		return []uint{shopID}, err
+	}
+}
+
+// SocialParam resolves the shop of a social media ID taken from the URL
+func SocialParam(param string) ShopResolver {
+	return func(db *gorm.DB, c *fiber.Ctx) ([]uint, error) {
+		id, err := paramID(c, param)
+		if err != nil {
+			return nil, err
+		}
+		shopID, err := shopOfSocial(db, id)
+		return []uint{shopID}, err
+	}
+}
+
+// bodyRefs holds the IDs a request body may point at
+type bodyRefs struct {
+	ShopID   uint `json:"shop_id" form:"shop_id"`
+	TempID   uint `json:"temp_id" form:"temp_id"`
+	MenuID   uint `json:"menu_id" form:"menu_id"`
+	SocialID uint `json:"social_id" form:"social_id"`
+	PhotoID  uint `json:"photo_id" form:"photo_id"`
+}
+
+// BodyRefs resolves the shops behind any shop_id, temp_id, menu_id, social_id
+// or photo_id in the request body. The body is left for the handler to parse again.
+func BodyRefs(db *gorm.DB, c *fiber.Ctx) ([]uint, error) {
+	var refs bodyRefs
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&refs); err != nil {
+			return nil, errInvalidRef
+		}
+	}
+
+	var shopIDs []uint
+	if refs.ShopID != 0 {
+		shopIDs = append(shopIDs, refs.ShopID)
+	}
+	lookups := []struct {
+		id      uint
+		resolve func(*gorm.DB, uint) (uint, error)
+	}{
+		{refs.TempID, shopOfTemp},
+		{refs.MenuID, shopOfMenu},
+		{refs.SocialID, shopOfSocial},
+		{refs.PhotoID, shopOfPhoto},
+	}
+	for _, l := range lookups {
+		if l.id == 0 {
+			continue
+		}
+		shopID, err := l.resolve(db, l.id)
+		if err != nil {
+			return nil, err
+		}
+		shopIDs = append(shopIDs, shopID)
+	}
+	return shopIDs, nil
+}
+
+func shopOfMenu(db *gorm.DB, menuID uint) (uint, error) {
+	var menu model.ShopMenu
+	if err := db.Select("shop_id").First(&menu, menuID).Error; err != nil {
+		return 0, err
+	}
+	return menu.ShopID, nil
+}
+
+func shopOfSocial(db *gorm.DB, socialID uint) (uint, error) {
+	var social model.SocialMedia
+	if err := db.Select("shop_id").First(&social, socialID).Error; err != nil {
+		return 0, err
+	}
+	return social.ShopID, nil
+}
+
+// shopOfTemp resolves a TempShop. A TempShop that is not linked to a shop has no owner.
+func shopOfTemp(db *gorm.DB, tempID uint) (uint, error) {
+	var tempShop model.TempShop
+	if err := db.Select("shop_id").First(&tempShop, tempID).Error; err != nil {
+		return 0, err
+	}
+	if tempShop.ShopID == nil {
+		return 0, gorm.ErrRecordNotFound
+	}
+	return *tempShop.ShopID, nil
+}
+
+// shopOfPhoto follows a photo to its shop directly, through its menu or through its TempShop
+func shopOfPhoto(db *gorm.DB, photoID uint) (uint, error) {
+	var photo model.Photo
+	if err := db.First(&photo, photoID).Error; err != nil {
+		return 0, err
+	}
+	switch {
+	case photo.ShopID != nil:
+		return *photo.ShopID, nil
+	case photo.MenuID != nil:
+		return shopOfMenu(db, *photo.MenuID)
+	case photo.TempID != nil:
+		return shopOfTemp(db, *photo.TempID)
+	}
+	// Workshop and event photos do not belong to a shop
+	return 0, gorm.ErrRecordNotFound
+}
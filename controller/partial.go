@@ -0,0 +1,232 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/HealthMe-pls/medic-go-api/model"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// itemDecision rejects one pending change with a reason for the vendor
+type itemDecision struct {
+	Key    string `json:"key"`
+	Reason string `json:"reason"`
+}
+
+// HandlePartialApprove accepts some pending changes of a submitted TempShop and
+// rejects the others. Keys come from GetTempShopDiff; shop field changes are
+// decided together under the key "shop". Every pending change must be decided:
+// {"accept": ["menu:edited:4"], "reject": [{"key": "photo:added:9", "reason": "..."}], "reason": "..."}
+// Accepted changes are applied, rejected ones stay in the change set for the
+// vendor to fix and resubmit. A photo of a new menu can only be accepted with
+// its menu. The round is decided Approve, NotApprove or PartiallyApproved; the
+// TempShop ends Approved only when nothing was rejected, and Rejected otherwise.
+func HandlePartialApprove(db *gorm.DB, c *fiber.Ctx) error {
+	var input struct {
+		Accept []string       `json:"accept"`
+		Reject []itemDecision `json:"reject"`
+		Reason string         `json:"reason"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	for _, decision := range input.Reject {
+		if strings.TrimSpace(decision.Reason) == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "reason is required for every rejected item", "key": decision.Key})
+		}
+	}
+
+	var tempShop model.TempShop
+	if err := db.First(&tempShop, "temp_id = ? AND status IN ?", c.Params("temp_id"), awaitingReview).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "TempShop not found or not waiting for review",
+		})
+	}
+	if tempShop.ShopID == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "TempShop has no associated shop"})
+	}
+	tempID := tempShop.TempID
+	if _, err := requireReviewClaim(c, tempID); err != nil {
+		var conflict *claimConflict
+		if errors.As(err, &conflict) {
+			return claimResponse(c, conflict)
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to check review claim",
+			"details": err.Error(),
+		})
+	}
+	shopBefore := shopSnapshot(db, *tempShop.ShopID)
+
+	var decision string
+	var files []string
+	var decideErr error
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&tempShop, "temp_id = ?", tempID).Error; err != nil {
+			return fmt.Errorf("failed to lock TempShop: %w", err)
+		}
+		if cs, ok, err := findOpenChangeSet(tx, *tempShop.ShopID); err != nil {
+			return err
+		} else if ok {
+			if _, err := pruneStaleOps(tx, cs); err != nil {
+				return fmt.Errorf("failed to drop stale changes: %w", err)
+			}
+		}
+		diff, err := computeTempShopDiff(tx, tempShop)
+		if err != nil {
+			return err
+		}
+		changes := pendingChanges(diff)
+		byKey := map[string]ItemChange{}
+		for _, change := range changes {
+			byKey[change.Key] = change
+		}
+		if decideErr = checkDecisions(byKey, input.Accept, input.Reject); decideErr != nil {
+			return decideErr
+		}
+
+		status := model.StatusRejected
+		switch {
+		case len(input.Reject) == 0:
+			decision, status = "Approve", model.StatusApproved
+		case len(input.Accept) == 0:
+			decision = "NotApprove"
+		default:
+			decision = "PartiallyApproved"
+		}
+		if err := decideTempShop(tx, actorOf(c), &tempShop, status, input.Reason); err != nil {
+			return err
+		}
+
+		if len(input.Accept) > 0 {
+			if err := ensureBaselineVersion(tx, *tempShop.ShopID, reviewerID(c)); err != nil {
+				return fmt.Errorf("failed to record baseline version: %w", err)
+			}
+		}
+		// The applier runs ops in staging order, whatever the request order
+		var accepted []model.ChangeOp
+		for _, key := range input.Accept {
+			accepted = append(accepted, diff.ops[key])
+		}
+		if len(accepted) > 0 {
+			cs, _, err := findOpenChangeSet(tx, *tempShop.ShopID)
+			if err != nil {
+				return err
+			}
+			if files, err = applyChangeOps(tx, cs, accepted); err != nil {
+				return err
+			}
+		}
+
+		round, err := closeReviewRound(tx, tempID, decision, reviewerID(c), input.Reason)
+		if err != nil {
+			return fmt.Errorf("failed to close review round: %w", err)
+		}
+		if strings.TrimSpace(input.Reason) != "" {
+			if _, err := addReviewComment(tx, c, round, model.ReviewComment{Body: input.Reason}); err != nil {
+				return err
+			}
+		}
+		for _, decision := range input.Reject {
+			itemType, _, _ := strings.Cut(decision.Key, ":")
+			comment := model.ReviewComment{ItemType: itemType, ItemKey: decision.Key, Body: decision.Reason}
+			if change := byKey[decision.Key]; change.ID != 0 {
+				comment.ItemID = &change.ID
+			}
+			if _, err := addReviewComment(tx, c, round, comment); err != nil {
+				return err
+			}
+		}
+
+		if len(input.Accept) > 0 {
+			if _, err := saveShopVersion(tx, *tempShop.ShopID, model.VersionApprove, &tempID, nil, reviewerID(c)); err != nil {
+				return fmt.Errorf("failed to record shop version: %w", err)
+			}
+		}
+		return nil
+	})
+	if decideErr != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": decideErr.Error()})
+	}
+	var refused *TransitionError
+	if errors.As(err, &refused) {
+		return transitionResponse(c, refused)
+	}
+	var invalid changeErrors
+	if errors.As(err, &invalid) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":  "The accepted changes cannot be applied",
+			"errors": invalid,
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Review failed, nothing was changed",
+			"details": err.Error(),
+		})
+	}
+	removeUploads(files)
+	releaseDecidedClaim(c, tempID)
+	recordAudit(db, c, "shop.partial_approve", auditEntityTempShop, tempID, shopBefore, fiber.Map{
+		"status":   tempShop.Status,
+		"decision": decision,
+		"accepted": input.Accept,
+		"rejected": input.Reject,
+		"shop":     shopSnapshot(db, *tempShop.ShopID),
+	})
+
+	return c.JSON(fiber.Map{
+		"temp_id":  tempID,
+		"status":   tempShop.Status,
+		"decision": decision,
+		"accepted": len(input.Accept),
+		"rejected": len(input.Reject),
+	})
+}
+
+// pendingChanges flattens a diff into its decidable changes, shop fields first
+func pendingChanges(diff *TempShopDiff) []ItemChange {
+	var changes []ItemChange
+	if _, ok := diff.ops[itemShop]; ok {
+		changes = append(changes, ItemChange{Key: itemShop, Change: changeEdited})
+	}
+	for _, list := range [][]ItemChange{diff.Menus, diff.Socials, diff.Photos, diff.OpenTimes} {
+		changes = append(changes, list...)
+	}
+	return changes
+}
+
+// checkDecisions makes sure every pending change is accepted or rejected exactly once
+func checkDecisions(pending map[string]ItemChange, accept []string, reject []itemDecision) error {
+	decided := map[string]bool{}
+	keys := append([]string{}, accept...)
+	for _, decision := range reject {
+		keys = append(keys, decision.Key)
+	}
+	for _, key := range keys {
+		if _, ok := pending[key]; !ok {
+			return fmt.Errorf("%s is not a pending change of this shop", key)
+		}
+		if decided[key] {
+			return fmt.Errorf("%s is decided more than once", key)
+		}
+		decided[key] = true
+	}
+
+	var undecided []string
+	for key := range pending {
+		if !decided[key] {
+			undecided = append(undecided, key)
+		}
+	}
+	if len(undecided) > 0 {
+		sort.Strings(undecided)
+		return fmt.Errorf("every pending change must be accepted or rejected, undecided: %s", strings.Join(undecided, ", "))
+	}
+	return nil
+}
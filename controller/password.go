@@ -0,0 +1,152 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/mail"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/HealthMe-pls/medic-go-api/database"
+	"github.com/HealthMe-pls/medic-go-api/mailer"
+	"github.com/HealthMe-pls/medic-go-api/middleware"
+	"github.com/HealthMe-pls/medic-go-api/model"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// passwordResetTTL is how long an emailed reset token can be redeemed
+const passwordResetTTL = 30 * time.Minute
+
+// errResetTokenInvalid covers unknown, expired and already used reset tokens alike
+var errResetTokenInvalid = errors.New("reset token is invalid or has expired")
+
+// normalizeEmail validates an optional email address. An empty string means no email.
+func normalizeEmail(email string) (*string, error) {
+	if email == "" {
+		return nil, nil
+	}
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return nil, errors.New("invalid email address")
+	}
+	return &addr.Address, nil
+}
+
+// resetLink builds the link sent to the user. PASSWORD_RESET_URL is the
+// frontend page that asks for the new password.
+func resetLink(resetToken string) string {
+	base := os.Getenv("PASSWORD_RESET_URL")
+	if base == "" {
+		base = "http://localhost:3000/reset-password"
+	}
+	return base + "?token=" + url.QueryEscape(resetToken)
+}
+
+// ForgotPassword emails a reset link to the entrepreneur with the given email.
+// The response is the same whether or not the address is known.
+func ForgotPassword(db *gorm.DB, c *fiber.Ctx) error {
+	var input struct {
+		Email string `json:"email"`
+	}
+	if err := c.BodyParser(&input); err != nil || input.Email == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "email is required"})
+	}
+	accepted := func() error {
+		return c.JSON(fiber.Map{"message": "If the email is registered, a reset link has been sent"})
+	}
+
+	var entrepreneur model.Entrepreneur
+	if err := db.First(&entrepreneur, "email = ?", input.Email).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Println("Failed to look up entrepreneur for password reset:", err)
+		}
+		return accepted()
+	}
+
+	resetToken, err := randomToken(32)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not generate reset token"})
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		// Only the most recent link works
+		if err := tx.Where("entrepreneur_id = ? AND used_at IS NULL", entrepreneur.ID).
+			Delete(&model.PasswordResetToken{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&model.PasswordResetToken{
+			EntrepreneurID: entrepreneur.ID,
+			TokenHash:      hashToken(resetToken),
+			ExpiresAt:      time.Now().Add(passwordResetTTL),
+		}).Error; err != nil {
+			return err
+		}
+		body := fmt.Sprintf("Hello %s,\n\nUse the link below to reset your password. It expires in %d minutes and can be used once.\n\n%s\n\nIf you did not ask for a reset, you can ignore this email.\n",
+			entrepreneur.Username, int(passwordResetTTL.Minutes()), resetLink(resetToken))
+		// The link redeems the token, so the outbox forgets it once the email is out
+		return mailer.EnqueueSensitive(tx, *entrepreneur.Email, "Reset your password", body)
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to create password reset",
+			"details": err.Error(),
+		})
+	}
+	return accepted()
+}
+
+// ConfirmPasswordReset redeems a reset token, sets the new password and signs
+// the entrepreneur out of every device
+func ConfirmPasswordReset(db *gorm.DB, c *fiber.Ctx) error {
+	var input struct {
+		Token       string `json:"token"`
+		NewPassword string `json:"new_password"`
+	}
+	if err := c.BodyParser(&input); err != nil || input.Token == "" || input.NewPassword == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "token and new_password are required"})
+	}
+
+	hashedPassword, err := HashPassword(input.NewPassword)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to hash password"})
+	}
+
+	var resetToken model.PasswordResetToken
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&resetToken, "token_hash = ?", hashToken(input.Token)).Error; err != nil {
+			return errResetTokenInvalid
+		}
+		if resetToken.UsedAt != nil || time.Now().After(resetToken.ExpiresAt) {
+			return errResetTokenInvalid
+		}
+		// Mark the token used only if no concurrent request got there first
+		result := tx.Model(&model.PasswordResetToken{}).
+			Where("id = ? AND used_at IS NULL", resetToken.ID).
+			Update("used_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected != 1 {
+			return errResetTokenInvalid
+		}
+		return tx.Model(&model.Entrepreneur{}).Where("id = ?", resetToken.EntrepreneurID).
+			Update("password", hashedPassword).Error
+	})
+	if errors.Is(err, errResetTokenInvalid) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to reset password",
+			"details": err.Error(),
+		})
+	}
+
+	if err := database.RevokeAllSessions(middleware.RoleEntrepreneur, resetToken.EntrepreneurID); err != nil {
+		log.Println("Failed to revoke sessions after password reset:", err)
+	}
+	return c.JSON(fiber.Map{"message": "Password reset successfully"})
+}
@@ -1,14 +1,37 @@
 package controller
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
+	"mime/multipart"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"github.com/HealthMe-pls/medic-go-api/model"
 	"github.com/gofiber/fiber/v2"
 	"gorm.io/gorm"
 )
 
+// saveUpload stores an uploaded image under a generated name, so uploads with
+// the same client filename cannot overwrite each other, and returns that name
+func saveUpload(c *fiber.Ctx, file *multipart.FileHeader) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	fileName := hex.EncodeToString(b) + strings.ToLower(filepath.Ext(file.Filename))
+	filePath := fmt.Sprintf("./uploads/%s", fileName)
+	if err := c.SaveFile(file, filePath); err != nil {
+		return "", err
+	}
+	// Set file permissions to allow deletion
+	if err := os.Chmod(filePath, 0666); err != nil {
+		fmt.Println("Failed to set file permissions:", err)
+	}
+	return fileName, nil
+}
 
 // CreatePhoto creates a new Photo entry
 func CreatePhoto(db *gorm.DB, c *fiber.Ctx) error {
@@ -97,7 +120,8 @@ func DeletePhoto(db *gorm.DB, c *fiber.Ctx) error {
 	}
 	return c.SendStatus(fiber.StatusNoContent)
 }
-// CreatePhotoByMenuID creates a Photo by MenuID with IsPublic set to false
+// CreatePhotoByMenuID uploads a photo for a menu. Admins publish it right
+// away; an entrepreneur's photo is staged as a change.
 func CreatePhotoByMenuID(db *gorm.DB, c *fiber.Ctx, isPublic bool) error {
 	// Parse menu_id from the URL params
 	menuID, err := strconv.Atoi(c.Params("menu_id"))
@@ -124,18 +148,25 @@ func CreatePhotoByMenuID(db *gorm.DB, c *fiber.Ctx, isPublic bool) error {
 	}
 	
 	// Save the file to the server
-	filePath := fmt.Sprintf("./uploads/%s", file.Filename)
-	fileName := file.Filename
-	if err := c.SaveFile(file, filePath); err != nil {
+	fileName, err := saveUpload(c, file)
+	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).SendString("Failed to save image")
 	}
-	
-	// Set file permissions to allow deletion
-	if err := os.Chmod(filePath, 0666); err != nil {
-		fmt.Println("Failed to set file permissions:", err)
+
+	// The file is removed again when no change or photo ends up referring to it
+	if !isPublic {
+		op, err := newChangeOp(model.ChangeEntityPhoto, model.ChangeCreate, nil, photoPayload{PathFile: fileName, MenuID: &unitMenuID})
+		if err == nil {
+			op, err = stageShopChange(db, actorOf(c), menu.ShopID, op)
+		}
+		if err != nil {
+			removeUploads([]string{fileName})
+		}
+		return stageResponse(c, op, err)
 	}
 	photo := new(model.Photo)
 	if err := c.BodyParser(photo); err != nil {
+		removeUploads([]string{fileName})
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error":   "Failed to parse request body",
 			"details": err.Error(),
@@ -150,6 +181,7 @@ func CreatePhotoByMenuID(db *gorm.DB, c *fiber.Ctx, isPublic bool) error {
 
 	// Create the Photo entry in the database
 	if err := db.Create(&photo).Error; err != nil {
+		removeUploads([]string{fileName})
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error":   "Failed to create photo",
 			"details": err.Error(),
@@ -161,7 +193,9 @@ func CreatePhotoByMenuID(db *gorm.DB, c *fiber.Ctx, isPublic bool) error {
 	})
 }
 
-// CreatePhotoByShopID creates a Photo by ShopID with IsPublic set to false
+// CreatePhotoByShopID uploads a photo for a shop, or for one of its pending
+// menus when the form names the menu's change in menu_change_id. Admins
+// publish it right away; an entrepreneur's photo is staged as a change.
 func CreatePhotoByShopID(db *gorm.DB, c *fiber.Ctx, isPublic bool) error {
 	// Parse shop_id from the URL params
 	shopID, err := strconv.Atoi(c.Params("shop_id"))
@@ -187,15 +221,42 @@ func CreatePhotoByShopID(db *gorm.DB, c *fiber.Ctx, isPublic bool) error {
 	}
 	
 	// Save the file to the server
-	filePath := fmt.Sprintf("./uploads/%s", file.Filename)
-	fileName := file.Filename
-	if err := c.SaveFile(file, filePath); err != nil {
+	fileName, err := saveUpload(c, file)
+	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).SendString("Failed to save image")
 	}
 
+	// An entrepreneur's photo is staged; menu_change_id attaches it to a menu
+	// that is still pending. The file is removed again when staging fails.
+	if !isPublic {
+		payload := photoPayload{PathFile: fileName}
+		var refOpID *uint
+		if ref := c.FormValue("menu_change_id"); ref != "" {
+			id, err := strconv.ParseUint(ref, 10, 32)
+			if err != nil {
+				removeUploads([]string{fileName})
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid menu_change_id"})
+			}
+			opID := uint(id)
+			refOpID = &opID
+		} else {
+			payload.ShopID = &unitShopId
+		}
+		op, err := newChangeOp(model.ChangeEntityPhoto, model.ChangeCreate, nil, payload)
+		op.RefOpID = refOpID
+		if err == nil {
+			op, err = stageShopChange(db, actorOf(c), unitShopId, op)
+		}
+		if err != nil {
+			removeUploads([]string{fileName})
+		}
+		return stageResponse(c, op, err)
+	}
+
 	// Parse request body into the Photo struct
 	photo := new(model.Photo)
 	if err := c.BodyParser(photo); err != nil {
+		removeUploads([]string{fileName})
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error":   "Failed to parse request body",
 			"details": err.Error(),
@@ -211,6 +272,7 @@ func CreatePhotoByShopID(db *gorm.DB, c *fiber.Ctx, isPublic bool) error {
 
 	// Create the Photo entry in the database
 	if err := db.Create(&photo).Error; err != nil {
+		removeUploads([]string{fileName})
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error":   "Failed to create photo",
 			"details": err.Error(),
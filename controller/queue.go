@@ -0,0 +1,488 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/HealthMe-pls/medic-go-api/database"
+	"github.com/HealthMe-pls/medic-go-api/model"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Reviewers work from a queue of submitted shops. Claiming a submission takes
+// a lock in Redis that lapses after REVIEW_CLAIM_MINUTES unless renewed, and
+// only the holder can decide on it. A round may also be assigned to one admin,
+// who is then the only one allowed to claim it.
+
+// reviewClaimTTL is how long a claim lasts without being renewed
+func reviewClaimTTL() time.Duration {
+	if minutes, err := strconv.Atoi(os.Getenv("REVIEW_CLAIM_MINUTES")); err == nil && minutes > 0 {
+		return time.Duration(minutes) * time.Minute
+	}
+	return 15 * time.Minute
+}
+
+// reviewSLA is how long a submission may wait for a decision, from REVIEW_SLA_HOURS
+func reviewSLA() time.Duration {
+	if hours, err := strconv.ParseFloat(os.Getenv("REVIEW_SLA_HOURS"), 64); err == nil && hours > 0 {
+		return time.Duration(hours * float64(time.Hour))
+	}
+	return 48 * time.Hour
+}
+
+// claimConflict is why an admin cannot work on a submission right now. With
+// neither a claim nor an assignee, nobody has claimed it yet.
+type claimConflict struct {
+	Claim      *database.ReviewClaim
+	AssigneeID *uint
+}
+
+func (e *claimConflict) Error() string {
+	switch {
+	case e.Claim != nil:
+		return fmt.Sprintf("admin %d is reviewing this submission", e.Claim.AdminID)
+	case e.AssigneeID != nil:
+		return fmt.Sprintf("this submission is assigned to admin %d", *e.AssigneeID)
+	}
+	return "claim this submission before deciding on it"
+}
+
+// claimResponse answers a claimConflict with 409 and who is in the way
+func claimResponse(c *fiber.Ctx, err *claimConflict) error {
+	return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+		"error":       err.Error(),
+		"claim":       err.Claim,
+		"assignee_id": err.AssigneeID,
+	})
+}
+
+// holdReviewClaim takes or renews the caller's claim on a submission. It
+// fails with a claimConflict when the round is assigned to someone else or
+// another admin holds the claim.
+func holdReviewClaim(db *gorm.DB, c *fiber.Ctx, tempID uint) (database.ReviewClaim, error) {
+	admin := reviewerID(c)
+	round, err := waitingReviewRound(db, tempID)
+	if err != nil {
+		return database.ReviewClaim{}, err
+	}
+	if round.AssigneeID != nil && *round.AssigneeID != admin {
+		return database.ReviewClaim{}, &claimConflict{AssigneeID: round.AssigneeID}
+	}
+	claim, err := database.ClaimReview(tempID, admin, reviewClaimTTL())
+	if errors.Is(err, database.ErrReviewClaimed) {
+		return claim, &claimConflict{Claim: &claim}
+	}
+	return claim, err
+}
+
+// requireReviewClaim renews the claim the caller holds on a submission before
+// they decide on it. Decisions never take a free claim: it fails with a
+// claimConflict when nobody or another admin holds it.
+func requireReviewClaim(c *fiber.Ctx, tempID uint) (database.ReviewClaim, error) {
+	claim, err := database.RenewReviewClaim(tempID, reviewerID(c), reviewClaimTTL())
+	switch {
+	case errors.Is(err, database.ErrReviewNotClaimed):
+		return claim, &claimConflict{}
+	case errors.Is(err, database.ErrReviewClaimed):
+		return claim, &claimConflict{Claim: &claim}
+	}
+	return claim, err
+}
+
+// releaseDecidedClaim frees the claim once a decision is recorded
+func releaseDecidedClaim(c *fiber.Ctx, tempID uint) {
+	if _, err := database.ReleaseReviewClaim(tempID, reviewerID(c)); err != nil {
+		log.Printf("Failed to release review claim of temp shop %d: %v", tempID, err)
+	}
+}
+
+// awaitingTempShop loads a submitted or in-review TempShop by :id
+func awaitingTempShop(db *gorm.DB, c *fiber.Ctx) (model.TempShop, error) {
+	var tempShop model.TempShop
+	err := db.First(&tempShop, "temp_id = ? AND status IN ?", c.Params("id"), awaitingReview).Error
+	return tempShop, err
+}
+
+// ClaimReview claims a submission for the calling admin, or renews the claim
+// they already hold. The first claim moves it to InReview and, when nobody is
+// assigned, assigns it to the claimer.
+func ClaimReview(db *gorm.DB, c *fiber.Ctx) error {
+	tempShop, err := awaitingTempShop(db, c)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "TempShop not found or not waiting for review"})
+	}
+
+	claim, err := holdReviewClaim(db, c, tempShop.TempID)
+	var conflict *claimConflict
+	if errors.As(err, &conflict) {
+		return claimResponse(c, conflict)
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to claim submission",
+			"details": err.Error(),
+		})
+	}
+
+	var round model.ReviewRound
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&tempShop, "temp_id = ?", tempShop.TempID).Error; err != nil {
+			return err
+		}
+		if tempShop.Status == model.StatusSubmitted {
+			if err := transitionTempShop(tx, actorOf(c), &tempShop, model.StatusInReview, ""); err != nil {
+				return err
+			}
+		}
+		var err error
+		if round, err = openReviewRound(tx, tempShop.TempID); err != nil {
+			return err
+		}
+		now := time.Now()
+		if round.ClaimedAt == nil {
+			round.ClaimedAt = &now
+		}
+		if round.AssigneeID == nil {
+			admin := reviewerID(c)
+			round.AssigneeID, round.AssignedAt = &admin, &now
+		}
+		return tx.Save(&round).Error
+	})
+	if err != nil {
+		releaseDecidedClaim(c, tempShop.TempID)
+		var refused *TransitionError
+		if errors.As(err, &refused) {
+			return transitionResponse(c, refused)
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to claim submission",
+			"details": err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"temp_id":     tempShop.TempID,
+		"status":      tempShop.Status,
+		"round":       round.Round,
+		"assignee_id": round.AssigneeID,
+		"claim":       claim,
+	})
+}
+
+// ReleaseReview gives up the caller's claim and puts the submission back in the queue.
+// The assignment stays.
+func ReleaseReview(db *gorm.DB, c *fiber.Ctx) error {
+	tempShop, err := awaitingTempShop(db, c)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "TempShop not found or not waiting for review"})
+	}
+	released, err := database.ReleaseReviewClaim(tempShop.TempID, reviewerID(c))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to release claim",
+			"details": err.Error(),
+		})
+	}
+	if !released {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "You do not hold the claim on this submission"})
+	}
+
+	if tempShop.Status == model.StatusInReview {
+		if err := transitionTempShop(db, actorOf(c), &tempShop, model.StatusSubmitted, "released"); err != nil {
+			var refused *TransitionError
+			if errors.As(err, &refused) {
+				return transitionResponse(c, refused)
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error":   "Failed to return submission to the queue",
+				"details": err.Error(),
+			})
+		}
+	}
+	return c.JSON(fiber.Map{"temp_id": tempShop.TempID, "status": tempShop.Status})
+}
+
+// AssignReview assigns the current round of a submission to an admin, or
+// clears the assignment with {"admin_id": null}. Body: {"admin_id": 3}
+// A claim held by anyone else is dropped and the submission goes back to the queue.
+func AssignReview(db *gorm.DB, c *fiber.Ctx) error {
+	var input struct {
+		AdminID *uint `json:"admin_id"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if input.AdminID != nil {
+		if err := db.First(&model.Admin{}, *input.AdminID).Error; err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Admin not found"})
+		}
+	}
+
+	tempShop, err := awaitingTempShop(db, c)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "TempShop not found or not waiting for review"})
+	}
+	claim, err := database.GetReviewClaim(tempShop.TempID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to read claim",
+			"details": err.Error(),
+		})
+	}
+
+	var before, round model.ReviewRound
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&tempShop, "temp_id = ?", tempShop.TempID).Error; err != nil {
+			return err
+		}
+		var err error
+		if round, err = openReviewRound(tx, tempShop.TempID); err != nil {
+			return err
+		}
+		before = round
+		round.AssigneeID, round.AssignedAt = input.AdminID, nil
+		if input.AdminID != nil {
+			now := time.Now()
+			round.AssignedAt = &now
+		}
+		if err := tx.Save(&round).Error; err != nil {
+			return err
+		}
+		if claim != nil && (input.AdminID == nil || claim.AdminID != *input.AdminID) && tempShop.Status == model.StatusInReview {
+			return transitionTempShop(tx, actorOf(c), &tempShop, model.StatusSubmitted, "reassigned")
+		}
+		return nil
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to assign submission",
+			"details": err.Error(),
+		})
+	}
+	if claim != nil && (input.AdminID == nil || claim.AdminID != *input.AdminID) {
+		if err := database.DropReviewClaim(tempShop.TempID); err != nil {
+			log.Printf("Failed to drop review claim of temp shop %d: %v", tempShop.TempID, err)
+		}
+	}
+	recordAudit(db, c, "review.assign", auditEntityTempShop, tempShop.TempID,
+		fiber.Map{"assignee_id": before.AssigneeID}, fiber.Map{"assignee_id": round.AssigneeID})
+
+	return c.JSON(fiber.Map{
+		"temp_id":     tempShop.TempID,
+		"status":      tempShop.Status,
+		"round":       round.Round,
+		"assignee_id": round.AssigneeID,
+	})
+}
+
+// queueEntry is one submission in the review queue
+type queueEntry struct {
+	TempID          uint                  `json:"temp_id"`
+	ShopID          uint                  `json:"shop_id"`
+	Name            string                `json:"name"`
+	Status          model.TempShopStatus  `json:"status"`
+	Round           int                   `json:"round"`
+	AssigneeID      *uint                 `json:"assignee_id"`
+	Claim           *database.ReviewClaim `json:"claim"`
+	Changes         int                   `json:"changes"`
+	SubmittedAt     time.Time             `json:"submitted_at"`
+	WaitingSeconds  int64                 `json:"waiting_seconds"`   // since submission
+	InReviewSeconds int64                 `json:"in_review_seconds"` // since the first claim, zero before
+	DueAt           time.Time             `json:"due_at"`
+	Breached        bool                  `json:"breached"`
+}
+
+// reviewQueue lists the submissions waiting for review, oldest first
+func reviewQueue(db *gorm.DB, now time.Time, sla time.Duration) ([]queueEntry, error) {
+	var tempShops []model.TempShop
+	if err := db.Where("status IN ? AND shop_id IS NOT NULL", awaitingReview).Find(&tempShops).Error; err != nil {
+		return nil, err
+	}
+
+	entries := []queueEntry{}
+	for _, tempShop := range tempShops {
+		round, err := waitingReviewRound(db, tempShop.TempID)
+		if err != nil {
+			return nil, err
+		}
+		ops, err := draftOps(db, *tempShop.ShopID)
+		if err != nil {
+			return nil, err
+		}
+		claim, err := database.GetReviewClaim(tempShop.TempID)
+		if err != nil {
+			return nil, err
+		}
+
+		entry := queueEntry{
+			TempID:         tempShop.TempID,
+			ShopID:         *tempShop.ShopID,
+			Name:           tempShop.Name,
+			Status:         tempShop.Status,
+			Round:          round.Round,
+			AssigneeID:     round.AssigneeID,
+			Claim:          claim,
+			Changes:        len(ops),
+			SubmittedAt:    round.SubmittedAt,
+			WaitingSeconds: int64(now.Sub(round.SubmittedAt).Seconds()),
+			DueAt:          round.SubmittedAt.Add(sla),
+		}
+		if round.ClaimedAt != nil {
+			entry.InReviewSeconds = int64(now.Sub(*round.ClaimedAt).Seconds())
+		}
+		entry.Breached = now.After(entry.DueAt)
+		entries = append(entries, entry)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].SubmittedAt.Before(entries[j].SubmittedAt)
+	})
+	return entries, nil
+}
+
+// GetReviewQueue lists the submissions waiting for review, oldest first, with
+// their assignee, claim and waiting time. ?assignee=me, none or an admin ID filters it.
+func GetReviewQueue(db *gorm.DB, c *fiber.Ctx) error {
+	entries, err := reviewQueue(db, time.Now(), reviewSLA())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to load review queue",
+			"details": err.Error(),
+		})
+	}
+
+	if assignee := c.Query("assignee"); assignee != "" {
+		var want *uint
+		switch assignee {
+		case "none":
+		case "me":
+			admin := reviewerID(c)
+			want = &admin
+		default:
+			id, err := strconv.ParseUint(assignee, 10, 32)
+			if err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "assignee must be me, none or an admin ID"})
+			}
+			admin := uint(id)
+			want = &admin
+		}
+		filtered := []queueEntry{}
+		for _, entry := range entries {
+			if (want == nil && entry.AssigneeID == nil) || (want != nil && entry.AssigneeID != nil && *entry.AssigneeID == *want) {
+				filtered = append(filtered, entry)
+			}
+		}
+		entries = filtered
+	}
+	return c.JSON(fiber.Map{"queue": entries})
+}
+
+// decidedReview is a closed round in the SLA report
+type decidedReview struct {
+	TempID      uint      `json:"temp_id"`
+	Round       int       `json:"round"`
+	Status      string    `json:"status"`
+	ReviewerID  *uint     `json:"reviewer_id"`
+	SubmittedAt time.Time `json:"submitted_at"`
+	ReviewedAt  time.Time `json:"reviewed_at"`
+	Hours       float64   `json:"hours"`
+}
+
+// GetReviewSLAReport lists the open submissions past the SLA and the rounds
+// decided between ?from and ?to (the last 30 days by default) that took longer.
+// ?sla_hours overrides REVIEW_SLA_HOURS for the report.
+func GetReviewSLAReport(db *gorm.DB, c *fiber.Ctx) error {
+	sla := reviewSLA()
+	if raw := c.Query("sla_hours"); raw != "" {
+		hours, err := strconv.ParseFloat(raw, 64)
+		if err != nil || hours <= 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "sla_hours must be a positive number"})
+		}
+		sla = time.Duration(hours * float64(time.Hour))
+	}
+	now := time.Now()
+	from, to := now.AddDate(0, 0, -30), now
+	for _, bound := range []struct {
+		param string
+		value *time.Time
+	}{{"from", &from}, {"to", &to}} {
+		if raw := c.Query(bound.param); raw != "" {
+			t, err := parseAuditTime(raw, bound.param == "to")
+			if err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": bound.param + " must be an RFC 3339 time or a YYYY-MM-DD date",
+				})
+			}
+			*bound.value = t
+		}
+	}
+
+	queue, err := reviewQueue(db, now, sla)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to load review queue",
+			"details": err.Error(),
+		})
+	}
+	overdue := []queueEntry{}
+	for _, entry := range queue {
+		if entry.Breached {
+			overdue = append(overdue, entry)
+		}
+	}
+
+	var rounds []model.ReviewRound
+	if err := db.Where("reviewed_at BETWEEN ? AND ? AND status <> ?", from, to, "Waiting").
+		Order("reviewed_at").Find(&rounds).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to load review rounds",
+			"details": err.Error(),
+		})
+	}
+	breached := []decidedReview{}
+	var total, longest time.Duration
+	for _, round := range rounds {
+		took := round.ReviewedAt.Sub(round.SubmittedAt)
+		total += took
+		if took > longest {
+			longest = took
+		}
+		if took > sla {
+			breached = append(breached, decidedReview{
+				TempID:      round.TempID,
+				Round:       round.Round,
+				Status:      round.Status,
+				ReviewerID:  round.ReviewerID,
+				SubmittedAt: round.SubmittedAt,
+				ReviewedAt:  *round.ReviewedAt,
+				Hours:       took.Hours(),
+			})
+		}
+	}
+	average := 0.0
+	if len(rounds) > 0 {
+		average = (total / time.Duration(len(rounds))).Hours()
+	}
+
+	return c.JSON(fiber.Map{
+		"sla_hours": sla.Hours(),
+		"from":      from,
+		"to":        to,
+		"open": fiber.Map{
+			"waiting": len(queue),
+			"overdue": overdue,
+		},
+		"decided": fiber.Map{
+			"count":         len(rounds),
+			"breached":      breached,
+			"average_hours": average,
+			"longest_hours": longest.Hours(),
+		},
+	})
+}
@@ -0,0 +1,352 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/HealthMe-pls/medic-go-api/model"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// A block is rented per market date: an entrepreneur requests it, an admin
+// confirms or rejects the request. While a reservation is requested or
+// confirmed nobody else can reserve the block for that date. Every write
+// locks the block's MarketMap row first, so two requests cannot both pass the
+// availability check.
+
+const auditEntityReservation = "stall_reservation"
+
+// activeReservations are the statuses that hold a block
+var activeReservations = []string{model.ReservationRequested, model.ReservationConfirmed}
+
+// errBlockTaken is returned when a block already has an active reservation for the date
+var errBlockTaken = errors.New("the block is already reserved for this market date")
+
+// errReservationClosed is returned for a reservation whose status no longer allows the change
+var errReservationClosed = errors.New("the reservation can no longer be changed")
+
+// reserveBlock creates reservation once its block is free on its market
+// date. The market date must not be over.
+func reserveBlock(tx *gorm.DB, reservation *model.StallReservation) error {
+	var block model.MarketMap
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&block, "block_id = ?", reservation.BlockID).Error; err != nil {
+		return fmt.Errorf("block %d: %w", reservation.BlockID, err)
+	}
+	var market model.MarketOpenDate
+	if err := tx.First(&market, reservation.MarketOpenDateID).Error; err != nil {
+		return fmt.Errorf("market open date %d: %w", reservation.MarketOpenDateID, err)
+	}
+	if marketOver(market) {
+		return errReservationClosed
+	}
+
+	var count int64
+	if err := tx.Model(&model.StallReservation{}).
+		Where("block_id = ? AND market_open_date_id = ? AND status IN ?", reservation.BlockID, reservation.MarketOpenDateID, activeReservations).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return errBlockTaken
+	}
+	return tx.Create(reservation).Error
+}
+
+// marketOver reports whether the market date is before today
+func marketOver(market model.MarketOpenDate) bool {
+	now := time.Now()
+	return market.Date.Before(time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, market.Date.Location()))
+}
+
+// decideReservation moves a reservation from one of from to status to,
+// holding the block lock while it does
+func decideReservation(db *gorm.DB, c *fiber.Ctx, id uint, from []string, to string, reason string) (model.StallReservation, model.StallReservation, error) {
+	var reservation, before model.StallReservation
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&reservation, id).Error; err != nil {
+			return err
+		}
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&model.MarketMap{}, "block_id = ?", reservation.BlockID).Error; err != nil {
+			return err
+		}
+		if err := tx.First(&reservation, id).Error; err != nil {
+			return err
+		}
+		before = reservation
+		allowed := false
+		for _, status := range from {
+			allowed = allowed || reservation.Status == status
+		}
+		if !allowed {
+			return errReservationClosed
+		}
+		// A market date that is over cannot get a confirmed stall any more
+		if to == model.ReservationConfirmed {
+			var market model.MarketOpenDate
+			if err := tx.First(&market, reservation.MarketOpenDateID).Error; err != nil {
+				return fmt.Errorf("market open date %d: %w", reservation.MarketOpenDateID, err)
+			}
+			if marketOver(market) {
+				return errReservationClosed
+			}
+		}
+
+		now := time.Now()
+		updates := map[string]interface{}{"status": to}
+		if to == model.ReservationConfirmed || to == model.ReservationRejected {
+			decidedBy := reviewerID(c)
+			updates["decided_by"], updates["decided_at"] = &decidedBy, &now
+		}
+		if reason != "" {
+			updates["reason"] = reason
+		}
+		if err := tx.Model(&reservation).Updates(updates).Error; err != nil {
+			return err
+		}
+		return tx.First(&reservation, id).Error
+	})
+	return before, reservation, err
+}
+
+// reservationResponse answers a failed reservation write
+func reservationResponse(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, errBlockTaken):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, errReservationClosed):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Reservation, block or market date not found", "details": err.Error()})
+	default:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to update reservation",
+			"details": err.Error(),
+		})
+	}
+}
+
+// RequestStallReservation asks for a block on a market date for the shop.
+// Body: {"block_id": 4, "market_open_date_id": 12, "note": "..."}
+func RequestStallReservation(db *gorm.DB, c *fiber.Ctx) error {
+	shopID, err := paramID(c, "shop_id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid shop ID"})
+	}
+	var input struct {
+		BlockID          uint   `json:"block_id"`
+		MarketOpenDateID uint   `json:"market_open_date_id"`
+		Note             string `json:"note"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if input.BlockID == 0 || input.MarketOpenDateID == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "block_id and market_open_date_id are required"})
+	}
+
+	reservation := model.StallReservation{
+		BlockID:          input.BlockID,
+		MarketOpenDateID: input.MarketOpenDateID,
+		ShopID:           shopID,
+		Status:           model.ReservationRequested,
+		Note:             strings.TrimSpace(input.Note),
+	}
+	if err := db.Transaction(func(tx *gorm.DB) error { return reserveBlock(tx, &reservation) }); err != nil {
+		return reservationResponse(c, err)
+	}
+	recordAudit(db, c, "reservation.request", auditEntityReservation, reservation.ID, nil, reservation)
+	return c.Status(fiber.StatusCreated).JSON(reservation)
+}
+
+// GetShopReservations lists the shop's reservations, latest market date first
+func GetShopReservations(db *gorm.DB, c *fiber.Ctx) error {
+	var reservations []model.StallReservation
+	if err := db.Joins("MarketOpenDate").Where("stall_reservations.shop_id = ?", c.Params("shop_id")).
+		Order("MarketOpenDate.date desc").Find(&reservations).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to retrieve reservations",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(reservations)
+}
+
+// CancelStallReservation gives back a requested or confirmed block before its market date
+func CancelStallReservation(db *gorm.DB, c *fiber.Ctx) error {
+	id, err := paramID(c, "id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid reservation ID"})
+	}
+	var reservation model.StallReservation
+	if err := db.Preload("MarketOpenDate").First(&reservation, "id = ? AND shop_id = ?", id, c.Params("shop_id")).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Reservation not found"})
+	}
+	if reservation.MarketOpenDate != nil && marketOver(*reservation.MarketOpenDate) {
+		return reservationResponse(c, errReservationClosed)
+	}
+	before, reservation, err := decideReservation(db, c, id, activeReservations, model.ReservationCancelled, "")
+	if err != nil {
+		return reservationResponse(c, err)
+	}
+	recordAudit(db, c, "reservation.cancel", auditEntityReservation, id, before, reservation)
+	return c.JSON(reservation)
+}
+
+// GetStallReservations lists reservations for admins. ?market_open_date_id,
+// ?block_id and ?status narrow the list.
+func GetStallReservations(db *gorm.DB, c *fiber.Ctx) error {
+	query := db.Order("id desc").Limit(c.QueryInt("limit", 100)).Offset(c.QueryInt("offset", 0))
+	for _, field := range []string{"market_open_date_id", "block_id", "status"} {
+		if value := c.Query(field); value != "" {
+			query = query.Where(field+" = ?", value)
+		}
+	}
+	var reservations []model.StallReservation
+	if err := query.Find(&reservations).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to retrieve reservations",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(reservations)
+}
+
+// CreateStallReservation reserves a block for a shop directly; it is confirmed at once.
+// Body: {"block_id": 4, "market_open_date_id": 12, "shop_id": 7}
+func CreateStallReservation(db *gorm.DB, c *fiber.Ctx) error {
+	var input struct {
+		BlockID          uint   `json:"block_id"`
+		MarketOpenDateID uint   `json:"market_open_date_id"`
+		ShopID           uint   `json:"shop_id"`
+		Note             string `json:"note"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if input.BlockID == 0 || input.MarketOpenDateID == 0 || input.ShopID == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "block_id, market_open_date_id and shop_id are required"})
+	}
+
+	decidedBy := reviewerID(c)
+	now := time.Now()
+	reservation := model.StallReservation{
+		BlockID:          input.BlockID,
+		MarketOpenDateID: input.MarketOpenDateID,
+		ShopID:           input.ShopID,
+		Status:           model.ReservationConfirmed,
+		Note:             strings.TrimSpace(input.Note),
+		DecidedBy:        &decidedBy,
+		DecidedAt:        &now,
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&model.Shop{}, input.ShopID).Error; err != nil {
+			return fmt.Errorf("shop %d: %w", input.ShopID, err)
+		}
+		return reserveBlock(tx, &reservation)
+	})
+	if err != nil {
+		return reservationResponse(c, err)
+	}
+	recordAudit(db, c, "reservation.create", auditEntityReservation, reservation.ID, nil, reservation)
+	return c.Status(fiber.StatusCreated).JSON(reservation)
+}
+
+// ConfirmStallReservation accepts a requested reservation
+func ConfirmStallReservation(db *gorm.DB, c *fiber.Ctx) error {
+	id, err := paramID(c, "id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid reservation ID"})
+	}
+	before, reservation, err := decideReservation(db, c, id, []string{model.ReservationRequested}, model.ReservationConfirmed, "")
+	if err != nil {
+		return reservationResponse(c, err)
+	}
+	recordAudit(db, c, "reservation.confirm", auditEntityReservation, id, before, reservation)
+	return c.JSON(reservation)
+}
+
+// RejectStallReservation turns down a requested reservation, or takes back a
+// confirmed one, and frees the block. Body: {"reason": "..."}
+func RejectStallReservation(db *gorm.DB, c *fiber.Ctx) error {
+	id, err := paramID(c, "id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid reservation ID"})
+	}
+	var input struct {
+		Reason string `json:"reason"`
+	}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+	}
+	before, reservation, err := decideReservation(db, c, id, activeReservations, model.ReservationRejected, strings.TrimSpace(input.Reason))
+	if err != nil {
+		return reservationResponse(c, err)
+	}
+	recordAudit(db, c, "reservation.reject", auditEntityReservation, id, before, reservation)
+	return c.JSON(reservation)
+}
+
+// marketDayLayout returns every block with the shop that has it confirmed on
+// date, and whether it is free or only requested
+func marketDayLayout(db *gorm.DB, date time.Time) ([]map[string]interface{}, error) {
+	var markets []model.MarketOpenDate
+	if err := db.Where("date = ?", date.Format("2006-01-02")).Find(&markets).Error; err != nil {
+		return nil, err
+	}
+	if len(markets) == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	marketIDs := make([]uint, len(markets))
+	for i, market := range markets {
+		marketIDs[i] = market.ID
+	}
+
+	var blocks []model.MarketMap
+	if err := db.Order("block_id").Find(&blocks).Error; err != nil {
+		return nil, err
+	}
+	var reservations []model.StallReservation
+	if err := db.Preload("Shop").
+		Where("market_open_date_id IN ? AND status IN ?", marketIDs, activeReservations).
+		Find(&reservations).Error; err != nil {
+		return nil, err
+	}
+	byBlock := map[uint]model.StallReservation{}
+	for _, reservation := range reservations {
+		if current, ok := byBlock[reservation.BlockID]; !ok || current.Status != model.ReservationConfirmed {
+			byBlock[reservation.BlockID] = reservation
+		}
+	}
+
+	result := []map[string]interface{}{}
+	for _, block := range blocks {
+		entry := map[string]interface{}{
+			"block_id":    block.BlockID,
+			"block_name":  block.BlockName,
+			"block_zone":  block.BlockZone,
+			"status":      "free",
+			"shop_id":     nil,
+			"shop_name":   "no shop",
+			"category_id": "no category",
+		}
+		if reservation, ok := byBlock[block.BlockID]; ok {
+			entry["status"] = reservation.Status
+			entry["reservation_id"] = reservation.ID
+			entry["market_open_date_id"] = reservation.MarketOpenDateID
+			// Requests stay anonymous until an admin confirms them
+			if reservation.Status == model.ReservationConfirmed {
+				entry["shop_id"] = reservation.ShopID
+				entry["shop_name"] = reservation.Shop.Name
+				entry["category_id"] = reservation.Shop.ShopCategoryID
+			}
+		}
+		result = append(result, entry)
+	}
+	return result, nil
+}
@@ -0,0 +1,253 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/HealthMe-pls/medic-go-api/middleware"
+	"github.com/HealthMe-pls/medic-go-api/model"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// Items a review comment can point at. An empty item type means the whole submission.
+var reviewItemTypes = map[string]bool{
+	itemShop:     true,
+	itemMenu:     true,
+	itemSocial:   true,
+	itemPhoto:    true,
+	itemOpenTime: true,
+}
+
+// reviewItem is one item-level note in a request body
+type reviewItem struct {
+	ItemType string `json:"item_type"`
+	ItemID   *uint  `json:"item_id"`
+	Reason   string `json:"reason"`
+}
+
+func (item reviewItem) validate() error {
+	if !reviewItemTypes[item.ItemType] {
+		return fmt.Errorf("item_type must be one of shop, menu, social, photo or open_time")
+	}
+	if item.ItemType != itemShop && item.ItemID == nil {
+		return fmt.Errorf("item_id is required for item_type %s", item.ItemType)
+	}
+	if strings.TrimSpace(item.Reason) == "" {
+		return fmt.Errorf("reason is required for every item")
+	}
+	return nil
+}
+
+// openReviewRound returns the round waiting for review, starting a new one when
+// the last round was already decided or there is none yet
+func openReviewRound(db *gorm.DB, tempID uint) (model.ReviewRound, error) {
+	round, err := waitingReviewRound(db, tempID)
+	if err != nil || round.ID != 0 {
+		return round, err
+	}
+	err = db.Create(&round).Error
+	return round, err
+}
+
+// waitingReviewRound returns the round waiting for review without writing
+// anything. When the last round was already decided, as with submissions made
+// before rounds were kept, it returns the unsaved round openReviewRound would
+// start, submitted when the TempShop last moved to Submitted.
+func waitingReviewRound(db *gorm.DB, tempID uint) (model.ReviewRound, error) {
+	var round model.ReviewRound
+	err := db.Where("temp_id = ?", tempID).Order("round desc").First(&round).Error
+	if err == nil && round.Status == "Waiting" {
+		return round, nil
+	}
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return round, err
+	}
+
+	next := model.ReviewRound{
+		TempID:      tempID,
+		Round:       round.Round + 1,
+		Status:      "Waiting",
+		SubmittedAt: time.Now(),
+	}
+	// A reviewer handing the submission back does not restart its clock
+	var submitted model.TempShopTransition
+	err = db.Where("temp_id = ? AND `to` = ? AND `from` <> ?", tempID, model.StatusSubmitted, model.StatusInReview).
+		Order("id desc").First(&submitted).Error
+	if err == nil {
+		next.SubmittedAt = submitted.CreatedAt
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return next, err
+	}
+	return next, nil
+}
+
+// closeReviewRound records the decision on the open round; reviewerID is zero
+// when no admin made it. A round decided without being claimed first counts as
+// claimed at the decision.
+func closeReviewRound(db *gorm.DB, tempID uint, status string, reviewerID uint, reason string) (model.ReviewRound, error) {
+	round, err := openReviewRound(db, tempID)
+	if err != nil {
+		return round, err
+	}
+	now := time.Now()
+	if round.ClaimedAt == nil {
+		round.ClaimedAt = &now
+	}
+	round.Status = status
+	round.Reason = reason
+	if reviewerID != 0 {
+		round.ReviewerID = &reviewerID
+	}
+	round.ReviewedAt = &now
+	if err := db.Save(&round).Error; err != nil {
+		return round, err
+	}
+	return round, nil
+}
+
+// addReviewComment stores comment from the caller on round
+func addReviewComment(db *gorm.DB, c *fiber.Ctx, round model.ReviewRound, comment model.ReviewComment) (model.ReviewComment, error) {
+	comment.TempID = round.TempID
+	comment.RoundID = round.ID
+	if claims, ok := middleware.GetClaims(c); ok {
+		comment.AuthorRole = claims.Role
+		comment.AuthorID = claims.UserID()
+	}
+	err := db.Create(&comment).Error
+	return comment, err
+}
+
+// reviewTempShop loads the TempShop addressed by the request, either by
+// :shop_id on entrepreneur routes or by :id on admin routes
+func reviewTempShop(db *gorm.DB, c *fiber.Ctx) (model.TempShop, error) {
+	var tempShop model.TempShop
+	if shopID := c.Params("shop_id"); shopID != "" {
+		err := db.First(&tempShop, "shop_id = ?", shopID).Error
+		return tempShop, err
+	}
+	err := db.First(&tempShop, "temp_id = ?", c.Params("id")).Error
+	return tempShop, err
+}
+
+// GetReviewHistory returns every review round of a TempShop, oldest first, with its comments
+func GetReviewHistory(db *gorm.DB, c *fiber.Ctx) error {
+	tempShop, err := reviewTempShop(db, c)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Temp shop not found"})
+	}
+
+	var rounds []model.ReviewRound
+	if err := db.Where("temp_id = ?", tempShop.TempID).
+		Preload("Comments", func(tx *gorm.DB) *gorm.DB { return tx.Order("id") }).
+		Order("round").Find(&rounds).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to retrieve review history",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(fiber.Map{
+		"temp_id": tempShop.TempID,
+		"status":  tempShop.Status,
+		"rounds":  rounds,
+	})
+}
+
+// CreateReviewComment adds a comment to the current round of a TempShop.
+// Body: {"body": "...", "item_type": "menu", "item_id": 3, "reply_to_id": 12}
+func CreateReviewComment(db *gorm.DB, c *fiber.Ctx) error {
+	var input struct {
+		Body      string `json:"body"`
+		ItemType  string `json:"item_type"`
+		ItemID    *uint  `json:"item_id"`
+		ReplyToID *uint  `json:"reply_to_id"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if strings.TrimSpace(input.Body) == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "body is required"})
+	}
+	if input.ItemType != "" {
+		if err := (reviewItem{ItemType: input.ItemType, ItemID: input.ItemID, Reason: input.Body}).validate(); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
+
+	tempShop, err := reviewTempShop(db, c)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Temp shop not found"})
+	}
+	if input.ReplyToID != nil {
+		var parent model.ReviewComment
+		if err := db.First(&parent, "id = ? AND temp_id = ?", *input.ReplyToID, tempShop.TempID).Error; err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "reply_to_id is not a comment on this shop"})
+		}
+	}
+
+	// Comments go on the latest round, decided or not
+	var round model.ReviewRound
+	err = db.Where("temp_id = ?", tempShop.TempID).Order("round desc").First(&round).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		round, err = openReviewRound(db, tempShop.TempID)
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to load review round",
+			"details": err.Error(),
+		})
+	}
+
+	// A reviewer's comment is news to the entrepreneur; their own is not
+	var comment model.ReviewComment
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		comment, err = addReviewComment(tx, c, round, model.ReviewComment{
+			ItemType:  input.ItemType,
+			ItemID:    input.ItemID,
+			ReplyToID: input.ReplyToID,
+			Body:      input.Body,
+		})
+		if err != nil || comment.AuthorRole != middleware.RoleAdmin {
+			return err
+		}
+		return notifyOwner(tx, tempShop, model.EventCommented, "New review comment on %s", input.Body)
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to save comment",
+			"details": err.Error(),
+		})
+	}
+	return c.Status(fiber.StatusCreated).JSON(comment)
+}
+
+// ResubmitTempShop sends a rejected TempShop back for review as a new round,
+// after the same checks as a first submission.
+// An optional {"message": "..."} is kept as the entrepreneur's comment on that round.
+func ResubmitTempShop(db *gorm.DB, c *fiber.Ctx) error {
+	var input struct {
+		Message string `json:"message"`
+	}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+	}
+
+	tempShop, err := reviewTempShop(db, c)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Temp shop not found"})
+	}
+	if tempShop.Status != model.StatusRejected {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error":  "Only a rejected submission can be resubmitted",
+			"status": tempShop.Status,
+		})
+	}
+
+	tempShop, round, err := submitTempShop(db, c, tempShop.TempID, input.Message)
+	return submitResponse(db, c, tempShop, round, err)
+}
@@ -0,0 +1,395 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/HealthMe-pls/medic-go-api/model"
+	"github.com/HealthMe-pls/medic-go-api/recurrence"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// A MarketSchedule owns the MarketOpenDates its rule generates. Editing the
+// series only touches occurrences after today; anything that would move or
+// remove an occurrence shops have booked is refused until the admin repeats
+// the request with ?confirm=true.
+
+const auditEntityMarketSchedule = "market_schedule"
+
+// scheduleInput is the body of a schedule create or update.
+// {"name": "Weekend market", "rrule": "FREQ=WEEKLY;BYDAY=SA,SU;UNTIL=20271231",
+// "start_date": "2026-11-07", "start_time": "08:00", "end_time": "14:00", "exceptions": ["2026-12-26"]}
+type scheduleInput struct {
+	Name       string   `json:"name"`
+	RRule      string   `json:"rrule"`
+	StartDate  string   `json:"start_date"`
+	StartTime  string   `json:"start_time"`
+	EndTime    string   `json:"end_time"`
+	Exceptions []string `json:"exceptions"`
+}
+
+// schedule checks the input and returns it as a schedule with a normalized rule
+func (in scheduleInput) schedule() (model.MarketSchedule, error) {
+	rule, err := recurrence.Parse(in.RRule)
+	if err != nil {
+		return model.MarketSchedule{}, fmt.Errorf("invalid rrule: %v", err)
+	}
+	startDate, err := time.ParseInLocation("2006-01-02", in.StartDate, time.Local)
+	if err != nil {
+		return model.MarketSchedule{}, errors.New("start_date must be YYYY-MM-DD")
+	}
+	start, errStart := time.Parse("15:04", in.StartTime)
+	end, errEnd := time.Parse("15:04", in.EndTime)
+	if errStart != nil || errEnd != nil {
+		return model.MarketSchedule{}, errors.New("start_time and end_time must be HH:MM")
+	}
+	if !start.Before(end) {
+		return model.MarketSchedule{}, errors.New("start_time must be before end_time")
+	}
+	for _, date := range in.Exceptions {
+		if _, err := time.Parse("2006-01-02", date); err != nil {
+			return model.MarketSchedule{}, fmt.Errorf("exception %q must be YYYY-MM-DD", date)
+		}
+	}
+	return model.MarketSchedule{
+		Name:       strings.TrimSpace(in.Name),
+		RRule:      rule.String(),
+		StartDate:  startDate,
+		StartTime:  in.StartTime,
+		EndTime:    in.EndTime,
+		Exceptions: strings.Join(in.Exceptions, ","),
+	}, nil
+}
+
+// scheduleExceptions returns the schedule's exception dates as a set
+func scheduleExceptions(schedule model.MarketSchedule) map[string]bool {
+	except := map[string]bool{}
+	for _, date := range strings.Split(schedule.Exceptions, ",") {
+		if date = strings.TrimSpace(date); date != "" {
+			except[date] = true
+		}
+	}
+	return except
+}
+
+// occurrence returns the market open on date according to schedule
+func occurrence(schedule model.MarketSchedule, date time.Time) model.MarketOpenDate {
+	at := func(clock string) time.Time {
+		t, _ := time.Parse("15:04", clock)
+		return time.Date(date.Year(), date.Month(), date.Day(), t.Hour(), t.Minute(), 0, 0, time.Local)
+	}
+	scheduleID := schedule.ID
+	return model.MarketOpenDate{
+		Date:       time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.Local),
+		StartTime:  at(schedule.StartTime),
+		EndTime:    at(schedule.EndTime),
+		ScheduleID: &scheduleID,
+	}
+}
+
+// scheduleChange is one occurrence a schedule edit creates, updates or deletes
+type scheduleChange struct {
+	Action           string `json:"action"`
+	Date             string `json:"date"`
+	MarketOpenDateID uint   `json:"market_open_date_id,omitempty"`
+	Bookings         int64  `json:"bookings"`
+	occurrence       model.MarketOpenDate
+}
+
+// planSchedule works out the changes that bring the schedule's occurrences on
+// or after from in line with its rule. Occurrences before from are left alone.
+func planSchedule(tx *gorm.DB, schedule model.MarketSchedule, from time.Time) ([]scheduleChange, error) {
+	rule, err := recurrence.Parse(schedule.RRule)
+	if err != nil {
+		return nil, err
+	}
+	dates, err := rule.Dates(schedule.StartDate, scheduleExceptions(schedule))
+	if err != nil {
+		return nil, err
+	}
+	return planOccurrences(tx, schedule, dates, from)
+}
+
+// planOccurrences works out the changes that leave the schedule with exactly
+// one occurrence per date from on
+func planOccurrences(tx *gorm.DB, schedule model.MarketSchedule, dates []time.Time, from time.Time) ([]scheduleChange, error) {
+	var err error
+	fromDay := from.Format("2006-01-02")
+
+	var existing []model.MarketOpenDate
+	if schedule.ID != 0 {
+		if err := tx.Where("schedule_id = ? AND date >= ?", schedule.ID, fromDay).Order("date").Find(&existing).Error; err != nil {
+			return nil, err
+		}
+	}
+	byDate := map[string]model.MarketOpenDate{}
+	for _, market := range existing {
+		byDate[market.Date.Format("2006-01-02")] = market
+	}
+
+	var changes []scheduleChange
+	wanted := map[string]bool{}
+	for _, date := range dates {
+		day := date.Format("2006-01-02")
+		if day < fromDay {
+			continue
+		}
+		wanted[day] = true
+		next := occurrence(schedule, date)
+		current, ok := byDate[day]
+		switch {
+		case !ok:
+			changes = append(changes, scheduleChange{Action: "create", Date: day, occurrence: next})
+		case !current.StartTime.Equal(next.StartTime) || !current.EndTime.Equal(next.EndTime):
+			next.ID = current.ID
+			changes = append(changes, scheduleChange{Action: "update", Date: day, MarketOpenDateID: current.ID, occurrence: next})
+		}
+	}
+	for _, market := range existing {
+		if day := market.Date.Format("2006-01-02"); !wanted[day] {
+			changes = append(changes, scheduleChange{Action: "delete", Date: day, MarketOpenDateID: market.ID, occurrence: market})
+		}
+	}
+
+	for i := range changes {
+		if changes[i].MarketOpenDateID == 0 {
+			continue
+		}
+		if changes[i].Bookings, err = countBookings(tx, changes[i].MarketOpenDateID); err != nil {
+			return nil, err
+		}
+	}
+	return changes, nil
+}
+
+// applySchedule carries out the planned changes
+func applySchedule(tx *gorm.DB, changes []scheduleChange) error {
+	for _, change := range changes {
+		var err error
+		switch change.Action {
+		case "create":
+			err = tx.Create(&change.occurrence).Error
+		case "update":
+			err = tx.Model(&model.MarketOpenDate{}).Where("id = ?", change.MarketOpenDateID).
+				Updates(map[string]interface{}{"start_time": change.occurrence.StartTime, "end_time": change.occurrence.EndTime}).Error
+		case "delete":
+			err = tx.Delete(&model.MarketOpenDate{}, change.MarketOpenDateID).Error
+		}
+		if err != nil {
+			return fmt.Errorf("failed to %s market date %s: %w", change.Action, change.Date, err)
+		}
+	}
+	return nil
+}
+
+// countBookings returns how many shop open times and active stall
+// reservations refer to a market date. Both go when the date is deleted.
+func countBookings(db *gorm.DB, marketOpenDateID uint) (int64, error) {
+	var openTimes, reservations int64
+	if err := db.Model(&model.ShopOpenDate{}).Where("market_open_date_id = ?", marketOpenDateID).Count(&openTimes).Error; err != nil {
+		return 0, err
+	}
+	err := db.Model(&model.StallReservation{}).
+		Where("market_open_date_id = ? AND status IN ?", marketOpenDateID, activeReservations).
+		Count(&reservations).Error
+	return openTimes + reservations, err
+}
+
+// bookedChanges returns the planned updates and deletes of booked occurrences
+func bookedChanges(changes []scheduleChange) []scheduleChange {
+	booked := []scheduleChange{}
+	for _, change := range changes {
+		if change.Action != "create" && change.Bookings > 0 {
+			booked = append(booked, change)
+		}
+	}
+	return booked
+}
+
+// errNeedsConfirmation is returned when a change touches booked market dates
+// and the request did not carry ?confirm=true
+var errNeedsConfirmation = errors.New("the change affects market dates shops have booked; repeat the request with ?confirm=true to go ahead")
+
+// confirmationResponse answers a change that needs confirmation with 409 and
+// the booked occurrences it would touch
+func confirmationResponse(c *fiber.Ctx, booked []scheduleChange) error {
+	return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+		"error":  errNeedsConfirmation.Error(),
+		"booked": booked,
+	})
+}
+
+// tomorrow is the first day a series edit may change
+func tomorrow() time.Time {
+	now := time.Now()
+	return time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.Local)
+}
+
+// CreateMarketSchedule saves a recurring market and generates its dates
+func CreateMarketSchedule(db *gorm.DB, c *fiber.Ctx) error {
+	var input scheduleInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request payload"})
+	}
+	schedule, err := input.schedule()
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var changes []scheduleChange
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&schedule).Error; err != nil {
+			return err
+		}
+		var err error
+		if changes, err = planSchedule(tx, schedule, schedule.StartDate); err != nil {
+			return err
+		}
+		return applySchedule(tx, changes)
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to create market schedule",
+			"details": err.Error(),
+		})
+	}
+	recordAudit(db, c, "marketschedule.create", auditEntityMarketSchedule, schedule.ID, nil, schedule)
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"schedule": schedule,
+		"created":  len(changes),
+	})
+}
+
+// GetMarketSchedules lists every recurring market
+func GetMarketSchedules(db *gorm.DB, c *fiber.Ctx) error {
+	var schedules []model.MarketSchedule
+	if err := db.Order("id").Find(&schedules).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to retrieve market schedules",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(schedules)
+}
+
+// GetMarketSchedule returns a recurring market with its dates
+func GetMarketSchedule(db *gorm.DB, c *fiber.Ctx) error {
+	var schedule model.MarketSchedule
+	if err := db.Preload("MarketOpenDates", func(tx *gorm.DB) *gorm.DB { return tx.Order("date") }).
+		First(&schedule, c.Params("id")).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Market schedule not found"})
+	}
+	return c.JSON(schedule)
+}
+
+// UpdateMarketSchedule changes a series. Only occurrences after today follow
+// the new rule; past ones keep the times they had.
+func UpdateMarketSchedule(db *gorm.DB, c *fiber.Ctx) error {
+	var input scheduleInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request payload"})
+	}
+	next, err := input.schedule()
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var before model.MarketSchedule
+	var changes, booked []scheduleChange
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&before, c.Params("id")).Error; err != nil {
+			return err
+		}
+		next.ID, next.CreatedAt = before.ID, before.CreatedAt
+		var err error
+		if changes, err = planSchedule(tx, next, tomorrow()); err != nil {
+			return err
+		}
+		if booked = bookedChanges(changes); len(booked) > 0 && !c.QueryBool("confirm") {
+			return errNeedsConfirmation
+		}
+		if err := applySchedule(tx, changes); err != nil {
+			return err
+		}
+		return tx.Save(&next).Error
+	})
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Market schedule not found"})
+	case errors.Is(err, errNeedsConfirmation):
+		return confirmationResponse(c, booked)
+	case err != nil:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to update market schedule",
+			"details": err.Error(),
+		})
+	}
+	recordAudit(db, c, "marketschedule.update", auditEntityMarketSchedule, next.ID, before, fiber.Map{"schedule": next, "changes": changes})
+	return c.JSON(fiber.Map{
+		"schedule": next,
+		"changes":  changes,
+	})
+}
+
+// DeleteMarketSchedule ends a series. Its dates after today are removed;
+// earlier ones stay as standalone market dates.
+func DeleteMarketSchedule(db *gorm.DB, c *fiber.Ctx) error {
+	var schedule model.MarketSchedule
+	var changes, booked []scheduleChange
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&schedule, c.Params("id")).Error; err != nil {
+			return err
+		}
+		var err error
+		if changes, err = planOccurrences(tx, schedule, nil, tomorrow()); err != nil {
+			return err
+		}
+		if booked = bookedChanges(changes); len(booked) > 0 && !c.QueryBool("confirm") {
+			return errNeedsConfirmation
+		}
+		if err := applySchedule(tx, changes); err != nil {
+			return err
+		}
+		if err := tx.Model(&model.MarketOpenDate{}).Where("schedule_id = ?", schedule.ID).Update("schedule_id", nil).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&schedule).Error
+	})
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Market schedule not found"})
+	case errors.Is(err, errNeedsConfirmation):
+		return confirmationResponse(c, booked)
+	case err != nil:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to delete market schedule",
+			"details": err.Error(),
+		})
+	}
+	recordAudit(db, c, "marketschedule.delete", auditEntityMarketSchedule, schedule.ID, schedule, fiber.Map{"changes": changes})
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// detachOccurrence takes a market date out of its series so later series
+// edits leave it alone, and keeps the series from generating its date again
+func detachOccurrence(tx *gorm.DB, market model.MarketOpenDate) error {
+	if market.ScheduleID == nil {
+		return nil
+	}
+	var schedule model.MarketSchedule
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&schedule, *market.ScheduleID).Error; err != nil {
+		return err
+	}
+	day := market.Date.Format("2006-01-02")
+	if except := scheduleExceptions(schedule); !except[day] {
+		exceptions := strings.Trim(schedule.Exceptions+","+day, ",")
+		if err := tx.Model(&schedule).Update("exceptions", exceptions).Error; err != nil {
+			return err
+		}
+	}
+	return tx.Model(&model.MarketOpenDate{}).Where("id = ?", market.ID).Update("schedule_id", nil).Error
+}
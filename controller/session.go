@@ -0,0 +1,170 @@
+package controller
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/HealthMe-pls/medic-go-api/database"
+	"github.com/HealthMe-pls/medic-go-api/middleware"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// accessTokenTTL is the lifetime of the JWT sent on every request
+	accessTokenTTL = 15 * time.Minute
+	// refreshTokenTTL is how long a session survives without being refreshed
+	refreshTokenTTL = 30 * 24 * time.Hour
+)
+
+// randomToken returns n random bytes encoded for use in URLs and headers
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken is how opaque tokens are stored server-side
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueTokens creates an access token and a fresh refresh token for the session
+func issueTokens(session *database.Session) (fiber.Map, error) {
+	accessToken, err := GenerateJWT(session.UserID, session.Username, session.Role, session.ID)
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	if err := database.StoreRefreshToken(hashToken(refreshToken), session.ID, refreshTokenTTL); err != nil {
+		return nil, err
+	}
+	if err := database.SaveSession(session, refreshTokenTTL); err != nil {
+		return nil, err
+	}
+
+	return fiber.Map{
+		"token":         accessToken,
+		"refresh_token": refreshToken,
+		"expires_in":    int(accessTokenTTL.Seconds()),
+	}, nil
+}
+
+// startSession opens a new session for a user who has just logged in
+func startSession(c *fiber.Ctx, userID uint, username string, role string) (fiber.Map, error) {
+	sessionID, err := randomToken(16)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	session := &database.Session{
+		ID:         sessionID,
+		UserID:     userID,
+		Role:       role,
+		Username:   username,
+		Device:     c.Get(fiber.HeaderUserAgent),
+		IP:         c.IP(),
+		CreatedAt:  now,
+		LastUsedAt: now,
+	}
+	return issueTokens(session)
+}
+
+// RefreshToken rotates a refresh token and returns a new access token.
+// Presenting a refresh token twice revokes the whole session.
+func RefreshToken(c *fiber.Ctx) error {
+	var input struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := c.BodyParser(&input); err != nil || input.RefreshToken == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "refresh_token is required"})
+	}
+
+	sessionID, err := database.ConsumeRefreshToken(hashToken(input.RefreshToken))
+	if errors.Is(err, database.ErrRefreshTokenReused) {
+		if session, err := database.GetSession(sessionID); err == nil {
+			if err := database.RevokeSession(session); err != nil {
+				log.Println("Failed to revoke session after refresh token reuse:", err)
+			}
+			log.Printf("Refresh token reuse detected for %s %d, session %s revoked", session.Role, session.UserID, session.ID)
+		}
+		return middleware.Unauthorized(c, "Refresh token has already been used; session revoked")
+	}
+	if err != nil {
+		return middleware.Unauthorized(c, "Invalid refresh token")
+	}
+
+	session, err := database.GetSession(sessionID)
+	if err != nil {
+		return middleware.Unauthorized(c, "Session has been revoked")
+	}
+	session.LastUsedAt = time.Now()
+	session.IP = c.IP()
+
+	tokens, err := issueTokens(session)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not generate token"})
+	}
+	return c.JSON(tokens)
+}
+
+// GetSessions lists the caller's active sessions
+func GetSessions(c *fiber.Ctx) error {
+	claims, ok := middleware.GetClaims(c)
+	if !ok {
+		return middleware.Unauthorized(c, "Missing token")
+	}
+
+	sessions, err := database.ListSessions(claims.Role, claims.UserID())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to retrieve sessions",
+			"details": err.Error(),
+		})
+	}
+
+	result := make([]fiber.Map, len(sessions))
+	for i, session := range sessions {
+		result[i] = fiber.Map{
+			"id":           session.ID,
+			"device":       session.Device,
+			"ip":           session.IP,
+			"created_at":   session.CreatedAt,
+			"last_used_at": session.LastUsedAt,
+			"current":      session.ID == claims.SessionID,
+		}
+	}
+	return c.JSON(fiber.Map{"sessions": result})
+}
+
+// RevokeSession signs out one of the caller's sessions
+func RevokeSession(c *fiber.Ctx) error {
+	claims, ok := middleware.GetClaims(c)
+	if !ok {
+		return middleware.Unauthorized(c, "Missing token")
+	}
+
+	session, err := database.GetSession(c.Params("id"))
+	// Do not reveal whether another user's session exists
+	if err != nil || session.Role != claims.Role || session.UserID != claims.UserID() {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Session not found"})
+	}
+
+	if err := database.RevokeSession(session); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to revoke session",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(fiber.Map{"message": "Session revoked"})
+}
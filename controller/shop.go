@@ -3,7 +3,6 @@ package controller
 import (
 	"fmt"
 	"log"
-	"os"
 	"strconv"
 	"strings"
 	"errors"
@@ -358,13 +357,18 @@ func CreateShopWithTemp(db *gorm.DB, c *fiber.Ctx) error {
 	tempShop := model.TempShop{
 		Name:           shop.Name,
 		ShopID:         &shop.ID, // Link TempShop to the newly created Shop
-		Status:         "Approve",
+		Status:         model.StatusApproved,
 		Description:    shop.Description,
 		ShopCategoryID: &shop.ShopCategoryID,
 	}
 
-	// Save the TempShop entry
-	if err := db.Create(&tempShop).Error; err != nil {
+	// Save the TempShop entry and start its status history
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&tempShop).Error; err != nil {
+			return err
+		}
+		return logTransition(tx, tempShop.TempID, "", tempShop.Status, actorOf(c), "")
+	}); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error":   "Failed to create temp shop",
 			"details": err.Error(),
@@ -392,6 +396,8 @@ func UpdateShopByAdmin(db *gorm.DB, c *fiber.Ctx) error {
 		// return c.Status(fiber.StatusNotFound).SendString("Shop not found")
 	}
 
+	before := shopSnapshot(db, shop.ID)
+
 	// Parse the updated details from the request body
 	if err := c.BodyParser(&shop); err != nil {
 		return c.Status(fiber.StatusBadRequest).SendString("Failed to parse request body")
@@ -402,6 +408,7 @@ func UpdateShopByAdmin(db *gorm.DB, c *fiber.Ctx) error {
 		return c.Status(fiber.StatusInternalServerError).SendString("Failed to update shop")
 	}
 	UpdateTempShopFromShop(db, shop.ID)
+	recordAudit(db, c, "shop.update", auditEntityShop, shop.ID, before, shopSnapshot(db, shop.ID))
 
 	// Return the updated shop as a JSON response
 	return c.JSON(shop)
@@ -470,18 +477,59 @@ func UpdateTempShopByShopID(db *gorm.DB, c *fiber.Ctx) error {
 	}
 
 	// Parse request body
+	tempID, ownerShopID, status := tempShop.TempID, tempShop.ShopID, tempShop.Status
 	if err := c.BodyParser(&tempShop); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error":   "Failed to parse request body",
 			"details": err.Error(),
 		})
 	}
-	tempShop.Status = "Waiting"
-	// Save updated TempShop
-	if result := db.Save(&tempShop); result.Error != nil {
+	// The body must not move the edit onto another TempShop or shop, nor set its status
+	tempShop.TempID, tempShop.ShopID, tempShop.Status = tempID, ownerShopID, status
+	// Save updated TempShop and stage the edit of the shop in the draft. A
+	// submission waiting for review cannot change.
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		locked, err := beginDraft(tx, actorOf(c), *ownerShopID)
+		if err != nil {
+			return err
+		}
+		tempShop.Status = locked.Status
+		if err := tx.Save(&tempShop).Error; err != nil {
+			return err
+		}
+		var shop model.Shop
+		if err := tx.First(&shop, *ownerShopID).Error; err != nil {
+			return err
+		}
+		payload := shopPayload{Name: tempShop.Name, Description: tempShop.Description, ShopCategoryID: shop.ShopCategoryID}
+		if tempShop.ShopCategoryID != nil {
+			payload.ShopCategoryID = *tempShop.ShopCategoryID
+		}
+		op, err := newChangeOp(model.ChangeEntityShop, model.ChangeUpdate, ownerShopID, payload)
+		if err != nil {
+			return err
+		}
+		cs, err := openChangeSet(tx, *ownerShopID, changeSourceEntrepreneur)
+		if err != nil {
+			return err
+		}
+		_, err = stageChange(tx, cs, op)
+		return err
+	}); err != nil {
+		var refused *TransitionError
+		if errors.As(err, &refused) {
+			return transitionResponse(c, refused)
+		}
+		var invalid changeErrors
+		if errors.As(err, &invalid) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error":  "Change rejected",
+				"errors": invalid,
+			})
+		}
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error":   "Failed to update temp shop",
-			"details": result.Error.Error(),
+			"details": err.Error(),
 		})
 	}
 
@@ -499,56 +547,73 @@ func DeleteShop(db *gorm.DB, c *fiber.Ctx) error {
 		})
 	}
 
+	before := shopSnapshot(db, uint(shopID))
+
 	// Call the helper function to delete the shop
-	if err := DeleteShopByID(db, uint(shopID)); err != nil {
+	var files []string
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		files, err = DeleteShopByID(tx, uint(shopID))
+		return err
+	}); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": err.Error(),
 		})
 	}
+	removeUploads(files)
+	recordAudit(db, c, "shop.delete", auditEntityShop, shopID, before, nil)
 
 	return c.SendString("Shop successfully deleted")
 }
-func DeleteShopByID(tx *gorm.DB, shopID uint) error {
+
+// DeleteShopByID deletes a shop with its menus, photos and pending changes and
+// returns the photo files to remove once the transaction has committed
+func DeleteShopByID(tx *gorm.DB, shopID uint) ([]string, error) {
 	// Step 1: Retrieve all menus associated with the shop
 	var menus []model.ShopMenu
 	if err := tx.Where("shop_id = ?", shopID).Find(&menus).Error; err != nil {
-		return fmt.Errorf("failed to retrieve shop menus: %w", err)
+		return nil, fmt.Errorf("failed to retrieve shop menus: %w", err)
 	}
 
 	// Step 2: Delete all menus associated with the shop
+	var files []string
 	for _, menu := range menus {
-		if err := DeleteShopMenu(tx, menu.ID); err != nil {
-			return fmt.Errorf("failed to delete shop menu: %w", err)
+		menuFiles, err := deleteShopMenuRows(tx, menu.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to delete shop menu: %w", err)
 		}
+		files = append(files, menuFiles...)
 	}
 
 	// Step 3: Find all photos associated with the shop
 	var photos []model.Photo
 	if err := tx.Where("shop_id = ?", shopID).Find(&photos).Error; err != nil {
-		return fmt.Errorf("failed to retrieve shop photos: %w", err)
+		return nil, fmt.Errorf("failed to retrieve shop photos: %w", err)
 	}
 
-	// Step 4: Delete photos from the filesystem if they exist
+	// Step 4: Delete the photos, keeping their files for after the commit
 	for _, photo := range photos {
-		filePath := fmt.Sprintf("./uploads/%s", photo.PathFile)
-
-		// Try deleting the file, log an error if it fails but continue
-		if err := os.Remove(filePath); err != nil {
-			fmt.Println("Error deleting file:", err)
-		}
+		files = append(files, photo.PathFile)
 
 		// Delete photo from the database
 		if err := tx.Delete(&photo).Error; err != nil {
-			return fmt.Errorf("failed to delete shop photo from database: %w", err)
+			return nil, fmt.Errorf("failed to delete shop photo from database: %w", err)
 		}
 	}
 
-	// Step 5: Delete the shop from the database
+	// Step 5: Discard pending changes and collect the files uploaded for them
+	changeFiles, err := discardChangeSet(tx, shopID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to discard pending changes: %w", err)
+	}
+	files = append(files, changeFiles...)
+
+	// Step 6: Delete the shop from the database
 	if result := tx.Where("id = ?", shopID).Delete(&model.Shop{}); result.Error != nil {
-		return fmt.Errorf("failed to delete shop: %w", result.Error)
+		return nil, fmt.Errorf("failed to delete shop: %w", result.Error)
 	}
 
-	return nil
+	return files, nil
 }
 
 
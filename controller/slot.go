@@ -0,0 +1,273 @@
+package controller
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/HealthMe-pls/medic-go-api/model"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// Every shop open time, written directly by an admin or staged and approved
+// through a change set, goes through validateSlot: it must fall inside its
+// market date's hours, start before it ends, and be the shop's only open time
+// on that market date without overlapping another one.
+
+// Slot error codes
+const (
+	slotMarketNotFound = "market_not_found"
+	slotInvalidRange   = "invalid_range"
+	slotBeforeOpen     = "before_market_open"
+	slotAfterClose     = "after_market_close"
+	slotDuplicate      = "duplicate"
+	slotOverlap        = "overlap"
+)
+
+// suggestionHorizon is how many market dates are tried for a suggestion
+const suggestionHorizon = 60
+
+// slot is a shop open time being written
+type slot struct {
+	MarketOpenDateID uint
+	StartTime        time.Time
+	EndTime          time.Time
+}
+
+// bookedSlot is one of the shop's other open times. ID is nil for one that is
+// only pending in a change set.
+type bookedSlot struct {
+	ID *uint
+	slot
+}
+
+// SlotError is one scheduling rule an open time breaks
+type SlotError struct {
+	Code       string `json:"code"`
+	Field      string `json:"field"`
+	Message    string `json:"message"`
+	ConflictID *uint  `json:"conflict_id,omitempty"`
+}
+
+// SlotSuggestion is the nearest open time that breaks no rule
+type SlotSuggestion struct {
+	MarketOpenDateID uint      `json:"market_open_date_id"`
+	Date             string    `json:"date"`
+	StartTime        time.Time `json:"start_time"`
+	EndTime          time.Time `json:"end_time"`
+}
+
+// slotProblem is an open time validateSlot refused, with what to try instead
+type slotProblem struct {
+	Errors     []SlotError
+	Suggestion *SlotSuggestion
+}
+
+func (p *slotProblem) Error() string {
+	messages := make([]string, len(p.Errors))
+	for i, slotErr := range p.Errors {
+		messages[i] = slotErr.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// validateSlot checks s against its market date and others. It returns nil
+// when s is valid and a slotProblem, with a suggestion when one exists, when
+// it is not.
+func validateSlot(db *gorm.DB, s slot, others []bookedSlot) (*slotProblem, error) {
+	var market model.MarketOpenDate
+	err := db.First(&market, s.MarketOpenDateID).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	var errs []SlotError
+	if err != nil {
+		errs = []SlotError{{Code: slotMarketNotFound, Field: "market_open_date_id", Message: fmt.Sprintf("market open date %d not found", s.MarketOpenDateID)}}
+	} else {
+		errs = slotErrors(s, market, others)
+	}
+	if len(errs) == 0 {
+		return nil, nil
+	}
+
+	problem := &slotProblem{Errors: errs}
+	if problem.Suggestion, err = suggestSlot(db, s, market, others); err != nil {
+		return nil, err
+	}
+	return problem, nil
+}
+
+// slotErrors lists the rules s breaks on market
+func slotErrors(s slot, market model.MarketOpenDate, others []bookedSlot) []SlotError {
+	var errs []SlotError
+	if !s.StartTime.Before(s.EndTime) {
+		errs = append(errs, SlotError{Code: slotInvalidRange, Field: "end_time", Message: "start_time must be before end_time"})
+	}
+	// Market dates saved without hours put no bound on the time
+	if market.EndTime.After(market.StartTime) {
+		if s.StartTime.Before(market.StartTime) {
+			errs = append(errs, SlotError{Code: slotBeforeOpen, Field: "start_time",
+				Message: fmt.Sprintf("start_time is before the market opens at %s", market.StartTime.Format(time.RFC3339))})
+		}
+		if s.EndTime.After(market.EndTime) {
+			errs = append(errs, SlotError{Code: slotAfterClose, Field: "end_time",
+				Message: fmt.Sprintf("end_time is after the market closes at %s", market.EndTime.Format(time.RFC3339))})
+		}
+	}
+	for _, other := range others {
+		switch {
+		case other.MarketOpenDateID == s.MarketOpenDateID:
+			errs = append(errs, SlotError{Code: slotDuplicate, Field: "market_open_date_id", ConflictID: other.ID,
+				Message: fmt.Sprintf("the shop already opens on market date %d", s.MarketOpenDateID)})
+		case s.StartTime.Before(other.EndTime) && other.StartTime.Before(s.EndTime):
+			errs = append(errs, SlotError{Code: slotOverlap, Field: "start_time", ConflictID: other.ID,
+				Message: fmt.Sprintf("overlaps the shop's open time on market date %d", other.MarketOpenDateID)})
+		}
+	}
+	return errs
+}
+
+// suggestSlot finds the open time closest to s that breaks no rule: the same
+// time of day and length, moved inside a market's hours and shortened if
+// needed. s's own market date is tried first unless it is past, then upcoming
+// ones by distance.
+func suggestSlot(db *gorm.DB, s slot, requested model.MarketOpenDate, others []bookedSlot) (*SlotSuggestion, error) {
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	var markets []model.MarketOpenDate
+	if err := db.Where("date >= ?", today.Format("2006-01-02")).Order("date").Limit(suggestionHorizon).Find(&markets).Error; err != nil {
+		return nil, err
+	}
+
+	target := s.StartTime
+	if requested.ID != 0 {
+		target = requested.Date
+	}
+	sort.SliceStable(markets, func(i, j int) bool {
+		if markets[i].ID == requested.ID || markets[j].ID == requested.ID {
+			return markets[i].ID == requested.ID
+		}
+		return absDuration(markets[i].Date.Sub(target)) < absDuration(markets[j].Date.Sub(target))
+	})
+	if requested.ID != 0 && !requested.Date.Before(today) && (len(markets) == 0 || markets[0].ID != requested.ID) {
+		markets = append([]model.MarketOpenDate{requested}, markets...)
+	}
+
+	for _, market := range markets {
+		candidate, ok := fitSlot(s, market)
+		if !ok || len(slotErrors(candidate, market, others)) > 0 {
+			continue
+		}
+		return &SlotSuggestion{
+			MarketOpenDateID: market.ID,
+			Date:             market.Date.Format("2006-01-02"),
+			StartTime:        candidate.StartTime,
+			EndTime:          candidate.EndTime,
+		}, nil
+	}
+	return nil, nil
+}
+
+// fitSlot moves s onto market's day and inside its hours. It reports false
+// for a market date without hours.
+func fitSlot(s slot, market model.MarketOpenDate) (slot, bool) {
+	window := market.EndTime.Sub(market.StartTime)
+	if window <= 0 {
+		return slot{}, false
+	}
+	length := s.EndTime.Sub(s.StartTime)
+	if length <= 0 || length > window {
+		length = window
+	}
+	open := market.StartTime
+	start := time.Date(open.Year(), open.Month(), open.Day(), s.StartTime.Hour(), s.StartTime.Minute(), 0, 0, open.Location())
+	if start.Before(open) {
+		start = open
+	}
+	if start.Add(length).After(market.EndTime) {
+		start = market.EndTime.Add(-length)
+	}
+	return slot{MarketOpenDateID: market.ID, StartTime: start, EndTime: start.Add(length)}, true
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// liveSlots returns the shop's open times, leaving out the ones in skip
+func liveSlots(db *gorm.DB, shopID uint, skip map[uint]bool) ([]bookedSlot, error) {
+	var dates []model.ShopOpenDate
+	if err := db.Where("shop_id = ?", shopID).Find(&dates).Error; err != nil {
+		return nil, err
+	}
+	var slots []bookedSlot
+	for _, date := range dates {
+		if skip[date.ID] {
+			continue
+		}
+		id := date.ID
+		slots = append(slots, bookedSlot{ID: &id, slot: slot{MarketOpenDateID: date.MarketOpenDateID, StartTime: date.StartTime, EndTime: date.EndTime}})
+	}
+	return slots, nil
+}
+
+// plannedSlots returns the shop's open times as they would be with set
+// applied, leaving out op itself
+func plannedSlots(db *gorm.DB, shopID uint, op model.ChangeOp, set []model.ChangeOp) ([]bookedSlot, error) {
+	skip := map[uint]bool{}
+	var pending []bookedSlot
+	self := false
+	for _, other := range set {
+		if other.Entity != model.ChangeEntityOpenTime {
+			continue
+		}
+		if other.EntityID != nil {
+			skip[*other.EntityID] = true
+		}
+		if !self && other.ID == op.ID && other.Action == op.Action && bytes.Equal(other.Payload, op.Payload) {
+			self = true
+			continue
+		}
+		if other.Action == model.ChangeDelete {
+			continue
+		}
+		var payload openTimePayload
+		if decodePayload(other, &payload) != nil {
+			continue
+		}
+		pending = append(pending, bookedSlot{ID: other.EntityID, slot: slot(payload)})
+	}
+	if op.EntityID != nil {
+		skip[*op.EntityID] = true
+	}
+	live, err := liveSlots(db, shopID, skip)
+	if err != nil {
+		return nil, err
+	}
+	return append(live, pending...), nil
+}
+
+// shopOpenDateProblem validates an open time an admin writes directly
+func shopOpenDateProblem(db *gorm.DB, date model.ShopOpenDate) (*slotProblem, error) {
+	others, err := liveSlots(db, date.ShopID, map[uint]bool{date.ID: true})
+	if err != nil {
+		return nil, err
+	}
+	return validateSlot(db, slot{MarketOpenDateID: date.MarketOpenDateID, StartTime: date.StartTime, EndTime: date.EndTime}, others)
+}
+
+// slotResponse answers a refused open time with 422, the broken rules and a suggestion
+func slotResponse(c *fiber.Ctx, problem *slotProblem) error {
+	return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+		"error":      "The open time does not fit the market schedule",
+		"errors":     problem.Errors,
+		"suggestion": problem.Suggestion,
+	})
+}
@@ -0,0 +1,122 @@
+package controller
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/HealthMe-pls/medic-go-api/model"
+)
+
+func at(day string, clock string) time.Time {
+	t, err := time.Parse("2006-01-02 15:04", day+" "+clock)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func testMarket(id uint, day string, open string, close string) model.MarketOpenDate {
+	market := model.MarketOpenDate{ID: id, Date: at(day, "00:00")}
+	if open != "" {
+		market.StartTime, market.EndTime = at(day, open), at(day, close)
+	}
+	return market
+}
+
+func TestSlotErrors(t *testing.T) {
+	market := testMarket(1, "2026-03-07", "08:00", "16:00")
+	otherID := uint(7)
+	slotOn := func(marketID uint, start string, end string) slot {
+		return slot{MarketOpenDateID: marketID, StartTime: at("2026-03-07", start), EndTime: at("2026-03-07", end)}
+	}
+
+	tests := []struct {
+		name          string
+		slot          slot
+		market        model.MarketOpenDate
+		others        []bookedSlot
+		wantCodes     []string
+		wantConflicts []*uint
+	}{
+		{name: "inside the hours", slot: slotOn(1, "09:00", "12:00"), market: market},
+		{name: "whole market day", slot: slotOn(1, "08:00", "16:00"), market: market},
+		{name: "ends before it starts", slot: slotOn(1, "12:00", "09:00"), market: market,
+			wantCodes: []string{slotInvalidRange}, wantConflicts: []*uint{nil}},
+		{name: "empty", slot: slotOn(1, "09:00", "09:00"), market: market,
+			wantCodes: []string{slotInvalidRange}, wantConflicts: []*uint{nil}},
+		{name: "before opening", slot: slotOn(1, "07:00", "10:00"), market: market,
+			wantCodes: []string{slotBeforeOpen}, wantConflicts: []*uint{nil}},
+		{name: "after closing", slot: slotOn(1, "14:00", "17:00"), market: market,
+			wantCodes: []string{slotAfterClose}, wantConflicts: []*uint{nil}},
+		{name: "both sides", slot: slotOn(1, "06:00", "18:00"), market: market,
+			wantCodes: []string{slotBeforeOpen, slotAfterClose}, wantConflicts: []*uint{nil, nil}},
+		{name: "market without hours", slot: slotOn(2, "02:00", "23:00"), market: testMarket(2, "2026-03-07", "", "")},
+		{name: "second open time on the market date", slot: slotOn(1, "09:00", "10:00"), market: market,
+			others:    []bookedSlot{{ID: &otherID, slot: slotOn(1, "13:00", "15:00")}},
+			wantCodes: []string{slotDuplicate}, wantConflicts: []*uint{&otherID}},
+		{name: "overlaps a pending open time on another market date", slot: slotOn(1, "09:00", "12:00"), market: market,
+			others:    []bookedSlot{{slot: slotOn(2, "11:00", "13:00")}},
+			wantCodes: []string{slotOverlap}, wantConflicts: []*uint{nil}},
+		{name: "touching is not overlapping", slot: slotOn(1, "09:00", "12:00"), market: market,
+			others: []bookedSlot{{ID: &otherID, slot: slotOn(2, "12:00", "13:00")}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var codes []string
+			var conflicts []*uint
+			for _, slotErr := range slotErrors(tt.slot, tt.market, tt.others) {
+				codes = append(codes, slotErr.Code)
+				conflicts = append(conflicts, slotErr.ConflictID)
+			}
+			if !reflect.DeepEqual(codes, tt.wantCodes) {
+				t.Errorf("slotErrors() codes = %v, want %v", codes, tt.wantCodes)
+			}
+			if !reflect.DeepEqual(conflicts, tt.wantConflicts) {
+				t.Errorf("slotErrors() conflicts = %v, want %v", conflicts, tt.wantConflicts)
+			}
+		})
+	}
+}
+
+func TestFitSlot(t *testing.T) {
+	market := testMarket(3, "2026-03-14", "08:00", "16:00")
+	requested := func(start string, end string) slot {
+		return slot{MarketOpenDateID: 1, StartTime: at("2026-03-07", start), EndTime: at("2026-03-07", end)}
+	}
+
+	tests := []struct {
+		name      string
+		slot      slot
+		market    model.MarketOpenDate
+		wantStart string
+		wantEnd   string
+		wantOK    bool
+	}{
+		{"same time of day on the other date", requested("09:00", "12:00"), market, "09:00", "12:00", true},
+		{"moved to the opening", requested("06:00", "09:00"), market, "08:00", "11:00", true},
+		{"moved back from the closing", requested("14:00", "18:00"), market, "12:00", "16:00", true},
+		{"shortened to the market hours", requested("06:00", "20:00"), market, "08:00", "16:00", true},
+		{"empty slot takes the market hours", requested("10:00", "10:00"), market, "08:00", "16:00", true},
+		{"market without hours", requested("09:00", "12:00"), testMarket(4, "2026-03-14", "", ""), "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := fitSlot(tt.slot, tt.market)
+			if ok != tt.wantOK {
+				t.Fatalf("fitSlot() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+			want := slot{MarketOpenDateID: tt.market.ID, StartTime: at("2026-03-14", tt.wantStart), EndTime: at("2026-03-14", tt.wantEnd)}
+			if !got.StartTime.Equal(want.StartTime) || !got.EndTime.Equal(want.EndTime) || got.MarketOpenDateID != want.MarketOpenDateID {
+				t.Errorf("fitSlot() = %v to %v on %d, want %v to %v on %d",
+					got.StartTime, got.EndTime, got.MarketOpenDateID, want.StartTime, want.EndTime, want.MarketOpenDateID)
+			}
+			if len(slotErrors(got, tt.market, nil)) > 0 {
+				t.Errorf("fitSlot() = %+v breaks the market's rules", got)
+			}
+		})
+	}
+}
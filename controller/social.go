@@ -1,7 +1,6 @@
 package controller
 
 import (
-	"fmt"
 	"strconv"
 	"github.com/HealthMe-pls/medic-go-api/model"
 	"github.com/gofiber/fiber/v2"
@@ -57,7 +56,7 @@ func GetSocialMediaByShopID(db *gorm.DB, c *fiber.Ctx) error {
 
 	return c.JSON(socialMedias)
 }
-// UpdateSocialMedia updates a SocialMedia entry by ID and also updates the TempSocial entry
+// UpdateSocialMedia updates a SocialMedia entry by ID
 func UpdateSocialMedia(db *gorm.DB, c *fiber.Ctx) error {
 	id := c.Params("id")
 	var socialMedia model.SocialMedia
@@ -83,71 +82,28 @@ func UpdateSocialMedia(db *gorm.DB, c *fiber.Ctx) error {
 		})
 	}
 
-	// Now update or create the corresponding TempSocial entry
-	var tempSocial model.TempSocial
-	// Check if a TempSocial exists for this SocialMedia
-	if err := db.Where("social_id = ?", socialMedia.ID).First(&tempSocial).Error; err != nil {
-		// If TempSocial doesn't exist, create a new entry
-		tempSocial = model.TempSocial{
-			SocialID: socialMedia.ID,
-			Name:     socialMedia.Name,
-			Platform: socialMedia.Platform,
-			Link:     socialMedia.Link,
-		}
-		if err := db.Create(&tempSocial).Error; err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": "Failed to create TempSocial",
-			})
-		}
-	} else {
-		// If TempSocial exists, update it
-		tempSocial.Name = socialMedia.Name
-		tempSocial.Platform = socialMedia.Platform
-		tempSocial.Link = socialMedia.Link
-		if err := db.Save(&tempSocial).Error; err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": "Failed to update TempSocial",
-			})
-		}
-	}
-
-	// Return the updated SocialMedia and TempSocial as JSON
 	return c.JSON(fiber.Map{
 		"social_media": socialMedia,
-		"temp_social":  tempSocial,
 	})
 }
 
 
-// DeleteSocialMedia deletes a SocialMedia entry and its related TempSocial entry
+// DeleteSocialMedia deletes a SocialMedia entry. Pending changes to it go stale.
 func DeleteSocialMedia(db *gorm.DB, c *fiber.Ctx) error {
 	id := c.Params("id")
 
-	// Begin a transaction to ensure atomicity
-	tx := db.Begin()
-
-	// Delete TempSocial entries related to the SocialMedia entry
-	if err := tx.Where("social_id = ?", id).Delete(&model.TempSocial{}).Error; err != nil {
-		tx.Rollback()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to delete associated TempSocial",
-		})
-	}
-
 	// Delete the SocialMedia entry
-	if err := tx.Delete(&model.SocialMedia{}, id).Error; err != nil {
-		tx.Rollback()
+	if err := db.Delete(&model.SocialMedia{}, id).Error; err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to delete social media",
 		})
 	}
 
-	// Commit the transaction
-	tx.Commit()
-
 	return c.SendStatus(fiber.StatusNoContent)
 }
 
+// CreateSocialWithTemp adds a social media link to a shop. Admins create it
+// live; an entrepreneur's link is staged as a change and appears once approved.
 func CreateSocialWithTemp(db *gorm.DB, c *fiber.Ctx, isPublic bool) error {
     social := new(model.SocialMedia)
     
@@ -159,8 +115,16 @@ func CreateSocialWithTemp(db *gorm.DB, c *fiber.Ctx, isPublic bool) error {
         })
     }
 
-    // Assign isPublic from function parameter
-    social.IsPublic = isPublic
+    if !isPublic {
+        payload := socialPayload{Name: social.Name, Platform: social.Platform, Link: social.Link}
+        op, err := newChangeOp(model.ChangeEntitySocial, model.ChangeCreate, nil, payload)
+        if err == nil {
+            op, err = stageShopChange(db, actorOf(c), social.ShopID, op)
+        }
+        return stageResponse(c, op, err)
+    }
+
+    social.IsPublic = true
 
     // Find TempShop that has the same ShopID as the social media
     var tempShop model.TempShop
@@ -182,26 +146,8 @@ func CreateSocialWithTemp(db *gorm.DB, c *fiber.Ctx, isPublic bool) error {
         })
     }
 
-    // Create a corresponding TempSocial entry
-    tempSocial := model.TempSocial{
-        TempID:   tempShop.TempID, // Use the found TempID
-        SocialID: social.ID,
-        Name:     social.Name,
-        Platform: social.Platform,
-        Link:     social.Link,
-    }
-
-    if result := db.Create(&tempSocial); result.Error != nil {
-        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-            "error":   "Failed to create temp social media",
-            "details": result.Error.Error(),
-        })
-    }
-
-    // Return created social media and temp social media
     return c.Status(fiber.StatusCreated).JSON(fiber.Map{
-        "social":     social,
-        "tempSocial": tempSocial,
+        "social": social,
     })
 }
 
@@ -225,6 +171,8 @@ func GetShopIDBySocialID(db *gorm.DB, c *fiber.Ctx) error {
 	})
 }
 
+// UpdateSocialBySocialID stages an edit of a social media link. Fields
+// missing from the body keep their live value.
 func UpdateSocialBySocialID(db *gorm.DB, c *fiber.Ctx) error {
 	socialID, err := strconv.Atoi(c.Params("social_id"))
 	if err != nil {
@@ -241,63 +189,18 @@ func UpdateSocialBySocialID(db *gorm.DB, c *fiber.Ctx) error {
 		})
 	}
 
-	// Check if TempSocial exists for this SocialID
-	var tempSocial model.TempSocial
-	if err := db.Where("social_id = ?", social.ID).First(&tempSocial).Error; err != nil {
-		// If not found, create a new TempSocial entry
-		tempSocial = model.TempSocial{
-			SocialID: social.ID,
-			TempID:   *social.TempID, // Ensure TempID is not nil
-			Name:     social.Name,
-			Platform: social.Platform,
-			Link:     social.Link,
-		}
-		if err := db.Create(&tempSocial).Error; err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": "Failed to create temp social media",
+	payload := socialPayload{Name: social.Name, Platform: social.Platform, Link: social.Link}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&payload); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error":   "Failed to parse request body",
+				"details": err.Error(),
 			})
 		}
-	} else {
-		// If found, update existing TempSocial
-		tempSocial.Name = social.Name
-		tempSocial.Platform = social.Platform
-		tempSocial.Link = social.Link
-
-		if err := db.Save(&tempSocial).Error; err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": "Failed to update temp social media",
-			})
-		}
-	}
-
-	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"message":   "Temp social media updated successfully",
-		"tempSocial": tempSocial,
-	})
-}
-
-func UpdateSocialFromTemp(db *gorm.DB, tempID uint) error {
-	// Fetch the TempSocial record by TempID
-	var tempSocial model.TempSocial
-	if err := db.First(&tempSocial, "temp_id = ?", tempID).Error; err != nil {
-		return fmt.Errorf("TempSocial not found: %w", err)
 	}
-
-	// Fetch the corresponding SocialMedia record by SocialID
-	var social model.SocialMedia
-	if err := db.First(&social, "id = ?", tempSocial.SocialID).Error; err != nil {
-		return fmt.Errorf("SocialMedia not found for SocialID %d: %w", tempSocial.SocialID, err)
+	op, err := newChangeOp(model.ChangeEntitySocial, model.ChangeUpdate, &social.ID, payload)
+	if err == nil {
+		op, err = stageShopChange(db, actorOf(c), social.ShopID, op)
 	}
-
-	// Update SocialMedia with values from TempSocial
-	social.Name = tempSocial.Name
-	social.Platform = tempSocial.Platform
-	social.Link = tempSocial.Link
-
-	// Save the updated SocialMedia record
-	if err := db.Save(&social).Error; err != nil {
-		return fmt.Errorf("failed to update SocialMedia: %w", err)
-	}
-
-	return nil
+	return stageResponse(c, op, err)
 }
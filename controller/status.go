@@ -0,0 +1,163 @@
+package controller
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/HealthMe-pls/medic-go-api/middleware"
+	"github.com/HealthMe-pls/medic-go-api/model"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// Statuses in which a submission waits for or is under review
+var awaitingReview = []model.TempShopStatus{model.StatusSubmitted, model.StatusInReview}
+
+// legacyStatuses maps the free-form statuses used before the state machine
+var legacyStatuses = map[string]model.TempShopStatus{
+	"":                  model.StatusDraft,
+	"Waiting":           model.StatusSubmitted,
+	"Approve":           model.StatusApproved,
+	"NotApprove":        model.StatusRejected,
+	"PartiallyApproved": model.StatusRejected, // the rejected part waits for the entrepreneur
+}
+
+// transitionActor is who moves a TempShop to a new status
+type transitionActor struct {
+	Role string
+	ID   uint
+}
+
+// systemActor makes the transitions nobody asked for, such as migrations
+var systemActor = transitionActor{Role: "system"}
+
+// actorOf returns the logged-in user behind c
+func actorOf(c *fiber.Ctx) transitionActor {
+	if claims, ok := middleware.GetClaims(c); ok {
+		return transitionActor{Role: claims.Role, ID: claims.UserID()}
+	}
+	return systemActor
+}
+
+// TransitionError is returned when a TempShop cannot move to the requested status,
+// either because the move is not allowed or because its status changed meanwhile
+type TransitionError struct {
+	From model.TempShopStatus
+	To   model.TempShopStatus
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("a submission in status %s cannot become %s", e.From, e.To)
+}
+
+// transitionTempShop moves tempShop to status to, records who did it and
+// queues a notification for the entrepreneur. The update only succeeds if the
+// stored status is still the one tempShop was loaded with, so concurrent
+// decisions cannot both win.
+func transitionTempShop(tx *gorm.DB, actor transitionActor, tempShop *model.TempShop, to model.TempShopStatus, note string) error {
+	from := tempShop.Status
+	if !from.CanTransitionTo(to) {
+		return &TransitionError{From: from, To: to}
+	}
+	result := tx.Model(&model.TempShop{}).
+		Where("temp_id = ? AND status = ?", tempShop.TempID, from).
+		Update("status", to)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		var current model.TempShop
+		if err := tx.Select("status").First(&current, "temp_id = ?", tempShop.TempID).Error; err != nil {
+			return err
+		}
+		return &TransitionError{From: current.Status, To: to}
+	}
+	tempShop.Status = to
+	if err := logTransition(tx, tempShop.TempID, from, to, actor, note); err != nil {
+		return err
+	}
+	return notifyTransition(tx, *tempShop, from, to, note)
+}
+
+// decideTempShop records a reviewer's decision. A submission nobody has
+// picked up yet goes through InReview first so the review time is kept.
+func decideTempShop(tx *gorm.DB, actor transitionActor, tempShop *model.TempShop, to model.TempShopStatus, note string) error {
+	if tempShop.Status == model.StatusSubmitted {
+		if err := transitionTempShop(tx, actor, tempShop, model.StatusInReview, ""); err != nil {
+			return err
+		}
+	}
+	return transitionTempShop(tx, actor, tempShop, to, note)
+}
+
+// logTransition appends a transition without checking it; new TempShops and
+// migrations start their history here
+func logTransition(tx *gorm.DB, tempID uint, from model.TempShopStatus, to model.TempShopStatus, actor transitionActor, note string) error {
+	return tx.Create(&model.TempShopTransition{
+		TempID:    tempID,
+		From:      from,
+		To:        to,
+		ActorRole: actor.Role,
+		ActorID:   actor.ID,
+		Note:      note,
+	}).Error
+}
+
+// transitionResponse answers a refused transition with 409 and the current status
+func transitionResponse(c *fiber.Ctx, err *TransitionError) error {
+	return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+		"error":  err.Error(),
+		"status": err.From,
+	})
+}
+
+// GetTempShopTransitions returns the status history of a TempShop, oldest first
+func GetTempShopTransitions(db *gorm.DB, c *fiber.Ctx) error {
+	tempShop, err := reviewTempShop(db, c)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Temp shop not found"})
+	}
+
+	var transitions []model.TempShopTransition
+	if err := db.Where("temp_id = ?", tempShop.TempID).Order("id").Find(&transitions).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to retrieve status history",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(fiber.Map{
+		"temp_id":     tempShop.TempID,
+		"status":      tempShop.Status,
+		"transitions": transitions,
+	})
+}
+
+// MigrateLegacyStatuses rewrites TempShops still carrying a pre-state-machine
+// status and starts their history with a system transition. Unknown values
+// are logged and left alone so an admin can look at them.
+func MigrateLegacyStatuses(db *gorm.DB) error {
+	var tempShops []model.TempShop
+	if err := db.Select("temp_id", "status").Find(&tempShops).Error; err != nil {
+		return fmt.Errorf("failed to load temp shops: %w", err)
+	}
+	for _, tempShop := range tempShops {
+		if tempShop.Status.Valid() {
+			continue
+		}
+		status, ok := legacyStatuses[string(tempShop.Status)]
+		if !ok {
+			log.Printf("TempShop %d has unknown status %q, left unchanged", tempShop.TempID, tempShop.Status)
+			continue
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&model.TempShop{}).Where("temp_id = ?", tempShop.TempID).Update("status", status).Error; err != nil {
+				return err
+			}
+			return logTransition(tx, tempShop.TempID, "", status, systemActor, fmt.Sprintf("migrated from %q", tempShop.Status))
+		})
+		if err != nil {
+			return fmt.Errorf("temp shop %d: %w", tempShop.TempID, err)
+		}
+	}
+	return nil
+}
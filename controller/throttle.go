@@ -0,0 +1,274 @@
+package controller
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/HealthMe-pls/medic-go-api/database"
+	"github.com/HealthMe-pls/medic-go-api/model"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+const (
+	// loginFailureWindow is how far back failed logins are counted
+	loginFailureWindow = 15 * time.Minute
+	// loginFreeAttempts is how many failures are allowed before delays start
+	loginFreeAttempts = 3
+	// loginMaxDelay caps the wait between attempts
+	loginMaxDelay = time.Minute
+	// loginLockThreshold failures for one username lock it for loginLockDuration
+	loginLockThreshold = 10
+	loginLockDuration  = 15 * time.Minute
+	// ipFailureThreshold failures from one IP block it until the window moves on
+	ipFailureThreshold = 50
+)
+
+// Auth event reasons
+const (
+	authReasonSuccess     = "success"
+	authReasonUnknownUser = "unknown_user"
+	authReasonBadPassword = "bad_password"
+	authReasonLocked      = "locked"
+	authReasonThrottled   = "throttled"
+	authReasonBadMFACode  = "bad_mfa_code"
+)
+
+// loginDelay is the wait required after the latest failure. It doubles with
+// every failure past loginFreeAttempts.
+func loginDelay(failures int64) time.Duration {
+	if failures < loginFreeAttempts {
+		return 0
+	}
+	delay := time.Second << min(failures-loginFreeAttempts, 16)
+	return min(delay, loginMaxDelay)
+}
+
+// throttleKey normalizes usernames so case variations share one counter
+func throttleKey(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+// adminThrottleKey keeps admin emails apart from entrepreneur usernames
+func adminThrottleKey(email string) string {
+	return "admin:" + throttleKey(email)
+}
+
+// tooManyAttempts writes a 429 with a Retry-After header
+func tooManyAttempts(c *fiber.Ctx, message string, wait time.Duration) error {
+	seconds := int(math.Ceil(wait.Seconds()))
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(seconds))
+	return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+		"error":       message,
+		"retry_after": seconds,
+	})
+}
+
+// loginAttempt is a login that passed the throttle. Its place in the failure
+// counters was taken before the password was checked, so a burst of parallel
+// guesses is counted as it arrives rather than after each bcrypt comparison.
+type loginAttempt struct {
+	username string
+	reserved []database.LoginAttempt
+}
+
+// beginLogin reserves an attempt for username from ip. It returns a non-empty
+// reason and the wait when the attempt must be refused before the password is
+// checked. Redis errors let the attempt through.
+func beginLogin(username string, ip string) (*loginAttempt, string, time.Duration) {
+	if ttl, err := database.LoginLockTTL(username); err != nil {
+		log.Println("Failed to read login lock:", err)
+	} else if ttl > 0 {
+		return nil, authReasonLocked, ttl
+	}
+
+	attempt := &loginAttempt{username: username}
+	for _, scope := range []struct{ name, id string }{
+		{database.ScopeUsername, username},
+		{database.ScopeIP, ip},
+	} {
+		reserved, err := database.ReserveLoginAttempt(scope.name, scope.id, loginFailureWindow)
+		if err != nil {
+			log.Println("Failed to reserve login attempt:", err)
+			continue
+		}
+		attempt.reserved = append(attempt.reserved, reserved)
+		failures := reserved.Before
+		if scope.name == database.ScopeIP && failures.Count >= ipFailureThreshold {
+			// Wait until the oldest counted failure leaves the window would need
+			// another lookup; the full window is a safe upper bound
+			attempt.release()
+			return nil, authReasonThrottled, loginFailureWindow
+		}
+		if wait := time.Until(failures.Last.Add(loginDelay(failures.Count))); wait > 0 {
+			attempt.release()
+			return nil, authReasonThrottled, wait
+		}
+	}
+	return attempt, "", 0
+}
+
+// release takes back the reserved attempts of a login that was refused
+func (a *loginAttempt) release() {
+	for _, reserved := range a.reserved {
+		if err := database.ReleaseLoginAttempt(reserved); err != nil {
+			log.Println("Failed to release login attempt:", err)
+		}
+	}
+}
+
+// failed keeps the reserved attempts as failures and locks the username once
+// it reaches loginLockThreshold
+func (a *loginAttempt) failed() {
+	failures, err := database.GetLoginFailures(database.ScopeUsername, a.username, loginFailureWindow)
+	if err != nil {
+		log.Println("Failed to read login failures:", err)
+		return
+	}
+	if failures.Count >= loginLockThreshold {
+		if err := database.LockLogin(a.username, loginLockDuration); err != nil {
+			log.Println("Failed to lock login:", err)
+		}
+		log.Printf("Login locked for %q after %d failures", a.username, failures.Count)
+	}
+}
+
+// countLoginFailure records a failure for username and ip that did not come
+// from a password check, such as a wrong second-factor code
+func countLoginFailure(username string, ip string) {
+	attempt := &loginAttempt{username: username}
+	for _, scope := range []struct{ name, id string }{
+		{database.ScopeUsername, username},
+		{database.ScopeIP, ip},
+	} {
+		reserved, err := database.ReserveLoginAttempt(scope.name, scope.id, loginFailureWindow)
+		if err != nil {
+			log.Println("Failed to record login failure:", err)
+			continue
+		}
+		attempt.reserved = append(attempt.reserved, reserved)
+	}
+	attempt.failed()
+}
+
+// succeeded forgets the username's failures and takes back the IP's reserved attempt
+func (a *loginAttempt) succeeded() {
+	if err := database.ClearLoginFailures(database.ScopeUsername, a.username); err != nil {
+		log.Println("Failed to clear login failures:", err)
+	}
+	for _, reserved := range a.reserved {
+		if reserved.Scope != database.ScopeIP {
+			continue
+		}
+		if err := database.ReleaseLoginAttempt(reserved); err != nil {
+			log.Println("Failed to release login attempt:", err)
+		}
+	}
+}
+
+// recordAuthEvent stores a login attempt. Failing to store it never fails the login.
+func recordAuthEvent(db *gorm.DB, c *fiber.Ctx, role string, username string, userID *uint, reason string) {
+	event := model.AuthEvent{
+		Role:      role,
+		Username:  username,
+		UserID:    userID,
+		IP:        c.IP(),
+		UserAgent: c.Get(fiber.HeaderUserAgent),
+		Success:   reason == authReasonSuccess,
+		Reason:    reason,
+	}
+	if err := db.Create(&event).Error; err != nil {
+		log.Println("Failed to record auth event:", err)
+	}
+}
+
+// GetLoginLocks lists the usernames that are locked after repeated failures
+func GetLoginLocks(c *fiber.Ctx) error {
+	locks, err := database.ListLoginLocks()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to retrieve login locks",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(locks)
+}
+
+// ClearLoginLock unlocks a username and resets its failure count
+func ClearLoginLock(c *fiber.Ctx) error {
+	username := throttleKey(c.Params("username"))
+	found, err := database.UnlockLogin(username)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to clear login lock",
+			"details": err.Error(),
+		})
+	}
+	if !found {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "No lock or failures for this username"})
+	}
+	return c.JSON(fiber.Map{"message": fmt.Sprintf("Login unlocked for %s", username)})
+}
+
+// GetAuthEvents lists login attempts, newest first.
+// Filters: username, ip, success (true/false), since (RFC 3339) and limit.
+func GetAuthEvents(db *gorm.DB, c *fiber.Ctx) error {
+	query := db.Order("id desc")
+	if username := c.Query("username"); username != "" {
+		query = query.Where("username = ?", username)
+	}
+	if ip := c.Query("ip"); ip != "" {
+		query = query.Where("ip = ?", ip)
+	}
+	if success := c.Query("success"); success != "" {
+		query = query.Where("success = ?", success == "true")
+	}
+	if since := c.Query("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "since must be an RFC 3339 time"})
+		}
+		query = query.Where("created_at >= ?", t)
+	}
+
+	var events []model.AuthEvent
+	if err := query.Limit(c.QueryInt("limit", 100)).Find(&events).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to retrieve auth events",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(events)
+}
+
+// GetSuspiciousIPs lists IPs whose failed logins in the last `hours` (default 24)
+// targeted at least `min_usernames` (default 5) different usernames, the usual
+// sign of credential stuffing
+func GetSuspiciousIPs(db *gorm.DB, c *fiber.Ctx) error {
+	since := time.Now().Add(-time.Duration(c.QueryInt("hours", 24)) * time.Hour)
+	minUsernames := c.QueryInt("min_usernames", 5)
+
+	var rows []struct {
+		IP        string    `json:"ip"`
+		Usernames int       `json:"usernames"`
+		Failures  int       `json:"failures"`
+		LastSeen  time.Time `json:"last_seen"`
+	}
+	if err := db.Model(&model.AuthEvent{}).
+		Select("ip, COUNT(DISTINCT username) AS usernames, COUNT(*) AS failures, MAX(created_at) AS last_seen").
+		Where("success = ? AND created_at >= ?", false, since).
+		Group("ip").
+		Having("COUNT(DISTINCT username) >= ?", minUsernames).
+		Order("usernames desc").
+		Scan(&rows).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to retrieve suspicious IPs",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(rows)
+}
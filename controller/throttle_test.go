@@ -0,0 +1,57 @@
+package controller
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoginDelay(t *testing.T) {
+	tests := []struct {
+		failures int64
+		want     time.Duration
+	}{
+		{0, 0},
+		{loginFreeAttempts - 1, 0},
+		{loginFreeAttempts, time.Second},
+		{loginFreeAttempts + 1, 2 * time.Second},
+		{loginFreeAttempts + 2, 4 * time.Second},
+		{loginFreeAttempts + 5, 32 * time.Second},
+		{loginFreeAttempts + 6, loginMaxDelay},
+		{loginLockThreshold, loginMaxDelay},
+		// The shift is bounded, so a huge count cannot overflow into a negative delay
+		{1000, loginMaxDelay},
+	}
+	for _, tt := range tests {
+		if got := loginDelay(tt.failures); got != tt.want {
+			t.Errorf("loginDelay(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestLoginDelayFitsWindow(t *testing.T) {
+	// Every failure until the lock must still be counted when its delay ends
+	var total time.Duration
+	for failures := int64(1); failures < loginLockThreshold; failures++ {
+		total += loginDelay(failures)
+	}
+	if total >= loginFailureWindow {
+		t.Errorf("delays before the lock add up to %v, more than the %v window", total, loginFailureWindow)
+	}
+}
+
+func TestThrottleKeys(t *testing.T) {
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{"case and spaces", throttleKey("  Somchai "), "somchai"},
+		{"admin email", adminThrottleKey(" Admin@Example.com"), "admin:admin@example.com"},
+		{"admin apart from a username", adminThrottleKey("somchai"), "admin:somchai"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, tt.got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,447 @@
+package controller
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/HealthMe-pls/medic-go-api/database"
+	"github.com/HealthMe-pls/medic-go-api/middleware"
+	"github.com/HealthMe-pls/medic-go-api/model"
+	"github.com/HealthMe-pls/medic-go-api/totp"
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	// mfaChallengeTTL is how long the second login step may take
+	mfaChallengeTTL = 5 * time.Minute
+	// maxMFAAttempts wrong codes end the challenge; the admin must enter the password again
+	maxMFAAttempts = 5
+	// recoveryCodeCount is how many recovery codes are issued at a time
+	recoveryCodeCount = 10
+	// totpSkew accepts codes from one step either side of now to allow for clock drift
+	totpSkew = 1
+)
+
+var (
+	errTOTPAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	errTOTPNotStarted     = errors.New("start two-factor setup first")
+	errInvalidMFACode     = errors.New("invalid authentication code")
+	errMFALocked          = errors.New("login is locked")
+)
+
+// adminRequires2FA reports whether ADMIN_REQUIRE_2FA forces every admin to use TOTP
+func adminRequires2FA() bool {
+	required := strings.ToLower(os.Getenv("ADMIN_REQUIRE_2FA"))
+	return required == "true" || required == "1"
+}
+
+// totpIssuer is the account name shown in authenticator apps
+func totpIssuer() string {
+	if issuer := os.Getenv("TOTP_ISSUER"); issuer != "" {
+		return issuer
+	}
+	return "HealthMe Admin"
+}
+
+// startMFAChallenge answers a correct password with a token for the second login step
+func startMFAChallenge(c *fiber.Ctx, admin model.Admin, purpose string) error {
+	mfaToken, err := randomToken(32)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not generate token"})
+	}
+	if err := database.SaveMFAChallenge(hashToken(mfaToken), admin.ID, purpose, mfaChallengeTTL); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not start two-factor login"})
+	}
+
+	response := fiber.Map{
+		"mfa_token":  mfaToken,
+		"expires_in": int(mfaChallengeTTL.Seconds()),
+	}
+	if purpose == database.MFAEnroll {
+		response["mfa_enrollment_required"] = true
+	} else {
+		response["mfa_required"] = true
+	}
+	return c.Status(fiber.StatusAccepted).JSON(response)
+}
+
+// loadMFAChallenge returns the admin behind an mfa_token issued for purpose
+func loadMFAChallenge(db *gorm.DB, mfaToken string, purpose string) (*model.Admin, error) {
+	challenge, err := database.GetMFAChallenge(hashToken(mfaToken))
+	if err != nil || challenge.Purpose != purpose {
+		return nil, errors.New("two-factor login has expired, sign in again")
+	}
+	var admin model.Admin
+	if err := db.First(&admin, challenge.AdminID).Error; err != nil {
+		return nil, errors.New("two-factor login has expired, sign in again")
+	}
+	return &admin, nil
+}
+
+// takeMFAAttempt uses up one of the challenge's attempts before a code is
+// checked. It refuses while the admin's login is locked, returning the wait,
+// and ends the challenge once maxMFAAttempts codes were tried.
+func takeMFAAttempt(mfaToken string, admin *model.Admin) (time.Duration, error) {
+	if ttl, err := database.LoginLockTTL(adminThrottleKey(admin.Email)); err != nil {
+		log.Println("Failed to read login lock:", err)
+	} else if ttl > 0 {
+		database.DeleteMFAChallenge(hashToken(mfaToken))
+		return ttl, errMFALocked
+	}
+	_, err := database.ReserveMFAAttempt(hashToken(mfaToken), maxMFAAttempts)
+	if errors.Is(err, database.ErrMFAAttemptsExhausted) {
+		database.DeleteMFAChallenge(hashToken(mfaToken))
+	}
+	return 0, err
+}
+
+// mfaAttemptError answers a code check takeMFAAttempt refused
+func mfaAttemptError(c *fiber.Ctx, err error, wait time.Duration) error {
+	switch {
+	case errors.Is(err, errMFALocked):
+		return tooManyAttempts(c, "Account temporarily locked after repeated failed logins", wait)
+	case errors.Is(err, database.ErrSessionNotFound), errors.Is(err, database.ErrMFAAttemptsExhausted):
+		return middleware.Unauthorized(c, "two-factor login has expired, sign in again")
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error":   "Could not check the code",
+		"details": err.Error(),
+	})
+}
+
+// failMFAAttempt counts a wrong code as a failed login of the admin, so new
+// challenges cannot be used to keep guessing past the lockout
+func failMFAAttempt(db *gorm.DB, c *fiber.Ctx, admin *model.Admin) {
+	countLoginFailure(adminThrottleKey(admin.Email), c.IP())
+	recordAuthEvent(db, c, middleware.RoleAdmin, admin.Email, &admin.ID, authReasonBadMFACode)
+}
+
+// finishMFALogin ends a challenge whose code was right and forgets the admin's failures
+func finishMFALogin(db *gorm.DB, c *fiber.Ctx, mfaToken string, admin *model.Admin) {
+	database.DeleteMFAChallenge(hashToken(mfaToken))
+	if err := database.ClearLoginFailures(database.ScopeUsername, adminThrottleKey(admin.Email)); err != nil {
+		log.Println("Failed to clear login failures:", err)
+	}
+	recordAuthEvent(db, c, middleware.RoleAdmin, admin.Email, &admin.ID, authReasonSuccess)
+}
+
+// verifyTOTP checks a code and records its time step so it cannot be used twice
+func verifyTOTP(db *gorm.DB, admin *model.Admin, code string) bool {
+	counter, ok := totp.Validate(admin.TOTPSecret, code, time.Now(), totpSkew)
+	if !ok {
+		return false
+	}
+	result := db.Model(&model.Admin{}).
+		Where("id = ? AND totp_last_counter < ?", admin.ID, counter).
+		Update("totp_last_counter", counter)
+	return result.Error == nil && result.RowsAffected == 1
+}
+
+// normalizeRecoveryCode ignores case, spaces and dashes
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// useRecoveryCode redeems one of the admin's unused recovery codes
+func useRecoveryCode(db *gorm.DB, adminID uint, code string) bool {
+	result := db.Model(&model.AdminRecoveryCode{}).
+		Where("admin_id = ? AND code_hash = ? AND used_at IS NULL", adminID, hashToken(normalizeRecoveryCode(code))).
+		Update("used_at", time.Now())
+	return result.Error == nil && result.RowsAffected == 1
+}
+
+// issueRecoveryCodes replaces the admin's recovery codes and returns the new ones.
+// Only hashes are stored, so the codes can be shown once.
+func issueRecoveryCodes(tx *gorm.DB, adminID uint) ([]string, error) {
+	if err := tx.Where("admin_id = ?", adminID).Delete(&model.AdminRecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		raw := strings.ToLower(encoding.EncodeToString(b))[:10]
+		codes[i] = raw[:5] + "-" + raw[5:]
+		if err := tx.Create(&model.AdminRecoveryCode{AdminID: adminID, CodeHash: hashToken(raw)}).Error; err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
+
+// beginTOTPEnrollment stores a new pending secret and returns what the
+// authenticator app needs
+func beginTOTPEnrollment(db *gorm.DB, admin *model.Admin) (fiber.Map, error) {
+	if admin.TOTPEnabled {
+		return nil, errTOTPAlreadyEnabled
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Model(admin).Updates(map[string]interface{}{"totp_secret": secret, "totp_last_counter": 0}).Error; err != nil {
+		return nil, err
+	}
+	return fiber.Map{
+		"secret":           secret,
+		"provisioning_uri": totp.ProvisioningURI(totpIssuer(), admin.Email, secret),
+	}, nil
+}
+
+// confirmTOTPEnrollment enables TOTP once the admin proves the app is set up
+// and returns the first set of recovery codes
+func confirmTOTPEnrollment(db *gorm.DB, admin *model.Admin, code string) ([]string, error) {
+	if admin.TOTPEnabled {
+		return nil, errTOTPAlreadyEnabled
+	}
+	if admin.TOTPSecret == "" {
+		return nil, errTOTPNotStarted
+	}
+	if !verifyTOTP(db, admin, code) {
+		return nil, errInvalidMFACode
+	}
+
+	var codes []string
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(admin).Update("totp_enabled", true).Error; err != nil {
+			return err
+		}
+		var err error
+		codes, err = issueRecoveryCodes(tx, admin.ID)
+		return err
+	})
+	return codes, err
+}
+
+// twoFactorError maps enrollment errors to responses
+func twoFactorError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, errInvalidMFACode):
+		return middleware.Unauthorized(c, err.Error())
+	case errors.Is(err, errTOTPAlreadyEnabled), errors.Is(err, errTOTPNotStarted):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error":   "Two-factor update failed",
+		"details": err.Error(),
+	})
+}
+
+// AdminLoginMFA completes an admin login with a TOTP or recovery code
+func AdminLoginMFA(db *gorm.DB, c *fiber.Ctx) error {
+	var input struct {
+		MFAToken string `json:"mfa_token"`
+		Code     string `json:"code"`
+	}
+	if err := c.BodyParser(&input); err != nil || input.MFAToken == "" || input.Code == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "mfa_token and code are required"})
+	}
+
+	admin, err := loadMFAChallenge(db, input.MFAToken, database.MFAVerify)
+	if err != nil {
+		return middleware.Unauthorized(c, err.Error())
+	}
+	if wait, err := takeMFAAttempt(input.MFAToken, admin); err != nil {
+		return mfaAttemptError(c, err, wait)
+	}
+	if !verifyTOTP(db, admin, input.Code) && !useRecoveryCode(db, admin.ID, input.Code) {
+		failMFAAttempt(db, c, admin)
+		return middleware.Unauthorized(c, errInvalidMFACode.Error())
+	}
+	finishMFALogin(db, c, input.MFAToken, admin)
+
+	tokens, err := startSession(c, admin.ID, admin.Email, middleware.RoleAdmin)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not generate token"})
+	}
+	return c.JSON(tokens)
+}
+
+// AdminLoginEnroll starts TOTP setup for an admin who must enroll before signing in
+func AdminLoginEnroll(db *gorm.DB, c *fiber.Ctx) error {
+	var input struct {
+		MFAToken string `json:"mfa_token"`
+	}
+	if err := c.BodyParser(&input); err != nil || input.MFAToken == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "mfa_token is required"})
+	}
+	admin, err := loadMFAChallenge(db, input.MFAToken, database.MFAEnroll)
+	if err != nil {
+		return middleware.Unauthorized(c, err.Error())
+	}
+
+	setup, err := beginTOTPEnrollment(db, admin)
+	if err != nil {
+		return twoFactorError(c, err)
+	}
+	return c.JSON(setup)
+}
+
+// AdminLoginEnrollConfirm enables TOTP with the first code and signs the admin in
+func AdminLoginEnrollConfirm(db *gorm.DB, c *fiber.Ctx) error {
+	var input struct {
+		MFAToken string `json:"mfa_token"`
+		Code     string `json:"code"`
+	}
+	if err := c.BodyParser(&input); err != nil || input.MFAToken == "" || input.Code == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "mfa_token and code are required"})
+	}
+	admin, err := loadMFAChallenge(db, input.MFAToken, database.MFAEnroll)
+	if err != nil {
+		return middleware.Unauthorized(c, err.Error())
+	}
+
+	if wait, err := takeMFAAttempt(input.MFAToken, admin); err != nil {
+		return mfaAttemptError(c, err, wait)
+	}
+	codes, err := confirmTOTPEnrollment(db, admin, input.Code)
+	if errors.Is(err, errInvalidMFACode) {
+		failMFAAttempt(db, c, admin)
+	}
+	if err != nil {
+		return twoFactorError(c, err)
+	}
+	finishMFALogin(db, c, input.MFAToken, admin)
+
+	tokens, err := startSession(c, admin.ID, admin.Email, middleware.RoleAdmin)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not generate token"})
+	}
+	tokens["recovery_codes"] = codes
+	return c.JSON(tokens)
+}
+
+// loggedInAdmin loads the admin making the request
+func loggedInAdmin(db *gorm.DB, c *fiber.Ctx) (*model.Admin, error) {
+	claims, ok := middleware.GetClaims(c)
+	if !ok {
+		return nil, errors.New("missing token")
+	}
+	var admin model.Admin
+	if err := db.First(&admin, claims.UserID()).Error; err != nil {
+		return nil, err
+	}
+	return &admin, nil
+}
+
+// SetupAdminTOTP starts TOTP setup for the logged-in admin
+func SetupAdminTOTP(db *gorm.DB, c *fiber.Ctx) error {
+	admin, err := loggedInAdmin(db, c)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Admin not found"})
+	}
+	setup, err := beginTOTPEnrollment(db, admin)
+	if err != nil {
+		return twoFactorError(c, err)
+	}
+	return c.JSON(setup)
+}
+
+// EnableAdminTOTP turns on TOTP for the logged-in admin and returns recovery codes
+func EnableAdminTOTP(db *gorm.DB, c *fiber.Ctx) error {
+	var input struct {
+		Code string `json:"code"`
+	}
+	if err := c.BodyParser(&input); err != nil || input.Code == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "code is required"})
+	}
+	admin, err := loggedInAdmin(db, c)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Admin not found"})
+	}
+
+	codes, err := confirmTOTPEnrollment(db, admin, input.Code)
+	if err != nil {
+		return twoFactorError(c, err)
+	}
+	return c.JSON(fiber.Map{
+		"message":        "Two-factor authentication enabled",
+		"recovery_codes": codes,
+	})
+}
+
+// DisableAdminTOTP turns off TOTP after checking the password and a current code.
+// It is refused while ADMIN_REQUIRE_2FA is set.
+func DisableAdminTOTP(db *gorm.DB, c *fiber.Ctx) error {
+	if adminRequires2FA() {
+		return middleware.Forbidden(c, "Two-factor authentication is required for all admins")
+	}
+	var input struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+	if err := c.BodyParser(&input); err != nil || input.Password == "" || input.Code == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "password and code are required"})
+	}
+	admin, err := loggedInAdmin(db, c)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Admin not found"})
+	}
+	if !admin.TOTPEnabled {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Two-factor authentication is not enabled"})
+	}
+	if bcrypt.CompareHashAndPassword([]byte(admin.Password), []byte(input.Password)) != nil {
+		return middleware.Unauthorized(c, "Password is incorrect")
+	}
+	if !verifyTOTP(db, admin, input.Code) && !useRecoveryCode(db, admin.ID, input.Code) {
+		return middleware.Unauthorized(c, errInvalidMFACode.Error())
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(admin).Updates(map[string]interface{}{
+			"totp_enabled":      false,
+			"totp_secret":       "",
+			"totp_last_counter": 0,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Where("admin_id = ?", admin.ID).Delete(&model.AdminRecoveryCode{}).Error
+	})
+	if err != nil {
+		return twoFactorError(c, err)
+	}
+	return c.JSON(fiber.Map{"message": "Two-factor authentication disabled"})
+}
+
+// RegenerateRecoveryCodes replaces the logged-in admin's recovery codes after checking a current TOTP code
+func RegenerateRecoveryCodes(db *gorm.DB, c *fiber.Ctx) error {
+	var input struct {
+		Code string `json:"code"`
+	}
+	if err := c.BodyParser(&input); err != nil || input.Code == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "code is required"})
+	}
+	admin, err := loggedInAdmin(db, c)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Admin not found"})
+	}
+	if !admin.TOTPEnabled {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Two-factor authentication is not enabled"})
+	}
+	if !verifyTOTP(db, admin, input.Code) {
+		return middleware.Unauthorized(c, errInvalidMFACode.Error())
+	}
+
+	var codes []string
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		codes, err = issueRecoveryCodes(tx, admin.ID)
+		return err
+	})
+	if err != nil {
+		log.Println("Failed to regenerate recovery codes:", err)
+		return twoFactorError(c, err)
+	}
+	return c.JSON(fiber.Map{"recovery_codes": codes})
+}
@@ -0,0 +1,622 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/HealthMe-pls/medic-go-api/model"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// shopState is the public state of a shop, stored as the snapshot of a ShopVersion
+type shopState struct {
+	Name           string          `json:"name"`
+	Description    string          `json:"description"`
+	ShopCategoryID uint            `json:"shop_category_id"`
+	Menus          []menuState     `json:"menus"`
+	Socials        []socialState   `json:"socials"`
+	Photos         []photoState    `json:"photos"`
+	OpenDates      []openDateState `json:"open_dates"`
+}
+
+type menuState struct {
+	ID                 uint    `json:"id"`
+	ProductName        string  `json:"product_name"`
+	ProductDescription string  `json:"product_description"`
+	Price              float64 `json:"price"`
+}
+
+type socialState struct {
+	ID       uint   `json:"id"`
+	Name     string `json:"name"`
+	Platform string `json:"platform"`
+	Link     string `json:"link"`
+}
+
+type photoState struct {
+	ID       uint   `json:"id"`
+	PathFile string `json:"path_file"`
+	ShopID   *uint  `json:"shop_id"`
+	MenuID   *uint  `json:"menu_id"`
+}
+
+type openDateState struct {
+	ID               uint      `json:"id"`
+	MarketOpenDateID uint      `json:"market_open_date_id"`
+	StartTime        time.Time `json:"start_time"`
+	EndTime          time.Time `json:"end_time"`
+}
+
+// loadShopState reads what the public currently sees of a shop
+func loadShopState(db *gorm.DB, shopID uint) (shopState, error) {
+	var state shopState
+	var shop model.Shop
+	if err := db.First(&shop, shopID).Error; err != nil {
+		return state, fmt.Errorf("Shop not found for ShopID %d: %w", shopID, err)
+	}
+	state.Name, state.Description, state.ShopCategoryID = shop.Name, shop.Description, shop.ShopCategoryID
+
+	var menus []model.ShopMenu
+	if err := db.Where("shop_id = ? AND is_public = ?", shopID, true).Order("id").Find(&menus).Error; err != nil {
+		return state, err
+	}
+	state.Menus = make([]menuState, len(menus))
+	menuIDs := make([]uint, len(menus))
+	for i, menu := range menus {
+		state.Menus[i] = menuState{ID: menu.ID, ProductName: menu.ProductName, ProductDescription: menu.ProductDescription, Price: menu.Price}
+		menuIDs[i] = menu.ID
+	}
+
+	var socials []model.SocialMedia
+	if err := db.Where("shop_id = ? AND is_public = ?", shopID, true).Order("id").Find(&socials).Error; err != nil {
+		return state, err
+	}
+	state.Socials = make([]socialState, len(socials))
+	for i, social := range socials {
+		state.Socials[i] = socialState{ID: social.ID, Name: social.Name, Platform: social.Platform, Link: social.Link}
+	}
+
+	// Photos hang off the shop directly or off one of its menus
+	photoQuery := db.Where("is_public = ?", true)
+	if len(menuIDs) > 0 {
+		photoQuery = photoQuery.Where("shop_id = ? OR menu_id IN ?", shopID, menuIDs)
+	} else {
+		photoQuery = photoQuery.Where("shop_id = ?", shopID)
+	}
+	var photos []model.Photo
+	if err := photoQuery.Order("id").Find(&photos).Error; err != nil {
+		return state, err
+	}
+	state.Photos = make([]photoState, len(photos))
+	for i, photo := range photos {
+		state.Photos[i] = photoState{ID: photo.ID, PathFile: photo.PathFile, ShopID: photo.ShopID, MenuID: photo.MenuID}
+	}
+
+	var openDates []model.ShopOpenDate
+	if err := db.Where("shop_id = ?", shopID).Order("market_open_date_id, id").Find(&openDates).Error; err != nil {
+		return state, err
+	}
+	state.OpenDates = make([]openDateState, len(openDates))
+	for i, date := range openDates {
+		state.OpenDates[i] = openDateState{ID: date.ID, MarketOpenDateID: date.MarketOpenDateID, StartTime: date.StartTime, EndTime: date.EndTime}
+	}
+	return state, nil
+}
+
+// saveShopVersion snapshots the shop's current public state as its next version
+func saveShopVersion(tx *gorm.DB, shopID uint, source string, tempID *uint, rollbackOf *int, actorID uint) (model.ShopVersion, error) {
+	state, err := loadShopState(tx, shopID)
+	if err != nil {
+		return model.ShopVersion{}, err
+	}
+	snapshot, err := json.Marshal(state)
+	if err != nil {
+		return model.ShopVersion{}, err
+	}
+
+	var last model.ShopVersion
+	err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("shop_id = ?", shopID).Order("version desc").First(&last).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return model.ShopVersion{}, err
+	}
+
+	version := model.ShopVersion{
+		ShopID:      shopID,
+		Version:     last.Version + 1,
+		Source:      source,
+		TempID:      tempID,
+		RollbackOf:  rollbackOf,
+		CreatedByID: actorID,
+		Snapshot:    snapshot,
+	}
+	if err := tx.Create(&version).Error; err != nil {
+		return version, err
+	}
+	return version, nil
+}
+
+// ensureBaselineVersion records the state a shop had before its first
+// versioned approval, so that approval can be rolled back too
+func ensureBaselineVersion(tx *gorm.DB, shopID uint, actorID uint) error {
+	var count int64
+	if err := tx.Model(&model.ShopVersion{}).Where("shop_id = ?", shopID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	_, err := saveShopVersion(tx, shopID, model.VersionBaseline, nil, nil, actorID)
+	return err
+}
+
+func loadShopVersion(db *gorm.DB, shopID uint, version int) (model.ShopVersion, shopState, error) {
+	var record model.ShopVersion
+	var state shopState
+	if err := db.First(&record, "shop_id = ? AND version = ?", shopID, version).Error; err != nil {
+		return record, state, err
+	}
+	err := json.Unmarshal(record.Snapshot, &state)
+	return record, state, err
+}
+
+// GetShopVersions lists the versions of a shop, newest first, without snapshots
+func GetShopVersions(db *gorm.DB, c *fiber.Ctx) error {
+	shopID, err := paramID(c, "shop_id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid shop ID"})
+	}
+
+	var versions []model.ShopVersion
+	if err := db.Omit("snapshot").Where("shop_id = ?", shopID).Order("version desc").Find(&versions).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to retrieve shop versions",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(versions)
+}
+
+// GetShopVersion returns one version of a shop with its snapshot
+func GetShopVersion(db *gorm.DB, c *fiber.Ctx) error {
+	shopID, err := paramID(c, "shop_id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid shop ID"})
+	}
+	version, err := strconv.Atoi(c.Params("version"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid version"})
+	}
+
+	record, _, err := loadShopVersion(db, shopID, version)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Shop version not found"})
+	}
+	return c.JSON(record)
+}
+
+// ShopVersionDiff is what changed between two versions of a shop
+type ShopVersionDiff struct {
+	ShopID    uint          `json:"shop_id"`
+	From      int           `json:"from"`
+	To        int           `json:"to"`
+	Shop      []FieldChange `json:"shop"`
+	Menus     []ItemChange  `json:"menus"`
+	Socials   []ItemChange  `json:"socials"`
+	Photos    []ItemChange  `json:"photos"`
+	OpenTimes []ItemChange  `json:"open_times"`
+}
+
+// DiffShopVersions compares two versions of a shop given as ?from=&to=
+func DiffShopVersions(db *gorm.DB, c *fiber.Ctx) error {
+	shopID, err := paramID(c, "shop_id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid shop ID"})
+	}
+	from, to := c.QueryInt("from"), c.QueryInt("to")
+	if from <= 0 || to <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "from and to must be version numbers"})
+	}
+
+	_, old, err := loadShopVersion(db, shopID, from)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": fmt.Sprintf("Shop version %d not found", from)})
+	}
+	_, new, err := loadShopVersion(db, shopID, to)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": fmt.Sprintf("Shop version %d not found", to)})
+	}
+
+	diff := diffShopStates(old, new)
+	diff.ShopID, diff.From, diff.To = shopID, from, to
+	return c.JSON(diff)
+}
+
+// versionItem is one entry of a snapshot list, keyed by what identifies it across versions
+type versionItem struct {
+	key    uint
+	values fiber.Map
+}
+
+func diffShopStates(old shopState, new shopState) ShopVersionDiff {
+	var diff ShopVersionDiff
+	diff.Shop = diffValues(
+		fiber.Map{"name": old.Name, "description": old.Description, "shop_category_id": old.ShopCategoryID},
+		fiber.Map{"name": new.Name, "description": new.Description, "shop_category_id": new.ShopCategoryID},
+	)
+
+	menuItems := func(menus []menuState) []versionItem {
+		items := make([]versionItem, len(menus))
+		for i, m := range menus {
+			items[i] = versionItem{m.ID, menuValues(model.ShopMenu{ProductName: m.ProductName, ProductDescription: m.ProductDescription, Price: m.Price})}
+		}
+		return items
+	}
+	socialItems := func(socials []socialState) []versionItem {
+		items := make([]versionItem, len(socials))
+		for i, s := range socials {
+			items[i] = versionItem{s.ID, socialValues(model.SocialMedia{Name: s.Name, Platform: s.Platform, Link: s.Link})}
+		}
+		return items
+	}
+	photoItems := func(photos []photoState) []versionItem {
+		items := make([]versionItem, len(photos))
+		for i, p := range photos {
+			items[i] = versionItem{p.ID, photoValues(model.Photo{PathFile: p.PathFile, ShopID: p.ShopID, MenuID: p.MenuID})}
+		}
+		return items
+	}
+	// Open times are matched by market date, as the open-time apply in changeset.go does
+	openDateItems := func(dates []openDateState) []versionItem {
+		items := make([]versionItem, len(dates))
+		for i, d := range dates {
+			items[i] = versionItem{d.MarketOpenDateID, openTimeValues(d.MarketOpenDateID, d.StartTime, d.EndTime)}
+		}
+		return items
+	}
+
+	diff.Menus = diffItemLists(menuItems(old.Menus), menuItems(new.Menus))
+	diff.Socials = diffItemLists(socialItems(old.Socials), socialItems(new.Socials))
+	diff.Photos = diffItemLists(photoItems(old.Photos), photoItems(new.Photos))
+	diff.OpenTimes = diffItemLists(openDateItems(old.OpenDates), openDateItems(new.OpenDates))
+	return diff
+}
+
+// diffItemLists pairs items by key and reports additions, edits and removals in key order
+func diffItemLists(old []versionItem, new []versionItem) []ItemChange {
+	oldByKey := map[uint]fiber.Map{}
+	newByKey := map[uint]fiber.Map{}
+	var keys []uint
+	for _, item := range old {
+		oldByKey[item.key] = item.values
+		keys = append(keys, item.key)
+	}
+	for _, item := range new {
+		if _, ok := oldByKey[item.key]; !ok {
+			keys = append(keys, item.key)
+		}
+		newByKey[item.key] = item.values
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	changes := []ItemChange{}
+	for _, key := range keys {
+		before, inOld := oldByKey[key]
+		after, inNew := newByKey[key]
+		switch {
+		case !inOld:
+			changes = append(changes, ItemChange{ID: key, Change: changeAdded, New: after})
+		case !inNew:
+			changes = append(changes, ItemChange{ID: key, Change: changeRemoved, Old: before})
+		default:
+			if item := editedItem(key, before, after); len(item.Fields) > 0 {
+				changes = append(changes, item)
+			}
+		}
+	}
+	return changes
+}
+
+// RollbackShopVersion restores a shop to an earlier version. The difference
+// between the live shop and that version is staged as a change set and
+// published with approveTempShop, the same path an approval takes. It refuses
+// while a submission is waiting for review and while the entrepreneur has
+// pending changes, which must be withdrawn or decided first. Both are checked
+// again once the TempShop is locked. The submission status is left as it was.
+// Photos whose files are no longer in uploads cannot come back and are reported.
+func RollbackShopVersion(db *gorm.DB, c *fiber.Ctx) error {
+	shopID, err := paramID(c, "shop_id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid shop ID"})
+	}
+	versionNumber, err := strconv.Atoi(c.Params("version"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid version"})
+	}
+
+	_, target, err := loadShopVersion(db, shopID, versionNumber)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Shop version not found"})
+	}
+	var tempShop model.TempShop
+	if err := db.First(&tempShop, "shop_id = ?", shopID).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Temp shop not found"})
+	}
+	if err := rollbackBlocked(db, tempShop); err != nil {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+	}
+	before := shopSnapshot(db, shopID)
+
+	var files, missing []string
+	var version model.ShopVersion
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&tempShop, "temp_id = ?", tempShop.TempID).Error; err != nil {
+			return fmt.Errorf("failed to lock TempShop: %w", err)
+		}
+		// A submission or edit may have arrived since the first check
+		if err := rollbackBlocked(tx, tempShop); err != nil {
+			return err
+		}
+		// Only an empty change set is left to close
+		discarded, err := discardChangeSet(tx, shopID)
+		if err != nil {
+			return fmt.Errorf("failed to clear pending changes: %w", err)
+		}
+		if missing, err = stageShopState(tx, tempShop, target); err != nil {
+			return fmt.Errorf("failed to stage version %d: %w", versionNumber, err)
+		}
+		published, _, err := approveTempShop(tx, tempShop.TempID)
+		if err != nil {
+			return err
+		}
+		files = append(discarded, published...)
+
+		// A rollback that cannot reproduce the version must not be half applied
+		live, err := loadShopState(tx, shopID)
+		if err != nil {
+			return err
+		}
+		if stateContent(live, nil) != stateContent(target, missing) {
+			return fmt.Errorf("shop does not match version %d after publishing", versionNumber)
+		}
+		version, err = saveShopVersion(tx, shopID, model.VersionRollback, &tempShop.TempID, &versionNumber, reviewerID(c))
+		return err
+	})
+	var blocked *rollbackConflict
+	if errors.As(err, &blocked) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Rollback failed, nothing was changed",
+			"details": err.Error(),
+		})
+	}
+	removeUploads(files)
+	recordAudit(db, c, "shop.rollback", auditEntityShop, shopID, before, fiber.Map{"version": version.Version, "rollback_of": versionNumber, "shop": shopSnapshot(db, shopID)})
+
+	return c.JSON(fiber.Map{
+		"message":        fmt.Sprintf("Shop rolled back to version %d", versionNumber),
+		"version":        version.Version,
+		"missing_photos": missing,
+	})
+}
+
+// rollbackConflict is why a rollback must wait
+type rollbackConflict struct{ reason string }
+
+func (e *rollbackConflict) Error() string { return e.reason }
+
+// rollbackBlocked returns a rollbackConflict while tempShop is waiting for
+// review or its shop has pending changes, which a rollback would throw away
+func rollbackBlocked(db *gorm.DB, tempShop model.TempShop) error {
+	if tempShop.Status == model.StatusSubmitted || tempShop.Status == model.StatusInReview {
+		return &rollbackConflict{"A submission is waiting for review; approve or reject it before rolling back"}
+	}
+	ops, err := pendingOpsOfShop(db, *tempShop.ShopID)
+	if err != nil {
+		return err
+	}
+	if len(ops) > 0 {
+		return &rollbackConflict{fmt.Sprintf("The shop has %d pending changes; they must be withdrawn or decided before rolling back", len(ops))}
+	}
+	return nil
+}
+
+// stageShopState stages the difference between the live shop and target as a
+// rollback change set, the same ops an entrepreneur's edits become. Menus
+// removed since come back as new ones and their photos follow them. It returns
+// the target photos that cannot be restored because their file is gone.
+func stageShopState(tx *gorm.DB, tempShop model.TempShop, target shopState) ([]string, error) {
+	shopID := *tempShop.ShopID
+	live, err := loadShopState(tx, shopID)
+	if err != nil {
+		return nil, err
+	}
+	cs, err := openChangeSet(tx, shopID, changeSourceRollback)
+	if err != nil {
+		return nil, err
+	}
+	stage := func(op model.ChangeOp, err error) (model.ChangeOp, error) {
+		if err != nil {
+			return op, err
+		}
+		return stageChange(tx, cs, op)
+	}
+
+	// The TempShop shows the shop as it will be once approved
+	if err := tx.Model(&tempShop).Updates(map[string]interface{}{
+		"name":             target.Name,
+		"description":      target.Description,
+		"shop_category_id": target.ShopCategoryID,
+	}).Error; err != nil {
+		return nil, err
+	}
+	if live.Name != target.Name || live.Description != target.Description || live.ShopCategoryID != target.ShopCategoryID {
+		payload := shopPayload{Name: target.Name, Description: target.Description, ShopCategoryID: target.ShopCategoryID}
+		if _, err := stage(newChangeOp(model.ChangeEntityShop, model.ChangeUpdate, &shopID, payload)); err != nil {
+			return nil, err
+		}
+	}
+
+	// Restored menus are only ops until applied; photos point at them by op
+	restoredMenus := map[uint]uint{}
+	liveMenus := map[uint]menuState{}
+	for _, menu := range live.Menus {
+		liveMenus[menu.ID] = menu
+	}
+	for _, menu := range target.Menus {
+		current, ok := liveMenus[menu.ID]
+		delete(liveMenus, menu.ID)
+		if ok && current == menu {
+			continue
+		}
+		payload := menuPayload{ProductName: menu.ProductName, ProductDescription: menu.ProductDescription, Price: menu.Price}
+		if ok {
+			id := menu.ID
+			if _, err := stage(newChangeOp(model.ChangeEntityMenu, model.ChangeUpdate, &id, payload)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		op, err := stage(newChangeOp(model.ChangeEntityMenu, model.ChangeCreate, nil, payload))
+		if err != nil {
+			return nil, err
+		}
+		restoredMenus[menu.ID] = op.ID
+	}
+	for id := range liveMenus {
+		id := id
+		if _, err := stage(newChangeOp(model.ChangeEntityMenu, model.ChangeDelete, &id, nil)); err != nil {
+			return nil, err
+		}
+	}
+
+	liveSocials := map[uint]socialState{}
+	for _, social := range live.Socials {
+		liveSocials[social.ID] = social
+	}
+	for _, social := range target.Socials {
+		current, ok := liveSocials[social.ID]
+		delete(liveSocials, social.ID)
+		if ok && current == social {
+			continue
+		}
+		payload := socialPayload{Name: social.Name, Platform: social.Platform, Link: social.Link}
+		var entityID *uint
+		action := model.ChangeCreate
+		if ok {
+			id := social.ID
+			entityID, action = &id, model.ChangeUpdate
+		}
+		if _, err := stage(newChangeOp(model.ChangeEntitySocial, action, entityID, payload)); err != nil {
+			return nil, err
+		}
+	}
+	for id := range liveSocials {
+		id := id
+		if _, err := stage(newChangeOp(model.ChangeEntitySocial, model.ChangeDelete, &id, nil)); err != nil {
+			return nil, err
+		}
+	}
+
+	var missing []string
+	livePhotos := map[uint]bool{}
+	for _, photo := range live.Photos {
+		livePhotos[photo.ID] = true
+	}
+	for _, photo := range target.Photos {
+		if livePhotos[photo.ID] {
+			delete(livePhotos, photo.ID)
+			continue
+		}
+		if _, err := os.Stat(fmt.Sprintf("./uploads/%s", photo.PathFile)); err != nil {
+			missing = append(missing, photo.PathFile)
+			continue
+		}
+		op, err := newChangeOp(model.ChangeEntityPhoto, model.ChangeCreate, nil, photoPayload{PathFile: photo.PathFile, ShopID: photo.ShopID})
+		if photo.MenuID != nil {
+			if refOpID, ok := restoredMenus[*photo.MenuID]; ok {
+				op.RefOpID = &refOpID
+			} else {
+				op, err = newChangeOp(model.ChangeEntityPhoto, model.ChangeCreate, nil, photoPayload{PathFile: photo.PathFile, MenuID: photo.MenuID})
+			}
+		}
+		if _, err := stage(op, err); err != nil {
+			return nil, err
+		}
+	}
+	for id := range livePhotos {
+		id := id
+		if _, err := stage(newChangeOp(model.ChangeEntityPhoto, model.ChangeDelete, &id, nil)); err != nil {
+			return nil, err
+		}
+	}
+
+	// Open times are matched by market date
+	liveDates := map[uint]openDateState{}
+	for _, date := range live.OpenDates {
+		liveDates[date.MarketOpenDateID] = date
+	}
+	for _, date := range target.OpenDates {
+		current, ok := liveDates[date.MarketOpenDateID]
+		delete(liveDates, date.MarketOpenDateID)
+		if ok && current.StartTime.Equal(date.StartTime) && current.EndTime.Equal(date.EndTime) {
+			continue
+		}
+		payload := openTimePayload{MarketOpenDateID: date.MarketOpenDateID, StartTime: date.StartTime, EndTime: date.EndTime}
+		var entityID *uint
+		action := model.ChangeCreate
+		if ok {
+			id := current.ID
+			entityID, action = &id, model.ChangeUpdate
+		}
+		if _, err := stage(newChangeOp(model.ChangeEntityOpenTime, action, entityID, payload)); err != nil {
+			return nil, err
+		}
+	}
+	for _, date := range liveDates {
+		id := date.ID
+		if _, err := stage(newChangeOp(model.ChangeEntityOpenTime, model.ChangeDelete, &id, nil)); err != nil {
+			return nil, err
+		}
+	}
+	return missing, nil
+}
+
+// stateContent renders what a visitor sees of a shop, ignoring row IDs, so a
+// restored version can be compared with the original. Photos in skip are left out.
+func stateContent(state shopState, skip []string) string {
+	skipped := map[string]bool{}
+	for _, path := range skip {
+		skipped[path] = true
+	}
+
+	var menus, socials, photos, dates []string
+	for _, m := range state.Menus {
+		menus = append(menus, fmt.Sprintf("%q|%q|%v", m.ProductName, m.ProductDescription, m.Price))
+	}
+	for _, s := range state.Socials {
+		socials = append(socials, fmt.Sprintf("%q|%q|%q", s.Name, s.Platform, s.Link))
+	}
+	for _, p := range state.Photos {
+		if !skipped[p.PathFile] {
+			photos = append(photos, p.PathFile)
+		}
+	}
+	for _, d := range state.OpenDates {
+		dates = append(dates, fmt.Sprintf("%d|%s|%s", d.MarketOpenDateID, d.StartTime.UTC().Format(time.RFC3339), d.EndTime.UTC().Format(time.RFC3339)))
+	}
+	for _, list := range [][]string{menus, socials, photos, dates} {
+		sort.Strings(list)
+	}
+	return fmt.Sprintf("%q|%q|%d\n%s\n%s\n%s\n%s", state.Name, state.Description, state.ShopCategoryID,
+		strings.Join(menus, ","), strings.Join(socials, ","), strings.Join(photos, ","), strings.Join(dates, ","))
+}
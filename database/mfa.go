@@ -0,0 +1,94 @@
+package database
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// MFA challenge purposes
+const (
+	// MFAVerify asks an admin with 2FA enabled for a code
+	MFAVerify = "verify"
+	// MFAEnroll lets an admin without 2FA enroll when it is required
+	MFAEnroll = "enroll"
+)
+
+// MFAChallenge is the state between a correct password and the second login step
+type MFAChallenge struct {
+	AdminID  uint
+	Purpose  string
+	Attempts int64
+}
+
+func mfaKey(tokenHash string) string {
+	return "mfa:" + tokenHash
+}
+
+// SaveMFAChallenge stores a challenge under the hash of the token given to the client
+func SaveMFAChallenge(tokenHash string, adminID uint, purpose string, ttl time.Duration) error {
+	pipe := RedisClient.TxPipeline()
+	pipe.HSet(ctx, mfaKey(tokenHash), "admin_id", adminID, "purpose", purpose, "attempts", 0)
+	pipe.Expire(ctx, mfaKey(tokenHash), ttl)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// GetMFAChallenge loads a challenge. Expired or unknown tokens return ErrSessionNotFound.
+func GetMFAChallenge(tokenHash string) (*MFAChallenge, error) {
+	values, err := RedisClient.HGetAll(ctx, mfaKey(tokenHash)).Result()
+	if err == redis.Nil || (err == nil && len(values) == 0) {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	adminID, err := strconv.ParseUint(values["admin_id"], 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	attempts, _ := strconv.ParseInt(values["attempts"], 10, 64)
+	return &MFAChallenge{AdminID: uint(adminID), Purpose: values["purpose"], Attempts: attempts}, nil
+}
+
+// ErrMFAAttemptsExhausted is returned once a challenge has used all its attempts
+var ErrMFAAttemptsExhausted = errors.New("no attempts left on this challenge")
+
+// reserveMFAAttemptScript takes one attempt from an existing challenge while
+// fewer than ARGV[1] were taken and returns the count, -1 when the challenge
+// is gone and -2 when no attempt is left. HINCRBY on its own would bring back
+// an expired challenge without a TTL.
+var reserveMFAAttemptScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return -1
+end
+local attempts = tonumber(redis.call("HGET", KEYS[1], "attempts") or "0")
+if attempts >= tonumber(ARGV[1]) then
+	return -2
+end
+return redis.call("HINCRBY", KEYS[1], "attempts", 1)
+`)
+
+// ReserveMFAAttempt counts a code check before the code is verified, so
+// parallel requests on one challenge cannot get more than limit guesses. It
+// returns ErrSessionNotFound for a challenge that expired or was used and
+// ErrMFAAttemptsExhausted once limit checks were made.
+func ReserveMFAAttempt(tokenHash string, limit int64) (int64, error) {
+	attempts, err := reserveMFAAttemptScript.Run(ctx, RedisClient, []string{mfaKey(tokenHash)}, limit).Int64()
+	switch {
+	case err != nil:
+		return 0, err
+	case attempts == -1:
+		return 0, ErrSessionNotFound
+	case attempts == -2:
+		return 0, ErrMFAAttemptsExhausted
+	}
+	return attempts, nil
+}
+
+// DeleteMFAChallenge ends a challenge once it has been used or abused
+func DeleteMFAChallenge(tokenHash string) error {
+	return RedisClient.Del(ctx, mfaKey(tokenHash)).Err()
+}
@@ -0,0 +1,146 @@
+package database
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrReviewClaimed is returned when another admin holds the claim on a submission
+var ErrReviewClaimed = errors.New("review claimed by another admin")
+
+// ErrReviewNotClaimed is returned when nobody holds the claim on a submission
+var ErrReviewNotClaimed = errors.New("review not claimed")
+
+// ReviewClaim is the admin currently reviewing a submission and when the claim lapses
+type ReviewClaim struct {
+	AdminID   uint      `json:"admin_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func reviewClaimKey(tempID uint) string {
+	return "review_claim:" + strconv.FormatUint(uint64(tempID), 10)
+}
+
+// claimReviewScript sets the claim when it is free, renews it when ARGV[1]
+// already holds it, and returns the holder with the milliseconds left
+var claimReviewScript = redis.NewScript(`
+local holder = redis.call("GET", KEYS[1])
+if not holder then
+	redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+	return {ARGV[1], tonumber(ARGV[2])}
+end
+if holder == ARGV[1] then
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+	return {holder, tonumber(ARGV[2])}
+end
+return {holder, redis.call("PTTL", KEYS[1])}
+`)
+
+// renewReviewScript renews the claim only if ARGV[1] holds it, and returns
+// the holder with the milliseconds left, or nothing when the claim is free
+var renewReviewScript = redis.NewScript(`
+local holder = redis.call("GET", KEYS[1])
+if not holder then
+	return {}
+end
+if holder == ARGV[1] then
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+	return {holder, tonumber(ARGV[2])}
+end
+return {holder, redis.call("PTTL", KEYS[1])}
+`)
+
+// releaseReviewScript deletes the claim only if ARGV[1] holds it
+var releaseReviewScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// ClaimReview takes or renews the claim on a submission for adminID. When
+// another admin holds it, the returned claim is theirs and the error is ErrReviewClaimed.
+func ClaimReview(tempID uint, adminID uint, ttl time.Duration) (ReviewClaim, error) {
+	admin := strconv.FormatUint(uint64(adminID), 10)
+	result, err := claimReviewScript.Run(ctx, RedisClient, []string{reviewClaimKey(tempID)}, admin, ttl.Milliseconds()).Slice()
+	if err != nil {
+		return ReviewClaim{}, err
+	}
+	claim, err := parseReviewClaim(result)
+	if err != nil {
+		return claim, err
+	}
+	if claim.AdminID != adminID {
+		return claim, ErrReviewClaimed
+	}
+	return claim, nil
+}
+
+// RenewReviewClaim renews the claim adminID already holds on a submission
+// without taking a free one. It returns ErrReviewNotClaimed when nobody holds
+// the claim, and the other admin's claim with ErrReviewClaimed.
+func RenewReviewClaim(tempID uint, adminID uint, ttl time.Duration) (ReviewClaim, error) {
+	admin := strconv.FormatUint(uint64(adminID), 10)
+	result, err := renewReviewScript.Run(ctx, RedisClient, []string{reviewClaimKey(tempID)}, admin, ttl.Milliseconds()).Slice()
+	if err != nil {
+		return ReviewClaim{}, err
+	}
+	if len(result) == 0 {
+		return ReviewClaim{}, ErrReviewNotClaimed
+	}
+	claim, err := parseReviewClaim(result)
+	if err != nil {
+		return claim, err
+	}
+	if claim.AdminID != adminID {
+		return claim, ErrReviewClaimed
+	}
+	return claim, nil
+}
+
+func parseReviewClaim(result []interface{}) (ReviewClaim, error) {
+	if len(result) != 2 {
+		return ReviewClaim{}, errors.New("unexpected claim reply")
+	}
+	holder, _ := result[0].(string)
+	adminID, err := strconv.ParseUint(holder, 10, 32)
+	if err != nil {
+		return ReviewClaim{}, err
+	}
+	left, _ := result[1].(int64)
+	return ReviewClaim{AdminID: uint(adminID), ExpiresAt: time.Now().Add(time.Duration(left) * time.Millisecond)}, nil
+}
+
+// GetReviewClaim returns the claim on a submission, or nil when nobody holds one
+func GetReviewClaim(tempID uint) (*ReviewClaim, error) {
+	pipe := RedisClient.TxPipeline()
+	holder := pipe.Get(ctx, reviewClaimKey(tempID))
+	left := pipe.PTTL(ctx, reviewClaimKey(tempID))
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+	if holder.Err() == redis.Nil {
+		return nil, nil
+	}
+	adminID, err := strconv.ParseUint(holder.Val(), 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	return &ReviewClaim{AdminID: uint(adminID), ExpiresAt: time.Now().Add(left.Val())}, nil
+}
+
+// ReleaseReviewClaim gives up adminID's claim on a submission. It reports
+// false when adminID did not hold it.
+func ReleaseReviewClaim(tempID uint, adminID uint) (bool, error) {
+	admin := strconv.FormatUint(uint64(adminID), 10)
+	deleted, err := releaseReviewScript.Run(ctx, RedisClient, []string{reviewClaimKey(tempID)}, admin).Int64()
+	return deleted > 0, err
+}
+
+// DropReviewClaim removes the claim on a submission whoever holds it
+func DropReviewClaim(tempID uint) error {
+	return RedisClient.Del(ctx, reviewClaimKey(tempID)).Err()
+}
@@ -0,0 +1,159 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrSessionNotFound is returned when a session has expired or been revoked
+var ErrSessionNotFound = errors.New("session not found")
+
+// ErrRefreshTokenReused is returned when a refresh token that was already
+// rotated is presented again. The whole session is revoked when this happens.
+var ErrRefreshTokenReused = errors.New("refresh token reused")
+
+// Session is one logged-in device. All refresh tokens issued for a session
+// belong to the same rotation family.
+type Session struct {
+	ID         string    `json:"id"`
+	UserID     uint      `json:"user_id"`
+	Role       string    `json:"role"`
+	Username   string    `json:"username"`
+	Device     string    `json:"device"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+}
+
+func sessionKey(id string) string {
+	return "session:" + id
+}
+
+func userSessionsKey(role string, userID uint) string {
+	return fmt.Sprintf("user_sessions:%s:%d", role, userID)
+}
+
+func refreshKey(tokenHash string) string {
+	return "refresh:" + tokenHash
+}
+
+// SaveSession stores the session and indexes it under its user
+func SaveSession(s *Session, ttl time.Duration) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	pipe := RedisClient.TxPipeline()
+	pipe.Set(ctx, sessionKey(s.ID), data, ttl)
+	pipe.SAdd(ctx, userSessionsKey(s.Role, s.UserID), s.ID)
+	pipe.Expire(ctx, userSessionsKey(s.Role, s.UserID), ttl)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// GetSession loads a session by ID
+func GetSession(id string) (*Session, error) {
+	data, err := RedisClient.Get(ctx, sessionKey(id)).Bytes()
+	if err == redis.Nil {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var s Session
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// SessionExists reports whether the session is still active
+func SessionExists(id string) (bool, error) {
+	n, err := RedisClient.Exists(ctx, sessionKey(id)).Result()
+	return n > 0, err
+}
+
+// ListSessions returns the active sessions of a user and prunes expired IDs from the index
+func ListSessions(role string, userID uint) ([]Session, error) {
+	ids, err := RedisClient.SMembers(ctx, userSessionsKey(role, userID)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := []Session{}
+	for _, id := range ids {
+		s, err := GetSession(id)
+		if err == ErrSessionNotFound {
+			RedisClient.SRem(ctx, userSessionsKey(role, userID), id)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *s)
+	}
+	return sessions, nil
+}
+
+// RevokeSession deletes a session. Access and refresh tokens that reference it stop working.
+func RevokeSession(s *Session) error {
+	pipe := RedisClient.TxPipeline()
+	pipe.Del(ctx, sessionKey(s.ID))
+	pipe.SRem(ctx, userSessionsKey(s.Role, s.UserID), s.ID)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// RevokeAllSessions deletes every session of a user
+func RevokeAllSessions(role string, userID uint) error {
+	ids, err := RedisClient.SMembers(ctx, userSessionsKey(role, userID)).Result()
+	if err != nil {
+		return err
+	}
+	keys := []string{userSessionsKey(role, userID)}
+	for _, id := range ids {
+		keys = append(keys, sessionKey(id))
+	}
+	return RedisClient.Del(ctx, keys...).Err()
+}
+
+// StoreRefreshToken links a refresh token hash to its session
+func StoreRefreshToken(tokenHash string, sessionID string, ttl time.Duration) error {
+	return RedisClient.Set(ctx, refreshKey(tokenHash), sessionID, ttl).Err()
+}
+
+// consumeRefreshScript swaps a refresh token's session ID for "used:<session ID>"
+// and returns the previous value, so exactly one caller can consume a token
+var consumeRefreshScript = redis.NewScript(`
+local v = redis.call("GET", KEYS[1])
+if not v then
+	return false
+end
+if string.sub(v, 1, 5) ~= "used:" then
+	redis.call("SET", KEYS[1], "used:" .. v, "KEEPTTL")
+end
+return v
+`)
+
+// ConsumeRefreshToken marks a refresh token as used and returns its session ID.
+// A token that was already used returns ErrRefreshTokenReused together with
+// the session ID it belonged to.
+func ConsumeRefreshToken(tokenHash string) (string, error) {
+	old, err := consumeRefreshScript.Run(ctx, RedisClient, []string{refreshKey(tokenHash)}).Text()
+	if err == redis.Nil {
+		return "", ErrSessionNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if sessionID, used := strings.CutPrefix(old, "used:"); used {
+		return sessionID, ErrRefreshTokenReused
+	}
+	return old, nil
+}
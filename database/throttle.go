@@ -0,0 +1,162 @@
+package database
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Login failures are kept in sorted sets scored by the time of each failure,
+// so counting the members left after trimming gives a sliding window.
+
+// Failure scopes
+const (
+	ScopeUsername = "user"
+	ScopeIP       = "ip"
+)
+
+func loginFailuresKey(scope string, id string) string {
+	return "login_fail:" + scope + ":" + id
+}
+
+func loginLockKey(username string) string {
+	return "login_lock:" + username
+}
+
+// LoginFailures is the failure count in the window and the time of the latest failure
+type LoginFailures struct {
+	Count int64
+	Last  time.Time
+}
+
+// LoginAttempt is an attempt counted against scope/id before its password was
+// checked. Before holds the failures, and attempts still in flight, ahead of it.
+type LoginAttempt struct {
+	Scope  string
+	ID     string
+	Member string
+	Before LoginFailures
+}
+
+// ReserveLoginAttempt counts an attempt for scope/id as a failure up front and
+// returns what came before it in the window. The member is added before
+// anything is read, so parallel attempts each see the ones ahead of them. A
+// successful or refused attempt takes itself back with ReleaseLoginAttempt.
+func ReserveLoginAttempt(scope string, id string, window time.Duration) (LoginAttempt, error) {
+	// The random suffix keeps attempts made in the same nanosecond apart
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return LoginAttempt{}, err
+	}
+	now := time.Now()
+	key := loginFailuresKey(scope, id)
+	attempt := LoginAttempt{Scope: scope, ID: id, Member: strconv.FormatInt(now.UnixNano(), 10) + ":" + hex.EncodeToString(suffix)}
+	cutoff := now.Add(-window).UnixNano()
+
+	pipe := RedisClient.TxPipeline()
+	pipe.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(cutoff, 10))
+	pipe.ZAdd(ctx, key, redis.Z{Score: float64(now.UnixNano()), Member: attempt.Member})
+	pipe.Expire(ctx, key, window)
+	rank := pipe.ZRank(ctx, key, attempt.Member)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return attempt, err
+	}
+
+	attempt.Before.Count = rank.Val()
+	if attempt.Before.Count > 0 {
+		previous, err := RedisClient.ZRangeWithScores(ctx, key, attempt.Before.Count-1, attempt.Before.Count-1).Result()
+		if err != nil {
+			return attempt, err
+		}
+		if len(previous) > 0 {
+			attempt.Before.Last = time.Unix(0, int64(previous[0].Score))
+		}
+	}
+	return attempt, nil
+}
+
+// ReleaseLoginAttempt takes back a reserved attempt so it no longer counts as a failure
+func ReleaseLoginAttempt(attempt LoginAttempt) error {
+	return RedisClient.ZRem(ctx, loginFailuresKey(attempt.Scope, attempt.ID), attempt.Member).Err()
+}
+
+// GetLoginFailures trims failures older than window and returns what is left
+func GetLoginFailures(scope string, id string, window time.Duration) (LoginFailures, error) {
+	key := loginFailuresKey(scope, id)
+	cutoff := time.Now().Add(-window).UnixNano()
+	pipe := RedisClient.TxPipeline()
+	pipe.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(cutoff, 10))
+	count := pipe.ZCard(ctx, key)
+	last := pipe.ZRevRangeWithScores(ctx, key, 0, 0)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return LoginFailures{}, err
+	}
+
+	failures := LoginFailures{Count: count.Val()}
+	if latest := last.Val(); len(latest) > 0 {
+		failures.Last = time.Unix(0, int64(latest[0].Score))
+	}
+	return failures, nil
+}
+
+// ClearLoginFailures forgets the failures of scope/id
+func ClearLoginFailures(scope string, id string) error {
+	return RedisClient.Del(ctx, loginFailuresKey(scope, id)).Err()
+}
+
+// LockLogin blocks logins for username for ttl
+func LockLogin(username string, ttl time.Duration) error {
+	return RedisClient.Set(ctx, loginLockKey(username), time.Now().Unix(), ttl).Err()
+}
+
+// LoginLockTTL returns how long username stays locked, or zero when it is not locked
+func LoginLockTTL(username string) (time.Duration, error) {
+	ttl, err := RedisClient.TTL(ctx, loginLockKey(username)).Result()
+	if err != nil || ttl < 0 {
+		return 0, err
+	}
+	return ttl, nil
+}
+
+// LoginLock is a locked username
+type LoginLock struct {
+	Username  string    `json:"username"`
+	LockedAt  time.Time `json:"locked_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// ListLoginLocks returns every username that is currently locked
+func ListLoginLocks() ([]LoginLock, error) {
+	locks := []LoginLock{}
+	iter := RedisClient.Scan(ctx, 0, loginLockKey("*"), 100).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		lockedAt, err := RedisClient.Get(ctx, key).Int64()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		ttl, err := RedisClient.TTL(ctx, key).Result()
+		if err != nil {
+			return nil, err
+		}
+		locks = append(locks, LoginLock{
+			Username:  strings.TrimPrefix(key, loginLockKey("")),
+			LockedAt:  time.Unix(lockedAt, 0),
+			ExpiresAt: time.Now().Add(ttl),
+		})
+	}
+	return locks, iter.Err()
+}
+
+// UnlockLogin removes the lock on username and forgets its failures
+func UnlockLogin(username string) (bool, error) {
+	n, err := RedisClient.Del(ctx, loginLockKey(username), loginFailuresKey(ScopeUsername, username)).Result()
+	return n > 0, err
+}
@@ -22,9 +22,7 @@ require (
 	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
 	github.com/go-sql-driver/mysql v1.8.1 // indirect
 	github.com/mattn/go-sqlite3 v1.14.22 // indirect
-
-	github.com/redis/go-redis/v9 v9.7.1 // indirect
-	golang.org/x/crypto v0.35.0 // indirect
+	github.com/redis/go-redis/v9 v9.7.1
 	gorm.io/driver/sqlite v1.5.7 // indirect
 )
 
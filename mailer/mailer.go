@@ -0,0 +1,152 @@
+// Package mailer sends email through an outbox table.
+//
+// Handlers call Enqueue, usually inside the transaction that produced the
+// email, and a background dispatcher delivers pending rows over SMTP. A failed
+// send is retried with backoff, so a slow or unavailable mail server never
+// blocks a request.
+package mailer
+
+import (
+	"fmt"
+	"log"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/HealthMe-pls/medic-go-api/model"
+	"gorm.io/gorm"
+)
+
+const (
+	// maxAttempts is how many times a message is tried before it is marked failed
+	maxAttempts = 5
+	// batchSize is how many pending messages are sent per poll
+	batchSize = 20
+)
+
+// Config is the SMTP server used to deliver mail
+type Config struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+// ConfigFromEnv reads SMTP_HOST, SMTP_PORT, SMTP_USERNAME, SMTP_PASSWORD and SMTP_FROM.
+// Without credentials mail is sent unauthenticated, which suits a local catch-all server.
+func ConfigFromEnv() Config {
+	cfg := Config{
+		Host:     os.Getenv("SMTP_HOST"),
+		Port:     os.Getenv("SMTP_PORT"),
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     os.Getenv("SMTP_FROM"),
+	}
+	if cfg.Host == "" {
+		cfg.Host = "localhost"
+	}
+	if cfg.Port == "" {
+		cfg.Port = "1025"
+	}
+	if cfg.From == "" {
+		cfg.From = "no-reply@localhost"
+	}
+	return cfg
+}
+
+// Enqueue stores an email for the dispatcher. Pass the transaction that creates
+// the data the email refers to so both are committed together.
+func Enqueue(db *gorm.DB, to string, subject string, body string) error {
+	return enqueue(db, to, subject, body, false)
+}
+
+// EnqueueSensitive stores an email whose body holds a secret, such as a
+// password reset link. The body is kept only until the email is sent or
+// marked failed.
+func EnqueueSensitive(db *gorm.DB, to string, subject string, body string) error {
+	return enqueue(db, to, subject, body, true)
+}
+
+func enqueue(db *gorm.DB, to string, subject string, body string, sensitive bool) error {
+	return db.Create(&model.EmailOutbox{
+		To:            to,
+		Subject:       subject,
+		Body:          body,
+		Sensitive:     sensitive,
+		Status:        model.EmailPending,
+		NextAttemptAt: time.Now(),
+	}).Error
+}
+
+// Send delivers one message over SMTP
+func (cfg Config) Send(to string, subject string, body string) error {
+	if strings.ContainsAny(to+subject, "\r\n") {
+		return fmt.Errorf("header values must not contain line breaks")
+	}
+	var auth smtp.Auth
+	if cfg.Username != "" {
+		auth = smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)
+	}
+	msg := strings.Join([]string{
+		"From: " + cfg.From,
+		"To: " + to,
+		"Subject: " + subject,
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		body,
+	}, "\r\n")
+	return smtp.SendMail(cfg.Host+":"+cfg.Port, auth, cfg.From, []string{to}, []byte(msg))
+}
+
+// StartDispatcher sends pending outbox rows every interval until the process exits
+func StartDispatcher(db *gorm.DB, cfg Config, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := Dispatch(db, cfg); err != nil {
+				log.Println("Email dispatch failed:", err)
+			}
+		}
+	}()
+}
+
+// Dispatch sends the messages that are due and records the outcome of each
+func Dispatch(db *gorm.DB, cfg Config) error {
+	var pending []model.EmailOutbox
+	if err := db.Where("status = ? AND next_attempt_at <= ?", model.EmailPending, time.Now()).
+		Order("id").Limit(batchSize).Find(&pending).Error; err != nil {
+		return err
+	}
+
+	for _, email := range pending {
+		updates := map[string]interface{}{"attempts": email.Attempts + 1}
+		if err := cfg.Send(email.To, email.Subject, email.Body); err != nil {
+			updates["last_error"] = err.Error()
+			if email.Attempts+1 >= maxAttempts {
+				updates["status"] = model.EmailFailed
+			} else {
+				updates["next_attempt_at"] = time.Now().Add(backoff(email.Attempts + 1))
+			}
+			log.Printf("Failed to send email %d to %s: %v", email.ID, email.To, err)
+		} else {
+			updates["status"] = model.EmailSent
+			updates["sent_at"] = time.Now()
+		}
+		if _, done := updates["status"]; done && email.Sensitive {
+			updates["body"] = ""
+		}
+		if err := db.Model(&model.EmailOutbox{}).Where("id = ?", email.ID).Updates(updates).Error; err != nil {
+			return fmt.Errorf("failed to update email %d: %w", email.ID, err)
+		}
+	}
+	return nil
+}
+
+// backoff doubles the wait after each failed attempt, starting at 30 seconds
+func backoff(attempts int) time.Duration {
+	return 30 * time.Second << (attempts - 1)
+}
@@ -9,7 +9,10 @@ import (
 	"github.com/HealthMe-pls/medic-go-api/middleware"
 	"github.com/HealthMe-pls/medic-go-api/database"
 	"github.com/HealthMe-pls/medic-go-api/controller"
+	"github.com/HealthMe-pls/medic-go-api/mailer"
+	"github.com/HealthMe-pls/medic-go-api/notify"
 	"github.com/HealthMe-pls/medic-go-api/model"
+	"github.com/HealthMe-pls/medic-go-api/token"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/joho/godotenv"
@@ -32,6 +35,7 @@ func main() {
 		&model.Admin{},
 		&model.ContactToAdmin{},
 		&model.ShopCategory{},
+		&model.MarketSchedule{},
 		&model.MarketOpenDate{},
 		&model.Entrepreneur{},
 		&model.Shop{},
@@ -39,6 +43,7 @@ func main() {
 		&model.ShopOpenDate{},
 		&model.TempShopOpenDate{},
 		&model.MarketMap{},
+		&model.StallReservation{},
 		&model.SocialMedia{},
 		&model.ShopMenu{},
 		&model.Workshop{},
@@ -47,15 +52,58 @@ func main() {
 		&model.TempSocial{},
 		&model.DeletePhoto{},
 		&model.DeleteSocial{},
-		&model.DeleteMenu{}); err != nil {
+		&model.DeleteMenu{},
+		&model.AccessViolation{},
+		&model.PasswordResetToken{},
+		&model.EmailOutbox{},
+		&model.AuthEvent{},
+		&model.AuditLog{},
+		&model.AdminRecoveryCode{},
+		&model.ReviewRound{},
+		&model.ReviewComment{},
+		&model.ShopVersion{},
+		&model.ChangeSet{},
+		&model.ChangeOp{},
+		&model.DataMigration{},
+		&model.TempShopTransition{},
+		&model.AutoApprovalRule{},
+		&model.AutoApprovalDecision{},
+		&model.NotificationPreference{},
+		&model.Notification{}); err != nil {
 		log.Fatalf("Failed to migrate: %v", err)
 	}
 
+	// map the old Waiting/Approve/NotApprove statuses onto the review states
+	if err := controller.MigrateLegacyStatuses(db); err != nil {
+		log.Fatalf("Failed to migrate TempShop statuses: %v", err)
+	}
+
+	// move pending edits still held in the Temp* and Delete* tables into change sets, once
+	if err := controller.MigrateLegacyChanges(db); err != nil {
+		log.Fatalf("Failed to migrate pending changes: %v", err)
+	}
+
 	// use godotenv to get .env variables
 	if err := godotenv.Load(); err != nil { // gogotenv init
 		log.Fatal("load .env error")
 	}
 
+	// load JWT signing and verification keys
+	if err := token.Init(); err != nil {
+		log.Fatalf("Failed to load JWT keys: %v", err)
+	}
+
+	// deliver queued emails such as password reset links
+	mailer.StartDispatcher(db, mailer.ConfigFromEnv(), 10*time.Second)
+
+	// tell entrepreneurs about their submissions by email, LINE or webhook
+	notify.StartDispatcher(db, notify.ChannelsFromEnv(), 10*time.Second)
+
+	// create the first admin from ADMIN_EMAIL / ADMIN_PASSWORD if none exists
+	if err := controller.BootstrapAdmin(db); err != nil {
+		log.Fatalf("Failed to bootstrap admin: %v", err)
+	}
+
 	createUploadsDirectory()
 
 	// ตัวแทนการสื่อสารกับ http server
@@ -72,250 +120,301 @@ func main() {
 	app.Use(cors.New(cors.Config{
 		AllowOrigins:     "http://localhost:3000, http://127.0.0.1:3000, http://172.18.0.4:3000, http://localhost, http://127.0.0.1, http://frontend ",
 		AllowMethods:     "GET,POST,HEAD,PUT,DELETE,PATCH,OPTIONS",
-		AllowHeaders:     "Origin, Content-Type, Accept",
+		AllowHeaders:     "Origin, Content-Type, Accept, Authorization",
 		AllowCredentials: true, // If you are using cookies or credentials
 	}))
 
 	app.Options("*", func(c *fiber.Ctx) error {
 		c.Set("Access-Control-Allow-Origin", "*")
 		c.Set("Access-Control-Allow-Methods", "GET,POST,HEAD,PUT,DELETE,PATCH,OPTIONS")
-		c.Set("Access-Control-Allow-Headers", "Origin, Content-Type, Accept")
+		c.Set("Access-Control-Allow-Headers", "Origin, Content-Type, Accept, Authorization")
 		return c.SendStatus(fiber.StatusNoContent)
 	})
 
+	// Record every successful admin mutation in the audit log
+	app.Use(controller.AuditAdminMutations(db))
+
+	// Route policies: every route below is declared public, entrepreneur, self or admin
+	public := middleware.Public
+	entrepreneur := middleware.Entrepreneur
+	self := middleware.Self
+	admin := middleware.Admin
+	// Entrepreneurs may only edit their own shops; ownsBody checks the IDs in the request body
+	ownsBody := controller.OwnsShop(db, controller.BodyRefs)
+
 	// c = response and request fiber context
-	app.Get("/hello", func(c *fiber.Ctx) error { return c.SendString("test gogo") })
+	app.Get("/hello", public, func(c *fiber.Ctx) error { return c.SendString("test gogo") })
 
 	// test route
-	app.Get("/patient", func(c *fiber.Ctx) error { return controller.GetPatients(db, c) })
-	app.Get("/patient/:id", func(c *fiber.Ctx) error { return controller.GetPatientID(db, c) })
-	app.Post("/patient", func(c *fiber.Ctx) error { return controller.CreatePatient(db, c) })
-	app.Put("/patient/:id", func(c *fiber.Ctx) error { return controller.UpdatePatient(db, c) })
-	app.Delete("/patient/:id", func(c *fiber.Ctx) error { return controller.DeletePatient(db, c) })
-	app.Post("/patient/:id/images", func(c *fiber.Ctx) error { return controller.UploadImage(db, c) })
-	app.Get("/patient/:id/images", func(c *fiber.Ctx) error { return controller.GetPatientImages(db, c) })
+	app.Get("/patient", public, func(c *fiber.Ctx) error { return controller.GetPatients(db, c) })
+	app.Get("/patient/:id", public, func(c *fiber.Ctx) error { return controller.GetPatientID(db, c) })
+	app.Post("/patient", admin, func(c *fiber.Ctx) error { return controller.CreatePatient(db, c) })
+	app.Put("/patient/:id", admin, func(c *fiber.Ctx) error { return controller.UpdatePatient(db, c) })
+	app.Delete("/patient/:id", admin, func(c *fiber.Ctx) error { return controller.DeletePatient(db, c) })
+	app.Post("/patient/:id/images", admin, func(c *fiber.Ctx) error { return controller.UploadImage(db, c) })
+	app.Get("/patient/:id/images", public, func(c *fiber.Ctx) error { return controller.GetPatientImages(db, c) })
 
 
 	
+	//admin auth
+	app.Post("/admin/login", public, func(c *fiber.Ctx) error { return controller.AdminLogin(db, c) })
+	app.Post("/admin/logout", admin, controller.Logout)
+	app.Put("/admin/password", admin, func(c *fiber.Ctx) error { return controller.ChangeAdminPassword(db, c) })
+	app.Post("/admin/login/mfa", public, func(c *fiber.Ctx) error { return controller.AdminLoginMFA(db, c) })
+	app.Post("/admin/login/enroll", public, func(c *fiber.Ctx) error { return controller.AdminLoginEnroll(db, c) })
+	app.Post("/admin/login/enroll/confirm", public, func(c *fiber.Ctx) error { return controller.AdminLoginEnrollConfirm(db, c) })
+	app.Post("/admin/2fa/setup", admin, func(c *fiber.Ctx) error { return controller.SetupAdminTOTP(db, c) })
+	app.Post("/admin/2fa/enable", admin, func(c *fiber.Ctx) error { return controller.EnableAdminTOTP(db, c) })
+	app.Post("/admin/2fa/disable", admin, func(c *fiber.Ctx) error { return controller.DisableAdminTOTP(db, c) })
+	app.Post("/admin/2fa/recovery-codes", admin, func(c *fiber.Ctx) error { return controller.RegenerateRecoveryCodes(db, c) })
+
+	app.Get("/admin/audit", admin, func(c *fiber.Ctx) error { return controller.GetAuditLogs(db, c) })
+	app.Get("/admin/accessviolations", admin, func(c *fiber.Ctx) error { return controller.GetAccessViolations(db, c) })
+	app.Get("/admin/loginlocks", admin, controller.GetLoginLocks)
+	app.Delete("/admin/loginlocks/:username", admin, controller.ClearLoginLock)
+	app.Get("/admin/authevents", admin, func(c *fiber.Ctx) error { return controller.GetAuthEvents(db, c) })
+	app.Get("/admin/authevents/suspicious", admin, func(c *fiber.Ctx) error { return controller.GetSuspiciousIPs(db, c) })
+
 	//admin --check
-	app.Get("/admin", func(c *fiber.Ctx) error { return controller.GetAdmins(db, c) })
-	app.Get("/admin/:id", func(c *fiber.Ctx) error { return controller.GetAdminByUsername(db, c) })
-	app.Post("/admin", func(c *fiber.Ctx) error { return controller.CreateAdmin(db, c) })
-	app.Put("/admin/:id", func(c *fiber.Ctx) error { return controller.UpdateAdmin(db, c) })
-	app.Delete("/admin/:id", func(c *fiber.Ctx) error { return controller.DeleteAdmin(db, c) })
+	app.Get("/admin", admin, func(c *fiber.Ctx) error { return controller.GetAdmins(db, c) })
+	app.Get("/admin/:id", admin, func(c *fiber.Ctx) error { return controller.GetAdminByUsername(db, c) })
+	app.Post("/admin", admin, func(c *fiber.Ctx) error { return controller.CreateAdmin(db, c) })
+	app.Put("/admin/:id", admin, func(c *fiber.Ctx) error { return controller.UpdateAdmin(db, c) })
+	app.Delete("/admin/:id", admin, func(c *fiber.Ctx) error { return controller.DeleteAdmin(db, c) })
 
 	//entrepreneur
 	// app.Get("/entrepreneur", func(c *fiber.Ctx) error { return controller.GetEntrepreneur(db, c) })
-	app.Get("/entrepreneur", func(c *fiber.Ctx) error { return controller.GetAllEntrepreneur(db, c) })
-	app.Get("/entrepreneurGetbyId", func(c *fiber.Ctx) error { return controller.GetEntrepreneurByIDLogin(db, c) })
+	app.Get("/entrepreneur", admin, func(c *fiber.Ctx) error { return controller.GetAllEntrepreneur(db, c) })
+	app.Get("/entrepreneurGetbyId", self, func(c *fiber.Ctx) error { return controller.GetEntrepreneurByIDLogin(db, c) })
+	app.Get("/notifications", self, func(c *fiber.Ctx) error { return controller.GetMyNotifications(db, c) })
+	app.Get("/notifications/preferences", self, func(c *fiber.Ctx) error { return controller.GetNotificationPreferences(db, c) })
+	app.Put("/notifications/preferences", self, func(c *fiber.Ctx) error { return controller.UpdateNotificationPreferences(db, c) })
 
-	app.Get("/entrepreneur/:id", func(c *fiber.Ctx) error { return controller.GetEntrepreneurByID(db, c) })
-	app.Post("/entrepreneur", func(c *fiber.Ctx) error { return controller.Register(db, c) })
-	app.Put("/entrepreneur/:id", func(c *fiber.Ctx) error { return controller.UpdateEntrepreneur(db, c) })
-	app.Delete("/entrepreneur/:id", func(c *fiber.Ctx) error { return controller.DeleteEntrepreneurByID(db, c) })
+	app.Get("/entrepreneur/:id", admin, func(c *fiber.Ctx) error { return controller.GetEntrepreneurByID(db, c) })
+	app.Post("/entrepreneur", public, func(c *fiber.Ctx) error { return controller.Register(db, c) })
+	app.Put("/entrepreneur/:id", admin, func(c *fiber.Ctx) error { return controller.UpdateEntrepreneur(db, c) })
+	app.Delete("/entrepreneur/:id", admin, func(c *fiber.Ctx) error { return controller.DeleteEntrepreneurByID(db, c) })
 
 	//map --check
-	app.Get("/map", func(c *fiber.Ctx) error { return controller.GetMarketMap(db, c) })
-	app.Get("/mapdetail", func(c *fiber.Ctx) error { return controller.GetMarketMapDetail(db, c) })
-	app.Get("/map/:id", func(c *fiber.Ctx) error { return controller.GetMapByBlockID(db, c) })
-	app.Get("/shopInmap/:id", func(c *fiber.Ctx) error { return controller.GetShopInMapID(db, c) })
-	app.Post("/map", func(c *fiber.Ctx) error { return controller.CreateMarketMap(db, c) })
-	app.Delete("/map/:block_id", func(c *fiber.Ctx) error { return controller.DeleteMarketMapsByBlockID(db, c) })
-	app.Put("/map/:block_id", func(c *fiber.Ctx) error { return controller.UpdateMarketMapByBlockID(db, c) })
-	app.Put("/Allmap", func(c *fiber.Ctx) error { return controller.UpdateAllMarketMaps(db, c) })
+	app.Get("/map", public, func(c *fiber.Ctx) error { return controller.GetMarketMap(db, c) })
+	app.Get("/mapdetail", public, func(c *fiber.Ctx) error { return controller.GetMarketMapDetail(db, c) }) // ?date=YYYY-MM-DD for that day's reservations
+	app.Get("/map/:id", public, func(c *fiber.Ctx) error { return controller.GetMapByBlockID(db, c) })
+	app.Get("/shopInmap/:id", public, func(c *fiber.Ctx) error { return controller.GetShopInMapID(db, c) })
+	app.Post("/map", admin, func(c *fiber.Ctx) error { return controller.CreateMarketMap(db, c) })
+	app.Delete("/map/:block_id", admin, func(c *fiber.Ctx) error { return controller.DeleteMarketMapsByBlockID(db, c) })
+	app.Put("/map/:block_id", admin, func(c *fiber.Ctx) error { return controller.UpdateMarketMapByBlockID(db, c) })
+	app.Put("/Allmap", admin, func(c *fiber.Ctx) error { return controller.UpdateAllMarketMaps(db, c) })
 
 	//name
-	app.Get("/mapN/:block_name", func(c *fiber.Ctx) error { return controller.GetMapByBlockName(db, c) })
-	app.Delete("/mapN/:block_name", func(c *fiber.Ctx) error { return controller.DeleteMarketMapsByBlockName(db, c) })
-	app.Put("/mapN/:block_name", func(c *fiber.Ctx) error { return controller.UpdateMarketMapByBlockName(db, c) })
+	app.Get("/mapN/:block_name", public, func(c *fiber.Ctx) error { return controller.GetMapByBlockName(db, c) })
+	app.Delete("/mapN/:block_name", admin, func(c *fiber.Ctx) error { return controller.DeleteMarketMapsByBlockName(db, c) })
+	app.Put("/mapN/:block_name", admin, func(c *fiber.Ctx) error { return controller.UpdateMarketMapByBlockName(db, c) })
+	app.Get("/reservations", admin, func(c *fiber.Ctx) error { return controller.GetStallReservations(db, c) })
+	app.Post("/reservations", admin, func(c *fiber.Ctx) error { return controller.CreateStallReservation(db, c) })
+	app.Put("/reservations/:id/confirm", admin, func(c *fiber.Ctx) error { return controller.ConfirmStallReservation(db, c) })
+	app.Put("/reservations/:id/reject", admin, func(c *fiber.Ctx) error { return controller.RejectStallReservation(db, c) })
 
 	//shop category
-	app.Post("/shopcategory", func(c *fiber.Ctx) error { return controller.CreateShopCategory(db, c) })
-	app.Get("/shopcategory", func(c *fiber.Ctx) error { return controller.GetShopCategories(db, c) })
-	app.Get("/shopcategory/:id", func(c *fiber.Ctx) error { return controller.GetShopCategoryByID(db, c) })
-	app.Delete("/shopcategory/:id", func(c *fiber.Ctx) error { return controller.DeleteShopCategory(db, c) })
-	app.Put("/shopcategory/:id", func(c *fiber.Ctx) error { return controller.UpdateShopCategory(db, c) })
+	app.Post("/shopcategory", admin, func(c *fiber.Ctx) error { return controller.CreateShopCategory(db, c) })
+	app.Get("/shopcategory", public, func(c *fiber.Ctx) error { return controller.GetShopCategories(db, c) })
+	app.Get("/shopcategory/:id", public, func(c *fiber.Ctx) error { return controller.GetShopCategoryByID(db, c) })
+	app.Delete("/shopcategory/:id", admin, func(c *fiber.Ctx) error { return controller.DeleteShopCategory(db, c) })
+	app.Put("/shopcategory/:id", admin, func(c *fiber.Ctx) error { return controller.UpdateShopCategory(db, c) })
 
 	//shop
-	app.Post("/shop", func(c *fiber.Ctx) error { return controller.CreateShop(db, c) })
-	app.Get("/shop/:id", func(c *fiber.Ctx) error { return controller.GetShopByID(db, c) })
-	app.Get("/shopdetail", func(c *fiber.Ctx) error { return controller.GetShopDetail(db, c) })
-	app.Get("/shopdetail/:id", func(c *fiber.Ctx) error { return controller.GetShopDetailByID(db, c) })
-	app.Get("/entrepreneur/shopdetail/:entrepreneur_id", func(c *fiber.Ctx) error { return controller.GetShopDetailsByEntrepreneurID(db, c) })
+	app.Post("/shop", admin, func(c *fiber.Ctx) error { return controller.CreateShop(db, c) })
+	app.Get("/shop/:id", public, func(c *fiber.Ctx) error { return controller.GetShopByID(db, c) })
+	app.Get("/shopdetail", public, func(c *fiber.Ctx) error { return controller.GetShopDetail(db, c) })
+	app.Get("/shopdetail/:id", public, func(c *fiber.Ctx) error { return controller.GetShopDetailByID(db, c) })
+	app.Get("/entrepreneur/shopdetail/:entrepreneur_id", entrepreneur, controller.IsSelf("entrepreneur_id"), func(c *fiber.Ctx) error { return controller.GetShopDetailsByEntrepreneurID(db, c) })
 	//how to use shopid?shopidkeyword=Cotton Farm
-	app.Get("/shopid", func(c *fiber.Ctx) error {return controller.SearchShopsidByshopname(db, c)})
-
-	app.Get("/shop", func(c *fiber.Ctx) error { return controller.GetShops(db, c) })
-	app.Put("/admin/shop/:id", func(c *fiber.Ctx) error { return controller.UpdateShopByAdmin(db, c) })
+	app.Get("/shopid", public, func(c *fiber.Ctx) error {return controller.SearchShopsidByshopname(db, c)})
+
+	app.Get("/shop", public, func(c *fiber.Ctx) error { return controller.GetShops(db, c) })
+	app.Put("/admin/shop/:id", admin, func(c *fiber.Ctx) error { return controller.UpdateShopByAdmin(db, c) })
+	app.Get("/admin/shop/:shop_id/versions", admin, func(c *fiber.Ctx) error { return controller.GetShopVersions(db, c) })
+	app.Get("/admin/shop/:shop_id/versions/diff", admin, func(c *fiber.Ctx) error { return controller.DiffShopVersions(db, c) })
+	app.Get("/admin/shop/:shop_id/versions/:version", admin, func(c *fiber.Ctx) error { return controller.GetShopVersion(db, c) })
+	app.Post("/admin/shop/:shop_id/versions/:version/rollback", admin, func(c *fiber.Ctx) error { return controller.RollbackShopVersion(db, c) })
 	
-	app.Delete("/shop/:id", func(c *fiber.Ctx) error { return controller.DeleteShop(db, c) })
-	app.Get("/shops/category/:shop_category_id", func(c *fiber.Ctx) error { return controller.GetShopsByCategory(db, c) })
+	app.Delete("/shop/:id", admin, func(c *fiber.Ctx) error { return controller.DeleteShop(db, c) })
+	app.Get("/shops/category/:shop_category_id", public, func(c *fiber.Ctx) error { return controller.GetShopsByCategory(db, c) })
 
 	// Workshop Routes
-	app.Get("/workshops", func(c *fiber.Ctx) error { return controller.GetWorkshops(db, c) })
-	app.Get("/workshops/:id", func(c *fiber.Ctx) error { return controller.GetWorkshopByID(db, c) })
-	app.Post("/workshops", func(c *fiber.Ctx) error { return controller.CreateWorkshop(db, c) })
-	app.Put("/workshops/:id", func(c *fiber.Ctx) error { return controller.UpdateWorkshop(db, c) })
-	app.Delete("/workshops/:id", func(c *fiber.Ctx) error { return controller.DeleteWorkshop(db, c) })
+	app.Get("/workshops", public, func(c *fiber.Ctx) error { return controller.GetWorkshops(db, c) })
+	app.Get("/workshops/:id", public, func(c *fiber.Ctx) error { return controller.GetWorkshopByID(db, c) })
+	app.Post("/workshops", admin, func(c *fiber.Ctx) error { return controller.CreateWorkshop(db, c) })
+	app.Put("/workshops/:id", admin, func(c *fiber.Ctx) error { return controller.UpdateWorkshop(db, c) })
+	app.Delete("/workshops/:id", admin, func(c *fiber.Ctx) error { return controller.DeleteWorkshop(db, c) })
 
 	//manage market
-	app.Post("/marketDate", func(c *fiber.Ctx) error { return controller.CreateMarketOpenDate(db, c) })
-	app.Get("/marketDate", func(c *fiber.Ctx) error {return controller.GetAllMarketDates(db, c)})
-	app.Get("/marketDate/:id", func(c *fiber.Ctx) error { return controller.GetMarketOpenDate(db, c) })
-	app.Put("/marketDate/:id", func(c *fiber.Ctx) error { return controller.UpdateMarketOpenDate(db, c) })
-	app.Delete("/marketDate/:id", func(c *fiber.Ctx) error { return controller.DeleteMarketOpenDate(db, c) })
+	app.Post("/marketDate", admin, func(c *fiber.Ctx) error { return controller.CreateMarketOpenDate(db, c) })
+	app.Get("/marketDate", public, func(c *fiber.Ctx) error {return controller.GetAllMarketDates(db, c)})
+	app.Get("/marketDate/:id", public, func(c *fiber.Ctx) error { return controller.GetMarketOpenDate(db, c) })
+	app.Put("/marketDate/:id", admin, func(c *fiber.Ctx) error { return controller.UpdateMarketOpenDate(db, c) })
+	app.Delete("/marketDate/:id", admin, func(c *fiber.Ctx) error { return controller.DeleteMarketOpenDate(db, c) })
+	app.Post("/marketSchedule", admin, func(c *fiber.Ctx) error { return controller.CreateMarketSchedule(db, c) })
+	app.Get("/marketSchedule", public, func(c *fiber.Ctx) error { return controller.GetMarketSchedules(db, c) })
+	app.Get("/marketSchedule/:id", public, func(c *fiber.Ctx) error { return controller.GetMarketSchedule(db, c) })
+	app.Put("/marketSchedule/:id", admin, func(c *fiber.Ctx) error { return controller.UpdateMarketSchedule(db, c) })
+	app.Delete("/marketSchedule/:id", admin, func(c *fiber.Ctx) error { return controller.DeleteMarketSchedule(db, c) })
 
 	//social media
-	app.Post("/social", func(c *fiber.Ctx) error { return controller.CreateSocialMediaByAdmin(db, c) })
-	app.Get("/social/:id", func(c *fiber.Ctx) error { return controller.GetSocialMedia(db, c) })
-	app.Get("/social/shop/:shop_id", func(c *fiber.Ctx) error { return controller.GetSocialMediaByShopID(db, c) })
+	app.Post("/social", admin, func(c *fiber.Ctx) error { return controller.CreateSocialMediaByAdmin(db, c) })
+	app.Get("/social/:id", public, func(c *fiber.Ctx) error { return controller.GetSocialMedia(db, c) })
+	app.Get("/social/shop/:shop_id", public, func(c *fiber.Ctx) error { return controller.GetSocialMediaByShopID(db, c) })
 
 
 	//shop open time
 
-	app.Get("/shoptime", func(c *fiber.Ctx) error {return controller.GetAllShopTimes(db, c)})
-	app.Get("/shoptime/:id", func(c *fiber.Ctx) error { return controller.GetShopOpenDate(db, c) })
-	app.Get("/shoptime/shop/:shop_id", func(c *fiber.Ctx) error { return controller.GetShopOpenDateByShopID(db, c) })
+	app.Get("/shoptime", public, func(c *fiber.Ctx) error {return controller.GetAllShopTimes(db, c)})
+	app.Get("/shoptime/:id", public, func(c *fiber.Ctx) error { return controller.GetShopOpenDate(db, c) })
+	app.Get("/shoptime/shop/:shop_id", public, func(c *fiber.Ctx) error { return controller.GetShopOpenDateByShopID(db, c) })
 
 
 	//shop menu
-	app.Post("/shopmenu", func(c *fiber.Ctx) error { return controller.CreateShopMenuByAdmin(db, c) })
-	app.Get("/shopmenu/:id", func(c *fiber.Ctx) error { return controller.GetShopMenu(db, c) })
-	app.Get("/shopmenu/shop/:shop_id", func(c *fiber.Ctx) error { return controller.GetShopMenuByShopID(db, c) })
+	app.Post("/shopmenu", admin, func(c *fiber.Ctx) error { return controller.CreateShopMenuByAdmin(db, c) })
+	app.Get("/shopmenu/:id", public, func(c *fiber.Ctx) error { return controller.GetShopMenu(db, c) })
+	app.Get("/shopmenu/shop/:shop_id", public, func(c *fiber.Ctx) error { return controller.GetShopMenuByShopID(db, c) })
 
 	//photo
-	app.Post("/photos", func(c *fiber.Ctx) error { return controller.CreatePhoto(db, c) })
-	app.Get("/photos/:id", func(c *fiber.Ctx) error { return controller.GetPhoto(db, c) })
-	app.Get("/photos/menu/:menu_id", func(c *fiber.Ctx) error { return controller.GetPhotoByMenuID(db, c) })
-	app.Get("/photos/shop/:shop_id", func(c *fiber.Ctx) error { return controller.GetPhotoByShopID(db, c) })
-	app.Put("/photos/:id", func(c *fiber.Ctx) error { return controller.UpdatePhoto(db, c) })
-	app.Delete("/photos/:id", func(c *fiber.Ctx) error { return controller.DeletePhoto(db, c) })
+	app.Post("/photos", admin, func(c *fiber.Ctx) error { return controller.CreatePhoto(db, c) })
+	app.Get("/photos/:id", public, func(c *fiber.Ctx) error { return controller.GetPhoto(db, c) })
+	app.Get("/photos/menu/:menu_id", public, func(c *fiber.Ctx) error { return controller.GetPhotoByMenuID(db, c) })
+	app.Get("/photos/shop/:shop_id", public, func(c *fiber.Ctx) error { return controller.GetPhotoByShopID(db, c) })
+	app.Put("/photos/:id", admin, func(c *fiber.Ctx) error { return controller.UpdatePhoto(db, c) })
+	app.Delete("/photos/:id", admin, func(c *fiber.Ctx) error { return controller.DeletePhoto(db, c) })
 
 	//contact to admin
-	app.Post("/contacts", func(c *fiber.Ctx) error { return controller.CreateContactToAdmin(db, c) })
-	app.Get("/contacts", func(c *fiber.Ctx) error { return controller.GetAllContacts(db, c) })
+	app.Post("/contacts", entrepreneur, func(c *fiber.Ctx) error { return controller.CreateContactToAdmin(db, c) })
+	app.Get("/contacts", admin, func(c *fiber.Ctx) error { return controller.GetAllContacts(db, c) })
 	// app.Post("/contacts", func(c *fiber.Ctx) error { return controller.CreateContactToAdmin(db, c) })
-	app.Get("/contacts/:id", func(c *fiber.Ctx) error { return controller.GetContactToAdmin(db, c) })
-	app.Put("/contacts/:id", func(c *fiber.Ctx) error { return controller.UpdateContactToAdmin(db, c) })
-	app.Delete("/contacts/:id", func(c *fiber.Ctx) error { return controller.DeleteContactToAdmin(db, c) })
+	app.Get("/contacts/:id", admin, func(c *fiber.Ctx) error { return controller.GetContactToAdmin(db, c) })
+	app.Put("/contacts/:id", admin, func(c *fiber.Ctx) error { return controller.UpdateContactToAdmin(db, c) })
+	app.Delete("/contacts/:id", admin, func(c *fiber.Ctx) error { return controller.DeleteContactToAdmin(db, c) })
 
 	//nothing usefull
 	// TempShop routes
-	app.Post("/tempshops", func(c *fiber.Ctx) error { return controller.CreateTempShop(db, c) })
-	app.Get("/tempshops", func(c *fiber.Ctx) error { return controller.GetTempShops(db, c) })
-	app.Get("/tempshops/:id", func(c *fiber.Ctx) error { return controller.GetTempShopByID(db, c) })
-	app.Put("/tempshops/:id", func(c *fiber.Ctx) error { return controller.UpdateTempShop(db, c) })
-	app.Delete("/tempshops/:id", func(c *fiber.Ctx) error { return controller.DeleteTempShop(db, c) })
+	app.Post("/tempshops", admin, func(c *fiber.Ctx) error { return controller.CreateTempShop(db, c) })
+	app.Get("/tempshops", admin, func(c *fiber.Ctx) error { return controller.GetTempShops(db, c) })
+	app.Get("/tempshops/:id", admin, func(c *fiber.Ctx) error { return controller.GetTempShopByID(db, c) })
+	app.Get("/tempshops/:id/changes", admin, func(c *fiber.Ctx) error { return controller.GetPendingChanges(db, c) })
+	app.Get("/tempshops/:id/diff", admin, func(c *fiber.Ctx) error { return controller.GetTempShopDiff(db, c) })
+	app.Put("/tempshops/:id", admin, func(c *fiber.Ctx) error { return controller.UpdateTempShop(db, c) })
+	app.Delete("/tempshops/:id", admin, func(c *fiber.Ctx) error { return controller.DeleteTempShop(db, c) })
 	
-	// TempShopOpenDate routes
-
-	app.Get("/tempshopopendates", func(c *fiber.Ctx) error { return controller.GetTempShopOpenDates(db, c) })
-	app.Get("/tempshopopendates/:id", func(c *fiber.Ctx) error { return controller.GetTempShopOpenDateByID(db, c) })
-	app.Put("/tempshopopendates/:id", func(c *fiber.Ctx) error { return controller.UpdateTempShopOpenDate(db, c) })
-	app.Delete("/tempshopopendates/:id", func(c *fiber.Ctx) error { return controller.DeleteTempShopOpenDate(db, c) })
 	//entrepreneur
 	//use this 
-	app.Post("/tempshopopendates", func(c *fiber.Ctx) error { return controller.CreateTempShopOpenDate(db, c) })
+	app.Post("/tempshopopendates", entrepreneur, ownsBody, func(c *fiber.Ctx) error { return controller.CreateTempShopOpenDate(db, c) })
 	//create with temp
-	app.Post("/socials/entrepreneur", func(c *fiber.Ctx) error {return controller.CreateSocialWithTemp(db, c, false) })
-	app.Post("/menus/entrepreneur", func(c *fiber.Ctx) error {return controller.CreateMenuWithTemp(db, c, false) })
+	app.Post("/socials/entrepreneur", entrepreneur, ownsBody, func(c *fiber.Ctx) error {return controller.CreateSocialWithTemp(db, c, false) })
+	app.Post("/menus/entrepreneur", entrepreneur, ownsBody, func(c *fiber.Ctx) error {return controller.CreateMenuWithTemp(db, c, false) })
 	//entrepreneur delete social
-	app.Post("/socialbin", func(c *fiber.Ctx) error { return controller.CreateBinSocial(db, c) })
+	app.Post("/socialbin", entrepreneur, ownsBody, func(c *fiber.Ctx) error { return controller.CreateBinSocial(db, c) })
 	//entrepreneur delete photo
-	app.Post("/photobin", func(c *fiber.Ctx) error { return controller.CreateBinPhoto(db, c) })
+	app.Post("/photobin", entrepreneur, ownsBody, func(c *fiber.Ctx) error { return controller.CreateBinPhoto(db, c) })
 	//entrepreneur delete menu
-	app.Post("/menubin", func(c *fiber.Ctx) error { return controller.CreateBinMenu(db, c) })
+	app.Post("/menubin", entrepreneur, ownsBody, func(c *fiber.Ctx) error { return controller.CreateBinMenu(db, c) })
 	// photo create by entrepreneur
-	app.Post("/photosmenu/:menu_id", func(c *fiber.Ctx) error {return controller.CreatePhotoByMenuID(db, c,false)})
-	app.Post("/photosshop/:shop_id", func(c *fiber.Ctx) error {return controller.CreatePhotoByShopID(db, c,false)})
+	app.Post("/photosmenu/:menu_id", entrepreneur, controller.OwnsShop(db, controller.MenuParam("menu_id"), controller.BodyRefs), func(c *fiber.Ctx) error {return controller.CreatePhotoByMenuID(db, c,false)})
+	app.Post("/photosshop/:shop_id", entrepreneur, controller.OwnsShop(db, controller.ShopParam("shop_id"), controller.BodyRefs), func(c *fiber.Ctx) error {return controller.CreatePhotoByShopID(db, c,false)})
 	//entrepreneurupdate
-	app.Put("/shop/:shop_id", func(c *fiber.Ctx) error { return controller.UpdateTempShopByShopID(db, c) })
+	app.Put("/shop/:shop_id", entrepreneur, controller.OwnsShop(db, controller.ShopParam("shop_id")), func(c *fiber.Ctx) error { return controller.UpdateTempShopByShopID(db, c) })
+	app.Get("/shop/:shop_id/reviews", entrepreneur, controller.OwnsShop(db, controller.ShopParam("shop_id")), func(c *fiber.Ctx) error { return controller.GetReviewHistory(db, c) })
+	app.Get("/shop/:shop_id/transitions", entrepreneur, controller.OwnsShop(db, controller.ShopParam("shop_id")), func(c *fiber.Ctx) error { return controller.GetTempShopTransitions(db, c) })
+	app.Post("/shop/:shop_id/comments", entrepreneur, controller.OwnsShop(db, controller.ShopParam("shop_id")), func(c *fiber.Ctx) error { return controller.CreateReviewComment(db, c) })
+	app.Get("/shop/:shop_id/changes", entrepreneur, controller.OwnsShop(db, controller.ShopParam("shop_id")), func(c *fiber.Ctx) error { return controller.GetPendingChanges(db, c) })
+	app.Delete("/shop/:shop_id/changes/:op_id", entrepreneur, controller.OwnsShop(db, controller.ShopParam("shop_id")), func(c *fiber.Ctx) error { return controller.WithdrawChange(db, c) })
+	app.Get("/shop/:shop_id/preview", entrepreneur, controller.OwnsShop(db, controller.ShopParam("shop_id")), func(c *fiber.Ctx) error { return controller.PreviewTempShop(db, c) })
+	app.Post("/shop/:shop_id/submit", entrepreneur, controller.OwnsShop(db, controller.ShopParam("shop_id")), func(c *fiber.Ctx) error { return controller.SubmitTempShop(db, c) })
+	app.Post("/shop/:shop_id/withdraw", entrepreneur, controller.OwnsShop(db, controller.ShopParam("shop_id")), func(c *fiber.Ctx) error { return controller.WithdrawSubmission(db, c) })
+	app.Post("/shop/:shop_id/resubmit", entrepreneur, controller.OwnsShop(db, controller.ShopParam("shop_id")), func(c *fiber.Ctx) error { return controller.ResubmitTempShop(db, c) })
+	app.Get("/shop/:shop_id/reservations", entrepreneur, controller.OwnsShop(db, controller.ShopParam("shop_id")), func(c *fiber.Ctx) error { return controller.GetShopReservations(db, c) })
+	app.Post("/shop/:shop_id/reservations", entrepreneur, controller.OwnsShop(db, controller.ShopParam("shop_id")), func(c *fiber.Ctx) error { return controller.RequestStallReservation(db, c) })
+	app.Delete("/shop/:shop_id/reservations/:id", entrepreneur, controller.OwnsShop(db, controller.ShopParam("shop_id")), func(c *fiber.Ctx) error { return controller.CancelStallReservation(db, c) })
 	//menuupdate by entrepreneur
-	app.Put("/updatemenu/:menu_id", func(c *fiber.Ctx) error {return controller.UpdateTempMenuByMenuID(db, c)})
+	app.Put("/updatemenu/:menu_id", entrepreneur, controller.OwnsShop(db, controller.MenuParam("menu_id")), func(c *fiber.Ctx) error {return controller.UpdateTempMenuByMenuID(db, c)})
 	//social update by entrepreneur
-	app.Put("updatesocial/:social_id",func(c *fiber.Ctx) error {return controller.UpdateSocialBySocialID(db, c)})
+	app.Put("updatesocial/:social_id", entrepreneur, controller.OwnsShop(db, controller.SocialParam("social_id")), func(c *fiber.Ctx) error {return controller.UpdateSocialBySocialID(db, c)})
 	
 	
 	//admin
-	app.Post("/socials/admin", func(c *fiber.Ctx) error {return controller.CreateSocialWithTemp(db, c, true)})
-	app.Post("/menus/admin", func(c *fiber.Ctx) error {return controller.CreateMenuWithTemp(db, c, true) })
-	app.Post("/createshop/admin", func(c *fiber.Ctx) error {return controller.CreateShopWithTemp(db, c)})
+	app.Post("/socials/admin", admin, func(c *fiber.Ctx) error {return controller.CreateSocialWithTemp(db, c, true)})
+	app.Post("/menus/admin", admin, func(c *fiber.Ctx) error {return controller.CreateMenuWithTemp(db, c, true) })
+	app.Post("/createshop/admin", admin, func(c *fiber.Ctx) error {return controller.CreateShopWithTemp(db, c)})
 	//photo create by admin
-	app.Post("/photos/workshop/:workshop_id", func(c *fiber.Ctx) error {return controller.CreatePhotoByWorkshopID(db, c)})
-	app.Post("/photos/menu/:menu_id", func(c *fiber.Ctx) error {return controller.CreatePhotoByMenuID(db, c,true)})
-	app.Post("/photos/shop/:shop_id", func(c *fiber.Ctx) error {return controller.CreatePhotoByShopID(db, c,true)})
+	app.Post("/photos/workshop/:workshop_id", admin, func(c *fiber.Ctx) error {return controller.CreatePhotoByWorkshopID(db, c)})
+	app.Post("/photos/menu/:menu_id", admin, func(c *fiber.Ctx) error {return controller.CreatePhotoByMenuID(db, c,true)})
+	app.Post("/photos/shop/:shop_id", admin, func(c *fiber.Ctx) error {return controller.CreatePhotoByShopID(db, c,true)})
 	//admin update and delete
-	app.Put("/shopmenu/:id", func(c *fiber.Ctx) error { return controller.UpdateShopMenu(db, c) })
-	app.Delete("/shopmenu/:id", func(c *fiber.Ctx) error { return controller.DeleteShopMenuByID(db, c) })
+	app.Put("/shopmenu/:id", admin, func(c *fiber.Ctx) error { return controller.UpdateShopMenu(db, c) })
+	app.Delete("/shopmenu/:id", admin, func(c *fiber.Ctx) error { return controller.DeleteShopMenuByID(db, c) })
 	//admin update and delete
-	app.Put("/social/:id", func(c *fiber.Ctx) error { return controller.UpdateSocialMedia(db, c) })
-	app.Delete("/social/:id", func(c *fiber.Ctx) error { return controller.DeleteSocialMedia(db, c) })
-	app.Post("/shoptime", func(c *fiber.Ctx) error { return controller.CreateShopOpenDate(db, c) })
-	app.Put("/shoptime/:id", func(c *fiber.Ctx) error { return controller.UpdateShopOpenDate(db, c) })
-	app.Delete("/shoptime/:id", func(c *fiber.Ctx) error { return controller.DeleteShopOpenDate(db, c) })
-	app.Delete("/uploadphotos/:id", func(c *fiber.Ctx) error {
+	app.Put("/social/:id", admin, func(c *fiber.Ctx) error { return controller.UpdateSocialMedia(db, c) })
+	app.Delete("/social/:id", admin, func(c *fiber.Ctx) error { return controller.DeleteSocialMedia(db, c) })
+	app.Post("/shoptime", admin, func(c *fiber.Ctx) error { return controller.CreateShopOpenDate(db, c) })
+	app.Put("/shoptime/:id", admin, func(c *fiber.Ctx) error { return controller.UpdateShopOpenDate(db, c) })
+	app.Delete("/shoptime/:id", admin, func(c *fiber.Ctx) error { return controller.DeleteShopOpenDate(db, c) })
+	app.Delete("/uploadphotos/:id", admin, func(c *fiber.Ctx) error {
 		return controller.DeletePhotoByID(db, c)
 	})
 
 	
-	app.Get("/menubin", func(c *fiber.Ctx) error { return controller.GetBinMenus(db, c) })
-	app.Get("/menubin/:id", func(c *fiber.Ctx) error { return controller.GetBinMenuByID(db, c) })
-	app.Get("/menubin/temp/:temp_id", func(c *fiber.Ctx) error { return controller.GetBinMenuByTempID(db, c) })
-	// app.Put("/menubin/:id", func(c *fiber.Ctx) error { return controller.UpdateBinMenu(db, c) })
-	app.Delete("/menubin/:id", func(c *fiber.Ctx) error { return controller.DeleteBinMenu(db, c) })
-	app.Delete("/menubin/temp/:temp_id", func(c *fiber.Ctx) error { return controller.DeleteBinMenuByTempID(db, c) }) // 🔥 Delete by TempID
-
-
-	//nothing usefull this for test
-	app.Get("/photobin", func(c *fiber.Ctx) error { return controller.GetBinPhotos(db, c) })
-	app.Get("/photobin/:id", func(c *fiber.Ctx) error { return controller.GetBinPhotoByID(db, c) })
-	app.Get("/photobin/temp/:temp_id", func(c *fiber.Ctx) error { return controller.GetBinPhotoByTempID(db, c) })
-	// app.Put("/photobin/:id", func(c *fiber.Ctx) error { return controller.UpdateBinPhoto(db, c) })
-	app.Delete("/photobin/:id", func(c *fiber.Ctx) error { return controller.DeleteBinPhoto(db, c) })
-	app.Delete("/photobin/temp/:temp_id", func(c *fiber.Ctx) error { return controller.DeleteBinPhotoByTempID(db, c) }) // 🔥 Delete by TempID
-	
-
-	//Bin
-	// Bin routes
-	app.Get("/socialbin", func(c *fiber.Ctx) error { return controller.GetBinSocials(db, c) })
-	app.Get("/socialbin/:id", func(c *fiber.Ctx) error { return controller.GetBinSocialByID(db, c) })
-	app.Get("/socialbin/temp/:temp_id", func(c *fiber.Ctx) error { return controller.GetBinSocialByTempID(db, c) })
-	// app.Put("/socialbin/:id", func(c *fiber.Ctx) error { return controller.UpdateBinSocial(db, c) })
-	app.Delete("/socialbin/:id", func(c *fiber.Ctx) error { return controller.DeleteBinSocial(db, c) })
-	app.Delete("/socialbin/temp/:temp_id", func(c *fiber.Ctx) error { return controller.DeleteBinSocialByTempID(db, c) }) // 🔥 Delete by TempID
-	
 	//not available
-	app.Get("/availablemenus", func(c *fiber.Ctx) error { return controller.GetAvailableMenus(db, c) })
-	app.Get("/availablemenus/:shop_id", func(c *fiber.Ctx) error { return controller.GetAvailableMenusByShopID(db, c) })
-	app.Get("/availablephotos/menu/:menu_id", func(c *fiber.Ctx) error { return controller.GetAvailablePhotosByMenuID(db, c) })
-	app.Get("/availablephotos/shop/:shop_id", func(c *fiber.Ctx) error { return controller.GetAvailablePhotosByShopID(db, c) })
-	app.Get("/availablesocial/:shop_id", func(c *fiber.Ctx) error { return controller.GetAvailableSocialByShopID(db, c) })
-	app.Get("/availableshopDetail/:shop_id", func(c *fiber.Ctx) error { return controller.GetAvailableShopDetailByID(db, c) })
+	app.Get("/availablemenus", public, func(c *fiber.Ctx) error { return controller.GetAvailableMenus(db, c) })
+	app.Get("/availablemenus/:shop_id", public, func(c *fiber.Ctx) error { return controller.GetAvailableMenusByShopID(db, c) })
+	app.Get("/availablephotos/menu/:menu_id", public, func(c *fiber.Ctx) error { return controller.GetAvailablePhotosByMenuID(db, c) })
+	app.Get("/availablephotos/shop/:shop_id", public, func(c *fiber.Ctx) error { return controller.GetAvailablePhotosByShopID(db, c) })
+	app.Get("/availablesocial/:shop_id", public, func(c *fiber.Ctx) error { return controller.GetAvailableSocialByShopID(db, c) })
+	app.Get("/availableshopDetail/:shop_id", public, func(c *fiber.Ctx) error { return controller.GetAvailableShopDetailByID(db, c) })
 
 	//tempshop
-	app.Get("/waitingshops", func(c *fiber.Ctx) error {return controller.GetAllTempShopsWaiting(db, c)})
+	app.Get("/waitingshops", admin, func(c *fiber.Ctx) error {return controller.GetAllTempShopsWaiting(db, c)})
 	
 	//admin manage
-	app.Get("/approve/:id", func(c *fiber.Ctx) error { return controller.Handleapprove(db, c) })
-	app.Put("/notApprove/:temp_id", func(c *fiber.Ctx) error { return controller.HandleNotApprove(db, c) })
+	app.Get("/approve/:id", admin, func(c *fiber.Ctx) error { return controller.Handleapprove(db, c) })
+	app.Put("/notApprove/:temp_id", admin, func(c *fiber.Ctx) error { return controller.HandleNotApprove(db, c) })
+	app.Put("/approve/:temp_id/items", admin, func(c *fiber.Ctx) error { return controller.HandlePartialApprove(db, c) })
+	app.Get("/review/queue", admin, func(c *fiber.Ctx) error { return controller.GetReviewQueue(db, c) })
+	app.Get("/review/sla", admin, func(c *fiber.Ctx) error { return controller.GetReviewSLAReport(db, c) })
+	app.Post("/review/queue/:id/claim", admin, func(c *fiber.Ctx) error { return controller.ClaimReview(db, c) })
+	app.Delete("/review/queue/:id/claim", admin, func(c *fiber.Ctx) error { return controller.ReleaseReview(db, c) })
+	app.Put("/review/queue/:id/assignee", admin, func(c *fiber.Ctx) error { return controller.AssignReview(db, c) })
+	app.Get("/tempshops/:id/reviews", admin, func(c *fiber.Ctx) error { return controller.GetReviewHistory(db, c) })
+	app.Get("/tempshops/:id/transitions", admin, func(c *fiber.Ctx) error { return controller.GetTempShopTransitions(db, c) })
+	app.Post("/tempshops/:id/comments", admin, func(c *fiber.Ctx) error { return controller.CreateReviewComment(db, c) })
+	app.Get("/tempshops/:id/autoapproval", admin, func(c *fiber.Ctx) error { return controller.CheckAutoApproval(db, c) })
+	app.Get("/autoapproval/rules", admin, func(c *fiber.Ctx) error { return controller.GetAutoApprovalRules(db, c) })
+	app.Post("/autoapproval/rules", admin, func(c *fiber.Ctx) error { return controller.CreateAutoApprovalRule(db, c) })
+	app.Put("/autoapproval/rules/:id", admin, func(c *fiber.Ctx) error { return controller.UpdateAutoApprovalRule(db, c) })
+	app.Delete("/autoapproval/rules/:id", admin, func(c *fiber.Ctx) error { return controller.DeleteAutoApprovalRule(db, c) })
+	app.Get("/autoapproval/decisions", admin, func(c *fiber.Ctx) error { return controller.GetAutoApprovalDecisions(db, c) })
+	app.Get("/notifications/outbox", admin, func(c *fiber.Ctx) error { return controller.GetNotificationOutbox(db, c) })
+	app.Post("/notifications/outbox/:id/retry", admin, func(c *fiber.Ctx) error { return controller.RetryNotification(db, c) })
 
 	//filter
 	//how to use search-shops?keyword=coffee
-	app.Get("/search-shops", func(c *fiber.Ctx) error { return controller.SearchShopsByKeyword(db, c) })
+	app.Get("/search-shops", public, func(c *fiber.Ctx) error { return controller.SearchShopsByKeyword(db, c) })
 
 	// Define Routes
-	app.Post("/register", func(c *fiber.Ctx) error {
+	app.Post("/register", public, func(c *fiber.Ctx) error {
 		return controller.Register(db, c)
 	})
 
-	app.Post("/login", func(c *fiber.Ctx) error {
+	app.Post("/login", public, func(c *fiber.Ctx) error {
 		return controller.Login(db, c)
 	})
-	app.Post("/logout", controller.Logout)
-	app.Put("/resetPassword", func(c *fiber.Ctx)error {
+	app.Post("/logout", entrepreneur, controller.Logout)
+	app.Post("/token/refresh", public, controller.RefreshToken)
+	app.Get("/.well-known/jwks.json", public, controller.GetJWKS)
+	app.Get("/sessions", entrepreneur, controller.GetSessions)
+	app.Delete("/sessions/:id", entrepreneur, controller.RevokeSession)
+	app.Put("/resetPassword", self, func(c *fiber.Ctx)error {
 		return controller.ResetPassword(db,c)
 	})
-	app.Get("/shopLogin", func(c *fiber.Ctx) error {
+	app.Post("/password/forgot", public, func(c *fiber.Ctx) error { return controller.ForgotPassword(db, c) })
+	app.Post("/password/reset", public, func(c *fiber.Ctx) error { return controller.ConfirmPasswordReset(db, c) })
+	app.Get("/shopLogin", self, func(c *fiber.Ctx) error {
         return controller.GetShopDetailsByLoggedInEntrepreneur(db, c)
     })
 
@@ -324,7 +423,7 @@ func main() {
 		return c.JSON(fiber.Map{"message": "You are authenticated!"})
 	})
 
-	app.Get("/config", getENV)
+	app.Get("/config", admin, getENV)
 
 	app.Listen(":8080")
 
@@ -2,44 +2,141 @@ package middleware
 
 import (
 	"context"
+	"errors"
+	"strconv"
 	"strings"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/gofiber/fiber/v2"
 	"github.com/HealthMe-pls/medic-go-api/database"
+	"github.com/HealthMe-pls/medic-go-api/token"
 )
 
-// Secret key for JWT
-var jwtSecret = []byte("your_secret_key")
+// Roles carried in the "role" claim
+const (
+	RoleAdmin        = "admin"
+	RoleEntrepreneur = "entrepreneur"
+)
+
+// claimsKey is the c.Locals key holding the authenticated *Claims
+const claimsKey = "claims"
+
+// Claims is the JWT payload. Subject holds the ID of the admin or entrepreneur
+// and SessionID the login session the access token was issued for.
+type Claims struct {
+	Username  string `json:"username"`
+	Role      string `json:"role"`
+	SessionID string `json:"sid"`
+	jwt.RegisteredClaims
+}
+
+// UserID returns the subject claim as a numeric ID
+func (c *Claims) UserID() uint {
+	id, err := strconv.ParseUint(c.Subject, 10, 32)
+	if err != nil {
+		return 0
+	}
+	return uint(id)
+}
+
+// Route policies. Every route in main.go is declared with exactly one of these.
+var (
+	// Public routes need no token
+	Public fiber.Handler = func(c *fiber.Ctx) error { return c.Next() }
+	// Entrepreneur routes accept entrepreneurs and admins
+	Entrepreneur = RequireRole(RoleEntrepreneur, RoleAdmin)
+	// Self routes act on the logged-in entrepreneur's own account, taking the
+	// token's subject as the entrepreneur ID, so they accept entrepreneurs only
+	Self = RequireRole(RoleEntrepreneur)
+	// Admin routes accept admins only
+	Admin = RequireRole(RoleAdmin)
+)
 
 // AuthLogin middleware checks for a valid JWT token and ensures it is not blacklisted
 func AuthLogin(c *fiber.Ctx) error {
-	// Get token from header
-	tokenString := c.Get("Authorization")
-	if tokenString == "" {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Missing token"})
+	claims, err := authenticate(c)
+	if err != nil {
+		return Unauthorized(c, err.Error())
 	}
+	c.Locals(claimsKey, claims)
 
-	// Remove "Bearer " prefix if present
-	if strings.HasPrefix(tokenString, "Bearer ") {
-		tokenString = strings.TrimPrefix(tokenString, "Bearer ")
+	// Token is valid, allow the request to continue
+	return c.Next()
+}
+
+// RequireRole authenticates the request like AuthLogin and then checks that
+// the token's role is one of roles
+func RequireRole(roles ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		claims, err := authenticate(c)
+		if err != nil {
+			return Unauthorized(c, err.Error())
+		}
+		if !hasRole(claims.Role, roles) {
+			return Forbidden(c, "Insufficient permissions")
+		}
+		c.Locals(claimsKey, claims)
+		return c.Next()
+	}
+}
+
+// GetClaims returns the claims stored by AuthLogin or RequireRole
+func GetClaims(c *fiber.Ctx) (*Claims, bool) {
+	claims, ok := c.Locals(claimsKey).(*Claims)
+	return claims, ok
+}
+
+// Unauthorized writes the standard 401 response
+func Unauthorized(c *fiber.Ctx, message string) error {
+	return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": message})
+}
+
+// Forbidden writes the standard 403 response
+func Forbidden(c *fiber.Ctx, message string) error {
+	return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": message})
+}
+
+// BearerToken extracts the raw token from the Authorization header
+func BearerToken(c *fiber.Ctx) string {
+	return strings.TrimPrefix(c.Get("Authorization"), "Bearer ")
+}
+
+func authenticate(c *fiber.Ctx) (*Claims, error) {
+	// Get token from header
+	tokenString := BearerToken(c)
+	if tokenString == "" {
+		return nil, errors.New("Missing token")
 	}
 
 	// Check if token is blacklisted in Redis
 	exists, err := database.RedisClient.Get(context.Background(), tokenString).Result()
 	if err == nil && exists == "blacklisted" {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Token has been revoked"})
+		return nil, errors.New("Token has been revoked")
 	}
 
 	// Parse the token
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return jwtSecret, nil
-	})
+	claims := &Claims{}
+	if err := token.Default.Parse(tokenString, claims); err != nil {
+		return nil, errors.New("Invalid token")
+	}
+	if claims.Role == "" || claims.UserID() == 0 || claims.SessionID == "" {
+		return nil, errors.New("Invalid token claims")
+	}
 
-	if err != nil || !token.Valid {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid token"})
+	// Revoking a session invalidates its access tokens immediately
+	active, err := database.SessionExists(claims.SessionID)
+	if err != nil || !active {
+		return nil, errors.New("Session has been revoked")
 	}
 
-	// Token is valid, allow the request to continue
-	return c.Next()
+	return claims, nil
+}
+
+func hasRole(role string, roles []string) bool {
+	for _, r := range roles {
+		if role == r {
+			return true
+		}
+	}
+	return false
 }
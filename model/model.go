@@ -1,7 +1,11 @@
 package model
 
 import (
+	"encoding/json"
+	"errors"
 	"time"
+
+	"gorm.io/gorm"
 )
 
 // Patient represents the Patient table
@@ -23,17 +27,21 @@ type Image struct {
 
 // Admin represents the Admin table
 type Admin struct {
-	ID         uint   `gorm:"primaryKey" json:"id"`
-	Email      string `gorm:"unique" json:"email"`
-	Password   string `json:"password"`
+	ID              uint   `gorm:"primaryKey" json:"id"`
+	Email           string `gorm:"unique" json:"email"`
+	Password        string `json:"-"` // bcrypt hash, never serialized
+	TOTPSecret      string `json:"-"` // base32 secret; set during enrollment before TOTPEnabled
+	TOTPEnabled     bool   `json:"totp_enabled"`
+	TOTPLastCounter int64  `json:"-"` // last accepted time step, so a code cannot be replayed
 }
 
 // Entrepreneur represents the Entrepreneur table
 type Entrepreneur struct {
-	ID       uint   `gorm:"primaryKey" json:"id"`
-	Username string `gorm:"unique;not null;size:255" json:"username"`
-	Password string `json:"password"`
-	Shops    []Shop `gorm:"foreignKey:EntrepreneurID;constraint:OnDelete:CASCADE;OnUpdate:CASCADE;" json:"shops"`
+	ID       uint    `gorm:"primaryKey" json:"id"`
+	Username string  `gorm:"unique;not null;size:255" json:"username"`
+	Email    *string `gorm:"unique;size:255" json:"email"` // used for password resets
+	Password string  `json:"-"`                            // bcrypt hash, never serialized
+	Shops    []Shop  `gorm:"foreignKey:EntrepreneurID;constraint:OnDelete:CASCADE;OnUpdate:CASCADE;" json:"shops"`
 }
 
 // Shop represents the Shop table
@@ -79,9 +87,27 @@ type MarketOpenDate struct {
 	Date          time.Time      `gorm:"type:date" json:"date"`
 	StartTime     time.Time      `json:"start_time"`
 	EndTime       time.Time      `json:"end_time"`
+	ScheduleID    *uint          `gorm:"index" json:"schedule_id"` // the series that generated it, if any
 	ShopOpenDates []ShopOpenDate `gorm:"foreignKey:MarketOpenDateID;constraint:OnDelete:CASCADE;OnUpdate:CASCADE;" json:"shop_open_dates"`
 }
 
+// MarketSchedule is a recurring market. Its RRule, a subset of RFC 5545
+// recurrence rules, generates MarketOpenDates from StartDate on, each open
+// from StartTime to EndTime ("15:04"). Exceptions lists the YYYY-MM-DD dates,
+// comma-separated, on which the market does not open.
+type MarketSchedule struct {
+	ID              uint             `gorm:"primaryKey" json:"id"`
+	Name            string           `gorm:"size:255" json:"name"`
+	RRule           string           `gorm:"size:255;not null" json:"rrule"`
+	StartDate       time.Time        `gorm:"type:date" json:"start_date"`
+	StartTime       string           `gorm:"size:5" json:"start_time"`
+	EndTime         string           `gorm:"size:5" json:"end_time"`
+	Exceptions      string           `gorm:"type:text" json:"exceptions"`
+	MarketOpenDates []MarketOpenDate `gorm:"foreignKey:ScheduleID;constraint:OnDelete:SET NULL;OnUpdate:CASCADE;" json:"market_open_dates,omitempty"`
+	CreatedAt       time.Time        `json:"created_at"`
+	UpdatedAt       time.Time        `json:"updated_at"`
+}
+
 // MarketMap represents the MarketMap table
 type MarketMap struct {
 	BlockID   uint   `gorm:"primaryKey" json:"block_id"`
@@ -91,6 +117,32 @@ type MarketMap struct {
 	Shop      Shop   `gorm:"foreignKey:ShopID;constraint:OnDelete:SET NULL;OnUpdate:CASCADE;" json:"shop"`
 }
 
+// Stall reservation statuses. Requested and confirmed reservations hold their block.
+const (
+	ReservationRequested = "requested"
+	ReservationConfirmed = "confirmed"
+	ReservationRejected  = "rejected"
+	ReservationCancelled = "cancelled"
+)
+
+// StallReservation rents a MarketMap block to a shop for one market date
+type StallReservation struct {
+	ID               uint            `gorm:"primaryKey" json:"id"`
+	BlockID          uint            `gorm:"not null;index:idx_reservation_block_date" json:"block_id"`
+	MarketOpenDateID uint            `gorm:"not null;index:idx_reservation_block_date" json:"market_open_date_id"`
+	ShopID           uint            `gorm:"not null;index" json:"shop_id"`
+	Status           string          `gorm:"size:16;index" json:"status"`
+	Note             string          `gorm:"type:text" json:"note"`
+	Reason           string          `gorm:"type:text" json:"reason"` // why it was rejected
+	DecidedBy        *uint           `json:"decided_by"`
+	DecidedAt        *time.Time      `json:"decided_at"`
+	CreatedAt        time.Time       `json:"created_at"`
+	UpdatedAt        time.Time       `json:"updated_at"`
+	Block            MarketMap       `gorm:"foreignKey:BlockID;references:BlockID;constraint:OnDelete:CASCADE;OnUpdate:CASCADE;" json:"-"`
+	MarketOpenDate   *MarketOpenDate `gorm:"foreignKey:MarketOpenDateID;constraint:OnDelete:CASCADE;OnUpdate:CASCADE;" json:"market_open_date,omitempty"`
+	Shop             Shop            `gorm:"foreignKey:ShopID;constraint:OnDelete:CASCADE;OnUpdate:CASCADE;" json:"-"`
+}
+
 // SocialMedia represents the SocialMedia table
 type SocialMedia struct {
 	ID           uint         `gorm:"primaryKey" json:"id"`
@@ -168,7 +220,7 @@ type TempShop struct {
 	TempID         uint           `gorm:"primaryKey" json:"id"`
 	Name           string         `json:"name"`
 	ShopID         *uint          `json:"shop_id"`
-	Status         string         `json:"status"`
+	Status         TempShopStatus `gorm:"size:20;index" json:"status"`
 	Description    string         `json:"description"`
 	DeletePhoto    []DeletePhoto  `gorm:"foreignKey:TempID;constraint:OnDelete:CASCADE;OnUpdate:CASCADE;" json:"delete_photos"`
 	DeleteSocial   []DeleteSocial `gorm:"foreignKey:TempID;constraint:OnDelete:CASCADE;OnUpdate:CASCADE;" json:"delete_socials"`
@@ -229,3 +281,364 @@ type TempSocial struct {
 	Platform string `json:"platform"`
 	Link     string `json:"link"`
 }
+
+// AccessViolation records an entrepreneur trying to edit a shop they do not own
+type AccessViolation struct {
+	ID             uint      `gorm:"primaryKey" json:"id"`
+	EntrepreneurID uint      `gorm:"index" json:"entrepreneur_id"`
+	ShopID         uint      `json:"shop_id"`
+	Method         string    `json:"method"`
+	Path           string    `json:"path"`
+	IP             string    `json:"ip"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// PasswordResetToken is a single-use token sent by email to reset an entrepreneur's password
+type PasswordResetToken struct {
+	ID             uint       `gorm:"primaryKey" json:"id"`
+	EntrepreneurID uint       `gorm:"not null;index" json:"entrepreneur_id"`
+	TokenHash      string     `gorm:"unique;not null;size:64" json:"-"` // sha256 of the token, the token itself is never stored
+	ExpiresAt      time.Time  `json:"expires_at"`
+	UsedAt         *time.Time `json:"used_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// Email outbox statuses
+const (
+	EmailPending = "pending"
+	EmailSent    = "sent"
+	EmailFailed  = "failed"
+)
+
+// EmailOutbox is an email waiting to be sent, or the record of one that was
+type EmailOutbox struct {
+	ID            uint       `gorm:"primaryKey" json:"id"`
+	To            string     `gorm:"not null;size:255" json:"to"`
+	Subject       string     `json:"subject"`
+	Body          string     `gorm:"type:text" json:"body"`
+	Sensitive     bool       `json:"sensitive"` // the body is cleared once the email is sent or given up on
+	Status        string     `gorm:"index;size:16" json:"status"`
+	Attempts      int        `json:"attempts"`
+	LastError     string     `json:"last_error"`
+	NextAttemptAt time.Time  `gorm:"index" json:"next_attempt_at"`
+	SentAt        *time.Time `json:"sent_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+// AuthEvent is one login attempt, kept to spot credential stuffing
+type AuthEvent struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Role      string    `gorm:"size:16" json:"role"`
+	Username  string    `gorm:"index;size:255" json:"username"`
+	UserID    *uint     `json:"user_id"`
+	IP        string    `gorm:"index;size:64" json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	Success   bool      `json:"success"`
+	Reason    string    `gorm:"size:32" json:"reason"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+}
+
+// ErrAuditLogAppendOnly is returned when code tries to change or remove an audit entry
+var ErrAuditLogAppendOnly = errors.New("audit log is append-only")
+
+// AuditLog is one admin action. Before and After are JSON snapshots of the
+// target entity; either is null when the entity did not exist on that side.
+type AuditLog struct {
+	ID         uint            `gorm:"primaryKey" json:"id"`
+	ActorID    uint            `gorm:"index" json:"actor_id"`
+	ActorRole  string          `gorm:"size:16" json:"actor_role"`
+	ActorName  string          `json:"actor_name"`
+	Action     string          `gorm:"index;size:64" json:"action"`
+	EntityType string          `gorm:"index:idx_audit_entity;size:64" json:"entity_type"`
+	EntityID   string          `gorm:"index:idx_audit_entity;size:64" json:"entity_id"`
+	Before     json.RawMessage `gorm:"type:json" json:"before"`
+	After      json.RawMessage `gorm:"type:json" json:"after"`
+	IP         string          `gorm:"size:64" json:"ip"`
+	Method     string          `gorm:"size:8" json:"method"`
+	Path       string          `json:"path"`
+	CreatedAt  time.Time       `gorm:"index" json:"created_at"`
+}
+
+// BeforeUpdate keeps audit entries immutable
+func (*AuditLog) BeforeUpdate(*gorm.DB) error {
+	return ErrAuditLogAppendOnly
+}
+
+// BeforeDelete keeps audit entries from being removed
+func (*AuditLog) BeforeDelete(*gorm.DB) error {
+	return ErrAuditLogAppendOnly
+}
+
+// AdminRecoveryCode is a one-time code that replaces a TOTP code when the admin's device is lost
+type AdminRecoveryCode struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	AdminID   uint       `gorm:"not null;index" json:"admin_id"`
+	CodeHash  string     `gorm:"not null;size:64" json:"-"` // sha256 of the code
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// ReviewRound is one submission of a TempShop and the admin's decision on it.
+// A new round starts every time the entrepreneur submits again.
+type ReviewRound struct {
+	ID          uint            `gorm:"primaryKey" json:"id"`
+	TempID      uint            `gorm:"not null;index" json:"temp_id"`
+	Round       int             `json:"round"`
+	Status      string          `gorm:"size:20" json:"status"` // Waiting until reviewed, then Approve, NotApprove, PartiallyApproved or Withdrawn
+	Reason      string          `gorm:"type:text" json:"reason"`
+	ReviewerID  *uint           `json:"reviewer_id"`
+	AssigneeID  *uint           `gorm:"index" json:"assignee_id"` // admin the round is assigned to, if any
+	AssignedAt  *time.Time      `json:"assigned_at"`
+	SubmittedAt time.Time       `json:"submitted_at"`
+	ClaimedAt   *time.Time      `json:"claimed_at"` // first claim by a reviewer
+	ReviewedAt  *time.Time      `json:"reviewed_at"`
+	Comments    []ReviewComment `gorm:"foreignKey:RoundID;constraint:OnDelete:CASCADE;OnUpdate:CASCADE;" json:"comments"`
+}
+
+// ReviewComment is a message between admin and entrepreneur about a TempShop.
+// ItemType and ItemID point at the menu, social, photo or open time it is about;
+// an empty ItemType means the whole submission.
+type ReviewComment struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	TempID     uint      `gorm:"not null;index" json:"temp_id"`
+	RoundID    uint      `gorm:"not null;index" json:"round_id"`
+	AuthorRole string    `gorm:"size:16" json:"author_role"`
+	AuthorID   uint      `json:"author_id"`
+	ItemType   string    `gorm:"size:16" json:"item_type"`
+	ItemID     *uint     `json:"item_id"`
+	ItemKey    string    `gorm:"size:64" json:"item_key"` // pending change the comment decided on, if any
+	ReplyToID  *uint     `json:"reply_to_id"`
+	Body       string    `gorm:"type:text" json:"body"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// ErrShopVersionImmutable is returned when code tries to change or remove a shop version
+var ErrShopVersionImmutable = errors.New("shop versions are immutable")
+
+// Shop version sources
+const (
+	VersionBaseline = "baseline" // state found before the first recorded approval
+	VersionApprove  = "approve"
+	VersionRollback = "rollback"
+)
+
+// ShopVersion is a snapshot of a shop's public state taken when a change was published
+type ShopVersion struct {
+	ID          uint            `gorm:"primaryKey" json:"id"`
+	ShopID      uint            `gorm:"not null;uniqueIndex:idx_shop_version" json:"shop_id"`
+	Version     int             `gorm:"not null;uniqueIndex:idx_shop_version" json:"version"`
+	Source      string          `gorm:"size:16" json:"source"`
+	TempID      *uint           `json:"temp_id"`
+	RollbackOf  *int            `json:"rollback_of"` // version restored by a rollback
+	CreatedByID uint            `json:"created_by_id"`
+	Snapshot    json.RawMessage `gorm:"type:json" json:"snapshot,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+}
+
+// BeforeUpdate keeps shop versions immutable
+func (*ShopVersion) BeforeUpdate(*gorm.DB) error {
+	return ErrShopVersionImmutable
+}
+
+// BeforeDelete keeps shop versions from being removed
+func (*ShopVersion) BeforeDelete(*gorm.DB) error {
+	return ErrShopVersionImmutable
+}
+
+// Change entities and actions. A ChangeOp applies one action to one entity.
+const (
+	ChangeEntityShop     = "shop"
+	ChangeEntityMenu     = "menu"
+	ChangeEntitySocial   = "social"
+	ChangeEntityPhoto    = "photo"
+	ChangeEntityOpenTime = "open_time"
+
+	ChangeCreate = "create"
+	ChangeUpdate = "update"
+	ChangeDelete = "delete"
+)
+
+// Change set and change op statuses
+const (
+	ChangeSetOpen      = "open"      // collecting or under review
+	ChangeSetApplied   = "applied"   // every op was applied
+	ChangeSetDiscarded = "discarded" // replaced before it was applied
+
+	ChangeOpPending   = "pending"
+	ChangeOpApplied   = "applied"
+	ChangeOpStale     = "stale" // its target was removed before the op could apply
+	ChangeOpDiscarded = "discarded"
+)
+
+// ChangeSet holds the pending changes to one shop. A shop has at most one open
+// change set; it is reviewed through the shop's TempShop.
+type ChangeSet struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	ShopID    uint       `gorm:"not null;index" json:"shop_id"`
+	TempID    uint       `gorm:"not null;index" json:"temp_id"`
+	Status    string     `gorm:"size:16;not null;index" json:"status"`
+	Source    string     `gorm:"size:16" json:"source"` // entrepreneur, rollback or legacy
+	ClosedAt  *time.Time `json:"closed_at"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	Ops       []ChangeOp `gorm:"foreignKey:ChangeSetID;constraint:OnDelete:CASCADE;OnUpdate:CASCADE;" json:"ops"`
+}
+
+// ChangeOp is one create, update or delete in a change set. EntityID is the
+// live record it targets and is empty for creates. A photo created for a menu
+// that is itself still pending points at that menu's op through RefOpID.
+type ChangeOp struct {
+	ID          uint            `gorm:"primaryKey" json:"id"`
+	ChangeSetID uint            `gorm:"not null;index" json:"change_set_id"`
+	Entity      string          `gorm:"size:16;not null" json:"entity"`
+	Action      string          `gorm:"size:8;not null" json:"action"`
+	EntityID    *uint           `json:"entity_id"`
+	RefOpID     *uint           `json:"ref_op_id"`
+	Payload     json.RawMessage `gorm:"type:json" json:"payload"`
+	Status      string          `gorm:"size:16;not null;index" json:"status"`
+	ResultID    *uint           `json:"result_id"` // live record the op created or changed
+	AppliedAt   *time.Time      `json:"applied_at"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+}
+
+// DataMigration records a one-time data migration that has run, so it is not run again
+type DataMigration struct {
+	Name      string    `gorm:"primaryKey;size:64" json:"name"`
+	AppliedAt time.Time `json:"applied_at"`
+}
+
+// TempShopStatus is where a TempShop is in review. Changes go through
+// CanTransitionTo and are recorded as TempShopTransitions.
+type TempShopStatus string
+
+const (
+	StatusDraft     TempShopStatus = "Draft"     // being edited, not visible to reviewers
+	StatusSubmitted TempShopStatus = "Submitted" // waiting for a reviewer
+	StatusInReview  TempShopStatus = "InReview"  // a reviewer is deciding
+	StatusApproved  TempShopStatus = "Approved"
+	StatusRejected  TempShopStatus = "Rejected"
+	StatusWithdrawn TempShopStatus = "Withdrawn" // taken back by the entrepreneur before review
+)
+
+// tempShopTransitions lists the statuses each status may move to
+var tempShopTransitions = map[TempShopStatus][]TempShopStatus{
+	StatusDraft:     {StatusSubmitted},
+	StatusSubmitted: {StatusInReview, StatusWithdrawn},
+	StatusInReview:  {StatusApproved, StatusRejected, StatusSubmitted}, // back to the queue when the reviewer lets go
+	StatusApproved:  {StatusDraft, StatusSubmitted},
+	StatusRejected:  {StatusDraft, StatusSubmitted},
+	StatusWithdrawn: {StatusDraft, StatusSubmitted},
+}
+
+// Valid reports whether s is a known status
+func (s TempShopStatus) Valid() bool {
+	_, ok := tempShopTransitions[s]
+	return ok
+}
+
+// CanTransitionTo reports whether a TempShop in status s may move to next
+func (s TempShopStatus) CanTransitionTo(next TempShopStatus) bool {
+	for _, allowed := range tempShopTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// TempShopTransition records one status change of a TempShop, who made it and when.
+// From is empty for the first status of a TempShop.
+type TempShopTransition struct {
+	ID        uint           `gorm:"primaryKey" json:"id"`
+	TempID    uint           `gorm:"not null;index" json:"temp_id"`
+	From      TempShopStatus `gorm:"size:20" json:"from"`
+	To        TempShopStatus `gorm:"size:20;not null" json:"to"`
+	ActorRole string         `gorm:"size:16" json:"actor_role"` // admin, entrepreneur or system
+	ActorID   uint           `json:"actor_id"`
+	Note      string         `json:"note"`
+	CreatedAt time.Time      `gorm:"index" json:"created_at"`
+}
+
+// Kinds of auto-approval rule
+const (
+	RulePriceChange     = "price_change"     // a menu price moves by at most max_percent
+	RuleDescriptionEdit = "description_edit" // only a description changes and it has no flagged word
+	RuleFutureOpenTime  = "future_open_time" // an open time on a market date at least min_days_ahead away
+)
+
+// AutoApprovalRule lets a kind of low-risk change through without a reviewer.
+// Params holds the settings of its kind.
+type AutoApprovalRule struct {
+	ID        uint            `gorm:"primaryKey" json:"id"`
+	Name      string          `gorm:"not null" json:"name"`
+	Kind      string          `gorm:"size:32;not null" json:"kind"`
+	Params    json.RawMessage `gorm:"type:json" json:"params"`
+	Enabled   bool            `json:"enabled"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// AutoApprovalDecision is the outcome of checking a submission against the
+// rules. Matches lists each pending change with the rule that covered it, if any.
+type AutoApprovalDecision struct {
+	ID        uint            `gorm:"primaryKey" json:"id"`
+	TempID    uint            `gorm:"not null;index" json:"temp_id"`
+	Round     int             `json:"round"`
+	Approved  bool            `json:"approved"`
+	Matches   json.RawMessage `gorm:"type:json" json:"matches"`
+	Reason    string          `gorm:"type:text" json:"reason"` // why it was not approved
+	CreatedAt time.Time       `gorm:"index" json:"created_at"`
+}
+
+// Notification channels
+const (
+	ChannelEmail   = "email"
+	ChannelLine    = "line"
+	ChannelWebhook = "webhook"
+)
+
+// Submission lifecycle events an entrepreneur is notified about
+const (
+	EventSubmitted = "submitted"
+	EventApproved  = "approved"
+	EventRejected  = "rejected"
+	EventCommented = "commented"
+)
+
+// Notification outbox statuses
+const (
+	NotificationPending = "pending"
+	NotificationSent    = "sent"
+	NotificationFailed  = "failed"
+)
+
+// NotificationPreference is how an entrepreneur wants to be notified on one
+// channel. Target is the LINE user ID or webhook URL; an empty email target
+// means the account's email. Events is a comma-separated list, empty for all.
+type NotificationPreference struct {
+	ID             uint      `gorm:"primaryKey" json:"id"`
+	EntrepreneurID uint      `gorm:"not null;uniqueIndex:idx_notification_preference" json:"entrepreneur_id"`
+	Channel        string    `gorm:"size:16;not null;uniqueIndex:idx_notification_preference" json:"channel"`
+	Target         string    `gorm:"size:512" json:"target"`
+	Events         string    `gorm:"size:255" json:"events"`
+	Enabled        bool      `json:"enabled"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// Notification is a message waiting to go out on a channel, or the record of one that did
+type Notification struct {
+	ID             uint       `gorm:"primaryKey" json:"id"`
+	EntrepreneurID uint       `gorm:"not null;index" json:"entrepreneur_id"`
+	Channel        string     `gorm:"size:16;not null" json:"channel"`
+	Event          string     `gorm:"size:32;not null" json:"event"`
+	Target         string     `gorm:"size:512" json:"target"`
+	Subject        string     `json:"subject"`
+	Body           string     `gorm:"type:text" json:"body"`
+	Status         string     `gorm:"index;size:16" json:"status"`
+	Attempts       int        `json:"attempts"`
+	LastError      string     `gorm:"type:text" json:"last_error"`
+	NextAttemptAt  time.Time  `gorm:"index" json:"next_attempt_at"`
+	SentAt         *time.Time `json:"sent_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
@@ -0,0 +1,125 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/HealthMe-pls/medic-go-api/mailer"
+	"github.com/HealthMe-pls/medic-go-api/model"
+)
+
+// Email sends notifications over SMTP with the mailer's settings
+type Email struct {
+	Config mailer.Config
+}
+
+func (e Email) Send(n model.Notification) error {
+	return e.Config.Send(n.Target, n.Subject, n.Body)
+}
+
+// Line pushes a text message to a LINE user through the Messaging API. URL
+// can point at a local stand-in during development.
+type Line struct {
+	URL    string
+	Token  string
+	Client *http.Client
+}
+
+func (l Line) Send(n model.Notification) error {
+	if l.Token == "" {
+		return fmt.Errorf("LINE_CHANNEL_TOKEN is not set")
+	}
+	payload, err := json.Marshal(map[string]interface{}{
+		"to": n.Target,
+		"messages": []map[string]string{
+			{"type": "text", "text": n.Subject + "\n\n" + n.Body},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, l.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+l.Token)
+	return do(l.Client, req)
+}
+
+// Webhook posts the notification as JSON to the URL the entrepreneur gave.
+// Client should refuse internal addresses, as a WebhookClient with
+// PublicAddresses does.
+// With a Secret, the body is signed in the X-Signature header as
+// "sha256=" followed by the hex HMAC-SHA256 of the body.
+type Webhook struct {
+	Secret string
+	Client *http.Client
+}
+
+func (w Webhook) Send(n model.Notification) error {
+	payload, err := json.Marshal(map[string]interface{}{
+		"id":              n.ID,
+		"event":           n.Event,
+		"entrepreneur_id": n.EntrepreneurID,
+		"subject":         n.Subject,
+		"body":            n.Body,
+		"created_at":      n.CreatedAt,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, n.Target, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Notification-Event", n.Event)
+	if w.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(w.Secret))
+		mac.Write(payload)
+		req.Header.Set("X-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	return do(w.Client, req)
+}
+
+// do sends req and treats any status outside 2xx as a failure
+func do(client *http.Client, req *http.Request) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// The response body is not kept: the error ends up where the entrepreneur can read it
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s returned %s", req.URL.Host, resp.Status)
+	}
+	return nil
+}
+
+// ChannelsFromEnv sets up every channel. Email uses the SMTP_* settings of
+// mailer.ConfigFromEnv; LINE reads LINE_CHANNEL_TOKEN and LINE_API_URL, which
+// defaults to the public push endpoint; webhooks are signed with WEBHOOK_SECRET
+// and only reach public addresses unless WEBHOOK_ALLOW_PRIVATE is true.
+func ChannelsFromEnv() Channels {
+	client := &http.Client{Timeout: 10 * time.Second}
+	lineURL := os.Getenv("LINE_API_URL")
+	if lineURL == "" {
+		lineURL = "https://api.line.me/v2/bot/message/push"
+	}
+	return Channels{
+		model.ChannelEmail:   Email{Config: mailer.ConfigFromEnv()},
+		model.ChannelLine:    Line{URL: lineURL, Token: os.Getenv("LINE_CHANNEL_TOKEN"), Client: client},
+		model.ChannelWebhook: Webhook{Secret: os.Getenv("WEBHOOK_SECRET"), Client: WebhookClient(10*time.Second, WebhookPolicyFromEnv())},
+	}
+}
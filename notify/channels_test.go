@@ -0,0 +1,145 @@
+package notify
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/HealthMe-pls/medic-go-api/model"
+)
+
+// standIn records the last request and answers with status
+type standIn struct {
+	status  int
+	header  http.Header
+	body    []byte
+	request int
+}
+
+func (s *standIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.request++
+	s.header = r.Header.Clone()
+	s.body, _ = io.ReadAll(r.Body)
+	w.WriteHeader(s.status)
+}
+
+func TestWebhookSend(t *testing.T) {
+	tests := []struct {
+		name    string
+		secret  string
+		status  int
+		wantErr bool
+	}{
+		{"signed", "s3cret", http.StatusOK, false},
+		{"unsigned", "", http.StatusAccepted, false},
+		{"stand-in fails", "s3cret", http.StatusInternalServerError, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := &standIn{status: tt.status}
+			server := httptest.NewServer(stub)
+			defer server.Close()
+
+			n := model.Notification{Event: "shop.approved", Target: server.URL, Subject: "Approved", Body: "Your shop is live"}
+			err := Webhook{Secret: tt.secret, Client: WebhookClient(time.Second, AnyAddress)}.Send(n)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Send() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if stub.request != 1 {
+				t.Fatalf("stand-in got %d requests, want 1", stub.request)
+			}
+			if got := stub.header.Get("X-Notification-Event"); got != n.Event {
+				t.Errorf("X-Notification-Event = %q, want %q", got, n.Event)
+			}
+			var payload map[string]interface{}
+			if err := json.Unmarshal(stub.body, &payload); err != nil {
+				t.Fatalf("body is not JSON: %v", err)
+			}
+			if payload["subject"] != n.Subject || payload["body"] != n.Body {
+				t.Errorf("payload = %v", payload)
+			}
+
+			signature := stub.header.Get("X-Signature")
+			if tt.secret == "" {
+				if signature != "" {
+					t.Errorf("X-Signature = %q, want none", signature)
+				}
+				return
+			}
+			mac := hmac.New(sha256.New, []byte(tt.secret))
+			mac.Write(stub.body)
+			if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); signature != want {
+				t.Errorf("X-Signature = %q, want %q", signature, want)
+			}
+		})
+	}
+}
+
+func TestWebhookSendRefusesStandInByDefault(t *testing.T) {
+	stub := &standIn{status: http.StatusOK}
+	server := httptest.NewServer(stub)
+	defer server.Close()
+
+	n := model.Notification{Event: "shop.approved", Target: server.URL}
+	if err := (Webhook{Client: WebhookClient(time.Second, PublicAddresses)}).Send(n); err == nil {
+		t.Fatal("Send() to a loopback stand-in succeeded with PublicAddresses")
+	}
+	if stub.request != 0 {
+		t.Errorf("stand-in got %d requests, want 0", stub.request)
+	}
+}
+
+func TestLineSend(t *testing.T) {
+	tests := []struct {
+		name    string
+		token   string
+		status  int
+		wantErr bool
+		wantHit int
+	}{
+		{"pushed", "line-token", http.StatusOK, false, 1},
+		{"rejected", "line-token", http.StatusUnauthorized, true, 1},
+		{"no token", "", http.StatusOK, true, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := &standIn{status: tt.status}
+			server := httptest.NewServer(stub)
+			defer server.Close()
+
+			n := model.Notification{Target: "U123", Subject: "Approved", Body: "Your shop is live"}
+			err := Line{URL: server.URL, Token: tt.token, Client: server.Client()}.Send(n)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Send() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if stub.request != tt.wantHit {
+				t.Fatalf("stand-in got %d requests, want %d", stub.request, tt.wantHit)
+			}
+			if tt.wantHit == 0 {
+				return
+			}
+			if got := stub.header.Get("Authorization"); got != "Bearer "+tt.token {
+				t.Errorf("Authorization = %q", got)
+			}
+			var payload struct {
+				To       string `json:"to"`
+				Messages []struct {
+					Type string `json:"type"`
+					Text string `json:"text"`
+				} `json:"messages"`
+			}
+			if err := json.Unmarshal(stub.body, &payload); err != nil {
+				t.Fatalf("body is not JSON: %v", err)
+			}
+			if payload.To != n.Target || len(payload.Messages) != 1 || payload.Messages[0].Text != n.Subject+"\n\n"+n.Body {
+				t.Errorf("payload = %+v", payload)
+			}
+		})
+	}
+}
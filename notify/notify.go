@@ -0,0 +1,169 @@
+// Package notify tells entrepreneurs what happens to their submissions.
+//
+// Handlers call Enqueue inside the transaction that produced the event. It
+// writes one outbox row per channel the entrepreneur chose, and a background
+// dispatcher delivers them. Like mailer, a failed delivery is retried with
+// backoff and never blocks a request.
+package notify
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/HealthMe-pls/medic-go-api/model"
+	"gorm.io/gorm"
+)
+
+const (
+	// maxAttempts is how many times a notification is tried before it is marked failed
+	maxAttempts = 5
+	// batchSize is how many pending notifications are sent per poll
+	batchSize = 20
+)
+
+// Channel delivers a notification to its target
+type Channel interface {
+	Send(n model.Notification) error
+}
+
+// Channels maps a channel name such as model.ChannelEmail to its sender
+type Channels map[string]Channel
+
+// Events lists every event a preference can subscribe to
+var Events = []string{model.EventSubmitted, model.EventApproved, model.EventRejected, model.EventCommented}
+
+// Wants reports whether pref is enabled and subscribed to event
+func Wants(pref model.NotificationPreference, event string) bool {
+	if !pref.Enabled {
+		return false
+	}
+	if strings.TrimSpace(pref.Events) == "" {
+		return true
+	}
+	for _, e := range strings.Split(pref.Events, ",") {
+		if strings.TrimSpace(e) == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Enqueue stores a notification for each channel entrepreneurID wants event
+// on. An entrepreneur without preferences gets email at their account address.
+func Enqueue(db *gorm.DB, entrepreneurID uint, event string, subject string, body string) error {
+	var entrepreneur model.Entrepreneur
+	if err := db.First(&entrepreneur, entrepreneurID).Error; err != nil {
+		return fmt.Errorf("failed to load entrepreneur %d: %w", entrepreneurID, err)
+	}
+	var prefs []model.NotificationPreference
+	if err := db.Where("entrepreneur_id = ?", entrepreneurID).Find(&prefs).Error; err != nil {
+		return err
+	}
+	if len(prefs) == 0 {
+		prefs = []model.NotificationPreference{{Channel: model.ChannelEmail, Enabled: true}}
+	}
+
+	for _, pref := range prefs {
+		if !Wants(pref, event) {
+			continue
+		}
+		target := pref.Target
+		if pref.Channel == model.ChannelEmail && target == "" {
+			if entrepreneur.Email == nil || *entrepreneur.Email == "" {
+				continue
+			}
+			target = *entrepreneur.Email
+		}
+		if target == "" {
+			continue
+		}
+		if err := db.Create(&model.Notification{
+			EntrepreneurID: entrepreneurID,
+			Channel:        pref.Channel,
+			Event:          event,
+			Target:         target,
+			Subject:        subject,
+			Body:           body,
+			Status:         model.NotificationPending,
+			NextAttemptAt:  time.Now(),
+		}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StartDispatcher sends pending notifications every interval until the process exits
+func StartDispatcher(db *gorm.DB, channels Channels, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := Dispatch(db, channels); err != nil {
+				log.Println("Notification dispatch failed:", err)
+			}
+		}
+	}()
+}
+
+// Dispatch sends the notifications that are due and records the outcome of each
+func Dispatch(db *gorm.DB, channels Channels) error {
+	var pending []model.Notification
+	if err := db.Where("status = ? AND next_attempt_at <= ?", model.NotificationPending, time.Now()).
+		Order("id").Limit(batchSize).Find(&pending).Error; err != nil {
+		return err
+	}
+
+	for _, n := range pending {
+		updates := map[string]interface{}{"attempts": n.Attempts + 1}
+		if err := send(channels, n); err != nil {
+			updates["last_error"] = err.Error()
+			if n.Attempts+1 >= maxAttempts {
+				updates["status"] = model.NotificationFailed
+			} else {
+				updates["next_attempt_at"] = time.Now().Add(backoff(n.Attempts + 1))
+			}
+			log.Printf("Failed to send %s notification %d: %v", n.Channel, n.ID, err)
+		} else {
+			updates["status"] = model.NotificationSent
+			updates["sent_at"] = time.Now()
+		}
+		if err := db.Model(&model.Notification{}).Where("id = ?", n.ID).Updates(updates).Error; err != nil {
+			return fmt.Errorf("failed to update notification %d: %w", n.ID, err)
+		}
+	}
+	return nil
+}
+
+func send(channels Channels, n model.Notification) error {
+	channel, ok := channels[n.Channel]
+	if !ok {
+		return fmt.Errorf("channel %q is not configured", n.Channel)
+	}
+	return channel.Send(n)
+}
+
+// Retry puts a failed notification back in the queue with a fresh set of attempts
+func Retry(db *gorm.DB, id uint) error {
+	result := db.Model(&model.Notification{}).
+		Where("id = ? AND status = ?", id, model.NotificationFailed).
+		Updates(map[string]interface{}{
+			"status":          model.NotificationPending,
+			"attempts":        0,
+			"next_attempt_at": time.Now(),
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// backoff doubles the wait after each failed attempt, starting at 30 seconds
+func backoff(attempts int) time.Duration {
+	return 30 * time.Second << (attempts - 1)
+}
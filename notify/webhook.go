@@ -0,0 +1,109 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// Webhook targets are given by entrepreneurs, so the server must not be made
+// to call itself or anything else on its own network. Targets are checked when
+// they are saved and again on every connection, against the address actually
+// dialled, so a name that later resolves somewhere private is still refused.
+// During development WEBHOOK_ALLOW_PRIVATE=true lifts the check, so webhooks
+// can reach a local stand-in server.
+
+// errPrivateTarget is returned for a webhook host that resolves to an internal address
+var errPrivateTarget = errors.New("webhook target must be a public address")
+
+// AddressPolicy reports whether a webhook may connect to ip
+type AddressPolicy func(ip net.IP) bool
+
+// PublicAddresses allows only addresses outside the server's own networks
+func PublicAddresses(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast())
+}
+
+// AnyAddress allows every address. It is meant for development only.
+func AnyAddress(net.IP) bool {
+	return true
+}
+
+// WebhookPolicyFromEnv returns AnyAddress when WEBHOOK_ALLOW_PRIVATE is
+// "true" and PublicAddresses otherwise
+func WebhookPolicyFromEnv() AddressPolicy {
+	if os.Getenv("WEBHOOK_ALLOW_PRIVATE") == "true" {
+		return AnyAddress
+	}
+	return PublicAddresses
+}
+
+// CheckWebhookTarget checks that target is an http or https URL whose host
+// resolves only to addresses allow accepts
+func CheckWebhookTarget(target string, allow AddressPolicy) error {
+	u, err := url.Parse(target)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return errors.New("webhook target must be an http or https URL")
+	}
+	ips, err := net.DefaultResolver.LookupIP(context.Background(), "ip", u.Hostname())
+	if err != nil || len(ips) == 0 {
+		return fmt.Errorf("webhook host %q could not be resolved", u.Hostname())
+	}
+	for _, ip := range ips {
+		if !allow(ip) {
+			return errPrivateTarget
+		}
+	}
+	return nil
+}
+
+// dialAllowed connects only to addresses allow accepts. It resolves the host
+// itself and dials the checked address, so the lookup cannot change in between.
+func dialAllowed(dialer *net.Dialer, allow AddressPolicy) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		ips, err := net.DefaultResolver.LookupIP(ctx, "ip", host)
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range ips {
+			if !allow(ip) {
+				return nil, errPrivateTarget
+			}
+		}
+		var lastErr error
+		for _, ip := range ips {
+			conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+			if err == nil {
+				return conn, nil
+			}
+			lastErr = err
+		}
+		return nil, lastErr
+	}
+}
+
+// WebhookClient is an HTTP client that refuses addresses allow does not
+// accept, including on redirects, and ignores proxy settings so the check
+// applies to the real target
+func WebhookClient(timeout time.Duration, allow AddressPolicy) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Proxy:               nil,
+			DialContext:         dialAllowed(dialer, allow),
+			TLSHandshakeTimeout: timeout,
+		},
+	}
+}
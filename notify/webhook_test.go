@@ -0,0 +1,88 @@
+package notify
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPublicAddresses(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"8.8.8.8", true},
+		{"2001:4860:4860::8888", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"0.0.0.0", false},
+		{"224.0.0.1", false},
+	}
+	for _, tt := range tests {
+		if got := PublicAddresses(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("PublicAddresses(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestCheckWebhookTarget(t *testing.T) {
+	tests := []struct {
+		name    string
+		target  string
+		allow   AddressPolicy
+		wantErr bool
+	}{
+		{"loopback refused", "http://127.0.0.1:8080/hook", PublicAddresses, true},
+		{"private refused", "https://10.0.0.5/hook", PublicAddresses, true},
+		{"loopback allowed in development", "http://127.0.0.1:8080/hook", AnyAddress, false},
+		{"wrong scheme", "ftp://127.0.0.1/hook", AnyAddress, true},
+		{"no host", "http:///hook", AnyAddress, true},
+		{"not a URL", "://nope", AnyAddress, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckWebhookTarget(tt.target, tt.allow)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CheckWebhookTarget(%q) error = %v, wantErr %v", tt.target, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWebhookClientPolicy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		allow   AddressPolicy
+		wantErr error
+	}{
+		{"stand-in reached in development", AnyAddress, nil},
+		{"stand-in refused by default", PublicAddresses, errPrivateTarget},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := WebhookClient(time.Second, tt.allow).Get(server.URL)
+			if err == nil {
+				resp.Body.Close()
+			}
+			if tt.wantErr == nil && err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Get() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,244 @@
+// Package recurrence expands the subset of RFC 5545 recurrence rules used for
+// market schedules:
+//
+//	FREQ=WEEKLY;BYDAY=SA,SU;UNTIL=20271231
+//	FREQ=MONTHLY;BYDAY=1SA,-1SU;INTERVAL=2;UNTIL=20271231
+//
+// FREQ is WEEKLY or MONTHLY, BYDAY lists weekdays (with an ordinal for
+// MONTHLY, "1SA" being the first Saturday and "-1SU" the last Sunday),
+// INTERVAL skips weeks or months and UNTIL is the last possible date. Rules
+// always end: UNTIL is required. Dates are calendar days without a time zone.
+package recurrence
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxOccurrences bounds how many dates a rule may expand to
+const MaxOccurrences = 1000
+
+const (
+	Weekly  = "WEEKLY"
+	Monthly = "MONTHLY"
+)
+
+var weekdays = map[string]time.Weekday{
+	"MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday, "TH": time.Thursday,
+	"FR": time.Friday, "SA": time.Saturday, "SU": time.Sunday,
+}
+
+// Day is a BYDAY entry. N is the ordinal within the month, 0 for every such
+// weekday; it is always 0 in weekly rules.
+type Day struct {
+	N       int
+	Weekday time.Weekday
+}
+
+// Rule is a parsed recurrence rule
+type Rule struct {
+	Freq     string
+	Interval int
+	Days     []Day
+	Until    time.Time
+}
+
+// Parse reads a rule such as "FREQ=WEEKLY;BYDAY=SA,SU;UNTIL=20271231"
+func Parse(s string) (Rule, error) {
+	rule := Rule{Interval: 1}
+	s = strings.TrimPrefix(strings.TrimSpace(s), "RRULE:")
+	for _, part := range strings.Split(s, ";") {
+		if part == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return rule, fmt.Errorf("%q is not KEY=VALUE", part)
+		}
+		switch strings.ToUpper(key) {
+		case "FREQ":
+			rule.Freq = strings.ToUpper(value)
+			if rule.Freq != Weekly && rule.Freq != Monthly {
+				return rule, fmt.Errorf("FREQ must be WEEKLY or MONTHLY, got %q", value)
+			}
+		case "INTERVAL":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return rule, fmt.Errorf("INTERVAL must be a positive number, got %q", value)
+			}
+			rule.Interval = n
+		case "BYDAY":
+			for _, entry := range strings.Split(value, ",") {
+				day, err := parseDay(strings.ToUpper(strings.TrimSpace(entry)))
+				if err != nil {
+					return rule, err
+				}
+				rule.Days = append(rule.Days, day)
+			}
+		case "UNTIL":
+			until, err := parseDate(value)
+			if err != nil {
+				return rule, fmt.Errorf("UNTIL must be YYYYMMDD, got %q", value)
+			}
+			rule.Until = until
+		default:
+			return rule, fmt.Errorf("%s is not supported", strings.ToUpper(key))
+		}
+	}
+
+	switch {
+	case rule.Freq == "":
+		return rule, errors.New("FREQ is required")
+	case len(rule.Days) == 0:
+		return rule, errors.New("BYDAY is required")
+	case rule.Until.IsZero():
+		return rule, errors.New("UNTIL is required")
+	}
+	if rule.Freq == Weekly {
+		for _, day := range rule.Days {
+			if day.N != 0 {
+				return rule, errors.New("weekly rules take plain weekdays in BYDAY")
+			}
+		}
+	}
+	return rule, nil
+}
+
+func parseDay(s string) (Day, error) {
+	if len(s) < 2 {
+		return Day{}, fmt.Errorf("%q is not a weekday", s)
+	}
+	weekday, ok := weekdays[s[len(s)-2:]]
+	if !ok {
+		return Day{}, fmt.Errorf("%q is not a weekday", s)
+	}
+	day := Day{Weekday: weekday}
+	if ordinal := s[:len(s)-2]; ordinal != "" {
+		n, err := strconv.Atoi(ordinal)
+		if err != nil || n == 0 || n < -5 || n > 5 {
+			return Day{}, fmt.Errorf("%q has an invalid ordinal", s)
+		}
+		day.N = n
+	}
+	return day, nil
+}
+
+// parseDate reads YYYYMMDD, ignoring a trailing time such as T235959Z
+func parseDate(s string) (time.Time, error) {
+	if i := strings.IndexByte(s, 'T'); i >= 0 {
+		s = s[:i]
+	}
+	return time.Parse("20060102", s)
+}
+
+// String formats the rule back into RRULE syntax
+func (r Rule) String() string {
+	var days []string
+	for _, day := range r.Days {
+		code := strings.ToUpper(day.Weekday.String()[:2])
+		if day.N != 0 {
+			code = strconv.Itoa(day.N) + code
+		}
+		days = append(days, code)
+	}
+	parts := []string{"FREQ=" + r.Freq, "BYDAY=" + strings.Join(days, ",")}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	parts = append(parts, "UNTIL="+r.Until.Format("20060102"))
+	return strings.Join(parts, ";")
+}
+
+// Dates expands the rule from start, the first day of the series, through
+// Until. Dates in except, keyed as YYYY-MM-DD, are left out. The result is in
+// order and every date is midnight UTC.
+func (r Rule) Dates(start time.Time, except map[string]bool) ([]time.Time, error) {
+	start = civil(start)
+	until := civil(r.Until)
+	var dates []time.Time
+	add := func(d time.Time) error {
+		if d.Before(start) || d.After(until) || except[d.Format("2006-01-02")] {
+			return nil
+		}
+		if len(dates) == MaxOccurrences {
+			return fmt.Errorf("the rule has more than %d dates, choose an earlier UNTIL", MaxOccurrences)
+		}
+		dates = append(dates, d)
+		return nil
+	}
+
+	switch r.Freq {
+	case Weekly:
+		// Weeks start on Monday, as with the RFC's default WKST
+		week := start.AddDate(0, 0, -((int(start.Weekday()) + 6) % 7))
+		for ; !week.After(until); week = week.AddDate(0, 0, 7*r.Interval) {
+			for offset := 0; offset < 7; offset++ {
+				d := week.AddDate(0, 0, offset)
+				if r.hasWeekday(d.Weekday()) {
+					if err := add(d); err != nil {
+						return nil, err
+					}
+				}
+			}
+		}
+	case Monthly:
+		month := time.Date(start.Year(), start.Month(), 1, 0, 0, 0, 0, time.UTC)
+		for ; !month.After(until); month = month.AddDate(0, r.Interval, 0) {
+			var inMonth []time.Time
+			for _, day := range r.Days {
+				inMonth = append(inMonth, monthDays(month, day)...)
+			}
+			sort.Slice(inMonth, func(i, j int) bool { return inMonth[i].Before(inMonth[j]) })
+			for i, d := range inMonth {
+				if i > 0 && d.Equal(inMonth[i-1]) {
+					continue
+				}
+				if err := add(d); err != nil {
+					return nil, err
+				}
+			}
+		}
+	default:
+		return nil, fmt.Errorf("FREQ %q is not supported", r.Freq)
+	}
+	return dates, nil
+}
+
+func (r Rule) hasWeekday(weekday time.Weekday) bool {
+	for _, day := range r.Days {
+		if day.Weekday == weekday {
+			return true
+		}
+	}
+	return false
+}
+
+// monthDays returns the days of month matching day: every such weekday when
+// N is 0, otherwise the Nth from the start, or from the end when N is negative
+func monthDays(month time.Time, day Day) []time.Time {
+	var matches []time.Time
+	for d := month; d.Month() == month.Month(); d = d.AddDate(0, 0, 1) {
+		if d.Weekday() == day.Weekday {
+			matches = append(matches, d)
+		}
+	}
+	switch {
+	case day.N == 0:
+		return matches
+	case day.N > 0 && day.N <= len(matches):
+		return matches[day.N-1 : day.N]
+	case day.N < 0 && -day.N <= len(matches):
+		i := len(matches) + day.N
+		return matches[i : i+1]
+	}
+	return nil
+}
+
+// civil drops the time and zone of t, keeping its calendar day
+func civil(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
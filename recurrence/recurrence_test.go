@@ -0,0 +1,190 @@
+package recurrence
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func date(s string) time.Time {
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    string
+		want    Rule
+		wantErr string
+	}{
+		{
+			name: "weekly",
+			rule: "FREQ=WEEKLY;BYDAY=SA,SU;UNTIL=20271231",
+			want: Rule{Freq: Weekly, Interval: 1, Days: []Day{{0, time.Saturday}, {0, time.Sunday}}, Until: date("2027-12-31")},
+		},
+		{
+			name: "monthly with prefix, lower case and a time on UNTIL",
+			rule: "RRULE:FREQ=monthly;BYDAY=1sa,-1SU;INTERVAL=2;UNTIL=20271231T235959Z",
+			want: Rule{Freq: Monthly, Interval: 2, Days: []Day{{1, time.Saturday}, {-1, time.Sunday}}, Until: date("2027-12-31")},
+		},
+		{name: "no FREQ", rule: "BYDAY=SA;UNTIL=20271231", wantErr: "FREQ is required"},
+		{name: "no BYDAY", rule: "FREQ=WEEKLY;UNTIL=20271231", wantErr: "BYDAY is required"},
+		{name: "no UNTIL", rule: "FREQ=WEEKLY;BYDAY=SA", wantErr: "UNTIL is required"},
+		{name: "daily", rule: "FREQ=DAILY;BYDAY=SA;UNTIL=20271231", wantErr: "FREQ must be WEEKLY or MONTHLY"},
+		{name: "zero interval", rule: "FREQ=WEEKLY;BYDAY=SA;INTERVAL=0;UNTIL=20271231", wantErr: "INTERVAL must be a positive number"},
+		{name: "ordinal in weekly", rule: "FREQ=WEEKLY;BYDAY=1SA;UNTIL=20271231", wantErr: "weekly rules take plain weekdays"},
+		{name: "ordinal out of range", rule: "FREQ=MONTHLY;BYDAY=6SA;UNTIL=20271231", wantErr: "invalid ordinal"},
+		{name: "unknown weekday", rule: "FREQ=WEEKLY;BYDAY=XX;UNTIL=20271231", wantErr: "is not a weekday"},
+		{name: "bad UNTIL", rule: "FREQ=WEEKLY;BYDAY=SA;UNTIL=2027-12-31", wantErr: "UNTIL must be YYYYMMDD"},
+		{name: "COUNT", rule: "FREQ=WEEKLY;BYDAY=SA;COUNT=3", wantErr: "COUNT is not supported"},
+		{name: "not KEY=VALUE", rule: "FREQ", wantErr: "is not KEY=VALUE"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.rule)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Parse(%q) error = %v, want %q", tt.rule, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse(%q) error = %v", tt.rule, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse(%q) = %+v, want %+v", tt.rule, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRuleString(t *testing.T) {
+	for _, rule := range []string{
+		"FREQ=WEEKLY;BYDAY=SA,SU;UNTIL=20271231",
+		"FREQ=MONTHLY;BYDAY=1SA,-1SU;INTERVAL=2;UNTIL=20271231",
+	} {
+		parsed, err := Parse(rule)
+		if err != nil {
+			t.Fatalf("Parse(%q) error = %v", rule, err)
+		}
+		if got := parsed.String(); got != rule {
+			t.Errorf("String() = %q, want %q", got, rule)
+		}
+	}
+}
+
+func TestDates(t *testing.T) {
+	tests := []struct {
+		name   string
+		rule   string
+		start  string
+		except map[string]bool
+		want   []string
+	}{
+		{
+			name:  "weekly weekend",
+			rule:  "FREQ=WEEKLY;BYDAY=SA,SU;UNTIL=20260118",
+			start: "2026-01-01",
+			want:  []string{"2026-01-03", "2026-01-04", "2026-01-10", "2026-01-11", "2026-01-17", "2026-01-18"},
+		},
+		{
+			name:  "weekly every other week",
+			rule:  "FREQ=WEEKLY;BYDAY=SA;INTERVAL=2;UNTIL=20260131",
+			start: "2026-01-01",
+			want:  []string{"2026-01-03", "2026-01-17", "2026-01-31"},
+		},
+		{
+			name:  "start within the series",
+			rule:  "FREQ=WEEKLY;BYDAY=SA;UNTIL=20260117",
+			start: "2026-01-05",
+			want:  []string{"2026-01-10", "2026-01-17"},
+		},
+		{
+			name:   "exceptions",
+			rule:   "FREQ=WEEKLY;BYDAY=SA;UNTIL=20260124",
+			start:  "2026-01-01",
+			except: map[string]bool{"2026-01-10": true},
+			want:   []string{"2026-01-03", "2026-01-17", "2026-01-24"},
+		},
+		{
+			name:  "first Saturday and last Sunday",
+			rule:  "FREQ=MONTHLY;BYDAY=1SA,-1SU;UNTIL=20260331",
+			start: "2026-01-01",
+			want:  []string{"2026-01-03", "2026-01-25", "2026-02-07", "2026-02-22", "2026-03-07", "2026-03-29"},
+		},
+		{
+			name:  "last Saturday every other month",
+			rule:  "FREQ=MONTHLY;BYDAY=-1SA;INTERVAL=2;UNTIL=20260531",
+			start: "2026-01-01",
+			want:  []string{"2026-01-31", "2026-03-28", "2026-05-30"},
+		},
+		{
+			name:  "fifth Saturday only in months that have one",
+			rule:  "FREQ=MONTHLY;BYDAY=5SA;UNTIL=20260331",
+			start: "2026-01-01",
+			want:  []string{"2026-01-31"},
+		},
+		{
+			name:  "ordinal and plain weekday on the same day",
+			rule:  "FREQ=MONTHLY;BYDAY=1SA,SA;UNTIL=20260131",
+			start: "2026-01-01",
+			want:  []string{"2026-01-03", "2026-01-10", "2026-01-17", "2026-01-24", "2026-01-31"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := Parse(tt.rule)
+			if err != nil {
+				t.Fatalf("Parse(%q) error = %v", tt.rule, err)
+			}
+			dates, err := rule.Dates(date(tt.start), tt.except)
+			if err != nil {
+				t.Fatalf("Dates() error = %v", err)
+			}
+			got := make([]string, len(dates))
+			for i, d := range dates {
+				got[i] = d.Format("2006-01-02")
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Dates() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDatesMaxOccurrences(t *testing.T) {
+	start := date("2026-01-01")
+	tests := []struct {
+		name    string
+		days    int
+		wantErr bool
+	}{
+		{"exactly the limit", MaxOccurrences, false},
+		{"one over the limit", MaxOccurrences + 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := Rule{
+				Freq:     Weekly,
+				Interval: 1,
+				Days: []Day{
+					{0, time.Monday}, {0, time.Tuesday}, {0, time.Wednesday}, {0, time.Thursday},
+					{0, time.Friday}, {0, time.Saturday}, {0, time.Sunday},
+				},
+				Until: start.AddDate(0, 0, tt.days-1),
+			}
+			dates, err := rule.Dates(start, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Dates() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && len(dates) != MaxOccurrences {
+				t.Errorf("Dates() returned %d dates, want %d", len(dates), MaxOccurrences)
+			}
+		})
+	}
+}
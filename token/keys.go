@@ -0,0 +1,120 @@
+package token
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// minHMACSecretLength is the shortest HS256 secret accepted
+const minHMACSecretLength = 32
+
+var randRead = rand.Read
+
+func signingMethod(alg string) jwt.SigningMethod {
+	switch alg {
+	case AlgRS256:
+		return jwt.SigningMethodRS256
+	case AlgEdDSA:
+		return jwt.SigningMethodEdDSA
+	default:
+		return jwt.SigningMethodHS256
+	}
+}
+
+func loadKey(cfg KeyConfig) (*Key, error) {
+	if cfg.ID == "" {
+		return nil, errors.New("kid is required")
+	}
+	key := &Key{ID: cfg.ID, Algorithm: cfg.Algorithm}
+
+	switch cfg.Algorithm {
+	case AlgHS256:
+		if len(cfg.Secret) < minHMACSecretLength {
+			return nil, fmt.Errorf("HS256 secret must be at least %d bytes", minHMACSecretLength)
+		}
+		key.signKey = []byte(cfg.Secret)
+		key.verifyKey = []byte(cfg.Secret)
+
+	case AlgRS256:
+		privatePEM, publicPEM, err := readPEMs(cfg)
+		if err != nil {
+			return nil, err
+		}
+		if privatePEM != nil {
+			private, err := jwt.ParseRSAPrivateKeyFromPEM(privatePEM)
+			if err != nil {
+				return nil, err
+			}
+			key.signKey = private
+			key.verifyKey = &private.PublicKey
+		} else {
+			public, err := jwt.ParseRSAPublicKeyFromPEM(publicPEM)
+			if err != nil {
+				return nil, err
+			}
+			key.verifyKey = public
+		}
+		if key.verifyKey.(*rsa.PublicKey).N.BitLen() < 2048 {
+			return nil, errors.New("RSA keys must be at least 2048 bits")
+		}
+
+	case AlgEdDSA:
+		privatePEM, publicPEM, err := readPEMs(cfg)
+		if err != nil {
+			return nil, err
+		}
+		if privatePEM != nil {
+			private, err := jwt.ParseEdPrivateKeyFromPEM(privatePEM)
+			if err != nil {
+				return nil, err
+			}
+			key.signKey = private
+			key.verifyKey = private.(crypto.Signer).Public()
+		} else {
+			public, err := jwt.ParseEdPublicKeyFromPEM(publicPEM)
+			if err != nil {
+				return nil, err
+			}
+			key.verifyKey = public
+		}
+		if _, ok := key.verifyKey.(ed25519.PublicKey); !ok {
+			return nil, errors.New("EdDSA keys must be Ed25519")
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported alg %q", cfg.Algorithm)
+	}
+
+	return key, nil
+}
+
+// readPEMs returns the private key PEM if one is configured, otherwise the public key PEM
+func readPEMs(cfg KeyConfig) (privatePEM []byte, publicPEM []byte, err error) {
+	if privatePEM, err = readPEM(cfg.PrivateKey, cfg.PrivateKeyFile); err != nil || privatePEM != nil {
+		return privatePEM, nil, err
+	}
+	if publicPEM, err = readPEM(cfg.PublicKey, cfg.PublicKeyFile); err != nil {
+		return nil, nil, err
+	}
+	if publicPEM == nil {
+		return nil, nil, errors.New("a private or public key is required")
+	}
+	return nil, publicPEM, nil
+}
+
+func readPEM(inline string, file string) ([]byte, error) {
+	if inline != "" {
+		return []byte(inline), nil
+	}
+	if file != "" {
+		return os.ReadFile(file)
+	}
+	return nil, nil
+}
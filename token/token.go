@@ -0,0 +1,192 @@
+// Package token signs and verifies the JWTs issued by the API.
+//
+// Keys come from configuration and every token carries the "kid" of the key
+// that signed it. Any configured key can verify, so a secret can be rotated by
+// adding the new key, switching JWT_SIGNING_KID to it and removing the old key
+// once the tokens it signed have expired.
+package token
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Supported signing algorithms
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+// KeyConfig describes one key in JWT_KEYS. HS256 keys use Secret. RS256 and
+// EdDSA keys use a PEM private key to sign, or only a PEM public key when the
+// key is kept for verification. PEM values can be inline or read from a file.
+type KeyConfig struct {
+	ID             string `json:"kid"`
+	Algorithm      string `json:"alg"`
+	Secret         string `json:"secret"`
+	PrivateKey     string `json:"private_key"`
+	PrivateKeyFile string `json:"private_key_file"`
+	PublicKey      string `json:"public_key"`
+	PublicKeyFile  string `json:"public_key_file"`
+}
+
+// Key is a loaded signing or verification key
+type Key struct {
+	ID        string
+	Algorithm string
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// CanSign reports whether the key holds private material
+func (k *Key) CanSign() bool {
+	return k.signKey != nil
+}
+
+// Service issues and verifies tokens with a set of keys
+type Service struct {
+	keys    map[string]*Key
+	signing *Key
+}
+
+// Default is the service used by the API, set by Init
+var Default *Service
+
+// Init loads Default from the environment
+func Init() error {
+	s, err := LoadFromEnv()
+	if err != nil {
+		return err
+	}
+	Default = s
+	return nil
+}
+
+// LoadFromEnv builds a Service from JWT_KEYS and JWT_SIGNING_KID. When JWT_KEYS
+// is unset it falls back to a single HS256 key from JWT_SECRET, and when that
+// is unset too it generates a random key so tokens do not survive a restart.
+func LoadFromEnv() (*Service, error) {
+	var configs []KeyConfig
+	if raw := os.Getenv("JWT_KEYS"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &configs); err != nil {
+			return nil, fmt.Errorf("invalid JWT_KEYS: %w", err)
+		}
+	} else if secret := os.Getenv("JWT_SECRET"); secret != "" {
+		configs = []KeyConfig{{ID: "default", Algorithm: AlgHS256, Secret: secret}}
+	} else {
+		log.Println("JWT_KEYS and JWT_SECRET are not set, using a random key; tokens will not survive a restart")
+		secret := make([]byte, 32)
+		if _, err := randRead(secret); err != nil {
+			return nil, err
+		}
+		configs = []KeyConfig{{ID: "ephemeral", Algorithm: AlgHS256, Secret: string(secret)}}
+	}
+	return NewService(configs, os.Getenv("JWT_SIGNING_KID"))
+}
+
+// NewService loads the configured keys. signingKID picks the key that signs new
+// tokens; when empty the first key able to sign is used.
+func NewService(configs []KeyConfig, signingKID string) (*Service, error) {
+	s := &Service{keys: map[string]*Key{}}
+	for _, cfg := range configs {
+		key, err := loadKey(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", cfg.ID, err)
+		}
+		if _, dup := s.keys[key.ID]; dup {
+			return nil, fmt.Errorf("duplicate kid %q", key.ID)
+		}
+		s.keys[key.ID] = key
+		if s.signing == nil && signingKID == "" && key.CanSign() {
+			s.signing = key
+		}
+	}
+
+	if signingKID != "" {
+		s.signing = s.keys[signingKID]
+	}
+	if s.signing == nil || !s.signing.CanSign() {
+		return nil, errors.New("no usable signing key configured")
+	}
+	return s, nil
+}
+
+// Sign returns a token for claims signed with the current signing key
+func (s *Service) Sign(claims jwt.Claims) (string, error) {
+	t := jwt.NewWithClaims(signingMethod(s.signing.Algorithm), claims)
+	t.Header["kid"] = s.signing.ID
+	return t.SignedString(s.signing.signKey)
+}
+
+// Parse verifies tokenString with the key named by its kid header and fills claims.
+// The token's alg must match the algorithm configured for that key.
+func (s *Service) Parse(tokenString string, claims jwt.Claims) error {
+	t, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		key, ok := s.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown kid %q", kid)
+		}
+		if t.Method.Alg() != key.Algorithm {
+			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+		}
+		return key.verifyKey, nil
+	}, jwt.WithValidMethods([]string{AlgHS256, AlgRS256, AlgEdDSA}), jwt.WithExpirationRequired())
+	if err != nil {
+		return err
+	}
+	if !t.Valid {
+		return errors.New("invalid token")
+	}
+	return nil
+}
+
+// JWK is a public key in JSON Web Key format
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+}
+
+// JWKS returns the public verification keys. HMAC secrets are never published.
+func (s *Service) JWKS() map[string][]JWK {
+	keys := []JWK{}
+	for _, key := range s.keys {
+		switch pub := key.verifyKey.(type) {
+		case *rsa.PublicKey:
+			keys = append(keys, JWK{
+				KeyType:   "RSA",
+				KeyID:     key.ID,
+				Algorithm: key.Algorithm,
+				Use:       "sig",
+				N:         base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			keys = append(keys, JWK{
+				KeyType:   "OKP",
+				KeyID:     key.ID,
+				Algorithm: key.Algorithm,
+				Use:       "sig",
+				Curve:     "Ed25519",
+				X:         base64.RawURLEncoding.EncodeToString(pub),
+			})
+		}
+	}
+	return map[string][]JWK{"keys": keys}
+}
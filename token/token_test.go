@@ -0,0 +1,291 @@
+package token
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	secretA = "0123456789abcdef0123456789abcdef"
+	secretB = "fedcba9876543210fedcba9876543210"
+)
+
+type testKeys struct {
+	rsaPrivate, rsaPublic string
+	edPrivate, edPublic   string
+	ed                    ed25519.PublicKey
+}
+
+func newTestKeys(t *testing.T) testKeys {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPublic, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encode := func(kind string, der []byte, err error) string {
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(pem.EncodeToMemory(&pem.Block{Type: kind, Bytes: der}))
+	}
+	rsaPublicDER, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	edPrivateDER, err2 := x509.MarshalPKCS8PrivateKey(edPrivate)
+	edPublicDER, err3 := x509.MarshalPKIXPublicKey(edPublic)
+	return testKeys{
+		rsaPrivate: encode("RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey), nil),
+		rsaPublic:  encode("PUBLIC KEY", rsaPublicDER, err),
+		edPrivate:  encode("PRIVATE KEY", edPrivateDER, err2),
+		edPublic:   encode("PUBLIC KEY", edPublicDER, err3),
+		ed:         edPublic,
+	}
+}
+
+func claimsFor(subject string, expires time.Time) *jwt.RegisteredClaims {
+	return &jwt.RegisteredClaims{Subject: subject, ExpiresAt: jwt.NewNumericDate(expires)}
+}
+
+func mustService(t *testing.T, configs []KeyConfig, signingKID string) *Service {
+	t.Helper()
+	s, err := NewService(configs, signingKID)
+	if err != nil {
+		t.Fatalf("NewService() error = %v", err)
+	}
+	return s
+}
+
+func TestNewService(t *testing.T) {
+	keys := newTestKeys(t)
+	tests := []struct {
+		name       string
+		configs    []KeyConfig
+		signingKID string
+		wantErr    string
+		wantSigner string
+	}{
+		{name: "first signing key by default",
+			configs:    []KeyConfig{{ID: "verify", Algorithm: AlgRS256, PublicKey: keys.rsaPublic}, {ID: "a", Algorithm: AlgHS256, Secret: secretA}},
+			wantSigner: "a"},
+		{name: "chosen signing key",
+			configs:    []KeyConfig{{ID: "a", Algorithm: AlgHS256, Secret: secretA}, {ID: "ed", Algorithm: AlgEdDSA, PrivateKey: keys.edPrivate}},
+			signingKID: "ed", wantSigner: "ed"},
+		{name: "no kid", configs: []KeyConfig{{Algorithm: AlgHS256, Secret: secretA}}, wantErr: "kid is required"},
+		{name: "short secret", configs: []KeyConfig{{ID: "a", Algorithm: AlgHS256, Secret: "short"}}, wantErr: "at least 32 bytes"},
+		{name: "duplicate kid",
+			configs: []KeyConfig{{ID: "a", Algorithm: AlgHS256, Secret: secretA}, {ID: "a", Algorithm: AlgHS256, Secret: secretB}},
+			wantErr: `duplicate kid "a"`},
+		{name: "unknown alg", configs: []KeyConfig{{ID: "a", Algorithm: "none", Secret: secretA}}, wantErr: "unsupported alg"},
+		{name: "RSA without a key", configs: []KeyConfig{{ID: "r", Algorithm: AlgRS256}}, wantErr: "a private or public key is required"},
+		{name: "RSA key given as EdDSA", configs: []KeyConfig{{ID: "r", Algorithm: AlgEdDSA, PrivateKey: keys.rsaPrivate}}, wantErr: `key "r"`},
+		{name: "unknown signing kid",
+			configs: []KeyConfig{{ID: "a", Algorithm: AlgHS256, Secret: secretA}}, signingKID: "b",
+			wantErr: "no usable signing key"},
+		{name: "signing kid without a private key",
+			configs:    []KeyConfig{{ID: "a", Algorithm: AlgHS256, Secret: secretA}, {ID: "verify", Algorithm: AlgRS256, PublicKey: keys.rsaPublic}},
+			signingKID: "verify", wantErr: "no usable signing key"},
+		{name: "no keys", wantErr: "no usable signing key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewService(tt.configs, tt.signingKID)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("NewService() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewService() error = %v", err)
+			}
+			if s.signing.ID != tt.wantSigner {
+				t.Errorf("signing kid = %q, want %q", s.signing.ID, tt.wantSigner)
+			}
+		})
+	}
+}
+
+func TestSignSetsKid(t *testing.T) {
+	keys := newTestKeys(t)
+	for _, cfg := range []KeyConfig{
+		{ID: "hs", Algorithm: AlgHS256, Secret: secretA},
+		{ID: "rs", Algorithm: AlgRS256, PrivateKey: keys.rsaPrivate},
+		{ID: "ed", Algorithm: AlgEdDSA, PrivateKey: keys.edPrivate},
+	} {
+		t.Run(cfg.Algorithm, func(t *testing.T) {
+			s := mustService(t, []KeyConfig{cfg}, "")
+			signed, err := s.Sign(claimsFor("42", time.Now().Add(time.Hour)))
+			if err != nil {
+				t.Fatalf("Sign() error = %v", err)
+			}
+			parsed, _, err := jwt.NewParser().ParseUnverified(signed, &jwt.RegisteredClaims{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if parsed.Header["kid"] != cfg.ID || parsed.Header["alg"] != cfg.Algorithm {
+				t.Errorf("header = %v, want kid %q and alg %q", parsed.Header, cfg.ID, cfg.Algorithm)
+			}
+			var claims jwt.RegisteredClaims
+			if err := s.Parse(signed, &claims); err != nil || claims.Subject != "42" {
+				t.Errorf("Parse() = %+v, %v", claims, err)
+			}
+		})
+	}
+}
+
+func TestRotation(t *testing.T) {
+	oldKey := KeyConfig{ID: "2025", Algorithm: AlgHS256, Secret: secretA}
+	newKey := KeyConfig{ID: "2026", Algorithm: AlgHS256, Secret: secretB}
+	expires := time.Now().Add(time.Hour)
+
+	before := mustService(t, []KeyConfig{oldKey}, "")
+	oldToken, err := before.Sign(claimsFor("old", expires))
+	if err != nil {
+		t.Fatal(err)
+	}
+	during := mustService(t, []KeyConfig{oldKey, newKey}, "2026")
+	newToken, err := during.Sign(claimsFor("new", expires))
+	if err != nil {
+		t.Fatal(err)
+	}
+	after := mustService(t, []KeyConfig{newKey}, "")
+
+	tests := []struct {
+		name    string
+		service *Service
+		token   string
+		wantErr string
+	}{
+		{"old token before rotation", before, oldToken, ""},
+		{"old token while both keys are configured", during, oldToken, ""},
+		{"new token while both keys are configured", during, newToken, ""},
+		{"new token after the old key is removed", after, newToken, ""},
+		{"old token after the old key is removed", after, oldToken, `unknown kid "2025"`},
+		{"new token before rotation", before, newToken, `unknown kid "2026"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.service.Parse(tt.token, &jwt.RegisteredClaims{})
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("Parse() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	keys := newTestKeys(t)
+	s := mustService(t, []KeyConfig{
+		{ID: "hs", Algorithm: AlgHS256, Secret: secretA},
+		{ID: "ed", Algorithm: AlgEdDSA, PrivateKey: keys.edPrivate},
+	}, "hs")
+	sign := func(method jwt.SigningMethod, kid interface{}, key interface{}, claims jwt.Claims) string {
+		t.Helper()
+		token := jwt.NewWithClaims(method, claims)
+		if kid != nil {
+			token.Header["kid"] = kid
+		}
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+	valid := claimsFor("42", time.Now().Add(time.Hour))
+	good := sign(jwt.SigningMethodHS256, "hs", []byte(secretA), valid)
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"no kid", sign(jwt.SigningMethodHS256, nil, []byte(secretA), valid)},
+		{"unknown kid", sign(jwt.SigningMethodHS256, "other", []byte(secretA), valid)},
+		{"wrong secret", sign(jwt.SigningMethodHS256, "hs", []byte(secretB), valid)},
+		{"alg other than the key's", sign(jwt.SigningMethodHS512, "hs", []byte(secretA), valid)},
+		{"HMAC under an EdDSA kid", sign(jwt.SigningMethodHS256, "ed", []byte(keys.ed), valid)},
+		{"expired", sign(jwt.SigningMethodHS256, "hs", []byte(secretA), claimsFor("42", time.Now().Add(-time.Minute)))},
+		{"no expiry", sign(jwt.SigningMethodHS256, "hs", []byte(secretA), &jwt.RegisteredClaims{Subject: "42"})},
+		{"tampered", good[:len(good)-2] + "xx"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := s.Parse(tt.token, &jwt.RegisteredClaims{}); err == nil {
+				t.Error("Parse() accepted the token")
+			}
+		})
+	}
+	if err := s.Parse(good, &jwt.RegisteredClaims{}); err != nil {
+		t.Errorf("Parse() of a good token error = %v", err)
+	}
+}
+
+func TestVerifyOnlyKey(t *testing.T) {
+	keys := newTestKeys(t)
+	issuer := mustService(t, []KeyConfig{{ID: "ed", Algorithm: AlgEdDSA, PrivateKey: keys.edPrivate}}, "")
+	signed, err := issuer.Sign(claimsFor("42", time.Now().Add(time.Hour)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier := mustService(t, []KeyConfig{
+		{ID: "hs", Algorithm: AlgHS256, Secret: secretA},
+		{ID: "ed", Algorithm: AlgEdDSA, PublicKey: keys.edPublic},
+	}, "hs")
+	if err := verifier.Parse(signed, &jwt.RegisteredClaims{}); err != nil {
+		t.Errorf("Parse() with the public key error = %v", err)
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	keys := newTestKeys(t)
+	s := mustService(t, []KeyConfig{
+		{ID: "hs", Algorithm: AlgHS256, Secret: secretA},
+		{ID: "rs", Algorithm: AlgRS256, PrivateKey: keys.rsaPrivate},
+		{ID: "ed", Algorithm: AlgEdDSA, PublicKey: keys.edPublic},
+	}, "hs")
+
+	byKID := map[string]JWK{}
+	for _, jwk := range s.JWKS()["keys"] {
+		byKID[jwk.KeyID] = jwk
+	}
+	if len(byKID) != 2 {
+		t.Fatalf("JWKS() has %d keys, want the RSA and Ed25519 keys only: %v", len(byKID), byKID)
+	}
+	if _, ok := byKID["hs"]; ok {
+		t.Error("JWKS() published the HMAC secret")
+	}
+
+	rs := byKID["rs"]
+	if rs.KeyType != "RSA" || rs.Algorithm != AlgRS256 || rs.Use != "sig" || rs.E != "AQAB" || rs.N == "" {
+		t.Errorf("RSA key = %+v", rs)
+	}
+	if n, err := base64.RawURLEncoding.DecodeString(rs.N); err != nil || len(n)*8 != 2048 {
+		t.Errorf("RSA modulus decodes to %d bits, %v", len(n)*8, err)
+	}
+
+	ed := byKID["ed"]
+	if ed.KeyType != "OKP" || ed.Curve != "Ed25519" || ed.Algorithm != AlgEdDSA || ed.Use != "sig" ||
+		ed.X != base64.RawURLEncoding.EncodeToString(keys.ed) {
+		t.Errorf("Ed25519 key = %+v", ed)
+	}
+}
+
+func TestJWKSWithOnlyHMAC(t *testing.T) {
+	s := mustService(t, []KeyConfig{{ID: "hs", Algorithm: AlgHS256, Secret: secretA}}, "")
+	if keys := s.JWKS()["keys"]; keys == nil || len(keys) != 0 {
+		t.Errorf("JWKS() = %v, want an empty key list", keys)
+	}
+}
@@ -0,0 +1,110 @@
+package totp
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 key of RFC 6238 appendix B, "12345678901234567890", in base32
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// rfcVectors are the SHA-1 test vectors of RFC 6238 appendix B, cut to the
+// last 6 of their 8 digits
+var rfcVectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+	{20000000000, "353130"},
+}
+
+func TestCodeAtRFC6238(t *testing.T) {
+	for _, tt := range rfcVectors {
+		got, err := CodeAt(rfcSecret, Counter(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatalf("CodeAt(%d) error = %v", tt.unix, err)
+		}
+		if got != tt.code {
+			t.Errorf("CodeAt(%d) = %s, want %s", tt.unix, got, tt.code)
+		}
+	}
+}
+
+func TestCodeAtLowerCaseSecret(t *testing.T) {
+	got, err := CodeAt("gezdgnbvgy3tqojqgezdgnbvgy3tqojq", Counter(time.Unix(59, 0)))
+	if err != nil || got != "287082" {
+		t.Errorf("CodeAt() = %s, %v, want 287082", got, err)
+	}
+}
+
+func TestCodeAtInvalidSecret(t *testing.T) {
+	if _, err := CodeAt("not base32!", 1); err == nil {
+		t.Error("CodeAt() with an invalid secret returned no error")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := Counter(now)
+	tests := []struct {
+		name     string
+		code     string
+		at       time.Time
+		skew     int64
+		wantStep int64
+		wantOK   bool
+	}{
+		{"current step", "050471", now, 1, step, true},
+		{"with spaces", "050 471", now, 1, step, true},
+		{"previous step within skew", "081804", time.Unix(1111111109, 0).Add(Period), 1, step - 1, true},
+		{"previous step without skew", "081804", time.Unix(1111111109, 0).Add(Period), 0, 0, false},
+		{"wrong code", "123456", now, 1, 0, false},
+		{"too short", "05047", now, 1, 0, false},
+		{"too long", "0504711", now, 1, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotStep, ok := Validate(rfcSecret, tt.code, tt.at, tt.skew)
+			if ok != tt.wantOK || gotStep != tt.wantStep {
+				t.Errorf("Validate(%q) = %d, %v, want %d, %v", tt.code, gotStep, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatalf("GenerateSecret() error = %v", err)
+	}
+	key, err := encoding.DecodeString(secret)
+	if err != nil || len(key) != secretSize {
+		t.Errorf("GenerateSecret() = %q, decodes to %d bytes, %v", secret, len(key), err)
+	}
+	if other, _ := GenerateSecret(); other == secret {
+		t.Error("GenerateSecret() returned the same secret twice")
+	}
+}
+
+func TestProvisioningURI(t *testing.T) {
+	u, err := url.Parse(ProvisioningURI("Medic Market", "admin@example.com", rfcSecret))
+	if err != nil {
+		t.Fatalf("ProvisioningURI() is not a URL: %v", err)
+	}
+	if u.Scheme != "otpauth" || u.Host != "totp" || u.Path != "/Medic Market:admin@example.com" {
+		t.Errorf("ProvisioningURI() = %s", u)
+	}
+	query := u.Query()
+	for key, want := range map[string]string{
+		"secret": rfcSecret, "issuer": "Medic Market", "algorithm": "SHA1", "digits": "6", "period": "30",
+	} {
+		if got := query.Get(key); got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+}